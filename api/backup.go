@@ -2,27 +2,137 @@ package api
 
 import (
 	"fmt"
-	"time"
+	"log/slog"
+	"strings"
 
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/backup_type"
 	"github.com/macarrie/relique/internal/client"
+	"github.com/macarrie/relique/internal/config"
 	"github.com/macarrie/relique/internal/job"
-	"github.com/macarrie/relique/internal/job_status"
 	"github.com/macarrie/relique/internal/module"
 	"github.com/macarrie/relique/internal/repo"
 )
 
-func BackupStart(c client.Client, m module.Module, r repo.Repository) error {
+// BackupResolve gets client, module and repository to use for a backup from configuration, and applies inclusions/exclusions overrides on module.
+// If no module name is provided, an on-demand module is built with provided paths
+func BackupResolve(p api_helpers.BackupParams) (client.Client, module.Module, repo.Repository, error) {
+	c, err := ClientGet(p.ClientName)
+	if err != nil {
+		return client.Client{}, module.Module{}, nil, fmt.Errorf("cannot find client: %w", err)
+	}
+
+	var mod module.Module
+	if p.ModuleName == "" {
+		if len(p.Paths) == 0 {
+			return client.Client{}, module.Module{}, nil, fmt.Errorf("paths are needed if no module is specified")
+		}
+		mod = module.Module{
+			Name:        "on-demand",
+			BackupType:  backup_type.BackupType{Type: backup_type.Diff},
+			ModuleType:  "generic",
+			BackupPaths: p.Paths,
+			Include:     p.Include,
+			Exclude:     p.Exclude,
+			ExcludeCVS:  p.ExcludeCVS,
+		}
+	} else {
+		mod, err = module.GetByName(c.Modules, p.ModuleName)
+		if err != nil {
+			return client.Client{}, module.Module{}, nil, fmt.Errorf("cannot find module on client: %w", err)
+		}
+
+		// Override module with provided params
+		if len(p.Include) > 0 {
+			slog.With(
+				slog.String("module", mod.Name),
+				slog.String("override_include", strings.Join(p.Include, ", ")),
+				slog.String("mod_include", strings.Join(mod.Include, ", ")),
+			).Info("Override module include list with the one provided in params")
+			mod.Include = p.Include
+		}
+		if len(p.Exclude) > 0 {
+			slog.With(
+				slog.String("module", mod.Name),
+				slog.String("override_exclude", strings.Join(p.Exclude, ", ")),
+				slog.String("mod_exclude", strings.Join(mod.Exclude, ", ")),
+			).Info("Override module exclusions list with the one provided in params")
+			mod.Exclude = p.Exclude
+		}
+		if p.ExcludeCVS {
+			slog.With(
+				slog.String("module", mod.Name),
+				slog.Bool("override_exclude_cvs", p.ExcludeCVS),
+				slog.Bool("mod_exclude_cvs", mod.ExcludeCVS),
+			).Info("Override module exclude_cvs parameter")
+			mod.ExcludeCVS = p.ExcludeCVS
+		}
+	}
+
+	var r repo.Repository
+	if p.RepoName == "" {
+		slog.Debug("Repository not provided. Looking for default repo in configuration")
+		r, err = repo.GetDefault(config.Current.Repositories)
+		if err != nil {
+			return client.Client{}, module.Module{}, nil, fmt.Errorf("cannot find default repository in config: %w", err)
+		}
+	} else {
+		r, err = repo.GetByName(config.Current.Repositories, p.RepoName)
+		if err != nil {
+			return client.Client{}, module.Module{}, nil, fmt.Errorf("cannot find repository in config: %w", err)
+		}
+	}
+
+	return c, mod, r, nil
+}
+
+// BackupStart registers and runs the backup job. The job is returned with its final status once it is done
+func BackupStart(c client.Client, m module.Module, r repo.Repository) (job.Job, error) {
+	j, err := backupSetup(c, m, r)
+	if err != nil {
+		return job.Job{}, err
+	}
+
+	if err := backupRun(&j); err != nil {
+		return j, err
+	}
+
+	return j, nil
+}
+
+// BackupStartAsync registers the backup job and runs it in background. The registered job is returned as soon as its setup is complete
+func BackupStartAsync(c client.Client, m module.Module, r repo.Repository) (job.Job, error) {
+	j, err := backupSetup(c, m, r)
+	if err != nil {
+		return job.Job{}, err
+	}
+
+	go func(j job.Job) {
+		if err := backupRun(&j); err != nil {
+			j.GetLog().With(
+				slog.Any("error", err),
+			).Error("Error during backup job")
+		}
+	}(j)
+
+	return j, nil
+}
+
+func backupSetup(c client.Client, m module.Module, r repo.Repository) (job.Job, error) {
 	j := job.NewBackup(c, m, r)
 	if err := j.SetupBackup(); err != nil {
-		return fmt.Errorf("cannot setup job:  %w", err)
+		if failErr := j.Fail(fmt.Sprintf("job setup failed: %s", err)); failErr != nil {
+			j.GetLog().With(slog.Any("error", failErr)).Error("Cannot mark job as failed")
+		}
+		return job.Job{}, fmt.Errorf("cannot setup job:  %w", err)
 	}
 
-	if err := ClientSSHPing(c); err != nil {
-		j.EndTime = time.Now()
-		j.Status.Status = job_status.Error
-		j.Done = true
+	return j, nil
+}
 
-		if _, err := j.Save(); err != nil {
+func backupRun(j *job.Job) error {
+	if err := ClientSSHPing(j.Client); err != nil {
+		if err := j.Fail(fmt.Sprintf("client unreachable: %s", err)); err != nil {
 			return fmt.Errorf("cannot save job info after failed client ping: %w", err)
 		}
 		return fmt.Errorf("cannot start backup on unreachable client:  %w", err)
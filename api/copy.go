@@ -0,0 +1,79 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/image"
+	"github.com/macarrie/relique/internal/job"
+	"github.com/macarrie/relique/internal/repo"
+)
+
+// CopyResolve gets the image to copy and the repository to copy it into from configuration
+func CopyResolve(p api_helpers.CopyParams) (image.Image, repo.Repository, error) {
+	img, err := image.GetByUuid(p.ImageUuid)
+	if err != nil {
+		return image.Image{}, nil, fmt.Errorf("cannot find image: %w", err)
+	}
+
+	if p.RepoName == "" {
+		return image.Image{}, nil, fmt.Errorf("target repository is needed to copy an image")
+	}
+	r, err := repo.GetByName(config.Current.Repositories, p.RepoName)
+	if err != nil {
+		return image.Image{}, nil, fmt.Errorf("cannot find repository in config: %w", err)
+	}
+
+	return img, r, nil
+}
+
+func CopyStart(img image.Image, target repo.Repository) error {
+	j, err := copySetup(img, target)
+	if err != nil {
+		return err
+	}
+
+	return copyRun(&j)
+}
+
+// CopyStartAsync registers the copy job and runs it in background. The registered job is returned as soon as its setup is complete
+func CopyStartAsync(img image.Image, target repo.Repository) (job.Job, error) {
+	j, err := copySetup(img, target)
+	if err != nil {
+		return job.Job{}, err
+	}
+
+	go func(j job.Job) {
+		if err := copyRun(&j); err != nil {
+			j.GetLog().With(
+				slog.Any("error", err),
+			).Error("Error during copy job")
+		}
+	}(j)
+
+	return j, nil
+}
+
+func copySetup(img image.Image, target repo.Repository) (job.Job, error) {
+	j := job.NewCopy(img, target)
+	if err := j.SetupCopy(); err != nil {
+		if failErr := j.Fail(fmt.Sprintf("job setup failed: %s", err)); failErr != nil {
+			j.GetLog().With(slog.Any("error", failErr)).Error("Cannot mark job as failed")
+		}
+		return job.Job{}, fmt.Errorf("cannot setup job:  %w", err)
+	}
+
+	return j, nil
+}
+
+// copyRun runs a registered copy job. Data is transferred between repositories, clients are not involved
+func copyRun(j *job.Job) error {
+	j.GetLog().Info("Starting job file sync")
+	if err := j.Start(); err != nil {
+		return fmt.Errorf("error encountered during job execution: %w", err)
+	}
+
+	return nil
+}
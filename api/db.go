@@ -0,0 +1,278 @@
+package api
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/backup_type"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/image"
+	"github.com/macarrie/relique/internal/job"
+	"github.com/macarrie/relique/internal/job_status"
+	"github.com/macarrie/relique/internal/job_type"
+	"github.com/macarrie/relique/internal/repo"
+)
+
+type rebuildEntry struct {
+	job      job.Job
+	inferred bool
+	hasData  bool
+}
+
+// DBRebuild recreates jobs and images rows missing from database from catalog folders and repository contents.
+// Rows already present in database are left untouched. Nothing is written if dryRun is set
+func DBRebuild(dryRun bool) (api_helpers.RebuildReport, error) {
+	report := api_helpers.RebuildReport{
+		DryRun: dryRun,
+		Issues: make([]api_helpers.RebuildIssue, 0),
+	}
+
+	catalogPath := config.GetCatalogCfgPath()
+	slog.With(
+		slog.String("path", catalogPath),
+	).Info("Scanning catalog")
+	dirs, err := os.ReadDir(catalogPath)
+	if err != nil {
+		return report, fmt.Errorf("cannot read catalog folder: %w", err)
+	}
+
+	uuids := make([]string, 0, len(dirs))
+	inCatalog := make(map[string]bool)
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		if _, err := uuid.Parse(d.Name()); err != nil {
+			addRebuildIssue(&report, "", fmt.Sprintf("%s/%s", catalogPath, d.Name()), "catalog folder is not named after a job uuid")
+			continue
+		}
+		uuids = append(uuids, d.Name())
+		inCatalog[d.Name()] = true
+	}
+
+	if err := rebuildFromCatalog(uuids, &report); err != nil {
+		return report, err
+	}
+
+	// Look for repository data that cannot be linked to any catalog entry
+	for _, r := range config.Current.Repositories {
+		localRepo, ok := r.(*repo.RepositoryLocal)
+		if !ok {
+			continue
+		}
+
+		repoDirs, err := os.ReadDir(localRepo.Path)
+		if err != nil {
+			addRebuildIssue(&report, "", localRepo.Path, fmt.Sprintf("cannot read repository '%s': %s", localRepo.Name, err))
+			continue
+		}
+		for _, d := range repoDirs {
+			if !d.IsDir() {
+				continue
+			}
+			if _, err := uuid.Parse(d.Name()); err != nil {
+				continue
+			}
+			if !inCatalog[d.Name()] {
+				addRebuildIssue(&report, d.Name(), fmt.Sprintf("%s/%s", localRepo.Path, d.Name()), "data folder with no catalog entry")
+			}
+		}
+	}
+
+	return report, nil
+}
+
+func addRebuildIssue(report *api_helpers.RebuildReport, u string, path string, problem string) {
+	slog.With(
+		slog.String("uuid", u),
+		slog.String("path", path),
+	).Warn(problem)
+	report.Issues = append(report.Issues, api_helpers.RebuildIssue{
+		Uuid:    u,
+		Path:    path,
+		Problem: problem,
+	})
+}
+
+// rebuildFromCatalog inserts jobs and images rows of the listed catalog entries
+func rebuildFromCatalog(uuids []string, report *api_helpers.RebuildReport) error {
+	entries := make([]rebuildEntry, 0, len(uuids))
+	for _, u := range uuids {
+		j, inferred, err := job.LoadFromCatalog(u)
+		if err != nil {
+			entry := job.Job{Uuid: u}
+			addRebuildIssue(report, u, entry.GetCatalogPath(), fmt.Sprintf("incomplete catalog entry: %s", err))
+			continue
+		}
+
+		entry := rebuildEntry{job: j, inferred: inferred}
+		if storagePath, err := j.GetStorageFolderPath(); err == nil {
+			hasData, err := j.Repository.Exists(fmt.Sprintf("%s/_data", storagePath))
+			if err != nil {
+				addRebuildIssue(report, u, storagePath, fmt.Sprintf("cannot check image data folder: %s", err))
+			}
+			entry.hasData = hasData
+		}
+		entries = append(entries, entry)
+	}
+
+	// Rows are inserted in chronological order so that database IDs order matches jobs order
+	sort.SliceStable(entries, func(i, k int) bool {
+		return entries[i].job.StartTime.Before(entries[k].job.StartTime)
+	})
+	inferDiffReferences(entries)
+
+	for _, e := range entries {
+		j := e.job
+		entryPath := j.GetCatalogPath()
+		if e.inferred {
+			report.Inferred++
+		}
+
+		if j.PreviousJobUuid != "" {
+			previous := job.Job{Uuid: j.PreviousJobUuid}
+			if _, err := os.Stat(previous.GetCatalogPath()); err != nil {
+				addRebuildIssue(report, j.Uuid, entryPath, fmt.Sprintf("diff reference job '%s' not found in catalog", j.PreviousJobUuid))
+			}
+		}
+		if j.JobType.Type == job_type.Restore && j.RestoreImageUuid == "" {
+			addRebuildIssue(report, j.Uuid, entryPath, "restore job source image is unknown")
+		}
+
+		jobExists, err := job.Exists(j.Uuid)
+		if err != nil {
+			return err
+		}
+		if jobExists {
+			report.Existing++
+		} else {
+			if !j.Done {
+				locked, err := job.IsLocked(j.Uuid)
+				if err != nil {
+					return fmt.Errorf("cannot check if job '%s' is running: %w", j.Uuid, err)
+				}
+				if locked {
+					addRebuildIssue(report, j.Uuid, entryPath, "job is still running, skipped")
+					continue
+				}
+
+				addRebuildIssue(report, j.Uuid, entryPath, "unfinished job marked as failed")
+				j.Status = job_status.New(job_status.Error)
+				j.StatusMessage = "job process stopped before job completion"
+				j.Done = true
+				j.EndTime = time.Now()
+			}
+
+			report.Jobs++
+			if !report.DryRun {
+				j.ID = 0
+				if _, err := j.Save(); err != nil {
+					return fmt.Errorf("cannot save job '%s': %w", j.Uuid, err)
+				}
+			}
+		}
+
+		if (j.JobType.Type != job_type.Backup && j.JobType.Type != job_type.Copy) || (j.Status.Status != job_status.Success && j.Status.Status != job_status.Incomplete) {
+			continue
+		}
+		if !e.hasData {
+			addRebuildIssue(report, j.Uuid, entryPath, "image data folder missing from repository")
+			continue
+		}
+
+		imgExists, err := image.Exists(j.Uuid)
+		if err != nil {
+			return err
+		}
+		if imgExists {
+			continue
+		}
+
+		report.Images++
+		if report.DryRun {
+			continue
+		}
+		if err := rebuildImage(j); err != nil {
+			return fmt.Errorf("cannot rebuild image '%s': %w", j.Uuid, err)
+		}
+	}
+
+	return nil
+}
+
+// inferDiffReferences links diff backups rebuilt without job record to the image they were most likely computed from,
+// using the same rules as backup setup: last diff image of the same client and module, or last full image if there is none.
+// Entries must be sorted by start time
+func inferDiffReferences(entries []rebuildEntry) {
+	for i := range entries {
+		j := &entries[i].job
+		if !entries[i].inferred || j.JobType.Type != job_type.Backup || j.BackupType.Type != backup_type.Diff {
+			continue
+		}
+
+		var lastDiff, lastFull string
+		for _, prev := range entries[:i] {
+			p := prev.job
+			if p.JobType.Type != job_type.Backup || !prev.hasData || p.Client.Name != j.Client.Name || p.Module.Name != j.Module.Name {
+				continue
+			}
+			if p.Status.Status != job_status.Success && p.Status.Status != job_status.Incomplete {
+				continue
+			}
+			if p.BackupType.Type == backup_type.Diff {
+				lastDiff = p.Uuid
+			} else {
+				lastFull = p.Uuid
+			}
+		}
+
+		if lastDiff != "" {
+			j.PreviousJobUuid = lastDiff
+		} else if lastFull != "" {
+			j.PreviousJobUuid = lastFull
+		} else {
+			// Backup setup switches diff backups without reference to full backups
+			j.BackupType = backup_type.New(backup_type.Full)
+		}
+	}
+}
+
+func rebuildImage(j job.Job) error {
+	img := image.New(j.Client, j.Module, j.Repository)
+	img.Uuid = j.Uuid
+	img.CreatedAt = j.EndTime
+	img.SourceImageUuid = j.SourceImageUuid
+
+	storagePath, err := img.GetStorageFolderPath()
+	if err != nil {
+		return fmt.Errorf("cannot get image storage path: %w", err)
+	}
+	if err := img.FillStats(j.Stats, storagePath); err != nil {
+		return fmt.Errorf("cannot get image stats: %w", err)
+	}
+	if _, err := img.Save(); err != nil {
+		return fmt.Errorf("cannot save image to database: %w", err)
+	}
+
+	if v, err := img.ReadVerification(); err == nil {
+		if err := image.RecordVerification(v); err != nil {
+			img.GetLog().With(slog.Any("error", err)).Error("Cannot restore image verification result")
+		}
+	}
+	if _, ok := img.Repository.(repo.StagedRepository); ok {
+		// Image data is not readable from the local filesystem
+		img.GetLog().Warn("Image stored on a remote repository, image files are not indexed")
+		return nil
+	}
+	if err := img.IndexFiles(); err != nil {
+		img.GetLog().With(slog.Any("error", err)).Error("Cannot index image files")
+	}
+
+	return nil
+}
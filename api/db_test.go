@@ -0,0 +1,248 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/macarrie/relique/internal/backup_type"
+	"github.com/macarrie/relique/internal/client"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/db"
+	"github.com/macarrie/relique/internal/image"
+	"github.com/macarrie/relique/internal/job"
+	"github.com/macarrie/relique/internal/job_status"
+	"github.com/macarrie/relique/internal/job_type"
+	"github.com/macarrie/relique/internal/module"
+	"github.com/macarrie/relique/internal/repo"
+	rsync_lib "github.com/macarrie/relique/internal/rsync_task/lib"
+	"github.com/macarrie/relique/internal/utils"
+)
+
+// setupRebuild points configuration to a temporary catalog and database and returns the local repository jobs are stored in
+func setupRebuild(t *testing.T) *repo.RepositoryLocal {
+	t.Helper()
+
+	viper.SetConfigFile(filepath.Join(t.TempDir(), "relique.toml"))
+	t.Cleanup(func() { viper.SetConfigFile("") })
+	config.Current.CatalogPath = "catalog"
+	if err := os.MkdirAll(config.GetCatalogCfgPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(t.TempDir()); err != nil {
+		t.Fatalf("cannot init test database: %v", err)
+	}
+
+	r := repo.RepoLocalNew("local", t.TempDir(), false)
+	config.Current.Repositories = []repo.Repository{&r}
+
+	return &r
+}
+
+// writeBackup writes the catalog entry and image data of a successful backup job started at start
+func writeBackup(t *testing.T, r *repo.RepositoryLocal, backupType uint8, start time.Time) job.Job {
+	t.Helper()
+
+	mod := module.Module{ModuleType: "generic", Name: "module", BackupType: backup_type.New(backupType)}
+	j := job.NewBackup(client.Client{Name: "client"}, mod, r)
+	j.Status = job_status.New(job_status.Success)
+	j.Done = true
+	j.StartTime = start
+	j.EndTime = start.Add(time.Minute)
+
+	catalogPath := j.GetCatalogPath()
+	if err := os.MkdirAll(catalogPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.SerializeToFile[module.Module](j.Module, fmt.Sprintf("%s/module.toml", catalogPath)); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.SerializeToFile[client.Client](j.Client, fmt.Sprintf("%s/client.toml", catalogPath)); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.WriteDefinition(r, fmt.Sprintf("%s/repo.toml", catalogPath)); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.SerializeToFile[rsync_lib.Stats](rsync_lib.Stats{}, fmt.Sprintf("%s/stats.toml", catalogPath)); err != nil {
+		t.Fatal(err)
+	}
+	// Jobs without record are dated from their catalog files
+	for _, f := range []string{"module.toml", "stats.toml"} {
+		if err := os.Chtimes(filepath.Join(catalogPath, f), start, start); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := r.PrepareImage(j.Uuid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(r.GetStoragePath(j.Uuid), "_data", "file"), []byte(j.Uuid), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return j
+}
+
+func TestDBRebuild(t *testing.T) {
+	r := setupRebuild(t)
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	full := writeBackup(t, r, backup_type.Full, start)
+	diff := writeBackup(t, r, backup_type.Diff, start.Add(time.Hour))
+	diff.PreviousJobUuid = full.Uuid
+	diff.StatusMessage = "saved with record"
+	// Saving jobs writes their record into catalog
+	for _, j := range []*job.Job{&full, &diff} {
+		if _, err := j.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Database is lost
+	if err := db.Init(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	report, err := DBRebuild(false)
+	if err != nil {
+		t.Fatalf("DBRebuild() error = %v", err)
+	}
+	if report.Jobs != 2 || report.Images != 2 || report.Inferred != 0 || len(report.Issues) != 0 {
+		t.Errorf("DBRebuild() report = %+v, want 2 jobs and images rebuilt from records", report)
+	}
+	got, err := job.GetByUuid(diff.Uuid)
+	if err != nil {
+		t.Fatalf("GetByUuid() error = %v", err)
+	}
+	if got.PreviousJobUuid != full.Uuid || got.StatusMessage != diff.StatusMessage || got.Status.Status != job_status.Success {
+		t.Errorf("rebuilt job = %+v, want %+v", got, diff)
+	}
+	if exists, err := image.Exists(diff.Uuid); err != nil || !exists {
+		t.Errorf("image.Exists() = %v, %v, want rebuilt image", exists, err)
+	}
+
+	// Rows already present are left untouched
+	if report, err := DBRebuild(false); err != nil || report.Jobs != 0 || report.Existing != 2 {
+		t.Errorf("second DBRebuild() = %+v, %v, want existing jobs kept", report, err)
+	}
+}
+
+func TestDBRebuild_WithoutRecord(t *testing.T) {
+	r := setupRebuild(t)
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	full := writeBackup(t, r, backup_type.Full, start)
+	diff := writeBackup(t, r, backup_type.Diff, start.Add(time.Hour))
+
+	// Cancelled backups remove their data folder and do not write stats
+	cancelled := writeBackup(t, r, backup_type.Diff, start.Add(2*time.Hour))
+	if err := os.RemoveAll(filepath.Join(r.GetStoragePath(cancelled.Uuid), "_data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(cancelled.GetCatalogPath(), "stats.toml")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := DBRebuild(false)
+	if err != nil {
+		t.Fatalf("DBRebuild() error = %v", err)
+	}
+	if report.Jobs != 3 || report.Images != 2 || report.Inferred != 3 {
+		t.Errorf("DBRebuild() report = %+v, want 3 inferred jobs and 2 images", report)
+	}
+
+	got, err := job.GetByUuid(diff.Uuid)
+	if err != nil {
+		t.Fatalf("GetByUuid() error = %v", err)
+	}
+	if got.JobType.Type != job_type.Backup || got.BackupType.Type != backup_type.Diff || got.PreviousJobUuid != full.Uuid {
+		t.Errorf("rebuilt diff job = %v/%v from %v, want diff backup from %v", got.JobType, got.BackupType, got.PreviousJobUuid, full.Uuid)
+	}
+	got, err = job.GetByUuid(cancelled.Uuid)
+	if err != nil {
+		t.Fatalf("GetByUuid() error = %v", err)
+	}
+	if got.JobType.Type != job_type.Backup || got.Status.Status != job_status.Error {
+		t.Errorf("rebuilt cancelled job = %v %v, want failed backup", got.JobType, got.Status)
+	}
+}
+
+func TestInferDiffReferences(t *testing.T) {
+	backup := func(uuid string, backupType uint8, clientName string, inferred bool, hasData bool) rebuildEntry {
+		return rebuildEntry{
+			job: job.Job{
+				Uuid:       uuid,
+				JobType:    job_type.New(job_type.Backup),
+				BackupType: backup_type.New(backupType),
+				Status:     job_status.New(job_status.Success),
+				Client:     client.Client{Name: clientName},
+				Module:     module.Module{Name: "module"},
+			},
+			inferred: inferred,
+			hasData:  hasData,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		entries      []rebuildEntry
+		wantPrevious string
+		wantType     uint8
+	}{
+		{
+			name:     "first diff becomes full",
+			entries:  []rebuildEntry{backup("diff", backup_type.Diff, "client", true, true)},
+			wantType: backup_type.Full,
+		},
+		{
+			name: "full reference",
+			entries: []rebuildEntry{
+				backup("full", backup_type.Full, "client", true, true),
+				backup("diff", backup_type.Diff, "client", true, true),
+			},
+			wantPrevious: "full",
+			wantType:     backup_type.Diff,
+		},
+		{
+			name: "last diff preferred",
+			entries: []rebuildEntry{
+				backup("full", backup_type.Full, "client", true, true),
+				backup("previous", backup_type.Diff, "client", false, true),
+				backup("diff", backup_type.Diff, "client", true, true),
+			},
+			wantPrevious: "previous",
+			wantType:     backup_type.Diff,
+		},
+		{
+			name: "images of other clients and images without data ignored",
+			entries: []rebuildEntry{
+				backup("full", backup_type.Full, "client", true, true),
+				backup("other", backup_type.Diff, "other", true, true),
+				backup("cancelled", backup_type.Diff, "client", true, false),
+				backup("diff", backup_type.Diff, "client", true, true),
+			},
+			wantPrevious: "full",
+			wantType:     backup_type.Diff,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inferDiffReferences(tt.entries)
+			got := tt.entries[len(tt.entries)-1].job
+			if got.PreviousJobUuid != tt.wantPrevious || got.BackupType.Type != tt.wantType {
+				t.Errorf("inferDiffReferences() = %v from '%v', want %v from '%v'", got.BackupType, got.PreviousJobUuid, backup_type.New(tt.wantType), tt.wantPrevious)
+			}
+		})
+	}
+
+	// Jobs with a record keep their reference
+	entries := []rebuildEntry{
+		backup("full", backup_type.Full, "client", true, true),
+		backup("diff", backup_type.Diff, "client", false, true),
+	}
+	inferDiffReferences(entries)
+	if got := entries[1].job; got.PreviousJobUuid != "" || got.BackupType.Type != backup_type.Diff {
+		t.Errorf("inferDiffReferences() changed job with record to %v from '%v'", got.BackupType, got.PreviousJobUuid)
+	}
+}
@@ -1,13 +1,26 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
 
 	"github.com/macarrie/relique/internal/api_helpers"
 	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/db"
 	"github.com/macarrie/relique/internal/image"
+	"github.com/macarrie/relique/internal/job"
+	"github.com/macarrie/relique/internal/retention"
 )
 
+var ErrImageReferenced = errors.New("image is used as diff reference by other jobs")
+
 func ImageList(p api_helpers.PaginationParams, s api_helpers.ImageSearch) (api_helpers.PaginatedResponse[image.Image], error) {
 	imgCount, err := image.Count()
 	if err != nil {
@@ -34,3 +47,331 @@ func ImageGet(uuid string) (image.Image, error) {
 
 	return img, nil
 }
+
+// ImageListFiles lists the content of a folder inside an image. Image root is used if path is empty
+func ImageListFiles(uuid string, path string, p api_helpers.PaginationParams) (api_helpers.PaginatedResponse[image.File], error) {
+	img, err := image.GetByUuid(uuid)
+	if err != nil {
+		return api_helpers.PaginatedResponse[image.File]{}, fmt.Errorf("cannot get image from db: %w", err)
+	}
+
+	files, err := img.ListFiles(path, p)
+	if err != nil {
+		return api_helpers.PaginatedResponse[image.File]{}, fmt.Errorf("cannot list image files: %w", err)
+	}
+
+	return files, nil
+}
+
+// ImageDiff lists file changes between two images
+func ImageDiff(fromUuid string, toUuid string) (image.Diff, error) {
+	from, err := image.GetByUuid(fromUuid)
+	if err != nil {
+		return image.Diff{}, fmt.Errorf("cannot get image '%s' from db: %w", fromUuid, err)
+	}
+	to, err := image.GetByUuid(toUuid)
+	if err != nil {
+		return image.Diff{}, fmt.Errorf("cannot get image '%s' from db: %w", toUuid, err)
+	}
+
+	diff, err := image.Compare(from, to)
+	if err != nil {
+		return image.Diff{}, fmt.Errorf("cannot compare images: %w", err)
+	}
+
+	return diff, nil
+}
+
+// ImageVerify checks image data against its integrity manifest and records the result on the image
+func ImageVerify(uuid string) (image.Verification, error) {
+	img, err := image.GetByUuid(uuid)
+	if err != nil {
+		return image.Verification{}, fmt.Errorf("cannot get image from db: %w", err)
+	}
+
+	return imageVerify(img)
+}
+
+// ImageScrub verifies integrity of every image having a manifest. Images without manifest are skipped
+func ImageScrub() ([]image.Verification, error) {
+	var errorList *multierror.Error
+	verifications := make([]image.Verification, 0)
+
+	imgs, err := image.Search(api_helpers.PaginationParams{}, api_helpers.ImageSearch{}, config.Current.ModuleInstallPath)
+	if err != nil {
+		return verifications, fmt.Errorf("cannot get images from database: %w", err)
+	}
+
+	for _, img := range imgs {
+		v, err := imageVerify(img)
+		if errors.Is(err, image.ErrNoManifest) {
+			img.GetLog().Warn("Image has no integrity manifest, skipping verification")
+			continue
+		} else if errors.Is(err, image.ErrRemoteRepository) {
+			img.GetLog().Warn("Image is stored on a remote repository, skipping verification")
+			continue
+		} else if err != nil {
+			errorList = multierror.Append(errorList, fmt.Errorf("cannot verify image '%s': %w", img.Uuid, err))
+			continue
+		}
+		verifications = append(verifications, v)
+	}
+
+	return verifications, errorList.ErrorOrNil()
+}
+
+func imageVerify(img image.Image) (image.Verification, error) {
+	v, err := img.Verify()
+	if err != nil {
+		return image.Verification{}, fmt.Errorf("cannot verify image data: %w", err)
+	}
+
+	if err := img.SaveVerification(v); err != nil {
+		img.GetLog().With(
+			slog.Any("error", err),
+		).Error("Cannot save image verification report")
+	}
+	if err := image.RecordVerification(v); err != nil {
+		return v, fmt.Errorf("cannot record image verification: %w", err)
+	}
+
+	return v, nil
+}
+
+// ImagePrune applies configured retention policies on images of every client/module matching the filters, in each repository.
+// Removed images are returned. Nothing is deleted if dryRun is set
+func ImagePrune(clientName string, moduleName string, dryRun bool) ([]image.Image, error) {
+	var errorList *multierror.Error
+	removed := make([]image.Image, 0)
+
+	for _, cl := range config.Current.Clients {
+		if clientName != "" && cl.Name != clientName {
+			continue
+		}
+
+		for _, mod := range cl.Modules {
+			if moduleName != "" && mod.Name != moduleName {
+				continue
+			}
+
+			policy := cl.GetRetentionPolicy(mod)
+			if !policy.IsEnabled() {
+				continue
+			}
+
+			imgs, err := image.Search(api_helpers.PaginationParams{}, api_helpers.ImageSearch{
+				ClientName: cl.Name,
+				ModuleName: mod.Name,
+			}, config.Current.ModuleInstallPath)
+			if err != nil {
+				errorList = multierror.Append(errorList, fmt.Errorf("cannot get images for client '%s' and module '%s': %w", cl.Name, mod.Name, err))
+				continue
+			}
+
+			// Policy is applied separately in each repository so that images copied to other repositories are kept independently from the original ones
+			imgsByRepo := make(map[string][]image.Image)
+			repoNames := make([]string, 0)
+			for _, img := range imgs {
+				if _, ok := imgsByRepo[img.RepoName]; !ok {
+					repoNames = append(repoNames, img.RepoName)
+				}
+				imgsByRepo[img.RepoName] = append(imgsByRepo[img.RepoName], img)
+			}
+			sort.Strings(repoNames)
+
+			for _, repoName := range repoNames {
+				imgByUuid := make(map[string]image.Image)
+				candidates := make([]retention.Candidate, 0, len(imgsByRepo[repoName]))
+				for _, img := range imgsByRepo[repoName] {
+					inUse, err := imageIsUsedByRunningJob(img.Uuid)
+					if err != nil {
+						errorList = multierror.Append(errorList, err)
+						inUse = true
+					}
+					imgByUuid[img.Uuid] = img
+					candidates = append(candidates, retention.Candidate{
+						Uuid:      img.Uuid,
+						CreatedAt: img.CreatedAt,
+						InUse:     inUse,
+					})
+				}
+
+				_, toRemove, err := policy.Apply(candidates, time.Now())
+				if err != nil {
+					errorList = multierror.Append(errorList, fmt.Errorf("cannot apply retention policy for client '%s' and module '%s' in repository '%s': %w", cl.Name, mod.Name, repoName, err))
+					continue
+				}
+
+				for _, c := range toRemove {
+					img := imgByUuid[c.Uuid]
+					if dryRun {
+						img.GetLog().Info("Image would be pruned by retention policy (dry run)")
+						removed = append(removed, img)
+						continue
+					}
+
+					if err := imageDelete(img, "retention", false); err != nil {
+						errorList = multierror.Append(errorList, fmt.Errorf("cannot prune image '%s': %w", img.Uuid, err))
+						continue
+					}
+					img.GetLog().Info("Image pruned by retention policy")
+					removed = append(removed, img)
+				}
+			}
+		}
+	}
+
+	return removed, errorList.ErrorOrNil()
+}
+
+// ImageDelete removes an image from repository, catalog and database.
+// Deletion is refused if other jobs use the image as diff reference, unless force is set
+func ImageDelete(uuid string, force bool) error {
+	img, err := image.GetByUuid(uuid)
+	if err != nil {
+		return fmt.Errorf("cannot get image from db: %w", err)
+	}
+
+	refs, err := job.GetByPreviousJobUuid(img.Uuid)
+	if err != nil {
+		return fmt.Errorf("cannot get jobs referencing image: %w", err)
+	}
+	if len(refs) > 0 && !force {
+		refUuids := make([]string, 0, len(refs))
+		for _, ref := range refs {
+			refUuids = append(refUuids, ref.Uuid)
+		}
+		return fmt.Errorf("%w (%s)", ErrImageReferenced, strings.Join(refUuids, ", "))
+	}
+
+	if err := imageDelete(img, "manual", force); err != nil {
+		return fmt.Errorf("cannot delete image: %w", err)
+	}
+	img.GetLog().Info("Image deleted")
+
+	return nil
+}
+
+// imageIsUsedByRunningJob checks if a running job uses the image as diff reference or copies it to another repository
+func imageIsUsedByRunningJob(uuid string) (bool, error) {
+	refs, err := job.GetByPreviousJobUuid(uuid)
+	if err != nil {
+		return false, fmt.Errorf("cannot get jobs referencing image '%s': %w", uuid, err)
+	}
+
+	copies, err := job.GetBySourceImageUuid(uuid)
+	if err != nil {
+		return false, fmt.Errorf("cannot get jobs copying image '%s': %w", uuid, err)
+	}
+
+	for _, ref := range append(refs, copies...) {
+		if !ref.Done {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// imageDelete removes image data from repository, its catalog folder and related jobs and images rows in database.
+// Jobs using the deleted image as diff reference are linked to the deleted image own reference to keep diff chains consistent.
+// Since diff images are built with hardlinks, their data stays available when the reference image is removed
+func imageDelete(img image.Image, reason string, forced bool) error {
+	inUse, err := imageIsUsedByRunningJob(img.Uuid)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return fmt.Errorf("image is used by a running job")
+	}
+
+	// Get diff reference of the job that generated the image, to relink jobs referencing the deleted image
+	var previousJobUuid string
+	if j, err := job.GetByUuid(img.Uuid); err == nil {
+		previousJobUuid = j.PreviousJobUuid
+	} else {
+		img.GetLog().With(
+			slog.Any("error", err),
+		).Warn("Cannot find job that generated image, jobs referencing this image will lose their diff reference")
+	}
+
+	storagePath, err := img.GetStorageFolderPath()
+	if err != nil {
+		return fmt.Errorf("cannot get image storage path: %w", err)
+	}
+	catalogPath := img.GetCatalogPath()
+
+	// Images sharing data with the deleted image are found while its data is still stored in the repository
+	related, relatedErr := img.GetRelatedSpace()
+	if relatedErr != nil {
+		img.GetLog().With(
+			slog.Any("error", relatedErr),
+		).Error("Cannot find images sharing data with image, their space usage will not be refreshed")
+	}
+
+	tx, err := db.Handler().Begin()
+	if err != nil {
+		return fmt.Errorf("cannot start transaction to delete image: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			img.GetLog().With(
+				slog.Any("error", err),
+			).Debug("Rollback image delete")
+			tx.Rollback()
+		}
+	}()
+
+	if err = job.ReplacePreviousJobUuid(tx, img.Uuid, previousJobUuid); err != nil {
+		return fmt.Errorf("cannot relink jobs referencing image: %w", err)
+	}
+	if err = job.DeleteByUuid(tx, img.Uuid); err != nil {
+		return fmt.Errorf("cannot delete job linked to image: %w", err)
+	}
+	if err = image.DeleteFileIndex(tx, img.Uuid); err != nil {
+		return fmt.Errorf("cannot delete image file index: %w", err)
+	}
+	if err = image.DeleteByUuid(tx, img.Uuid); err != nil {
+		return fmt.Errorf("cannot delete image: %w", err)
+	}
+	if err = image.RecordDeletion(tx, img, reason, forced); err != nil {
+		return fmt.Errorf("cannot record image deletion: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("cannot commit image delete transaction: %w", err)
+	}
+
+	// Files are removed once the image is gone from database, so that a failed transaction never leaves database rows without data.
+	// Files left behind are logged so that they can be removed manually
+	img.GetLog().With(
+		slog.String("path", storagePath),
+	).Debug("Removing image data from repository")
+	if err := img.Repository.DeleteImage(img.Uuid); err != nil {
+		img.GetLog().With(
+			slog.String("path", storagePath),
+			slog.Any("error", err),
+		).Error("Cannot remove image data from repository")
+	}
+
+	img.GetLog().With(
+		slog.String("path", catalogPath),
+	).Debug("Removing image catalog folder")
+	if err := os.RemoveAll(catalogPath); err != nil {
+		img.GetLog().With(
+			slog.String("path", catalogPath),
+			slog.Any("error", err),
+		).Error("Cannot remove image catalog folder")
+	}
+
+	// Files hardlinked or chunks shared with the deleted image may now be used by a single image
+	if relatedErr == nil {
+		if err := related.Refresh(true); err != nil {
+			img.GetLog().With(
+				slog.Any("error", err),
+			).Error("Cannot refresh space usage of related images")
+		}
+	}
+
+	return nil
+}
@@ -1,12 +1,17 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"log/slog"
 
 	"github.com/macarrie/relique/internal/api_helpers"
 	"github.com/macarrie/relique/internal/job"
+	rsync_lib "github.com/macarrie/relique/internal/rsync_task/lib"
 )
 
+var ErrJobNotRunning = errors.New("job is not running")
+
 func JobList(p api_helpers.PaginationParams, s api_helpers.JobSearch) (api_helpers.PaginatedResponse[job.Job], error) {
 	jobCount, err := job.Count(s)
 	if err != nil {
@@ -33,3 +38,89 @@ func JobGet(uuid string) (job.Job, error) {
 
 	return j, nil
 }
+
+// JobProgress gets live progress of a job. Job status is taken from database since the progress file is not updated if the process running the job stopped unexpectedly
+func JobProgress(uuid string) (job.Progress, error) {
+	j, err := job.GetByUuid(uuid)
+	if err != nil {
+		return job.Progress{}, fmt.Errorf("cannot get job from db: %w", err)
+	}
+
+	p, err := job.ReadProgress(uuid)
+	if err != nil {
+		return job.Progress{}, fmt.Errorf("cannot get job progress: %w", err)
+	}
+	p.Status = j.Status
+	p.Done = j.Done
+
+	return p, nil
+}
+
+// JobChanges lists files changed by a job, optionally filtered on change type
+func JobChanges(uuid string, p api_helpers.PaginationParams, changeType string) (api_helpers.PaginatedResponse[rsync_lib.Change], error) {
+	if _, err := job.GetByUuid(uuid); err != nil {
+		return api_helpers.PaginatedResponse[rsync_lib.Change]{}, fmt.Errorf("cannot get job from db: %w", err)
+	}
+
+	changes, err := job.ReadChanges(uuid, p, changeType)
+	if err != nil {
+		return api_helpers.PaginatedResponse[rsync_lib.Change]{}, fmt.Errorf("cannot get job changes: %w", err)
+	}
+
+	return changes, nil
+}
+
+// JobCancel requests cancellation of a running job. Cancellation is asynchronous: the job status is updated by the process running the job once its file sync tasks are stopped
+func JobCancel(uuid string) error {
+	j, err := job.GetByUuid(uuid)
+	if err != nil {
+		return fmt.Errorf("cannot get job from db: %w", err)
+	}
+
+	if j.Done {
+		return fmt.Errorf("%w (status: %s)", ErrJobNotRunning, j.Status.String())
+	}
+
+	if err := j.RequestCancel(); err != nil {
+		return fmt.Errorf("cannot request job cancellation: %w", err)
+	}
+
+	return nil
+}
+
+// JobRecoverStale marks jobs left unfinished by a relique process that stopped unexpectedly as failed. A job is considered orphaned when no live process holds its lock
+func JobRecoverStale() ([]job.Job, error) {
+	jobs, err := job.GetUnfinished()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get unfinished jobs: %w", err)
+	}
+
+	recovered := make([]job.Job, 0)
+	for _, j := range jobs {
+		logger := slog.With(
+			slog.String("uuid", j.Uuid),
+			slog.String("client", j.ClientName),
+			slog.String("module", j.ModuleName),
+			slog.String("job_type", j.JobType.String()),
+			slog.String("status", j.Status.String()),
+		)
+
+		locked, err := job.IsLocked(j.Uuid)
+		if err != nil {
+			logger.With(slog.Any("error", err)).Error("Cannot check if job is still running")
+			continue
+		}
+		if locked {
+			continue
+		}
+
+		if err := job.MarkAsOrphaned(j.Uuid, "job process stopped before job completion"); err != nil {
+			logger.With(slog.Any("error", err)).Error("Cannot mark orphaned job as failed")
+			continue
+		}
+		logger.Warn("Orphaned job found (no running relique process attached to it). Job has been marked as failed")
+		recovered = append(recovered, j)
+	}
+
+	return recovered, nil
+}
@@ -2,11 +2,17 @@ package api
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
 
 	"github.com/macarrie/relique/internal/api_helpers"
 	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/job"
 	"github.com/macarrie/relique/internal/repo"
+	"github.com/macarrie/relique/internal/utils"
 	"github.com/samber/lo"
 )
 
@@ -36,6 +42,60 @@ func RepoGet(name string) (repo.Repository, error) {
 	return repo.GetByName(config.Current.Repositories, name)
 }
 
+// RepoGetDetails returns repository configuration with the space used by the whole repository, and its capacity for repositories able to report it
+func RepoGetDetails(name string) (api_helpers.RepoDetails, error) {
+	r, err := repo.GetByName(config.Current.Repositories, name)
+	if err != nil {
+		return api_helpers.RepoDetails{}, err
+	}
+
+	details := api_helpers.RepoDetails{
+		Repository: r,
+	}
+	if space, err := r.GetSpace(); err != nil {
+		details.SpaceError = err.Error()
+	} else {
+		details.Space = &space
+	}
+	if reporter, ok := r.(repo.CapacityReporter); ok {
+		capacity, err := reporter.GetCapacity()
+		if err != nil {
+			details.CapacityError = err.Error()
+		} else {
+			details.Capacity = &capacity
+		}
+	}
+
+	return details, nil
+}
+
+// RepoGetStatus checks repository storage health and gets its space usage
+func RepoGetStatus(name string) (api_helpers.RepoStatus, error) {
+	r, err := repo.GetByName(config.Current.Repositories, name)
+	if err != nil {
+		return api_helpers.RepoStatus{}, err
+	}
+
+	status := api_helpers.RepoStatus{
+		Name:    r.GetName(),
+		Healthy: true,
+	}
+	if err := r.HealthCheck(); err != nil {
+		status.Healthy = false
+		status.Error = err.Error()
+		return status, nil
+	}
+
+	space, err := r.GetSpace()
+	if err != nil {
+		status.Error = fmt.Sprintf("cannot get space usage: %s", err)
+		return status, nil
+	}
+	status.Space = space
+
+	return status, nil
+}
+
 func RepoCreateLocal(name string, path string, isDefault bool) error {
 	// Check if repository name is already taken
 	if repo, _ := repo.GetByName(config.Current.Repositories, name); repo.GetName() != "" {
@@ -65,6 +125,387 @@ func RepoCreateLocal(name string, path string, isDefault bool) error {
 	if err := os.Mkdir(path, 0755); err != nil {
 		return fmt.Errorf("cannot create local repository folder '%s': %w", path, err)
 	}
+	if err := r.WriteMetadata(); err != nil {
+		return fmt.Errorf("cannot write repository metadata: %w", err)
+	}
+
+	return nil
+}
+
+// RepoCreateSSH registers a repository stored on a remote host reached over SSH and creates its folder on the remote host
+func RepoCreateSSH(name string, host string, user string, port int, path string, stagingPath string, isDefault bool) error {
+	// Check if repository name is already taken
+	if repo, _ := repo.GetByName(config.Current.Repositories, name); repo.GetName() != "" {
+		return fmt.Errorf("a repository of same name already exists ('%s')", repo.GetName())
+	}
+
+	// Check if a default repository already exists
+	if isDefault {
+		if repo, _ := repo.GetDefault(config.Current.Repositories); repo.GetName() != "" {
+			return fmt.Errorf("a default repository already exists ('%s')", repo.GetName())
+		}
+	}
+
+	r := repo.RepoRemoteSSHNew(name, host, user, port, path, isDefault)
+	r.StagingPath = stagingPath
+
+	// Check if path already exists. This also checks that the remote host can be reached
+	exists, err := r.Exists(path)
+	if err != nil {
+		return fmt.Errorf("cannot reach repository host: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("specified folder '%s' already exists on '%s', aborting repository creation to avoid polluting folder", path, host)
+	}
+
+	// Save repo to config file
+	if err := r.Write(config.GetReposCfgPath()); err != nil {
+		return fmt.Errorf("cannot write repository configuration to file: %w", err)
+	}
+
+	// Create repo folder
+	if _, err := r.Run("mkdir", "-p", "--", path); err != nil {
+		return fmt.Errorf("cannot create repository folder '%s' on '%s': %w", path, host, err)
+	}
+
+	return nil
+}
+
+// RepoCreateS3 registers a repository stored in an S3 compatible bucket. Image data is encrypted before being sent to the bucket if a passphrase or a key file is set
+func RepoCreateS3(name string, endpoint string, region string, bucket string, prefix string, accessKey string, secretKey string, stagingPath string, passphrase string, keyFile string, isDefault bool) error {
+	// Check if repository name is already taken
+	if repo, _ := repo.GetByName(config.Current.Repositories, name); repo.GetName() != "" {
+		return fmt.Errorf("a repository of same name already exists ('%s')", repo.GetName())
+	}
+
+	// Check if a default repository already exists
+	if isDefault {
+		if repo, _ := repo.GetDefault(config.Current.Repositories); repo.GetName() != "" {
+			return fmt.Errorf("a default repository already exists ('%s')", repo.GetName())
+		}
+	}
+
+	if passphrase != "" && keyFile != "" {
+		return fmt.Errorf("encryption passphrase and key file cannot be both set")
+	}
+
+	r := repo.RepoS3New(name, endpoint, region, bucket, prefix, accessKey, secretKey, isDefault)
+	r.StagingPath = stagingPath
+	r.SetEncryption(passphrase, keyFile)
+
+	// Check if prefix already holds objects. This also checks that the bucket can be reached with provided credentials
+	root := r.GetStoragePath("")
+	exists, err := r.Exists(root)
+	if err != nil {
+		return fmt.Errorf("cannot reach bucket '%s': %w", bucket, err)
+	}
+	if exists {
+		return fmt.Errorf("bucket '%s' already contains objects under prefix '%s', aborting repository creation to avoid polluting bucket", bucket, root)
+	}
+
+	// Save repo to config file
+	if err := r.Write(config.GetReposCfgPath()); err != nil {
+		return fmt.Errorf("cannot write repository configuration to file: %w", err)
+	}
+
+	if r.IsEncrypted() {
+		if err := r.InitEncryption(); err != nil {
+			return fmt.Errorf("cannot initialize repository encryption: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// RepoCreateArchive registers a local repository storing images in compressed archive format
+func RepoCreateArchive(name string, path string, stagingPath string, isDefault bool) error {
+	// Check if repository name is already taken
+	if repo, _ := repo.GetByName(config.Current.Repositories, name); repo.GetName() != "" {
+		return fmt.Errorf("a repository of same name already exists ('%s')", repo.GetName())
+	}
+
+	// Check if a default repository already exists
+	if isDefault {
+		if repo, _ := repo.GetDefault(config.Current.Repositories); repo.GetName() != "" {
+			return fmt.Errorf("a default repository already exists ('%s')", repo.GetName())
+		}
+	}
+
+	// Check if path already exists
+	if _, err := os.Stat(path); err == nil && !os.IsNotExist(err) {
+		return fmt.Errorf("specified folder '%s' already exists, aborting archive repository creation to avoid polluting folder", path)
+	}
+
+	r := repo.RepoArchiveNew(name, path, isDefault)
+	r.StagingPath = stagingPath
+
+	// Save repo to config file
+	if err := r.Write(config.GetReposCfgPath()); err != nil {
+		return fmt.Errorf("cannot write repository configuration to file: %w", err)
+	}
+
+	// Create repo folder
+	if err := os.Mkdir(path, 0755); err != nil {
+		return fmt.Errorf("cannot create archive repository folder '%s': %w", path, err)
+	}
+
+	return nil
+}
+
+// RepoCreateDedup registers a local repository storing image data as content-addressed chunks shared by every image of the repository
+func RepoCreateDedup(name string, path string, stagingPath string, isDefault bool) error {
+	// Check if repository name is already taken
+	if repo, _ := repo.GetByName(config.Current.Repositories, name); repo.GetName() != "" {
+		return fmt.Errorf("a repository of same name already exists ('%s')", repo.GetName())
+	}
+
+	// Check if a default repository already exists
+	if isDefault {
+		if repo, _ := repo.GetDefault(config.Current.Repositories); repo.GetName() != "" {
+			return fmt.Errorf("a default repository already exists ('%s')", repo.GetName())
+		}
+	}
+
+	// Check if path already exists
+	if _, err := os.Stat(path); err == nil && !os.IsNotExist(err) {
+		return fmt.Errorf("specified folder '%s' already exists, aborting dedup repository creation to avoid polluting folder", path)
+	}
+
+	r := repo.RepoDedupNew(name, path, isDefault)
+	r.StagingPath = stagingPath
+
+	// Save repo to config file
+	if err := r.Write(config.GetReposCfgPath()); err != nil {
+		return fmt.Errorf("cannot write repository configuration to file: %w", err)
+	}
+
+	// Create repo folder
+	if err := os.Mkdir(path, 0755); err != nil {
+		return fmt.Errorf("cannot create dedup repository folder '%s': %w", path, err)
+	}
+
+	return nil
+}
+
+// RepoCreateEncrypted registers a local repository whose image data is encrypted with a repository key protected by a passphrase or a key file
+func RepoCreateEncrypted(name string, path string, passphrase string, keyFile string, stagingPath string, isDefault bool) error {
+	// Check if repository name is already taken
+	if repo, _ := repo.GetByName(config.Current.Repositories, name); repo.GetName() != "" {
+		return fmt.Errorf("a repository of same name already exists ('%s')", repo.GetName())
+	}
+
+	// Check if a default repository already exists
+	if isDefault {
+		if repo, _ := repo.GetDefault(config.Current.Repositories); repo.GetName() != "" {
+			return fmt.Errorf("a default repository already exists ('%s')", repo.GetName())
+		}
+	}
+
+	if (passphrase == "") == (keyFile == "") {
+		return fmt.Errorf("either an encryption passphrase or a key file is needed")
+	}
+
+	// Check if path already exists
+	if _, err := os.Stat(path); err == nil && !os.IsNotExist(err) {
+		return fmt.Errorf("specified folder '%s' already exists, aborting encrypted repository creation to avoid polluting folder", path)
+	}
+
+	r := repo.RepoEncryptedNew(name, path, passphrase, keyFile, isDefault)
+	r.StagingPath = stagingPath
+
+	// Save repo to config file
+	if err := r.Write(config.GetReposCfgPath()); err != nil {
+		return fmt.Errorf("cannot write repository configuration to file: %w", err)
+	}
+
+	// Create repo folder and repository key
+	if err := os.Mkdir(path, 0700); err != nil {
+		return fmt.Errorf("cannot create encrypted repository folder '%s': %w", path, err)
+	}
+	if err := r.InitEncryption(); err != nil {
+		return fmt.Errorf("cannot initialize repository encryption: %w", err)
+	}
+
+	return nil
+}
+
+// RepoChangePassphrase protects the key of an encrypted repository with a new passphrase or key file and revokes the previous ones.
+// The repository key itself does not change, so image data is not encrypted again and stays readable with the repository key if it was disclosed, RepoRotateKey replaces it. Repository configuration is updated with the new passphrase or key file, and catalog entries of the repository images with the new key file
+func RepoChangePassphrase(name string, passphrase string, keyFile string) error {
+	r, err := repo.GetByName(config.Current.Repositories, name)
+	if err != nil {
+		return err
+	}
+	encryptedRepo, ok := r.(repo.EncryptedRepository)
+	if !ok || !encryptedRepo.IsEncrypted() {
+		return fmt.Errorf("repository '%s' is not encrypted", name)
+	}
+
+	keyID, err := encryptedRepo.AddKey(passphrase, keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot add new repository key: %w", err)
+	}
+
+	// Previous keys are kept until every reference to the previous passphrase or key file is updated, so that a failure does not lock images out
+	encryptedRepo.SetEncryption(passphrase, keyFile)
+	if err := encryptedRepo.Write(config.GetReposCfgPath()); err != nil {
+		return fmt.Errorf("cannot write repository configuration to file, previous passphrase or key file is still valid: %w", err)
+	}
+	if err := updateCatalogRepository(encryptedRepo); err != nil {
+		return fmt.Errorf("cannot update catalog, previous passphrase or key file is still valid: %w", err)
+	}
+
+	if err := encryptedRepo.RemoveOtherKeys(keyID); err != nil {
+		return fmt.Errorf("cannot remove previous repository keys: %w", err)
+	}
+	slog.With(
+		slog.String("repository", name),
+		slog.String("key", keyID),
+	).Info("Repository passphrase changed")
 
 	return nil
 }
+
+// RepoRotateKey replaces the key of an encrypted repository with a new one, encrypts every chunk, index and image file again with it, then retires the previous key.
+// The new key is protected by the configured passphrase or key file only. An interrupted rotation leaves the repository locked until it is resumed by rotating the key again
+func RepoRotateKey(name string) error {
+	r, err := repo.GetByName(config.Current.Repositories, name)
+	if err != nil {
+		return err
+	}
+	encryptedRepo, ok := r.(repo.EncryptedRepository)
+	if !ok || !encryptedRepo.IsEncrypted() {
+		return fmt.Errorf("repository '%s' is not encrypted", name)
+	}
+
+	keyID, err := encryptedRepo.RotateKey()
+	if err != nil {
+		return fmt.Errorf("cannot rotate repository key: %w", err)
+	}
+	slog.With(
+		slog.String("repository", name),
+		slog.String("key", keyID),
+	).Info("Repository key rotated")
+
+	return nil
+}
+
+// updateCatalogRepository replaces the repository definition stored in catalog entries of jobs using r. Credentials saved in catalog entries by older versions are removed
+func updateCatalogRepository(r repo.Repository) error {
+	dirs, err := os.ReadDir(config.GetCatalogCfgPath())
+	if err != nil {
+		return fmt.Errorf("cannot read catalog folder: %w", err)
+	}
+
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+
+		repoFilePath := fmt.Sprintf("%s/repo.toml", utils.GetCatalogPath(d.Name()))
+		catalogRepo, err := repo.LoadFromFile(repoFilePath)
+		if err != nil || catalogRepo.GetName() != r.GetName() || catalogRepo.GetType() != r.GetType() {
+			continue
+		}
+		if err := repo.WriteDefinition(r, repoFilePath); err != nil {
+			return fmt.Errorf("cannot update repository of catalog entry '%s': %w", d.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// RepoImportLocal registers an existing local repository, possibly coming from another relique server, and imports its images.
+// Repository name is read from repository metadata if not specified. Images already present in catalog are not imported again
+func RepoImportLocal(path string, name string, isDefault bool) (api_helpers.RebuildReport, error) {
+	report := api_helpers.RebuildReport{
+		Issues: make([]api_helpers.RebuildIssue, 0),
+	}
+
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return report, fmt.Errorf("cannot get repository absolute path: %w", err)
+	}
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return report, fmt.Errorf("cannot find repository folder '%s'", path)
+	}
+
+	meta, err := repo.LoadMetadata(path)
+	if err != nil {
+		return report, fmt.Errorf("cannot load repository metadata: %w", err)
+	}
+	if name == "" {
+		name = meta.Name
+	}
+	if name == "" {
+		return report, fmt.Errorf("repository has no metadata file, a repository name must be specified")
+	}
+	if meta.Type != "" && meta.Type != "local" {
+		return report, fmt.Errorf("cannot import repository of type '%s' as a local repository", meta.Type)
+	}
+
+	var r *repo.RepositoryLocal
+	if existing, _ := repo.GetByName(config.Current.Repositories, name); existing.GetName() != "" {
+		// Importing a repository that is already registered with the same path only imports missing images
+		localRepo, ok := existing.(*repo.RepositoryLocal)
+		if !ok || filepath.Clean(localRepo.Path) != path {
+			return report, fmt.Errorf("a repository of same name already exists ('%s')", existing.GetName())
+		}
+		r = localRepo
+	} else {
+		if isDefault {
+			if def, _ := repo.GetDefault(config.Current.Repositories); def.GetName() != "" {
+				return report, fmt.Errorf("a default repository already exists ('%s')", def.GetName())
+			}
+		}
+
+		newRepo := repo.RepoLocalNew(name, path, isDefault)
+		r = &newRepo
+		if err := r.Write(config.GetReposCfgPath()); err != nil {
+			return report, fmt.Errorf("cannot write repository configuration to file: %w", err)
+		}
+		config.Current.Repositories = append(config.Current.Repositories, r)
+		r.GetLog().Info("Repository registered")
+	}
+	if err := r.WriteMetadata(); err != nil {
+		r.GetLog().With(slog.Any("error", err)).Warn("Cannot write repository metadata")
+	}
+
+	dirs, err := os.ReadDir(path)
+	if err != nil {
+		return report, fmt.Errorf("cannot read repository folder: %w", err)
+	}
+
+	imported := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		if _, err := uuid.Parse(d.Name()); err != nil {
+			continue
+		}
+		storagePath := fmt.Sprintf("%s/%s", path, d.Name())
+
+		catalogEntry := job.Job{Uuid: d.Name()}
+		if _, err := os.Stat(catalogEntry.GetCatalogPath()); err == nil {
+			report.Existing++
+			continue
+		}
+
+		if _, err := os.Stat(fmt.Sprintf("%s/%s", storagePath, repo.METADATA_FOLDER)); err != nil {
+			addRebuildIssue(&report, d.Name(), storagePath, "no image metadata found in repository, data folder cannot be imported")
+			continue
+		}
+		if err := job.ImportMetadata(d.Name(), storagePath, r); err != nil {
+			addRebuildIssue(&report, d.Name(), storagePath, fmt.Sprintf("cannot import image metadata: %s", err))
+			continue
+		}
+		imported = append(imported, d.Name())
+	}
+
+	if err := rebuildFromCatalog(imported, &report); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/macarrie/relique/internal/backup_type"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/db"
+	"github.com/macarrie/relique/internal/image"
+	"github.com/macarrie/relique/internal/job"
+	"github.com/macarrie/relique/internal/repo"
+)
+
+func TestRepoImportLocal(t *testing.T) {
+	r := setupRebuild(t)
+	if err := os.MkdirAll(config.GetReposCfgPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	full := writeBackup(t, r, backup_type.Full, start)
+	diff := writeBackup(t, r, backup_type.Diff, start.Add(time.Hour))
+	diff.PreviousJobUuid = full.Uuid
+	for _, j := range []*job.Job{&full, &diff} {
+		if err := os.Mkdir(filepath.Join(r.GetStoragePath(j.Uuid), repo.METADATA_FOLDER), 0755); err != nil {
+			t.Fatal(err)
+		}
+		// Saving a job exports its record along with catalog files into image metadata
+		if _, err := j.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Repository disk is attached to another relique server
+	if err := os.RemoveAll(config.GetCatalogCfgPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(config.GetCatalogCfgPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Init(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	config.Current.Repositories = nil
+
+	report, err := RepoImportLocal(r.Path, "", false)
+	if err != nil {
+		t.Fatalf("RepoImportLocal() error = %v", err)
+	}
+	if report.Jobs != 2 || report.Images != 2 || report.Inferred != 0 || len(report.Issues) != 0 {
+		t.Errorf("RepoImportLocal() report = %+v, want 2 jobs and images imported from records", report)
+	}
+	if imported, err := repo.GetByName(config.Current.Repositories, r.Name); err != nil || imported.GetName() != r.Name {
+		t.Errorf("RepoImportLocal() registered repository = %v, %v, want '%s'", imported, err, r.Name)
+	}
+	got, err := job.GetByUuid(diff.Uuid)
+	if err != nil {
+		t.Fatalf("GetByUuid() error = %v", err)
+	}
+	if got.PreviousJobUuid != full.Uuid || got.BackupType.Type != backup_type.Diff {
+		t.Errorf("imported job = %v from '%v', want diff from '%v'", got.BackupType, got.PreviousJobUuid, full.Uuid)
+	}
+	if exists, err := image.Exists(full.Uuid); err != nil || !exists {
+		t.Errorf("image.Exists() = %v, %v, want imported image", exists, err)
+	}
+
+	// Images already in catalog are not imported again
+	if report, err := RepoImportLocal(r.Path, "", false); err != nil || report.Jobs != 0 || report.Existing != 2 {
+		t.Errorf("second RepoImportLocal() = %+v, %v, want existing images skipped", report, err)
+	}
+}
@@ -2,25 +2,107 @@ package api
 
 import (
 	"fmt"
+	"log/slog"
+	"strings"
 
+	"github.com/macarrie/relique/internal/api_helpers"
 	"github.com/macarrie/relique/internal/client"
 	"github.com/macarrie/relique/internal/image"
 	"github.com/macarrie/relique/internal/job"
 	"github.com/macarrie/relique/internal/utils"
 )
 
+// RestoreResolve gets source image and target client for a restore, and applies inclusions/exclusions overrides on image module
+func RestoreResolve(p api_helpers.RestoreParams) (image.Image, client.Client, error) {
+	img, err := image.GetByUuid(p.ImageUuid)
+	if err != nil {
+		return image.Image{}, client.Client{}, fmt.Errorf("cannot find image: %w", err)
+	}
+
+	// Override module with provided params
+	if len(p.Include) > 0 {
+		slog.With(
+			slog.String("module", img.Module.Name),
+			slog.String("override_include", strings.Join(p.Include, ", ")),
+			slog.String("mod_include", strings.Join(img.Module.Include, ", ")),
+		).Info("Override module include list with the one provided in params")
+		img.Module.Include = p.Include
+	}
+	if len(p.Exclude) > 0 {
+		slog.With(
+			slog.String("module", img.Module.Name),
+			slog.String("override_exclude", strings.Join(p.Exclude, ", ")),
+			slog.String("mod_exclude", strings.Join(img.Module.Exclude, ", ")),
+		).Info("Override module exclusions list with the one provided in params")
+		img.Module.Exclude = p.Exclude
+	}
+	if p.ExcludeCVS {
+		slog.With(
+			slog.String("module", img.Module.Name),
+			slog.Bool("override_exclude_cvs", p.ExcludeCVS),
+			slog.Bool("mod_exclude_cvs", img.Module.ExcludeCVS),
+		).Info("Override module exclude_cvs parameter")
+		img.Module.ExcludeCVS = p.ExcludeCVS
+	}
+
+	c, err := ClientGet(p.ClientName)
+	if err != nil {
+		return image.Image{}, client.Client{}, fmt.Errorf("cannot find client: %w", err)
+	}
+
+	return img, c, nil
+}
+
 func RestoreStart(targetClient client.Client, img image.Image, rawCustomPathRestore []string) error {
-	if err := ClientSSHPing(targetClient); err != nil {
-		return fmt.Errorf("cannot start restore on unreachable client:  %w", err)
+	j, err := restoreSetup(targetClient, img, rawCustomPathRestore)
+	if err != nil {
+		return err
 	}
 
+	return restoreRun(&j)
+}
+
+// RestoreStartAsync registers the restore job and runs it in background. The registered job is returned as soon as its setup is complete
+func RestoreStartAsync(targetClient client.Client, img image.Image, rawCustomPathRestore []string) (job.Job, error) {
+	j, err := restoreSetup(targetClient, img, rawCustomPathRestore)
+	if err != nil {
+		return job.Job{}, err
+	}
+
+	go func(j job.Job) {
+		if err := restoreRun(&j); err != nil {
+			j.GetLog().With(
+				slog.Any("error", err),
+			).Error("Error during restore job")
+		}
+	}(j)
+
+	return j, nil
+}
+
+func restoreSetup(targetClient client.Client, img image.Image, rawCustomPathRestore []string) (job.Job, error) {
 	restorePaths := utils.GenerateCustomRestorePaths(rawCustomPathRestore, img.Module.BackupPaths)
 
 	j := job.NewRestore(img, targetClient, restorePaths)
 	if err := j.SetupRestore(); err != nil {
-		return fmt.Errorf("cannot setup job:  %w", err)
+		if failErr := j.Fail(fmt.Sprintf("job setup failed: %s", err)); failErr != nil {
+			j.GetLog().With(slog.Any("error", failErr)).Error("Cannot mark job as failed")
+		}
+		return job.Job{}, fmt.Errorf("cannot setup job:  %w", err)
+	}
+
+	return j, nil
+}
+
+func restoreRun(j *job.Job) error {
+	if err := ClientSSHPing(j.Client); err != nil {
+		if err := j.Fail(fmt.Sprintf("client unreachable: %s", err)); err != nil {
+			return fmt.Errorf("cannot save job info after failed client ping: %w", err)
+		}
+		return fmt.Errorf("cannot start restore on unreachable client:  %w", err)
 	}
 
+	j.GetLog().Info("Starting job file sync")
 	if err := j.Start(); err != nil {
 		return fmt.Errorf("error encountered during job execution: %w", err)
 	}
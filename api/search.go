@@ -0,0 +1,31 @@
+package api
+
+import (
+	"fmt"
+
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/image"
+)
+
+// Search looks for files matching pattern in indexed images
+func Search(p api_helpers.PaginationParams, s api_helpers.FileSearch) (api_helpers.PaginatedResponse[image.IndexedFile], error) {
+	if s.Pattern == "" {
+		return api_helpers.PaginatedResponse[image.IndexedFile]{}, fmt.Errorf("empty search pattern")
+	}
+
+	count, err := image.CountFiles(s)
+	if err != nil {
+		return api_helpers.PaginatedResponse[image.IndexedFile]{}, fmt.Errorf("cannot count matching files: %w", err)
+	}
+
+	files, err := image.SearchFiles(p, s)
+	if err != nil {
+		return api_helpers.PaginatedResponse[image.IndexedFile]{}, fmt.Errorf("cannot search files in index: %w", err)
+	}
+
+	return api_helpers.PaginatedResponse[image.IndexedFile]{
+		Count:      count,
+		Pagination: p,
+		Data:       files,
+	}, nil
+}
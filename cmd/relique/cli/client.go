@@ -109,6 +109,8 @@ Port: 	{{.SSHPort}}
 | Variant | {{ if .Variant | eq "" }} default {{ else }}{{ .Variant }}{{ end }} |
 | Available variants | {{ if .AvailableVariants | len | eq 0 }} default {{ else }}{{ .Variant }}{{ end }}{{ join .AvailableVariants ", " }} |
 | Backup paths | {{ join .BackupPaths ", " }} |
+| Schedule | {{ if .Schedule.Cron }}{{ .Schedule.Cron }}{{ else if .Schedule.Interval }}every {{ .Schedule.Interval }}{{ else }}none{{ end }} |
+| Hooks | {{ if or .PreBackup.Script .PreBackup.Inline }}pre_backup {{ end }}{{ if or .PostBackup.Script .PostBackup.Inline }}post_backup {{ end }}{{ if or .PreRestore.Script .PreRestore.Inline }}pre_restore {{ end }}{{ if or .PostRestore.Script .PostRestore.Inline }}post_restore{{ end }} |
 
 {{ end }}
 `
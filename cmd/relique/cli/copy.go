@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/macarrie/relique/api"
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/db"
+	"github.com/spf13/cobra"
+)
+
+var copyImageId string
+var copyRepo string
+
+func init() {
+	copyCmd := &cobra.Command{
+		Use:   "copy",
+		Short: "Copy an image into another repository",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			_, err := api.ConfigGet()
+			if err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot get relique configuration")
+				os.Exit(1)
+			}
+
+			if err := db.Init(config.GetDBPath()); err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot initialize database connection")
+				os.Exit(1)
+			}
+
+			if _, err := api.JobRecoverStale(); err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot recover orphaned jobs")
+			}
+
+			img, r, err := api.CopyResolve(api_helpers.CopyParams{
+				ImageUuid: copyImageId,
+				RepoName:  copyRepo,
+			})
+			if err != nil {
+				slog.With(
+					slog.Any("error", err),
+					slog.String("image_uuid", copyImageId),
+					slog.String("repository", copyRepo),
+				).Error("Cannot prepare copy")
+				os.Exit(1)
+			}
+
+			if err := api.CopyStart(img, r); err != nil {
+				slog.With(
+					slog.Any("error", err),
+					slog.String("image", img.Uuid),
+					slog.String("repository", r.GetName()),
+				).Error("Error during copy job")
+				os.Exit(1)
+			}
+		},
+	}
+	copyCmd.Flags().StringVarP(&copyImageId, "image", "", "", "Image to copy")
+	copyCmd.Flags().StringVarP(&copyRepo, "repo", "r", "", "Repository to copy image into")
+	copyCmd.MarkFlagRequired("image")
+	copyCmd.MarkFlagRequired("repo")
+
+	rootCmd.AddCommand(copyCmd)
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/InVisionApp/tabular"
+	"github.com/macarrie/relique/api"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/db"
+	"github.com/spf13/cobra"
+)
+
+var dbRebuildDryRun bool
+
+func init() {
+	dbCmd := &cobra.Command{
+		Use:   "db",
+		Short: "Database related commands",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			_, err := api.ConfigGet()
+			if err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot get relique configuration")
+				os.Exit(1)
+			}
+
+			// Orphaned jobs recovery is not performed here, unfinished jobs are handled by the rebuild itself
+			if err := db.Init(config.GetDBPath()); err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot initialize database connection")
+				os.Exit(1)
+			}
+		},
+	}
+
+	dbRebuildCmd := &cobra.Command{
+		Use:   "rebuild",
+		Short: "Rebuild jobs and images from catalog and repositories contents",
+		Long: `Rebuild jobs and images from catalog and repositories contents.
+
+Jobs and images found in catalog but missing from database are inserted back. Rows already present in database are left untouched.
+Inconsistencies between catalog and repositories, such as data folders with no catalog entry, are reported.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			report, err := api.DBRebuild(dbRebuildDryRun)
+			if err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot rebuild database")
+				os.Exit(1)
+			}
+
+			if len(report.Issues) > 0 {
+				tab := tabular.New()
+				tab.Col("uuid", "UUID", 40)
+				tab.Col("problem", "Problem", 50)
+				tab.Col("path", "Path", 60)
+
+				format := tab.Print("uuid", "problem", "path")
+				for _, i := range report.Issues {
+					fmt.Printf(format, i.Uuid, i.Problem, i.Path)
+				}
+				fmt.Println()
+			}
+
+			verb := "Restored"
+			if report.DryRun {
+				verb = "Would restore"
+			}
+			fmt.Printf("%s %d jobs and %d images (%d jobs inferred without job record). %d catalog entries already in database, %d issues found\n",
+				verb,
+				report.Jobs,
+				report.Images,
+				report.Inferred,
+				report.Existing,
+				len(report.Issues),
+			)
+		},
+	}
+	dbRebuildCmd.Flags().BoolVarP(&dbRebuildDryRun, "dry-run", "n", false, "Report what would be restored without writing to database")
+
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbRebuildCmd)
+}
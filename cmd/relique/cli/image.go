@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -11,6 +12,7 @@ import (
 	"github.com/macarrie/relique/internal/api_helpers"
 	"github.com/macarrie/relique/internal/config"
 	"github.com/macarrie/relique/internal/db"
+	"github.com/macarrie/relique/internal/image"
 	"github.com/macarrie/relique/internal/utils"
 	"github.com/spf13/cobra"
 )
@@ -18,6 +20,69 @@ import (
 var imageListPageSize int
 var imageListSearchModule string
 var imageListSearchClient string
+var imageLsPageSize int
+var imageLsOffset int
+var imagePruneClient string
+var imagePruneModule string
+var imagePruneDryRun bool
+var imagePruneAssumeYes bool
+var imageDeleteForce bool
+var imageDeleteAssumeYes bool
+
+func printImageTable(imgs []image.Image) {
+	tab := tabular.New()
+	tab.Col("uuid", "UUID", 40)
+	tab.Col("client", "Client", 25)
+	tab.Col("module", "Module", 15)
+	tab.Col("date", "Date", 20)
+	tab.Col("size", "Size", 20)
+
+	format := tab.Print("uuid", "client", "module", "date", "size")
+	for _, img := range imgs {
+		fmt.Printf(
+			format,
+			img.Uuid,
+			img.Client.String(),
+			img.Module.String(),
+			utils.FormatDatetime(img.CreatedAt),
+			humanize.Bytes(img.SizeOnDisk),
+		)
+	}
+}
+
+func formatSizeDelta(delta int64) string {
+	if delta < 0 {
+		return fmt.Sprintf("-%s", humanize.Bytes(uint64(-delta)))
+	}
+
+	return fmt.Sprintf("+%s", humanize.Bytes(uint64(delta)))
+}
+
+func printVerification(v image.Verification) {
+	tab := tabular.New()
+	tab.Col("status", "Status", 10)
+	tab.Col("path", "Path", 60)
+
+	format := tab.Print("status", "path")
+	for _, p := range v.Missing {
+		fmt.Printf(format, "missing", p)
+	}
+	for _, p := range v.Changed {
+		fmt.Printf(format, "changed", p)
+	}
+	for _, p := range v.Extra {
+		fmt.Printf(format, "extra", p)
+	}
+
+	fmt.Printf("\nImage %s: %s. %d files checked, %d missing, %d changed, %d extra\n",
+		v.ImageUuid,
+		v.Status,
+		v.CheckedFiles,
+		len(v.Missing),
+		len(v.Changed),
+		len(v.Extra),
+	)
+}
 
 func init() {
 	imageCmd := &cobra.Command{
@@ -38,6 +103,12 @@ func init() {
 				).Error("Cannot initialize database connection")
 				os.Exit(1)
 			}
+
+			if _, err := api.JobRecoverStale(); err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot recover orphaned jobs")
+			}
 		},
 	}
 
@@ -61,24 +132,7 @@ func init() {
 				os.Exit(1)
 			}
 
-			tab := tabular.New()
-			tab.Col("uuid", "UUID", 40)
-			tab.Col("client", "Client", 25)
-			tab.Col("module", "Module", 15)
-			tab.Col("date", "Date", 20)
-			tab.Col("size", "Size", 20)
-
-			format := tab.Print("uuid", "client", "module", "date", "size")
-			for _, img := range imageList.Data {
-				fmt.Printf(
-					format,
-					img.Uuid,
-					img.Client.String(),
-					img.Module.String(),
-					utils.FormatDatetime(img.CreatedAt),
-					humanize.Bytes(img.SizeOnDisk),
-				)
-			}
+			printImageTable(imageList.Data)
 
 			fmt.Printf("\nShowing %d out of %d records\n", len(imageList.Data), imageList.Count)
 		},
@@ -130,11 +184,21 @@ Repository type: 	{{ .Repository.Type }}
 
 Size on disk: {{ file_size .SizeOnDisk}}
 
+Unique size: {{ file_size .UniqueSize}}
+
+Shared size: {{ file_size .SharedSize}}
+
+New data: {{ file_size .NewDataSize}} (deduplication ratio: {{ percent .DedupRatio}})
+
 Number of elements: {{ .NumberOfElements}}
 
 Files: {{ .NumberOfFiles}}
 
 Directories: {{ .NumberOfFolders}}
+
+## Integrity
+
+{{ if .VerificationStatus }}Last verification: {{ .VerificationStatus }} ({{ datetime .VerifiedAt }}){{ else }}Last verification: never{{ end }}
 `
 
 			render, err := utils.RenderTemplateToMarkdown("image_details", imageDetailsTemplate, img)
@@ -148,7 +212,265 @@ Directories: {{ .NumberOfFolders}}
 		},
 	}
 
+	imageLsCmd := &cobra.Command{
+		Use:   "ls UUID [PATH]",
+		Short: "List files stored in image",
+		Long:  "List files and folders stored in image. PATH is the path of the folder on the client, image root is used if not specified",
+		Args:  cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			path := "/"
+			if len(args) == 2 {
+				path = args[1]
+			}
+			page := api_helpers.PaginationParams{
+				Limit:  uint64(imageLsPageSize),
+				Offset: uint64(imageLsOffset),
+			}
+
+			files, err := api.ImageListFiles(args[0], path, page)
+			if err != nil {
+				slog.With(
+					slog.String("image", args[0]),
+					slog.String("path", path),
+					slog.Any("error", err),
+				).Error("Cannot list image files")
+				os.Exit(1)
+			}
+
+			tab := tabular.New()
+			tab.Col("mode", "Mode", 12)
+			tab.Col("owner", "UID:GID", 12)
+			tab.Col("size", "Size", 10)
+			tab.Col("mtime", "Modification time", 20)
+			tab.Col("name", "Name", 40)
+
+			format := tab.Print("mode", "owner", "size", "mtime", "name")
+			for _, f := range files.Data {
+				name := f.Name
+				if f.IsDir {
+					name = fmt.Sprintf("%s/", f.Name)
+				} else if f.LinkTarget != "" {
+					name = fmt.Sprintf("%s -> %s", f.Name, f.LinkTarget)
+				}
+
+				fmt.Printf(
+					format,
+					f.Mode,
+					fmt.Sprintf("%d:%d", f.Uid, f.Gid),
+					humanize.Bytes(uint64(f.Size)),
+					utils.FormatDatetime(f.ModTime),
+					name,
+				)
+			}
+
+			fmt.Printf("\nShowing %d out of %d records\n", len(files.Data), files.Count)
+		},
+	}
+	utils.AddPaginationParams(imageLsCmd, &imageLsPageSize)
+	imageLsCmd.Flags().IntVarP(&imageLsOffset, "offset", "o", 0, "Number of elements to skip")
+
+	imageDiffCmd := &cobra.Command{
+		Use:   "diff UUID_A UUID_B",
+		Short: "Show file changes between two images",
+		Long:  "List files added, removed, modified or with changed permissions in image UUID_B compared to image UUID_A",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			diff, err := api.ImageDiff(args[0], args[1])
+			if err != nil {
+				slog.With(
+					slog.String("from", args[0]),
+					slog.String("to", args[1]),
+					slog.Any("error", err),
+				).Error("Cannot compare images")
+				os.Exit(1)
+			}
+
+			tab := tabular.New()
+			tab.Col("change", "Change", 12)
+			tab.Col("delta", "Size delta", 12)
+			tab.Col("path", "Path", 60)
+
+			format := tab.Print("change", "delta", "path")
+			for _, c := range diff.Changes {
+				delta := "---"
+				if !c.IsDir {
+					delta = formatSizeDelta(c.SizeDelta())
+				}
+				path := c.Path
+				if c.IsDir {
+					path = fmt.Sprintf("%s/", c.Path)
+				}
+				if c.Change == image.ChangePermissions {
+					path = fmt.Sprintf("%s (%s -> %s)", path, c.OldMode, c.NewMode)
+				}
+
+				fmt.Printf(format, c.Change, delta, path)
+			}
+
+			fmt.Printf("\n%d added, %d removed, %d modified, %d with changed permissions. Size delta: %s\n",
+				diff.Added,
+				diff.Removed,
+				diff.Modified,
+				diff.PermissionsChanged,
+				formatSizeDelta(diff.SizeDelta),
+			)
+		},
+	}
+
+	imageVerifyCmd := &cobra.Command{
+		Use:   "verify UUID",
+		Short: "Check image data against its integrity manifest",
+		Long:  "Rehash image data and report files missing, changed or added since the image integrity manifest was written",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			v, err := api.ImageVerify(args[0])
+			if err != nil {
+				slog.With(
+					slog.String("image", args[0]),
+					slog.Any("error", err),
+				).Error("Cannot verify image")
+				os.Exit(1)
+			}
+
+			printVerification(v)
+			if v.Status != image.VerificationOK {
+				os.Exit(1)
+			}
+		},
+	}
+
+	imageScrubCmd := &cobra.Command{
+		Use:   "scrub",
+		Short: "Check integrity of every image",
+		Run: func(cmd *cobra.Command, args []string) {
+			verifications, err := api.ImageScrub()
+			failed := 0
+			for _, v := range verifications {
+				if v.Status != image.VerificationOK {
+					failed++
+					printVerification(v)
+					fmt.Println()
+				}
+			}
+			if err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Errors encountered during image scrubbing")
+				os.Exit(1)
+			}
+
+			fmt.Printf("%d images verified, %d with integrity errors\n", len(verifications), failed)
+			if failed > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+
+	imagePruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove images according to configured retention policies",
+		Run: func(cmd *cobra.Command, args []string) {
+			toPrune, err := api.ImagePrune(imagePruneClient, imagePruneModule, true)
+			if err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot compute images to prune")
+				os.Exit(1)
+			}
+
+			if len(toPrune) == 0 {
+				slog.Info("No images to prune")
+				return
+			}
+
+			fmt.Printf("The following images will be removed:\n\n")
+			printImageTable(toPrune)
+			fmt.Println()
+
+			if imagePruneDryRun {
+				slog.Info("Dry run, no images removed")
+				return
+			}
+
+			if imagePruneAssumeYes {
+				slog.Info("Skipping confirmation on user request (-y/--yes flag provided)")
+			} else {
+				if !utils.Confirm("Remove images") {
+					slog.Error("Prune canceled")
+					os.Exit(1)
+				}
+			}
+
+			pruned, err := api.ImagePrune(imagePruneClient, imagePruneModule, false)
+			if err != nil {
+				slog.With(
+					slog.Any("error", err),
+					slog.Int("pruned_images", len(pruned)),
+				).Error("Errors encountered during image pruning")
+				os.Exit(1)
+			}
+
+			slog.With(
+				slog.Int("pruned_images", len(pruned)),
+			).Info("Images pruned")
+		},
+	}
+	imagePruneCmd.Flags().StringVarP(&imagePruneClient, "client", "", "", "Only prune images of this client")
+	imagePruneCmd.Flags().StringVarP(&imagePruneModule, "module", "m", "", "Only prune images of this module")
+	imagePruneCmd.Flags().BoolVarP(&imagePruneDryRun, "dry-run", "n", false, "Show images that would be removed without removing them")
+	imagePruneCmd.Flags().BoolVarP(&imagePruneAssumeYes, "yes", "y", false, "Skip confirmation")
+
+	imageDeleteCmd := &cobra.Command{
+		Use:   "delete UUID",
+		Short: "Delete image from repository, catalog and database",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			img, err := api.ImageGet(args[0])
+			if err != nil {
+				slog.With(
+					slog.String("image", args[0]),
+					slog.Any("error", err),
+				).Error("Cannot get image details")
+				os.Exit(1)
+			}
+
+			fmt.Printf("The following image will be removed:\n\n")
+			printImageTable([]image.Image{img})
+			fmt.Println()
+
+			if imageDeleteAssumeYes {
+				slog.Info("Skipping confirmation on user request (-y/--yes flag provided)")
+			} else {
+				if !utils.Confirm("Delete image") {
+					slog.Error("Image deletion canceled")
+					os.Exit(1)
+				}
+			}
+
+			if err := api.ImageDelete(img.Uuid, imageDeleteForce); err != nil {
+				logger := slog.With(
+					slog.String("image", img.Uuid),
+					slog.Any("error", err),
+				)
+				if errors.Is(err, api.ErrImageReferenced) {
+					logger.Error("Cannot delete image. Use --force to delete images used as diff reference by other jobs")
+				} else {
+					logger.Error("Cannot delete image")
+				}
+				os.Exit(1)
+			}
+		},
+	}
+	imageDeleteCmd.Flags().BoolVarP(&imageDeleteForce, "force", "f", false, "Delete image even if used as diff reference by other jobs")
+	imageDeleteCmd.Flags().BoolVarP(&imageDeleteAssumeYes, "yes", "y", false, "Skip confirmation")
+
 	rootCmd.AddCommand(imageCmd)
 	imageCmd.AddCommand(imageListCmd)
 	imageCmd.AddCommand(imageShowCmd)
+	imageCmd.AddCommand(imageLsCmd)
+	imageCmd.AddCommand(imageDiffCmd)
+	imageCmd.AddCommand(imageVerifyCmd)
+	imageCmd.AddCommand(imageScrubCmd)
+	imageCmd.AddCommand(imagePruneCmd)
+	imageCmd.AddCommand(imageDeleteCmd)
 }
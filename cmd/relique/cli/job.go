@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -24,6 +25,9 @@ var jobListSearchClient string
 var jobListSearchType string
 var jobListSearchBackupType string
 var jobListSearchStatus string
+var jobChangesPageSize int
+var jobChangesOffset int
+var jobChangesType string
 
 func init() {
 	jobCmd := &cobra.Command{
@@ -44,6 +48,12 @@ func init() {
 				).Error("Cannot initialize database connection")
 				os.Exit(1)
 			}
+
+			if _, err := api.JobRecoverStale(); err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot recover orphaned jobs")
+			}
 		},
 	}
 
@@ -145,7 +155,71 @@ func init() {
 		},
 	}
 
+	jobChangesCmd := &cobra.Command{
+		Use:   "changes UUID",
+		Short: "List files changed by job",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			page := api_helpers.PaginationParams{
+				Limit:  uint64(jobChangesPageSize),
+				Offset: uint64(jobChangesOffset),
+			}
+
+			changes, err := api.JobChanges(args[0], page, jobChangesType)
+			if err != nil {
+				slog.With(
+					slog.String("job", args[0]),
+					slog.Any("error", err),
+				).Error("Cannot get job changes")
+				os.Exit(1)
+			}
+
+			tab := tabular.New()
+			tab.Col("type", "Change", 12)
+			tab.Col("file_type", "Type", 10)
+			tab.Col("flags", "Flags", 12)
+			tab.Col("path", "Path", 60)
+
+			format := tab.Print("type", "file_type", "flags", "path")
+			for _, c := range changes.Data {
+				fmt.Printf(format, c.Type, c.FileType, c.Flags, c.Path)
+			}
+
+			fmt.Printf("\nShowing %d out of %d records\n", len(changes.Data), changes.Count)
+		},
+	}
+	utils.AddPaginationParams(jobChangesCmd, &jobChangesPageSize)
+	jobChangesCmd.Flags().IntVarP(&jobChangesOffset, "offset", "o", 0, "Number of elements to skip")
+	jobChangesCmd.Flags().StringVarP(&jobChangesType, "type", "t", "", "Filter on change type (created, updated, deleted, attributes)")
+
+	jobCancelCmd := &cobra.Command{
+		Use:   "cancel UUID",
+		Short: "Cancel a running job",
+		Long:  "Stop file sync tasks of a running job. Partial logs are kept and no image is generated for cancelled backups",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := api.JobCancel(args[0]); err != nil {
+				if errors.Is(err, api.ErrJobNotRunning) {
+					slog.With(
+						slog.String("job", args[0]),
+						slog.Any("error", err),
+					).Error("Only running jobs can be cancelled")
+				} else {
+					slog.With(
+						slog.String("job", args[0]),
+						slog.Any("error", err),
+					).Error("Cannot cancel job")
+				}
+				os.Exit(1)
+			}
+
+			fmt.Printf("Cancellation requested for job %s\n", args[0])
+		},
+	}
+
 	rootCmd.AddCommand(jobCmd)
 	jobCmd.AddCommand(jobListCmd)
 	jobCmd.AddCommand(jobShowCmd)
+	jobCmd.AddCommand(jobChangesCmd)
+	jobCmd.AddCommand(jobCancelCmd)
 }
@@ -1,23 +1,59 @@
 package cli
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"strings"
 
 	"github.com/InVisionApp/tabular"
+	"github.com/dustin/go-humanize"
 	"github.com/macarrie/relique/api"
 	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/db"
+	"github.com/macarrie/relique/internal/repo"
+	"github.com/macarrie/relique/internal/s3"
 	"github.com/macarrie/relique/internal/utils"
 	"github.com/pelletier/go-toml/v2"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 )
 
 var repoCreateName string
 var repoCreateIsDefault bool
 var repoCreateLocalPath string
+var repoCreateSSHHost string
+var repoCreateSSHUser string
+var repoCreateSSHPort int
+var repoCreateSSHPath string
+var repoCreateSSHStagingPath string
+var repoCreateS3Endpoint string
+var repoCreateS3Region string
+var repoCreateS3Bucket string
+var repoCreateS3Prefix string
+var repoCreateS3AccessKey string
+var repoCreateS3SecretKey string
+var repoCreateS3StagingPath string
+var repoCreateS3PassphraseStdin bool
+var repoCreateS3KeyFile string
+var repoCreateArchivePath string
+var repoCreateArchiveStagingPath string
+var repoCreateDedupPath string
+var repoCreateDedupStagingPath string
+var repoCreateEncryptedPath string
+var repoCreateEncryptedPassphraseStdin bool
+var repoCreateEncryptedKeyFile string
+var repoCreateEncryptedStagingPath string
+var repoChangePassphraseStdin bool
+var repoChangePassphraseKeyFile string
 var repoListPageSize int
 var repoListSearchType string
+var repoImportName string
+var repoImportIsDefault bool
 
 func init() {
 	repoCmd := &cobra.Command{
@@ -86,6 +122,36 @@ func init() {
 		},
 	}
 
+	repoStatusCmd := &cobra.Command{
+		Use:   "status REPO_NAME",
+		Short: "Check backup repository storage and show its space usage",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			status, err := api.RepoGetStatus(args[0])
+			if err != nil {
+				slog.With(
+					slog.String("repository", args[0]),
+					slog.Any("error", err),
+				).Error("Cannot get repository status")
+				os.Exit(1)
+			}
+
+			fmt.Printf("Repository: %s\n", status.Name)
+			fmt.Printf("Healthy:    %t\n", status.Healthy)
+			if status.Error != "" {
+				fmt.Printf("Error:      %s\n", status.Error)
+			}
+			if status.Healthy {
+				fmt.Printf("Used:       %s\n", formatSpace(status.Space.Used))
+				fmt.Printf("Free:       %s\n", formatSpace(status.Space.Free))
+				fmt.Printf("Total:      %s\n", formatSpace(status.Space.Total))
+			}
+			if !status.Healthy {
+				os.Exit(1)
+			}
+		},
+	}
+
 	repoCreateCmd := &cobra.Command{
 		Use:   "create",
 		Short: "Create a new backup repository",
@@ -122,8 +188,357 @@ func init() {
 	repoCreateLocalCmd.Flags().StringVarP(&repoCreateLocalPath, "path", "p", "", "Local repository data storage path")
 	repoCreateLocalCmd.MarkFlagRequired("path")
 
+	repoCreateSSHCmd := &cobra.Command{
+		Use:   "ssh",
+		Short: "Create a new backup repository on a remote host reached over SSH",
+		Long: `Create a new backup repository on a remote host reached over SSH.
+
+Backup data is transferred from clients into a local staging folder, then pushed to the repository host where unchanged files of diff backups are hardlinked to the reference image.
+The repository host needs rsync and GNU find, and must accept SSH key authentication from the relique server.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := api.RepoCreateSSH(repoCreateName, repoCreateSSHHost, repoCreateSSHUser, repoCreateSSHPort, repoCreateSSHPath, repoCreateSSHStagingPath, repoCreateIsDefault); err != nil {
+				slog.With(
+					slog.String("name", repoCreateName),
+					slog.String("host", repoCreateSSHHost),
+					slog.String("path", repoCreateSSHPath),
+					slog.Bool("default", repoCreateIsDefault),
+					slog.Any("error", err),
+				).Error("cannot create ssh repository")
+				os.Exit(1)
+			}
+
+			slog.With(
+				slog.String("name", repoCreateName),
+				slog.String("host", repoCreateSSHHost),
+				slog.String("path", repoCreateSSHPath),
+				slog.Bool("default", repoCreateIsDefault),
+			).Info("Successfully created ssh repository")
+		},
+	}
+	repoCreateCmd.AddCommand(repoCreateSSHCmd)
+	repoCreateSSHCmd.Flags().StringVarP(&repoCreateSSHHost, "host", "", "", "Repository host address")
+	repoCreateSSHCmd.Flags().StringVarP(&repoCreateSSHUser, "user", "u", "", "SSH user on repository host")
+	repoCreateSSHCmd.Flags().IntVarP(&repoCreateSSHPort, "port", "", repo.SSH_DEFAULT_PORT, "SSH port of repository host")
+	repoCreateSSHCmd.Flags().StringVarP(&repoCreateSSHPath, "path", "p", "", "Repository data storage path on repository host")
+	repoCreateSSHCmd.Flags().StringVarP(&repoCreateSSHStagingPath, "staging-path", "", "", "Local folder used to stage job data before it is sent to repository host (defaults to a private folder in relique data directory)")
+	repoCreateSSHCmd.MarkFlagRequired("host")
+	repoCreateSSHCmd.MarkFlagRequired("path")
+
+	repoCreateS3Cmd := &cobra.Command{
+		Use:   "s3",
+		Short: "Create a new backup repository in an S3 compatible bucket",
+		Long: `Create a new backup repository in an S3 compatible bucket.
+
+Backup data is transferred from clients into a local staging folder, then sent to the bucket. Files are split into chunks stored only once in the repository, so unchanged data is not uploaded again.
+Secret key is stored in repository configuration file, which is only readable by its owner.
+Image data is encrypted before being sent to the bucket if an encryption passphrase or key file is set. The passphrase is read from standard input.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			passphrase := ""
+			if repoCreateS3PassphraseStdin {
+				var err error
+				if passphrase, err = readPassphrase(); err != nil {
+					slog.With(
+						slog.Any("error", err),
+					).Error("Cannot read passphrase")
+					os.Exit(1)
+				}
+			}
+
+			if err := api.RepoCreateS3(repoCreateName, repoCreateS3Endpoint, repoCreateS3Region, repoCreateS3Bucket, repoCreateS3Prefix, repoCreateS3AccessKey, repoCreateS3SecretKey, repoCreateS3StagingPath, passphrase, repoCreateS3KeyFile, repoCreateIsDefault); err != nil {
+				slog.With(
+					slog.String("name", repoCreateName),
+					slog.String("endpoint", repoCreateS3Endpoint),
+					slog.String("bucket", repoCreateS3Bucket),
+					slog.String("prefix", repoCreateS3Prefix),
+					slog.Bool("default", repoCreateIsDefault),
+					slog.Any("error", err),
+				).Error("cannot create s3 repository")
+				os.Exit(1)
+			}
+
+			slog.With(
+				slog.String("name", repoCreateName),
+				slog.String("endpoint", repoCreateS3Endpoint),
+				slog.String("bucket", repoCreateS3Bucket),
+				slog.String("prefix", repoCreateS3Prefix),
+				slog.Bool("default", repoCreateIsDefault),
+			).Info("Successfully created s3 repository")
+		},
+	}
+	repoCreateCmd.AddCommand(repoCreateS3Cmd)
+	repoCreateS3Cmd.Flags().StringVarP(&repoCreateS3Endpoint, "endpoint", "", "", "Object storage endpoint URL (for instance https://s3.eu-west-3.amazonaws.com)")
+	repoCreateS3Cmd.Flags().StringVarP(&repoCreateS3Region, "region", "", s3.DEFAULT_REGION, "Bucket region")
+	repoCreateS3Cmd.Flags().StringVarP(&repoCreateS3Bucket, "bucket", "b", "", "Bucket name")
+	repoCreateS3Cmd.Flags().StringVarP(&repoCreateS3Prefix, "prefix", "", "", "Key prefix under which repository objects are stored (defaults to bucket root)")
+	repoCreateS3Cmd.Flags().StringVarP(&repoCreateS3AccessKey, "access-key", "", "", "Access key ID")
+	repoCreateS3Cmd.Flags().StringVarP(&repoCreateS3SecretKey, "secret-key", "", "", "Secret access key")
+	repoCreateS3Cmd.Flags().StringVarP(&repoCreateS3StagingPath, "staging-path", "", "", "Local folder used to stage job data before it is sent to bucket (defaults to a private folder in relique data directory)")
+	repoCreateS3Cmd.Flags().BoolVarP(&repoCreateS3PassphraseStdin, "passphrase-stdin", "", false, "Encrypt image data with a repository key protected by a passphrase read from standard input")
+	repoCreateS3Cmd.Flags().StringVarP(&repoCreateS3KeyFile, "key-file", "", "", "Encrypt image data with a repository key protected by the content of this file")
+	repoCreateS3Cmd.MarkFlagsMutuallyExclusive("passphrase-stdin", "key-file")
+	repoCreateS3Cmd.MarkFlagRequired("endpoint")
+	repoCreateS3Cmd.MarkFlagRequired("bucket")
+	repoCreateS3Cmd.MarkFlagRequired("access-key")
+	repoCreateS3Cmd.MarkFlagRequired("secret-key")
+
+	repoCreateArchiveCmd := &cobra.Command{
+		Use:   "archive",
+		Short: "Create a new backup repository on local filesystem storing images as compressed archives",
+		Long: `Create a new backup repository on local filesystem storing images as compressed archives.
+
+Backup data is transferred from clients into a local staging folder, then packed into gzip compressed tar segments with an index allowing to read any file without extracting the whole image.
+Archived images do not share data with other images: diff backups are stored as full archives. This format suits compressible data such as logs and configuration files.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := api.RepoCreateArchive(repoCreateName, repoCreateArchivePath, repoCreateArchiveStagingPath, repoCreateIsDefault); err != nil {
+				slog.With(
+					slog.String("name", repoCreateName),
+					slog.String("path", repoCreateArchivePath),
+					slog.Bool("default", repoCreateIsDefault),
+					slog.Any("error", err),
+				).Error("cannot create archive repository")
+				os.Exit(1)
+			}
+
+			slog.With(
+				slog.String("name", repoCreateName),
+				slog.String("path", repoCreateArchivePath),
+				slog.Bool("default", repoCreateIsDefault),
+			).Info("Successfully created archive repository")
+		},
+	}
+	repoCreateCmd.AddCommand(repoCreateArchiveCmd)
+	repoCreateArchiveCmd.Flags().StringVarP(&repoCreateArchivePath, "path", "p", "", "Archive repository data storage path")
+	repoCreateArchiveCmd.Flags().StringVarP(&repoCreateArchiveStagingPath, "staging-path", "", "", "Local folder used to stage job data before it is packed into the repository (defaults to a private folder in relique data directory)")
+	repoCreateArchiveCmd.MarkFlagRequired("path")
+
+	repoCreateDedupCmd := &cobra.Command{
+		Use:   "dedup",
+		Short: "Create a new deduplicating backup repository on local filesystem",
+		Long: `Create a new deduplicating backup repository on local filesystem.
+
+Backup data is transferred from clients into a local staging folder, then split into chunks at boundaries defined by file content. Each chunk is stored once in the repository, whatever the client, module or image it belongs to, so that data modified in place or duplicated across clients is only stored once.
+Chunks are removed when the last image referencing them is deleted.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := api.RepoCreateDedup(repoCreateName, repoCreateDedupPath, repoCreateDedupStagingPath, repoCreateIsDefault); err != nil {
+				slog.With(
+					slog.String("name", repoCreateName),
+					slog.String("path", repoCreateDedupPath),
+					slog.Bool("default", repoCreateIsDefault),
+					slog.Any("error", err),
+				).Error("cannot create dedup repository")
+				os.Exit(1)
+			}
+
+			slog.With(
+				slog.String("name", repoCreateName),
+				slog.String("path", repoCreateDedupPath),
+				slog.Bool("default", repoCreateIsDefault),
+			).Info("Successfully created dedup repository")
+		},
+	}
+	repoCreateCmd.AddCommand(repoCreateDedupCmd)
+	repoCreateDedupCmd.Flags().StringVarP(&repoCreateDedupPath, "path", "p", "", "Dedup repository data storage path")
+	repoCreateDedupCmd.Flags().StringVarP(&repoCreateDedupStagingPath, "staging-path", "", "", "Local folder used to stage job data before it is split into the repository (defaults to a private folder in relique data directory)")
+	repoCreateDedupCmd.MarkFlagRequired("path")
+
+	repoCreateEncryptedCmd := &cobra.Command{
+		Use:   "encrypted",
+		Short: "Create a new encrypted backup repository on local filesystem",
+		Long: `Create a new encrypted backup repository on local filesystem.
+
+Backup data is transferred from clients into a local staging folder, then encrypted into the repository folder. Files are split into chunks stored only once in the repository.
+Data is encrypted with a repository key protected by a passphrase or by the content of a key file. The passphrase or key file path is stored in repository configuration file, which is only readable by its owner.
+Losing both the passphrase or key file and the repository configuration makes stored images unreadable. The passphrase is read from standard input.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			passphrase := ""
+			if repoCreateEncryptedPassphraseStdin {
+				var err error
+				if passphrase, err = readPassphrase(); err != nil {
+					slog.With(
+						slog.Any("error", err),
+					).Error("Cannot read passphrase")
+					os.Exit(1)
+				}
+			}
+
+			if err := api.RepoCreateEncrypted(repoCreateName, repoCreateEncryptedPath, passphrase, repoCreateEncryptedKeyFile, repoCreateEncryptedStagingPath, repoCreateIsDefault); err != nil {
+				slog.With(
+					slog.String("name", repoCreateName),
+					slog.String("path", repoCreateEncryptedPath),
+					slog.Bool("default", repoCreateIsDefault),
+					slog.Any("error", err),
+				).Error("cannot create encrypted repository")
+				os.Exit(1)
+			}
+
+			slog.With(
+				slog.String("name", repoCreateName),
+				slog.String("path", repoCreateEncryptedPath),
+				slog.Bool("default", repoCreateIsDefault),
+			).Info("Successfully created encrypted repository")
+		},
+	}
+	repoCreateCmd.AddCommand(repoCreateEncryptedCmd)
+	repoCreateEncryptedCmd.Flags().StringVarP(&repoCreateEncryptedPath, "path", "p", "", "Encrypted repository data storage path")
+	repoCreateEncryptedCmd.Flags().BoolVarP(&repoCreateEncryptedPassphraseStdin, "passphrase-stdin", "", false, "Protect the repository key with a passphrase read from standard input")
+	repoCreateEncryptedCmd.Flags().StringVarP(&repoCreateEncryptedKeyFile, "key-file", "", "", "File whose content protects the repository key")
+	repoCreateEncryptedCmd.Flags().StringVarP(&repoCreateEncryptedStagingPath, "staging-path", "", "", "Local folder used to stage job data before it is encrypted into the repository (defaults to a private folder in relique data directory)")
+	repoCreateEncryptedCmd.MarkFlagRequired("path")
+	repoCreateEncryptedCmd.MarkFlagsMutuallyExclusive("passphrase-stdin", "key-file")
+	repoCreateEncryptedCmd.MarkFlagsOneRequired("passphrase-stdin", "key-file")
+
+	repoChangePassphraseCmd := &cobra.Command{
+		Use:   "change-passphrase REPO_NAME",
+		Short: "Change the passphrase or key file of an encrypted repository",
+		Long: `Change the passphrase or key file of an encrypted repository.
+
+The repository key is protected with the new passphrase or key file, then the previous ones are revoked. The new passphrase is read from standard input.
+The repository key itself is not changed and stored data is not encrypted again: data stays readable by anyone who already obtained the repository key. Use rotate-key to replace the repository key.
+Repository configuration and catalog entries of the repository images are updated with the new passphrase or key file.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			passphrase := ""
+			if repoChangePassphraseStdin {
+				var err error
+				if passphrase, err = readPassphrase(); err != nil {
+					slog.With(
+						slog.Any("error", err),
+					).Error("Cannot read passphrase")
+					os.Exit(1)
+				}
+			}
+
+			if err := api.RepoChangePassphrase(args[0], passphrase, repoChangePassphraseKeyFile); err != nil {
+				slog.With(
+					slog.String("repository", args[0]),
+					slog.Any("error", err),
+				).Error("Cannot change repository passphrase")
+				os.Exit(1)
+			}
+
+			slog.With(
+				slog.String("repository", args[0]),
+			).Info("Successfully changed repository passphrase")
+		},
+	}
+	repoChangePassphraseCmd.Flags().BoolVarP(&repoChangePassphraseStdin, "passphrase-stdin", "", false, "Read new passphrase protecting the repository key from standard input")
+	repoChangePassphraseCmd.Flags().StringVarP(&repoChangePassphraseKeyFile, "key-file", "", "", "New file whose content protects the repository key")
+	repoChangePassphraseCmd.MarkFlagsMutuallyExclusive("passphrase-stdin", "key-file")
+	repoChangePassphraseCmd.MarkFlagsOneRequired("passphrase-stdin", "key-file")
+
+	repoRotateKeyCmd := &cobra.Command{
+		Use:   "rotate-key REPO_NAME",
+		Short: "Replace the key of an encrypted repository and encrypt stored data again",
+		Long: `Replace the key of an encrypted repository and encrypt stored data again.
+
+A new repository key is generated and protected by the configured passphrase or key file, then every chunk, index and image file is encrypted again with it. The previous repository key and the other passphrases or key files are revoked once every image has been rewritten.
+Every object of the repository is read and written again, which can take a long time for large repositories. Backups and image removals wait for the rotation to end.
+If the rotation is interrupted, the repository cannot be used until the rotation is resumed by running this command again.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := api.RepoRotateKey(args[0]); err != nil {
+				slog.With(
+					slog.String("repository", args[0]),
+					slog.Any("error", err),
+				).Error("Cannot rotate repository key")
+				os.Exit(1)
+			}
+
+			slog.With(
+				slog.String("repository", args[0]),
+			).Info("Successfully rotated repository key")
+		},
+	}
+
+	repoImportCmd := &cobra.Command{
+		Use:   "import PATH",
+		Short: "Register an existing local repository and import its images",
+		Long: `Register an existing local repository and import its images.
+
+Repositories carry their own metadata so that they can be attached to another relique server, for example after moving a repository disk.
+Repository name is read from repository metadata unless specified with --name. Images already known in catalog are not imported again.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := db.Init(config.GetDBPath()); err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot initialize database connection")
+				os.Exit(1)
+			}
+
+			report, err := api.RepoImportLocal(args[0], repoImportName, repoImportIsDefault)
+			if err != nil {
+				slog.With(
+					slog.String("path", args[0]),
+					slog.Any("error", err),
+				).Error("Cannot import repository")
+				os.Exit(1)
+			}
+
+			if len(report.Issues) > 0 {
+				tab := tabular.New()
+				tab.Col("uuid", "UUID", 40)
+				tab.Col("problem", "Problem", 50)
+				tab.Col("path", "Path", 60)
+
+				format := tab.Print("uuid", "problem", "path")
+				for _, i := range report.Issues {
+					fmt.Printf(format, i.Uuid, i.Problem, i.Path)
+				}
+				fmt.Println()
+			}
+
+			fmt.Printf("Imported %d jobs and %d images. %d images already known, %d issues found\n",
+				report.Jobs,
+				report.Images,
+				report.Existing,
+				len(report.Issues),
+			)
+		},
+	}
+	repoImportCmd.Flags().StringVarP(&repoImportName, "name", "n", "", "Repository name (defaults to the name stored in repository metadata)")
+	repoImportCmd.Flags().BoolVarP(&repoImportIsDefault, "default", "", false, "Set repository as default")
+
 	rootCmd.AddCommand(repoCmd)
 	repoCmd.AddCommand(repoListCmd)
 	repoCmd.AddCommand(repoShowCmd)
+	repoCmd.AddCommand(repoStatusCmd)
 	repoCmd.AddCommand(repoCreateCmd)
+	repoCmd.AddCommand(repoImportCmd)
+	repoCmd.AddCommand(repoChangePassphraseCmd)
+	repoCmd.AddCommand(repoRotateKeyCmd)
+}
+
+func formatSpace(size int64) string {
+	if size == repo.SPACE_UNKNOWN {
+		return "unknown"
+	}
+
+	return humanize.Bytes(uint64(size))
+}
+
+// readPassphrase reads a passphrase from standard input. Passphrases are not passed as arguments, which are visible to other users in process list and kept in shell history
+func readPassphrase() (string, error) {
+	var passphrase string
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Fprint(os.Stderr, "Passphrase: ")
+		input, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("cannot read passphrase from terminal: %w", err)
+		}
+		passphrase = string(input)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("cannot read passphrase from standard input: %w", err)
+		}
+		passphrase = strings.TrimRight(line, "\r\n")
+	}
+
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase cannot be empty")
+	}
+
+	return passphrase, nil
 }
@@ -7,6 +7,7 @@ import (
 	"strings"
 
 	"github.com/macarrie/relique/api"
+	"github.com/macarrie/relique/internal/api_helpers"
 	"github.com/macarrie/relique/internal/client"
 	"github.com/macarrie/relique/internal/config"
 	"github.com/macarrie/relique/internal/db"
@@ -108,47 +109,26 @@ func init() {
 				os.Exit(1)
 			}
 
-			img, err := image.GetByUuid(imageId)
-			if err != nil {
+			if _, err := api.JobRecoverStale(); err != nil {
 				slog.With(
 					slog.Any("error", err),
-					slog.Any("image_uuid", imageId),
-				).Error("Cannot find image")
-				os.Exit(1)
-			}
-
-			// Override module with provided cli params
-			if len(restoreInclusions) > 0 {
-				slog.With(
-					slog.String("module", img.Module.Name),
-					slog.String("cli_include", strings.Join(restoreInclusions, ", ")),
-					slog.String("mod_include", strings.Join(img.Module.Include, ", ")),
-				).Info("Override module include list with the one provided in CLI params")
-				img.Module.Include = restoreInclusions
-			}
-			if len(restoreExclusions) > 0 {
-				slog.With(
-					slog.String("module", img.Module.Name),
-					slog.String("cli_exclude", strings.Join(restoreExclusions, ", ")),
-					slog.String("mod_exclude", strings.Join(img.Module.Exclude, ", ")),
-				).Info("Override module exclusions list with the one provided in CLI params")
-				img.Module.Exclude = restoreExclusions
-			}
-			if restoreExcludeCVS {
-				slog.With(
-					slog.String("module", img.Module.Name),
-					slog.Bool("cli_exclude_cvs", restoreExcludeCVS),
-					slog.Bool("mod_exclude_cvs", img.Module.ExcludeCVS),
-				).Info("Override module exclude_cvs parameter")
-				img.Module.ExcludeCVS = restoreExcludeCVS
+				).Error("Cannot recover orphaned jobs")
 			}
 
-			c, err := api.ClientGet(restoreClient)
+			img, c, err := api.RestoreResolve(api_helpers.RestoreParams{
+				ImageUuid:  imageId,
+				ClientName: restoreClient,
+				Paths:      args,
+				Include:    restoreInclusions,
+				Exclude:    restoreExclusions,
+				ExcludeCVS: restoreExcludeCVS,
+			})
 			if err != nil {
 				slog.With(
 					slog.Any("error", err),
+					slog.String("image_uuid", imageId),
 					slog.String("client", restoreClient),
-				).Error("Cannot find client")
+				).Error("Cannot prepare restore")
 				os.Exit(1)
 			}
 
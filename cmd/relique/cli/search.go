@@ -0,0 +1,109 @@
+package cli
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/InVisionApp/tabular"
+	"github.com/dustin/go-humanize"
+	"github.com/macarrie/relique/api"
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/db"
+	"github.com/macarrie/relique/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+var searchPageSize int
+var searchOffset int
+var searchClient string
+var searchModule string
+
+func init() {
+	searchCmd := &cobra.Command{
+		Use:   "search PATTERN",
+		Short: "Search files in backup images",
+		Long: `Search files in backup images.
+
+PATTERN is matched against any part of the file path. If it contains wildcards (*, ?, [...]), it is matched as a glob against the full file path instead.`,
+		Args: cobra.ExactArgs(1),
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			_, err := api.ConfigGet()
+			if err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot get relique configuration")
+				os.Exit(1)
+			}
+
+			if err := db.Init(config.GetDBPath()); err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot initialize database connection")
+				os.Exit(1)
+			}
+
+			if _, err := api.JobRecoverStale(); err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot recover orphaned jobs")
+			}
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			page := api_helpers.PaginationParams{
+				Limit:  uint64(searchPageSize),
+				Offset: uint64(searchOffset),
+			}
+			search := api_helpers.FileSearch{
+				Pattern:    args[0],
+				ClientName: searchClient,
+				ModuleName: searchModule,
+			}
+
+			files, err := api.Search(page, search)
+			if err != nil {
+				slog.With(
+					slog.String("pattern", args[0]),
+					slog.Any("error", err),
+				).Error("Cannot search files")
+				os.Exit(1)
+			}
+
+			tab := tabular.New()
+			tab.Col("path", "Path", 50)
+			tab.Col("size", "Size", 10)
+			tab.Col("mtime", "Modified", 20)
+			tab.Col("image", "Image", 40)
+			tab.Col("client", "Client", 20)
+			tab.Col("module", "Module", 15)
+			tab.Col("date", "Image date", 20)
+
+			format := tab.Print("path", "size", "mtime", "image", "client", "module", "date")
+			for _, f := range files.Data {
+				size := humanize.Bytes(uint64(f.Size))
+				if f.IsDir {
+					size = "-"
+				}
+				fmt.Printf(
+					format,
+					f.Path,
+					size,
+					utils.FormatDatetime(f.ModTime),
+					f.ImageUuid,
+					f.ClientName,
+					f.ModuleName,
+					utils.FormatDatetime(f.ImageCreatedAt),
+				)
+			}
+
+			fmt.Printf("\nShowing %d out of %d records\n", len(files.Data), files.Count)
+		},
+	}
+	utils.AddPaginationParams(searchCmd, &searchPageSize)
+	searchCmd.Flags().IntVarP(&searchOffset, "offset", "o", 0, "Number of elements to skip")
+	searchCmd.Flags().StringVarP(&searchClient, "client", "", "", "Filter on client name")
+	searchCmd.Flags().StringVarP(&searchModule, "module", "m", "", "Filter on module name")
+
+	rootCmd.AddCommand(searchCmd)
+}
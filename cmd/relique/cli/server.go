@@ -7,10 +7,13 @@ import (
 	"github.com/macarrie/relique/api"
 	"github.com/macarrie/relique/internal/config"
 	"github.com/macarrie/relique/internal/db"
+	"github.com/macarrie/relique/internal/scheduler"
 	"github.com/macarrie/relique/internal/server"
 	"github.com/spf13/cobra"
 )
 
+var serverDisableScheduler bool
+
 func init() {
 	serverCmd := &cobra.Command{
 		Use:   "server",
@@ -30,6 +33,12 @@ func init() {
 				).Error("Cannot initialize database connection")
 				os.Exit(1)
 			}
+
+			if _, err := api.JobRecoverStale(); err != nil {
+				slog.With(
+					slog.Any("error", err),
+				).Error("Cannot recover orphaned jobs")
+			}
 		},
 	}
 
@@ -37,10 +46,24 @@ func init() {
 		Use:   "start",
 		Short: "Start relique web server",
 		Run: func(cmd *cobra.Command, args []string) {
+			if serverDisableScheduler {
+				slog.Info("Scheduler disabled on user request (--no-scheduler flag provided)")
+			} else {
+				if err := scheduler.Start(config.Current); err != nil {
+					slog.With(
+						slog.Any("error", err),
+					).Error("Cannot start scheduler")
+					os.Exit(1)
+				}
+				defer scheduler.Stop()
+			}
+
 			server.Start(debug, config.Current.WebUI.BindAddr, config.Current.WebUI.Port, config.Current.WebUI.SSLCert, config.Current.WebUI.SSLKey)
 		},
 	}
 
+	serverStartCmd.Flags().BoolVarP(&serverDisableScheduler, "no-scheduler", "", false, "Do not run scheduled tasks (backups, pruning)")
+
 	rootCmd.AddCommand(serverCmd)
 	serverCmd.AddCommand(serverStartCmd)
 }
@@ -0,0 +1,11 @@
+package api_helpers
+
+type BackupParams struct {
+	ClientName string   `json:"client"`
+	ModuleName string   `json:"module"`
+	RepoName   string   `json:"repository"`
+	Paths      []string `json:"paths"`
+	Include    []string `json:"include"`
+	Exclude    []string `json:"exclude"`
+	ExcludeCVS bool     `json:"exclude_cvs"`
+}
@@ -0,0 +1,6 @@
+package api_helpers
+
+type CopyParams struct {
+	ImageUuid string `json:"image"`
+	RepoName  string `json:"repository"`
+}
@@ -0,0 +1,19 @@
+package api_helpers
+
+type RebuildIssue struct {
+	Uuid    string `json:"uuid"`
+	Path    string `json:"path"`
+	Problem string `json:"problem"`
+}
+
+type RebuildReport struct {
+	DryRun bool `json:"dry_run"`
+	// Rows inserted (or that would have been inserted on dry run)
+	Jobs   int `json:"jobs"`
+	Images int `json:"images"`
+	// Catalog entries already present in database
+	Existing int `json:"existing"`
+	// Jobs rebuilt without job record, whose details have been inferred from catalog and repository contents
+	Inferred int            `json:"inferred"`
+	Issues   []RebuildIssue `json:"issues"`
+}
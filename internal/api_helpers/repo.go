@@ -1,5 +1,54 @@
 package api_helpers
 
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/macarrie/relique/internal/repo"
+)
+
 type RepoSearch struct {
 	RepoType string `json:"type"`
 }
+
+type RepoStatus struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// Health check or space usage error, empty if the repository is healthy
+	Error string     `json:"error"`
+	Space repo.Space `json:"space"`
+}
+
+// RepoDetails is a repository configuration with its space usage, and the space left for new images for repositories able to report it
+type RepoDetails struct {
+	Repository repo.Repository
+	Space      *repo.Space
+	// Space usage error, empty if space usage is known
+	SpaceError string
+	Capacity   *repo.Capacity
+	// Capacity error, empty if capacity is known or not supported by the repository
+	CapacityError string
+}
+
+// MarshalJSON adds capacity fields to the repository JSON object, so that details can be read like a repository configuration
+func (d RepoDetails) MarshalJSON() ([]byte, error) {
+	content, err := json.Marshal(d.Repository)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]any)
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, fmt.Errorf("cannot read repository fields: %w", err)
+	}
+
+	fields["space"] = d.Space
+	if d.SpaceError != "" {
+		fields["space_error"] = d.SpaceError
+	}
+	fields["capacity"] = d.Capacity
+	if d.CapacityError != "" {
+		fields["capacity_error"] = d.CapacityError
+	}
+
+	return json.Marshal(fields)
+}
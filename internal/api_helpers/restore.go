@@ -0,0 +1,10 @@
+package api_helpers
+
+type RestoreParams struct {
+	ImageUuid  string   `json:"image"`
+	ClientName string   `json:"client"`
+	Paths      []string `json:"paths"`
+	Include    []string `json:"include"`
+	Exclude    []string `json:"exclude"`
+	ExcludeCVS bool     `json:"exclude_cvs"`
+}
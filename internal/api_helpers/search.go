@@ -0,0 +1,7 @@
+package api_helpers
+
+type FileSearch struct {
+	Pattern    string `json:"pattern"`
+	ModuleName string `json:"module"`
+	ClientName string `json:"client"`
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/kennygrant/sanitize"
 
 	"github.com/macarrie/relique/internal/module"
+	"github.com/macarrie/relique/internal/retention"
 
 	"github.com/pelletier/go-toml"
 )
@@ -20,6 +21,8 @@ type Client struct {
 	SSHUser string          `json:"ssh_user" toml:"ssh_user"`
 	SSHPort int             `json:"ssh_port" toml:"ssh_port"`
 	Modules []module.Module `json:"modules" toml:"modules"`
+	// Default retention policy for client modules that do not define their own
+	Retention retention.Policy `json:"retention" toml:"retention"`
 }
 
 func (c *Client) Write(rootPath string) error {
@@ -59,6 +62,15 @@ func (c *Client) GetLog() *slog.Logger {
 	)
 }
 
+// GetRetentionPolicy returns the retention policy to apply on images of a module: the module own policy if defined, client policy otherwise
+func (c *Client) GetRetentionPolicy(m module.Module) retention.Policy {
+	if m.Retention.IsEnabled() {
+		return m.Retention
+	}
+
+	return c.Retention
+}
+
 func (c *Client) Valid() bool {
 	if c.Name == "" || c.Address == "" {
 		return false
@@ -23,6 +23,7 @@ var Loaded bool
 var CLIENTS_DEFAULT_FOLDER string = "clients"
 var REPOS_DEFAULT_FOLDER string = "repositories"
 var MODULES_DEFAULT_FOLDER string = "/var/lib/relique/modules"
+var DATA_DEFAULT_FOLDER string = "/var/lib/relique"
 var CATALOG_DEFAULT_FOLDER string = "catalog"
 var DB_DEFAULT_FOLDER string = "db"
 
@@ -31,6 +32,9 @@ var WEBUI_DEFAULT_PORT int = 8433
 var WEBUI_DEFAULT_SSL_CERT string = "/etc/relique/certs/cert.pem"
 var WEBUI_DEFAULT_SSL_KEY string = "/etc/relique/certs/key.pem"
 
+var PRUNE_DEFAULT_SCHEDULE string = "@daily"
+var SCRUB_DEFAULT_SCHEDULE string = "@weekly"
+
 type Configuration struct {
 	Clients      []client.Client   `json:"clients" toml:"clients"`
 	Repositories []repo.Repository `json:"repositories" toml:"repositories"`
@@ -41,8 +45,11 @@ type Configuration struct {
 	ClientCfgPath     string `mapstructure:"client_cfg_path" json:"client_cfg_path" toml:"client_cfg_path"`
 	RepoCfgPath       string `mapstructure:"repo_cfg_path" json:"repo_cfg_path" toml:"repo_cfg_path"`
 	ModuleInstallPath string `mapstructure:"module_install_path" json:"module_install_path" toml:"module_install_path"`
+	DataPath          string `mapstructure:"data_path" json:"data_path" toml:"data_path"`
 	DBPath            string `mapstructure:"db_path" json:"db_path" toml:"db_path"`
 	CatalogPath       string `mapstructure:"catalog_path" json:"catalog_path" toml:"catalog_path"`
+	PruneSchedule     string `mapstructure:"prune_schedule" json:"prune_schedule" toml:"prune_schedule"`
+	ScrubSchedule     string `mapstructure:"scrub_schedule" json:"scrub_schedule" toml:"scrub_schedule"`
 }
 
 func New() {
@@ -75,6 +82,10 @@ func Load(fileName string) error {
 
 	setDefaultValues()
 	module.MODULES_INSTALL_PATH = cfg.ModuleInstallPath
+	if cfg.DataPath == "" {
+		cfg.DataPath = DATA_DEFAULT_FOLDER
+	}
+	repo.DATA_PATH = cfg.DataPath
 
 	clients, err := client.LoadFromPath(getAbsCfgDir(cfg.ClientCfgPath, CLIENTS_DEFAULT_FOLDER))
 	if err != nil {
@@ -120,12 +131,22 @@ func setDefaultValues() {
 		Current.ModuleInstallPath = MODULES_DEFAULT_FOLDER
 		module.MODULES_INSTALL_PATH = MODULES_DEFAULT_FOLDER
 	}
+	if Current.DataPath == "" {
+		Current.DataPath = DATA_DEFAULT_FOLDER
+		repo.DATA_PATH = DATA_DEFAULT_FOLDER
+	}
 	if Current.DBPath == "" {
 		Current.DBPath = DB_DEFAULT_FOLDER
 	}
 	if Current.CatalogPath == "" {
 		Current.CatalogPath = CATALOG_DEFAULT_FOLDER
 	}
+	if Current.PruneSchedule == "" {
+		Current.PruneSchedule = PRUNE_DEFAULT_SCHEDULE
+	}
+	if Current.ScrubSchedule == "" {
+		Current.ScrubSchedule = SCRUB_DEFAULT_SCHEDULE
+	}
 	if Current.WebUI.BindAddr == "" {
 		Current.WebUI.BindAddr = WEBUI_DEFAULT_BIND_ADDR
 	}
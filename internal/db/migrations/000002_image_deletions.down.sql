@@ -0,0 +1 @@
+DROP TABLE image_deletions;
\ No newline at end of file
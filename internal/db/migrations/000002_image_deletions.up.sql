@@ -0,0 +1,12 @@
+CREATE TABLE image_deletions (
+	id 					INTEGER PRIMARY KEY,
+	uuid 				TEXT NOT NULL,
+	created_at 			TIMESTAMP,
+	deleted_at 			TIMESTAMP,
+    module_name 		TEXT,
+    client_name 		TEXT,
+    repo_name 			TEXT,
+	size_on_disk       	INTEGER,
+	reason 				TEXT,
+	forced 				INTEGER NOT NULL
+);
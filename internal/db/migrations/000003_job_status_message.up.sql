@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN status_message TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+DROP INDEX image_files_path;
+DROP INDEX image_files_image_uuid;
+DROP TABLE image_files;
@@ -0,0 +1,11 @@
+CREATE TABLE image_files (
+	id 					INTEGER PRIMARY KEY,
+	image_uuid 			TEXT NOT NULL,
+	path 				TEXT NOT NULL,
+	is_dir 				INTEGER NOT NULL,
+	size 				INTEGER NOT NULL,
+	mtime 				TIMESTAMP
+);
+
+CREATE INDEX image_files_image_uuid ON image_files(image_uuid);
+CREATE INDEX image_files_path ON image_files(path);
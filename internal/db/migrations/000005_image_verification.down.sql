@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN verification_status;
+ALTER TABLE images DROP COLUMN verified_at;
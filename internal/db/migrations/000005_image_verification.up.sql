@@ -0,0 +1,2 @@
+ALTER TABLE images ADD COLUMN verified_at TIMESTAMP;
+ALTER TABLE images ADD COLUMN verification_status TEXT NOT NULL DEFAULT '';
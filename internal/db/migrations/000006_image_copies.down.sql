@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN source_image_uuid;
+ALTER TABLE jobs DROP COLUMN source_image_uuid;
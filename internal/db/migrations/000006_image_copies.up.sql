@@ -0,0 +1,2 @@
+ALTER TABLE jobs ADD COLUMN source_image_uuid TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN source_image_uuid TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN dedup_ratio;
+ALTER TABLE images DROP COLUMN new_data_size;
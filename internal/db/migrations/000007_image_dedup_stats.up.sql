@@ -0,0 +1,2 @@
+ALTER TABLE images ADD COLUMN new_data_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN dedup_ratio REAL NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN shared_size;
+ALTER TABLE images DROP COLUMN unique_size;
@@ -0,0 +1,3 @@
+ALTER TABLE images ADD COLUMN unique_size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN shared_size INTEGER NOT NULL DEFAULT 0;
+UPDATE images SET unique_size = COALESCE(size_on_disk, 0);
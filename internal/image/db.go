@@ -4,12 +4,14 @@ import (
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/Masterminds/squirrel"
 	sq "github.com/Masterminds/squirrel"
 
 	"github.com/macarrie/relique/internal/api_helpers"
 	"github.com/macarrie/relique/internal/client"
+	"github.com/macarrie/relique/internal/config"
 	"github.com/macarrie/relique/internal/db"
 	"github.com/macarrie/relique/internal/module"
 	"github.com/macarrie/relique/internal/repo"
@@ -56,6 +58,11 @@ func (img *Image) Save() (int64, error) {
 		"number_of_files":    img.NumberOfFiles,
 		"number_of_folders":  img.NumberOfFolders,
 		"size_on_disk":       img.SizeOnDisk,
+		"unique_size":        img.UniqueSize,
+		"shared_size":        img.SharedSize,
+		"new_data_size":      img.NewDataSize,
+		"dedup_ratio":        img.DedupRatio,
+		"source_image_uuid":  img.SourceImageUuid,
 	})
 	query, args, err := request.ToSql()
 	if err != nil {
@@ -91,6 +98,11 @@ func (img *Image) Update(tx *sql.Tx) (int64, error) {
 		"number_of_files":    img.NumberOfFiles,
 		"number_of_folders":  img.NumberOfFolders,
 		"size_on_disk":       img.SizeOnDisk,
+		"unique_size":        img.UniqueSize,
+		"shared_size":        img.SharedSize,
+		"new_data_size":      img.NewDataSize,
+		"dedup_ratio":        img.DedupRatio,
+		"source_image_uuid":  img.SourceImageUuid,
 	}).Where(
 		"uuid = ?",
 		img.Uuid,
@@ -134,6 +146,13 @@ func GetByUuid(uuid string) (Image, error) {
 		"number_of_files",
 		"number_of_folders",
 		"size_on_disk",
+		"unique_size",
+		"shared_size",
+		"new_data_size",
+		"dedup_ratio",
+		"verified_at",
+		"verification_status",
+		"source_image_uuid",
 	).From("images").Where("uuid = ?", uuid)
 	query, args, err := request.ToSql()
 	if err != nil {
@@ -143,6 +162,7 @@ func GetByUuid(uuid string) (Image, error) {
 	row := db.Handler().QueryRow(query, args...)
 
 	var img Image
+	var verifiedAt sql.NullTime
 	if err := row.Scan(&img.ID,
 		&img.Uuid,
 		&img.CreatedAt,
@@ -153,12 +173,21 @@ func GetByUuid(uuid string) (Image, error) {
 		&img.NumberOfFiles,
 		&img.NumberOfFolders,
 		&img.SizeOnDisk,
+		&img.UniqueSize,
+		&img.SharedSize,
+		&img.NewDataSize,
+		&img.DedupRatio,
+		&verifiedAt,
+		&img.VerificationStatus,
+		&img.SourceImageUuid,
 	); err == sql.ErrNoRows {
 		return Image{}, fmt.Errorf("no image with UUID '%s' found in db", uuid)
 	} else if err != nil {
 		return Image{}, fmt.Errorf("cannot retrieve image from db: %w", err)
 	}
 
+	img.VerifiedAt = verifiedAt.Time
+
 	imgCatalogPath := img.GetCatalogPath()
 
 	modFilePath := fmt.Sprintf("%s/module.toml", imgCatalogPath)
@@ -176,7 +205,7 @@ func GetByUuid(uuid string) (Image, error) {
 	img.Client = cl
 
 	repoFilePath := fmt.Sprintf("%s/repo.toml", imgCatalogPath)
-	r, err := repo.LoadFromFile(repoFilePath)
+	r, err := repo.LoadDefinition(repoFilePath, config.Current.Repositories)
 	if err != nil {
 		return Image{}, fmt.Errorf("linked repo cannot be loaded from file: %w", err)
 	}
@@ -185,6 +214,133 @@ func GetByUuid(uuid string) (Image, error) {
 	return img, nil
 }
 
+// Exists checks if an image with this uuid is stored in database
+func Exists(uuid string) (bool, error) {
+	var count int
+
+	request := sq.Select("COUNT(*)").From("images").Where("uuid = ?", uuid)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return false, fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	if err := db.Handler().QueryRow(query, args...).Scan(&count); err != nil {
+		return false, fmt.Errorf("cannot count images from db: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+// GetLatest returns the last image of a client and module stored in a repository
+func GetLatest(clientName string, moduleName string, repoName string) (Image, error) {
+	request := sq.Select("uuid").From("images").Where(sq.Eq{
+		"client_name": clientName,
+		"module_name": moduleName,
+		"repo_name":   repoName,
+	}).OrderBy("id DESC").Limit(1)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return Image{}, fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	var uuid string
+	if err := db.Handler().QueryRow(query, args...).Scan(&uuid); err == sql.ErrNoRows {
+		return Image{}, fmt.Errorf("no image found in db for client '%s' and module '%s' in repository '%s'", clientName, moduleName, repoName)
+	} else if err != nil {
+		return Image{}, fmt.Errorf("cannot retrieve latest image from db: %w", err)
+	}
+
+	return GetByUuid(uuid)
+}
+
+// getAdjacent returns the uuids of the images of the same client, module and repository saved right before and right after the image
+func (img *Image) getAdjacent() ([]string, error) {
+	where := sq.Eq{
+		"client_name": img.Client.Name,
+		"module_name": img.Module.Name,
+		"repo_name":   img.Repository.GetName(),
+	}
+	requests := []sq.SelectBuilder{
+		sq.Select("uuid").From("images").Where(where).Where("id < ?", img.ID).OrderBy("id DESC").Limit(1),
+		sq.Select("uuid").From("images").Where(where).Where("id > ?", img.ID).OrderBy("id ASC").Limit(1),
+	}
+
+	uuids := make([]string, 0, len(requests))
+	for _, request := range requests {
+		query, args, err := request.ToSql()
+		if err != nil {
+			return nil, fmt.Errorf("cannot build sql query: %w", err)
+		}
+
+		var uuid string
+		if err := db.Handler().QueryRow(query, args...).Scan(&uuid); err == sql.ErrNoRows {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("cannot retrieve adjacent image from db: %w", err)
+		}
+		uuids = append(uuids, uuid)
+	}
+
+	return uuids, nil
+}
+
+// saveSpace stores the unique and shared space of an image
+func saveSpace(uuid string, space repo.ImageSpace) error {
+	return updateSpace(uuid, sq.Eq{
+		"unique_size": space.Unique,
+		"shared_size": space.Shared,
+	})
+}
+
+// moveSharedSpace moves size bytes of an image from shared to unique space, or from unique to shared space if size is negative
+func moveSharedSpace(uuid string, size int64) error {
+	return updateSpace(uuid, sq.Eq{
+		"unique_size": sq.Expr("MAX(unique_size + ?, 0)", size),
+		"shared_size": sq.Expr("MAX(shared_size - ?, 0)", size),
+	})
+}
+
+func updateSpace(uuid string, values sq.Eq) error {
+	request := sq.Update("images").SetMap(values).Where("uuid = ?", uuid)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	if _, err := db.Handler().Exec(query, args...); err != nil {
+		return fmt.Errorf("cannot update image space into db: %w", err)
+	}
+
+	return nil
+}
+
+// RecordVerification stores the result of an image integrity verification
+func RecordVerification(v Verification) error {
+	request := sq.Update("images").SetMap(sq.Eq{
+		"verified_at":         v.VerifiedAt,
+		"verification_status": v.Status,
+	}).Where(
+		"uuid = ?",
+		v.ImageUuid,
+	)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	result, err := db.Handler().Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("cannot record image verification into db: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if rowsAffected != 1 || err != nil {
+		return fmt.Errorf("no rows affected: %w", err)
+	}
+
+	return nil
+}
+
 func ApplySearchParams(request squirrel.SelectBuilder, s api_helpers.ImageSearch) squirrel.SelectBuilder {
 	if s.ModuleName != "" {
 		request = request.Where("module_name = ?", s.ModuleName)
@@ -301,3 +457,57 @@ func Count() (uint64, error) {
 
 	return count, nil
 }
+
+func DeleteByUuid(tx *sql.Tx, uuid string) error {
+	slog.With(
+		slog.String("uuid", uuid),
+	).Debug("Deleting image from database")
+
+	request := sq.Delete("images").Where("uuid = ?", uuid)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return fmt.Errorf("cannot delete image from db: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if rowsAffected != 1 || err != nil {
+		return fmt.Errorf("no rows affected: %w", err)
+	}
+
+	return nil
+}
+
+// RecordDeletion keeps a trace of a deleted image in database
+func RecordDeletion(tx *sql.Tx, img Image, reason string, forced bool) error {
+	img.GetLog().With(
+		slog.String("reason", reason),
+		slog.Bool("forced", forced),
+	).Debug("Recording image deletion into database")
+
+	request := sq.Insert("image_deletions").SetMap(sq.Eq{
+		"uuid":         img.Uuid,
+		"created_at":   img.CreatedAt,
+		"deleted_at":   time.Now(),
+		"module_name":  img.Module.Name,
+		"client_name":  img.Client.Name,
+		"repo_name":    img.Repository.GetName(),
+		"size_on_disk": img.SizeOnDisk,
+		"reason":       reason,
+		"forced":       forced,
+	})
+	query, args, err := request.ToSql()
+	if err != nil {
+		return fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("cannot record image deletion into db: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,211 @@
+package image
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+)
+
+const (
+	ChangeAdded       = "added"
+	ChangeRemoved     = "removed"
+	ChangeModified    = "modified"
+	ChangePermissions = "permissions"
+)
+
+// FileChange describes the difference of a single element between two images
+type FileChange struct {
+	Path    string `json:"path"`
+	Change  string `json:"change"`
+	IsDir   bool   `json:"is_dir"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+	OldMode string `json:"old_mode"`
+	NewMode string `json:"new_mode"`
+}
+
+func (c *FileChange) SizeDelta() int64 {
+	return c.NewSize - c.OldSize
+}
+
+type Diff struct {
+	From               string       `json:"from"`
+	To                 string       `json:"to"`
+	Changes            []FileChange `json:"changes"`
+	Added              int          `json:"added"`
+	Removed            int          `json:"removed"`
+	Modified           int          `json:"modified"`
+	PermissionsChanged int          `json:"permissions_changed"`
+	SizeDelta          int64        `json:"size_delta"`
+}
+
+type diffEntry struct {
+	info       fs.FileInfo
+	linkTarget string
+}
+
+// Compare lists changes between two images. Files hardlinked between images (unchanged files in diff backups) are skipped without further checks
+func Compare(from Image, to Image) (Diff, error) {
+	if err := from.requireLocalData(); err != nil {
+		return Diff{}, err
+	}
+	if err := to.requireLocalData(); err != nil {
+		return Diff{}, err
+	}
+
+	fromPath, err := from.GetDataPath()
+	if err != nil {
+		return Diff{}, fmt.Errorf("cannot get source image data path: %w", err)
+	}
+	toPath, err := to.GetDataPath()
+	if err != nil {
+		return Diff{}, fmt.Errorf("cannot get target image data path: %w", err)
+	}
+
+	fromEntries, err := listDiffEntries(fromPath)
+	if err != nil {
+		return Diff{}, fmt.Errorf("cannot list source image files: %w", err)
+	}
+
+	diff := Diff{
+		From:    from.Uuid,
+		To:      to.Uuid,
+		Changes: make([]FileChange, 0),
+	}
+	err = walkDiffEntries(toPath, func(path string, entry diffEntry) {
+		old, ok := fromEntries[path]
+		if !ok {
+			diff.add(FileChange{
+				Path:    path,
+				Change:  ChangeAdded,
+				IsDir:   entry.info.IsDir(),
+				NewSize: entry.info.Size(),
+				NewMode: entry.info.Mode().String(),
+			})
+			return
+		}
+		delete(fromEntries, path)
+
+		if change := compareEntries(old, entry); change != "" {
+			diff.add(FileChange{
+				Path:    path,
+				Change:  change,
+				IsDir:   entry.info.IsDir(),
+				OldSize: old.info.Size(),
+				NewSize: entry.info.Size(),
+				OldMode: old.info.Mode().String(),
+				NewMode: entry.info.Mode().String(),
+			})
+		}
+	})
+	if err != nil {
+		return Diff{}, fmt.Errorf("cannot list target image files: %w", err)
+	}
+
+	for path, old := range fromEntries {
+		diff.add(FileChange{
+			Path:    path,
+			Change:  ChangeRemoved,
+			IsDir:   old.info.IsDir(),
+			OldSize: old.info.Size(),
+			OldMode: old.info.Mode().String(),
+		})
+	}
+
+	slices.SortFunc(diff.Changes, func(a, b FileChange) int {
+		return cmp.Compare(a.Path, b.Path)
+	})
+
+	return diff, nil
+}
+
+func (d *Diff) add(c FileChange) {
+	d.Changes = append(d.Changes, c)
+	switch c.Change {
+	case ChangeAdded:
+		d.Added++
+	case ChangeRemoved:
+		d.Removed++
+	case ChangeModified:
+		d.Modified++
+	case ChangePermissions:
+		d.PermissionsChanged++
+	}
+	if !c.IsDir {
+		d.SizeDelta += c.SizeDelta()
+	}
+}
+
+func compareEntries(old diffEntry, new diffEntry) string {
+	// Same inode: file has been hardlinked by rsync during diff backup and is unchanged
+	if os.SameFile(old.info, new.info) {
+		return ""
+	}
+
+	if old.info.Mode().Type() != new.info.Mode().Type() || old.linkTarget != new.linkTarget {
+		return ChangeModified
+	}
+	// Folder size and modification time change with their content, which is already reported
+	if !new.info.IsDir() && (old.info.Size() != new.info.Size() || !old.info.ModTime().Equal(new.info.ModTime())) {
+		return ChangeModified
+	}
+
+	if old.info.Mode() != new.info.Mode() || getOwner(old.info) != getOwner(new.info) {
+		return ChangePermissions
+	}
+
+	return ""
+}
+
+func getOwner(info fs.FileInfo) [2]uint32 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return [2]uint32{stat.Uid, stat.Gid}
+	}
+
+	return [2]uint32{}
+}
+
+func listDiffEntries(root string) (map[string]diffEntry, error) {
+	entries := make(map[string]diffEntry)
+	err := walkDiffEntries(root, func(path string, entry diffEntry) {
+		entries[path] = entry
+	})
+
+	return entries, err
+}
+
+// walkDiffEntries calls fn for each element of root with its path relative to root, starting with '/'. Symbolic links are not followed
+func walkDiffEntries(root string, fn func(path string, entry diffEntry)) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("cannot get file info: %w", err)
+		}
+
+		entry := diffEntry{info: info}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if entry.linkTarget, err = os.Readlink(path); err != nil {
+				return fmt.Errorf("cannot read symbolic link: %w", err)
+			}
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("cannot get relative path: %w", err)
+		}
+		fn("/"+filepath.ToSlash(rel), entry)
+
+		return nil
+	})
+}
@@ -0,0 +1,82 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/macarrie/relique/internal/repo"
+)
+
+func TestCompare(t *testing.T) {
+	root := t.TempDir()
+	r := &repo.RepositoryLocal{Name: "local", Type: "local", Path: root}
+	from := Image{Uuid: "from", Repository: r}
+	to := Image{Uuid: "to", Repository: r}
+	fromData := filepath.Join(root, "from", "_data", "etc")
+	toData := filepath.Join(root, "to", "_data", "etc")
+	mtime := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	write := func(path string, content string, mode os.FileMode) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Unchanged file, hardlinked like rsync --link-dest does
+	write(filepath.Join(fromData, "unchanged.conf"), "pouet", 0644)
+	if err := os.MkdirAll(toData, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(fromData, "unchanged.conf"), filepath.Join(toData, "unchanged.conf")); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(fromData, "modified.conf"), "pouet", 0644)
+	write(filepath.Join(toData, "modified.conf"), "pouet pouet", 0644)
+	write(filepath.Join(fromData, "perms.conf"), "pouet", 0644)
+	write(filepath.Join(toData, "perms.conf"), "pouet", 0600)
+	write(filepath.Join(fromData, "removed.conf"), "pouet", 0644)
+	write(filepath.Join(toData, "added", "added.conf"), "pouet", 0644)
+
+	got, err := Compare(from, to)
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+
+	want := []FileChange{
+		{Path: "/etc/added", Change: ChangeAdded, IsDir: true},
+		{Path: "/etc/added/added.conf", Change: ChangeAdded, NewSize: 5},
+		{Path: "/etc/modified.conf", Change: ChangeModified, OldSize: 5, NewSize: 11},
+		{Path: "/etc/perms.conf", Change: ChangePermissions, OldSize: 5, NewSize: 5},
+		{Path: "/etc/removed.conf", Change: ChangeRemoved, OldSize: 5},
+	}
+	// Sizes and modes of folders depend on the filesystem
+	for i := range got.Changes {
+		got.Changes[i].OldMode = ""
+		got.Changes[i].NewMode = ""
+		if got.Changes[i].IsDir {
+			got.Changes[i].OldSize = 0
+			got.Changes[i].NewSize = 0
+		}
+	}
+	if !reflect.DeepEqual(got.Changes, want) {
+		t.Errorf("Compare() changes = %+v, want %+v", got.Changes, want)
+	}
+	if got.Added != 2 || got.Removed != 1 || got.Modified != 1 || got.PermissionsChanged != 1 {
+		t.Errorf("Compare() counts = %d/%d/%d/%d, want 2/1/1/1", got.Added, got.Removed, got.Modified, got.PermissionsChanged)
+	}
+	if got.SizeDelta != 6+5-5 {
+		t.Errorf("Compare() size delta = %d, want %d", got.SizeDelta, 6)
+	}
+}
@@ -0,0 +1,206 @@
+package image
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+)
+
+const (
+	ArchiveFormatTar = "tar"
+	ArchiveFormatZip = "zip"
+)
+
+// GetFileInfo describes an element of the image. Symbolic links are not followed
+func (img *Image) GetFileInfo(imagePath string) (File, error) {
+	fullPath, err := img.ResolvePath(imagePath)
+	if err != nil {
+		return File{}, err
+	}
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return File{}, fmt.Errorf("cannot get path info: %w", err)
+	}
+
+	return newFile(path.Clean("/"+imagePath), filepath.Dir(fullPath), info), nil
+}
+
+// OpenFile opens a regular file of the image for reading
+func (img *Image) OpenFile(imagePath string) (*os.File, error) {
+	fullPath, err := img.ResolvePath(imagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// O_NOFOLLOW makes sure that the file has not been replaced by a symlink since path resolution
+	f, err := os.OpenFile(fullPath, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot get file info: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("%w: '%s' is not a regular file", ErrInvalidPath, imagePath)
+	}
+
+	return f, nil
+}
+
+// WriteArchive writes a tar or zip archive of an image folder. Symbolic links are stored as links and never followed
+func (img *Image) WriteArchive(w io.Writer, imagePath string, format string) error {
+	fullPath, err := img.ResolvePath(imagePath)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return fmt.Errorf("cannot get path info: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%w: '%s' is not a folder", ErrInvalidPath, imagePath)
+	}
+
+	rootName := img.GetArchiveRootName(imagePath)
+	switch format {
+	case ArchiveFormatTar:
+		return writeTar(w, fullPath, rootName)
+	case ArchiveFormatZip:
+		return writeZip(w, fullPath, rootName)
+	default:
+		return fmt.Errorf("unknown archive format '%s'", format)
+	}
+}
+
+// GetArchiveRootName returns the name of the folder containing archived files: the folder name, or image uuid for image root
+func (img *Image) GetArchiveRootName(imagePath string) string {
+	name := path.Base(path.Clean("/" + imagePath))
+	if name == "/" {
+		return img.Uuid
+	}
+
+	return name
+}
+
+// walkArchive calls fn for each element of root. Archive names are prefixed with rootName
+func walkArchive(root string, rootName string, fn func(name string, path string, info fs.FileInfo, linkTarget string) error) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("cannot get file info: %w", err)
+		}
+
+		linkTarget := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if linkTarget, err = os.Readlink(path); err != nil {
+				return fmt.Errorf("cannot read symbolic link: %w", err)
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			// Devices, sockets and pipes are not exported
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return fmt.Errorf("cannot get archive name: %w", err)
+		}
+
+		return fn(filepath.ToSlash(filepath.Join(rootName, rel)), path, info, linkTarget)
+	})
+}
+
+func copyFileContent(w io.Writer, path string) error {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return fmt.Errorf("cannot open file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("cannot copy file content: %w", err)
+	}
+
+	return nil
+}
+
+func writeTar(w io.Writer, root string, rootName string) error {
+	tw := tar.NewWriter(w)
+
+	err := walkArchive(root, rootName, func(name string, path string, info fs.FileInfo, linkTarget string) error {
+		header, err := tar.FileInfoHeader(info, linkTarget)
+		if err != nil {
+			return fmt.Errorf("cannot create tar header: %w", err)
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("cannot write tar header: %w", err)
+		}
+		if info.Mode().IsRegular() {
+			return copyFileContent(tw, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func writeZip(w io.Writer, root string, rootName string) error {
+	zw := zip.NewWriter(w)
+
+	err := walkArchive(root, rootName, func(name string, path string, info fs.FileInfo, linkTarget string) error {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return fmt.Errorf("cannot create zip header: %w", err)
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		} else {
+			header.Method = zip.Deflate
+		}
+
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return fmt.Errorf("cannot write zip header: %w", err)
+		}
+		if linkTarget != "" {
+			// Zip stores symbolic link target as file content
+			_, err := entry.Write([]byte(linkTarget))
+			return err
+		}
+		if info.Mode().IsRegular() {
+			return copyFileContent(entry, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
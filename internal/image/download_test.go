@@ -0,0 +1,119 @@
+package image
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestImage_OpenFile(t *testing.T) {
+	img := setupTestImage(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "regular_file",
+			path:    "/etc/a.conf",
+			want:    "pouet",
+			wantErr: false,
+		},
+		{
+			name:    "folder",
+			path:    "/etc",
+			wantErr: true,
+		},
+		{
+			name:    "symlink",
+			path:    "/etc/root",
+			wantErr: true,
+		},
+		{
+			name:    "missing",
+			path:    "/etc/missing.conf",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := img.OpenFile(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("OpenFile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			defer f.Close()
+
+			content, _ := io.ReadAll(f)
+			if string(content) != tt.want {
+				t.Errorf("OpenFile() content = %v, want %v", string(content), tt.want)
+			}
+		})
+	}
+}
+
+func TestImage_WriteArchive(t *testing.T) {
+	img := setupTestImage(t)
+	wantNames := []string{"etc/", "etc/a.conf", "etc/app/", "etc/b.conf", "etc/c.conf", "etc/root"}
+
+	t.Run("tar", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := img.WriteArchive(&buf, "/etc", ArchiveFormatTar); err != nil {
+			t.Fatalf("WriteArchive() error = %v", err)
+		}
+
+		names := make([]string, 0)
+		tr := tar.NewReader(&buf)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("cannot read tar archive: %v", err)
+			}
+			names = append(names, header.Name)
+			if header.Name == "etc/root" && (header.Typeflag != tar.TypeSymlink || header.Linkname != "/") {
+				t.Errorf("WriteArchive() symlink stored as %v -> %v", header.Typeflag, header.Linkname)
+			}
+		}
+		if !reflect.DeepEqual(names, wantNames) {
+			t.Errorf("WriteArchive() names = %v, want %v", names, wantNames)
+		}
+	})
+
+	t.Run("zip", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := img.WriteArchive(&buf, "/etc", ArchiveFormatZip); err != nil {
+			t.Fatalf("WriteArchive() error = %v", err)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatalf("cannot read zip archive: %v", err)
+		}
+		names := make([]string, 0)
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		if !reflect.DeepEqual(names, wantNames) {
+			t.Errorf("WriteArchive() names = %v, want %v", names, wantNames)
+		}
+	})
+
+	t.Run("not_a_folder", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := img.WriteArchive(&buf, "/etc/a.conf", ArchiveFormatTar); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("WriteArchive() error = %v, want %v", err, ErrInvalidPath)
+		}
+	})
+}
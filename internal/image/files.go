@@ -0,0 +1,168 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/repo"
+)
+
+var ErrInvalidPath = repo.ErrInvalidPath
+var ErrRemoteRepository = errors.New("operation not available for images stored on a remote repository")
+
+// File describes an element stored in an image
+type File struct {
+	Name string `json:"name"`
+	// Path inside the image, which is the path of the file on the client
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"mtime"`
+	// Numeric IDs are kept since owner names are only meaningful on the client
+	Uid uint32 `json:"uid"`
+	Gid uint32 `json:"gid"`
+	// Symbolic link target, empty for other file types
+	LinkTarget string `json:"link_target"`
+}
+
+// GetDataPath returns the image data folder
+func (img *Image) GetDataPath() (string, error) {
+	storagePath, err := img.GetStorageFolderPath()
+	if err != nil {
+		return "", fmt.Errorf("cannot get image storage path: %w", err)
+	}
+
+	return filepath.Clean(fmt.Sprintf("%s/_data", storagePath)), nil
+}
+
+// requireLocalData returns ErrRemoteRepository if image data cannot be read from the local filesystem
+func (img *Image) requireLocalData() error {
+	if _, ok := img.Repository.(repo.StagedRepository); ok {
+		return ErrRemoteRepository
+	}
+
+	return nil
+}
+
+// ResolvePath converts a path inside the image into a path on the repository, making sure it does not point outside of the image data folder
+func (img *Image) ResolvePath(imagePath string) (string, error) {
+	if err := img.requireLocalData(); err != nil {
+		return "", err
+	}
+
+	dataPath, err := img.GetDataPath()
+	if err != nil {
+		return "", err
+	}
+
+	if strings.ContainsRune(imagePath, 0) {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidPath, imagePath)
+	}
+	// Image paths are absolute paths from the client. Cleaning a rooted path removes every '..' element
+	cleaned := path.Clean("/" + imagePath)
+	fullPath := filepath.Join(dataPath, filepath.FromSlash(cleaned))
+	if fullPath == dataPath {
+		return fullPath, nil
+	}
+	if !strings.HasPrefix(fullPath, dataPath+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: '%s'", ErrInvalidPath, imagePath)
+	}
+
+	// Symbolic links stored in the image point to client paths and must not be followed on the repository.
+	// Only the last path element can be a symlink, it is never followed since files are accessed with Lstat
+	realDataPath, err := filepath.EvalSymlinks(dataPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve image data path: %w", err)
+	}
+	realParentPath, err := filepath.EvalSymlinks(filepath.Dir(fullPath))
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve path: %w", err)
+	}
+	relParentPath, _ := filepath.Rel(dataPath, filepath.Dir(fullPath))
+	if realParentPath != filepath.Join(realDataPath, relParentPath) {
+		return "", fmt.Errorf("%w: '%s' goes through a symbolic link", ErrInvalidPath, imagePath)
+	}
+
+	return fullPath, nil
+}
+
+// ListFiles lists the content of a folder inside the image. Entries are sorted by name
+func (img *Image) ListFiles(imagePath string, p api_helpers.PaginationParams) (api_helpers.PaginatedResponse[File], error) {
+	if stagedRepo, ok := img.Repository.(repo.StagedRepository); ok {
+		return img.listStagedFiles(stagedRepo, imagePath, p)
+	}
+
+	fullPath, err := img.ResolvePath(imagePath)
+	if err != nil {
+		return api_helpers.PaginatedResponse[File]{}, err
+	}
+
+	info, err := os.Lstat(fullPath)
+	if err != nil {
+		return api_helpers.PaginatedResponse[File]{}, fmt.Errorf("cannot get path info: %w", err)
+	}
+	if !info.IsDir() {
+		return api_helpers.PaginatedResponse[File]{}, fmt.Errorf("%w: '%s' is not a folder", ErrInvalidPath, imagePath)
+	}
+
+	entries, err := os.ReadDir(fullPath)
+	if err != nil {
+		return api_helpers.PaginatedResponse[File]{}, fmt.Errorf("cannot list folder content: %w", err)
+	}
+
+	count := uint64(len(entries))
+	start := min(p.Offset, count)
+	end := count
+	if p.Limit > 0 {
+		end = min(start+p.Limit, count)
+	}
+
+	files := make([]File, 0, end-start)
+	for _, entry := range entries[start:end] {
+		entryInfo, err := entry.Info()
+		if err != nil {
+			// File removed since folder listing
+			continue
+		}
+		files = append(files, newFile(path.Join(path.Clean("/"+imagePath), entry.Name()), fullPath, entryInfo))
+	}
+
+	return api_helpers.PaginatedResponse[File]{
+		Pagination: p,
+		Count:      count,
+		Data:       files,
+	}, nil
+}
+
+func newFile(imagePath string, parentPath string, info fs.FileInfo) File {
+	f := File{
+		Name:    info.Name(),
+		Path:    imagePath,
+		IsDir:   info.IsDir(),
+		Size:    info.Size(),
+		Mode:    info.Mode().String(),
+		ModTime: info.ModTime(),
+	}
+
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		f.Uid = stat.Uid
+		f.Gid = stat.Gid
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if target, err := os.Readlink(filepath.Join(parentPath, info.Name())); err == nil {
+			f.LinkTarget = target
+		}
+	}
+
+	return f
+}
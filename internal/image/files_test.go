@@ -0,0 +1,170 @@
+package image
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/repo"
+)
+
+func setupTestImage(t *testing.T) Image {
+	root := t.TempDir()
+	img := Image{
+		Uuid:       "test",
+		Repository: &repo.RepositoryLocal{Name: "local", Type: "local", Path: root},
+	}
+
+	dataPath := filepath.Join(root, "test", "_data")
+	if err := os.MkdirAll(filepath.Join(dataPath, "etc", "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.conf", "b.conf", "c.conf"} {
+		if err := os.WriteFile(filepath.Join(dataPath, "etc", name), []byte("pouet"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/", filepath.Join(dataPath, "etc", "root")); err != nil {
+		t.Fatal(err)
+	}
+
+	return img
+}
+
+func TestImage_ResolvePath(t *testing.T) {
+	img := setupTestImage(t)
+	dataPath, _ := img.GetDataPath()
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "root",
+			path:    "/",
+			want:    dataPath,
+			wantErr: false,
+		},
+		{
+			name:    "folder",
+			path:    "/etc/app",
+			want:    filepath.Join(dataPath, "etc", "app"),
+			wantErr: false,
+		},
+		{
+			name:    "relative",
+			path:    "etc/a.conf",
+			want:    filepath.Join(dataPath, "etc", "a.conf"),
+			wantErr: false,
+		},
+		{
+			name:    "parent_traversal",
+			path:    "/../../../etc/passwd",
+			want:    filepath.Join(dataPath, "etc", "passwd"),
+			wantErr: false,
+		},
+		{
+			name:    "symlink_as_last_element",
+			path:    "/etc/root",
+			want:    filepath.Join(dataPath, "etc", "root"),
+			wantErr: false,
+		},
+		{
+			name:    "through_symlink",
+			path:    "/etc/root/etc/passwd",
+			wantErr: true,
+		},
+		{
+			name:    "null_byte",
+			path:    "/etc/a.conf\x00",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := img.ResolvePath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ResolvePath() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ResolvePath() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImage_ListFiles(t *testing.T) {
+	img := setupTestImage(t)
+
+	tests := []struct {
+		name      string
+		path      string
+		page      api_helpers.PaginationParams
+		wantNames []string
+		wantCount uint64
+		wantErr   error
+	}{
+		{
+			name:      "all",
+			path:      "/etc",
+			page:      api_helpers.PaginationParams{},
+			wantNames: []string{"a.conf", "app", "b.conf", "c.conf", "root"},
+			wantCount: 5,
+		},
+		{
+			name:      "paginated",
+			path:      "/etc",
+			page:      api_helpers.PaginationParams{Limit: 2, Offset: 2},
+			wantNames: []string{"b.conf", "c.conf"},
+			wantCount: 5,
+		},
+		{
+			name:      "offset_out_of_range",
+			path:      "/etc",
+			page:      api_helpers.PaginationParams{Limit: 2, Offset: 10},
+			wantNames: []string{},
+			wantCount: 5,
+		},
+		{
+			name:    "not_a_folder",
+			path:    "/etc/a.conf",
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name:    "symlink",
+			path:    "/etc/root",
+			wantErr: ErrInvalidPath,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := img.ListFiles(tt.path, tt.page)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ListFiles() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("ListFiles() error = %v", err)
+				return
+			}
+			if got.Count != tt.wantCount {
+				t.Errorf("ListFiles() count = %v, want %v", got.Count, tt.wantCount)
+			}
+			names := make([]string, 0)
+			for _, f := range got.Data {
+				names = append(names, f.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("ListFiles() names = %v, want %v", names, tt.wantNames)
+			}
+		})
+	}
+}
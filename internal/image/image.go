@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -25,18 +26,28 @@ func New(c client.Client, m module.Module, r repo.Repository) Image {
 	}
 }
 
+// GetSizeOnDisk returns the size of the files of an image folder. Files hardlinked several times inside the image are counted once
 func GetSizeOnDisk(rootPath string) (uint64, error) {
 	var totalSize uint64
+	seen := make(map[[2]uint64]bool)
 
 	// TODO: Handle error
 	filepath.Walk(rootPath, func(_ string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if !info.IsDir() {
-			totalSize += uint64(info.Size())
+		if info.IsDir() {
+			return nil
 		}
-		return err
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink > 1 {
+			inode := [2]uint64{uint64(stat.Dev), uint64(stat.Ino)}
+			if seen[inode] {
+				return nil
+			}
+			seen[inode] = true
+		}
+		totalSize += uint64(info.Size())
+		return nil
 	})
 
 	return totalSize, nil
@@ -63,3 +74,107 @@ func (img *Image) FillStats(stats rsync_lib.Stats, storagePath string) error {
 
 	return nil
 }
+
+// RefreshSpace splits image size between data used by this image only and data shared with other images of the repository.
+// Repositories unable to tell shared data apart count all image data as unique
+func (img *Image) RefreshSpace() error {
+	accounter, ok := img.Repository.(repo.SpaceAccounter)
+	if !ok {
+		img.UniqueSize = img.SizeOnDisk
+		img.SharedSize = 0
+		return nil
+	}
+
+	space, err := accounter.GetImageSpace(img.Uuid)
+	if err != nil {
+		return fmt.Errorf("cannot get image space usage from repository: %w", err)
+	}
+	img.UniqueSize = uint64(space.Unique)
+	img.SharedSize = uint64(space.Shared)
+
+	return nil
+}
+
+// RelatedSpace lists the images whose space accounting changes when an image is added to or removed from its repository
+type RelatedSpace struct {
+	repository repo.Repository
+	// Previous and next images of the same client, module and repository, which diff images hardlink unchanged files with
+	adjacent []string
+	// Size of the data other images only share with the image, by image uuid, in repositories sharing data between images of any client and module
+	paired map[string]int64
+}
+
+// GetRelatedSpace finds the images whose space accounting changes with the image. It has to be called while the image is saved into database and its data is stored in the repository
+func (img *Image) GetRelatedSpace() (RelatedSpace, error) {
+	related := RelatedSpace{repository: img.Repository}
+
+	if reporter, ok := img.Repository.(repo.PairedSpaceReporter); ok {
+		paired, err := reporter.GetPairedSpace(img.Uuid)
+		if err != nil {
+			return related, fmt.Errorf("cannot get space shared with other images: %w", err)
+		}
+		related.paired = paired
+		return related, nil
+	}
+	if _, ok := img.Repository.(repo.SpaceAccounter); !ok {
+		return related, nil
+	}
+
+	adjacent, err := img.getAdjacent()
+	if err != nil {
+		return related, fmt.Errorf("cannot get adjacent images: %w", err)
+	}
+	related.adjacent = adjacent
+
+	return related, nil
+}
+
+// Refresh updates the space accounting of related images once the image is added, or removed if removed is set
+func (related RelatedSpace) Refresh(removed bool) error {
+	for _, uuid := range related.adjacent {
+		space, err := related.repository.(repo.SpaceAccounter).GetImageSpace(uuid)
+		if err != nil {
+			return fmt.Errorf("cannot get space of image '%s': %w", uuid, err)
+		}
+		if err := saveSpace(uuid, space); err != nil {
+			return fmt.Errorf("cannot save space of image '%s': %w", uuid, err)
+		}
+	}
+
+	for uuid, size := range related.paired {
+		if !removed {
+			size = -size
+		}
+		if err := moveSharedSpace(uuid, size); err != nil {
+			return fmt.Errorf("cannot save space of image '%s': %w", uuid, err)
+		}
+	}
+
+	return nil
+}
+
+// TotalSize estimates the space used by a set of images. Unique data of every image is counted, while data shared between images of a client and module,
+// which mostly is the same files kept from an image to the next, is counted once per client, module and repository with the largest shared size of their images
+func TotalSize(imgs []Image) uint64 {
+	var total uint64
+	shared := make(map[string]uint64)
+	for _, img := range imgs {
+		total += img.UniqueSize
+		key := fmt.Sprintf("%s/%s/%s", img.Client.Name, img.Module.Name, img.Repository.GetName())
+		shared[key] = max(shared[key], img.SharedSize)
+	}
+	for _, size := range shared {
+		total += size
+	}
+
+	return total
+}
+
+// FillDedupStats computes deduplication stats from the size of image data and the size of the part of it that was not already stored in the repository
+func (img *Image) FillDedupStats(dataBytes int64, newBytes int64) {
+	img.NewDataSize = uint64(max(newBytes, 0))
+	img.DedupRatio = 0
+	if dataBytes > 0 {
+		img.DedupRatio = min(max(1-float64(newBytes)/float64(dataBytes), 0), 1)
+	}
+}
@@ -0,0 +1,225 @@
+package image
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/macarrie/relique/internal/client"
+	"github.com/macarrie/relique/internal/db"
+	"github.com/macarrie/relique/internal/module"
+	"github.com/macarrie/relique/internal/repo"
+)
+
+func TestImage_RefreshSpace(t *testing.T) {
+	root := t.TempDir()
+	r := repo.RepoLocalNew("local", root, false)
+	for _, uuid := range []string{"previous", "uuid"} {
+		if err := r.PrepareImage(uuid); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(root, "previous", "_data", "shared"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "previous", "_data", "shared"), filepath.Join(root, "uuid", "_data", "shared")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "uuid", "_data", "shared"), filepath.Join(root, "uuid", "_data", "shared.link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "uuid", "_data", "new"), make([]byte, 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files hardlinked inside the image are counted once
+	size, err := GetSizeOnDisk(filepath.Join(root, "uuid"))
+	if err != nil || size != 1010 {
+		t.Errorf("GetSizeOnDisk() = %v, %v, want 1010", size, err)
+	}
+
+	img := Image{Uuid: "uuid", Repository: &r, SizeOnDisk: size}
+	if err := img.RefreshSpace(); err != nil {
+		t.Fatalf("RefreshSpace() error = %v", err)
+	}
+	if img.UniqueSize != 10 || img.SharedSize != 1000 {
+		t.Errorf("RefreshSpace() = %v unique, %v shared, want 10 unique, 1000 shared", img.UniqueSize, img.SharedSize)
+	}
+
+	// Repositories unable to tell shared data apart count image data as unique
+	img.Repository = &repo.GenericRepository{}
+	if err := img.RefreshSpace(); err != nil || img.UniqueSize != size || img.SharedSize != 0 {
+		t.Errorf("RefreshSpace() on generic repository = %v unique, %v shared, %v, want all data unique", img.UniqueSize, img.SharedSize, err)
+	}
+}
+
+func TestImage_FillDedupStats(t *testing.T) {
+	tests := []struct {
+		name      string
+		dataBytes int64
+		newBytes  int64
+		wantNew   uint64
+		wantRatio float64
+	}{
+		{
+			name:      "all_new",
+			dataBytes: 100,
+			newBytes:  100,
+			wantNew:   100,
+			wantRatio: 0,
+		},
+		{
+			name:      "deduplicated",
+			dataBytes: 100,
+			newBytes:  25,
+			wantNew:   25,
+			wantRatio: 0.75,
+		},
+		{
+			name:      "no_data",
+			dataBytes: 0,
+			newBytes:  0,
+			wantNew:   0,
+			wantRatio: 0,
+		},
+		{
+			name:      "more_new_than_data",
+			dataBytes: 100,
+			newBytes:  150,
+			wantNew:   150,
+			wantRatio: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var img Image
+			img.FillDedupStats(tt.dataBytes, tt.newBytes)
+			if img.NewDataSize != tt.wantNew || img.DedupRatio != tt.wantRatio {
+				t.Errorf("FillDedupStats() = %v, %v, want %v, %v", img.NewDataSize, img.DedupRatio, tt.wantNew, tt.wantRatio)
+			}
+		})
+	}
+}
+
+func TestTotalSize(t *testing.T) {
+	local := repo.RepoLocalNew("local", t.TempDir(), false)
+	other := repo.RepoLocalNew("other", t.TempDir(), false)
+	img := func(clientName string, r repo.Repository, unique uint64, shared uint64) Image {
+		return Image{
+			Client:     client.Client{Name: clientName},
+			Module:     module.Module{Name: "module"},
+			Repository: r,
+			UniqueSize: unique,
+			SharedSize: shared,
+		}
+	}
+
+	tests := []struct {
+		name string
+		imgs []Image
+		want uint64
+	}{
+		{
+			name: "empty",
+			want: 0,
+		},
+		{
+			name: "diff_chain",
+			imgs: []Image{img("client", &local, 100, 1000), img("client", &local, 10, 1000), img("client", &local, 20, 900)},
+			want: 1130,
+		},
+		{
+			name: "several_chains",
+			imgs: []Image{img("client", &local, 100, 1000), img("other", &local, 10, 500), img("client", &other, 20, 700)},
+			want: 2330,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TotalSize(tt.imgs); got != tt.want {
+				t.Errorf("TotalSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// getSpace reads image space from database
+func getSpace(t *testing.T, uuid string) repo.ImageSpace {
+	t.Helper()
+
+	var space repo.ImageSpace
+	if err := db.Handler().QueryRow("SELECT unique_size, shared_size FROM images WHERE uuid = ?", uuid).Scan(&space.Unique, &space.Shared); err != nil {
+		t.Fatal(err)
+	}
+
+	return space
+}
+
+func TestImage_RelatedSpace(t *testing.T) {
+	if err := db.Init(t.TempDir()); err != nil {
+		t.Fatalf("cannot init test database: %v", err)
+	}
+
+	root := t.TempDir()
+	r := repo.RepoLocalNew("local", root, false)
+	imgs := make([]Image, 0)
+	for _, uuid := range []string{"other", "first", "second"} {
+		if err := r.PrepareImage(uuid); err != nil {
+			t.Fatal(err)
+		}
+		img := Image{Uuid: uuid, Client: client.Client{Name: "client"}, Module: module.Module{Name: "module"}, Repository: &r}
+		if uuid == "other" {
+			img.Client.Name = "other"
+		}
+		imgs = append(imgs, img)
+	}
+	if err := os.WriteFile(filepath.Join(root, "first", "_data", "shared"), make([]byte, 1000), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range imgs[:2] {
+		if err := img.RefreshSpace(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := img.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Diff image hardlinks unchanged files to the previous image of the same client and module
+	second := imgs[2]
+	if err := os.Link(filepath.Join(root, "first", "_data", "shared"), filepath.Join(root, "second", "_data", "shared")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Save(); err != nil {
+		t.Fatal(err)
+	}
+	related, err := second.GetRelatedSpace()
+	if err != nil {
+		t.Fatalf("GetRelatedSpace() error = %v", err)
+	}
+	if want := []string{"first"}; !reflect.DeepEqual(related.adjacent, want) {
+		t.Errorf("GetRelatedSpace() adjacent images = %v, want %v", related.adjacent, want)
+	}
+	if err := related.Refresh(false); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if space := getSpace(t, "first"); space != (repo.ImageSpace{Shared: 1000}) {
+		t.Errorf("space of previous image after adding image = %+v, want shared file", space)
+	}
+
+	// Data only shared with a removed image becomes unique
+	related = RelatedSpace{paired: map[string]int64{"first": 600}}
+	if err := related.Refresh(true); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if space := getSpace(t, "first"); space != (repo.ImageSpace{Unique: 600, Shared: 400}) {
+		t.Errorf("space of paired image after removing image = %+v, want 600 unique, 400 shared", space)
+	}
+	if err := related.Refresh(false); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if space := getSpace(t, "first"); space != (repo.ImageSpace{Shared: 1000}) {
+		t.Errorf("space of paired image after adding image = %+v, want 1000 shared", space)
+	}
+}
@@ -0,0 +1,241 @@
+package image
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/db"
+)
+
+// IndexedFile is a file search result, with details of the image containing the file
+type IndexedFile struct {
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"is_dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+
+	ImageUuid      string    `json:"image_uuid"`
+	ImageCreatedAt time.Time `json:"image_created_at"`
+	ClientName     string    `json:"client_name"`
+	ModuleName     string    `json:"module_name"`
+	RepoName       string    `json:"repo_name"`
+}
+
+// IndexFiles records paths, sizes and modification times of every element stored in the image into the database file index.
+// Previously indexed files for this image are replaced
+func (img *Image) IndexFiles() error {
+	dataPath, err := img.GetDataPath()
+	if err != nil {
+		return err
+	}
+
+	return img.IndexFilesFrom(dataPath)
+}
+
+// IndexFilesFrom indexes image files from a local copy of image data, used when image data is not stored on the local filesystem
+func (img *Image) IndexFilesFrom(dataPath string) (err error) {
+	img.GetLog().With(
+		slog.String("path", dataPath),
+	).Debug("Indexing image files")
+
+	tx, err := db.Handler().Begin()
+	if err != nil {
+		return fmt.Errorf("cannot start transaction to index image files: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			img.GetLog().With(
+				slog.Any("error", err),
+			).Debug("Rollback image files indexing")
+			tx.Rollback()
+		}
+	}()
+
+	if err = DeleteFileIndex(tx, img.Uuid); err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO image_files (image_uuid, path, is_dir, size, mtime) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("cannot prepare file index query: %w", err)
+	}
+	defer stmt.Close()
+
+	count := 0
+	err = filepath.WalkDir(dataPath, func(p string, d fs.DirEntry, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if p == dataPath {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(dataPath, p)
+		if err != nil {
+			return err
+		}
+
+		var size int64
+		if !d.IsDir() {
+			size = info.Size()
+		}
+		if _, err := stmt.Exec(img.Uuid, "/"+filepath.ToSlash(relPath), d.IsDir(), size, info.ModTime()); err != nil {
+			return fmt.Errorf("cannot insert file into index: %w", err)
+		}
+		count++
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("cannot index image files: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("cannot commit image files index transaction: %w", err)
+	}
+
+	img.GetLog().With(
+		slog.Int("files", count),
+	).Debug("Image files indexed")
+
+	return nil
+}
+
+// DeleteFileIndex removes indexed files of an image
+func DeleteFileIndex(tx *sql.Tx, uuid string) error {
+	request := sq.Delete("image_files").Where("image_uuid = ?", uuid)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("cannot delete image file index from db: %w", err)
+	}
+
+	return nil
+}
+
+// applyFileSearchParams filters indexed files on search parameters.
+// Patterns containing wildcards are matched as globs on the full path, other patterns match any part of the path
+func applyFileSearchParams(request sq.SelectBuilder, s api_helpers.FileSearch) sq.SelectBuilder {
+	if strings.ContainsAny(s.Pattern, "*?[") {
+		request = request.Where("image_files.path GLOB ?", s.Pattern)
+	} else if s.Pattern != "" {
+		request = request.Where("instr(image_files.path, ?) > 0", s.Pattern)
+	}
+	if s.ModuleName != "" {
+		request = request.Where("images.module_name = ?", s.ModuleName)
+	}
+	if s.ClientName != "" {
+		request = request.Where("images.client_name = ?", s.ClientName)
+	}
+
+	return request
+}
+
+// SearchFiles looks for indexed files matching search parameters. Results are sorted by path, most recent images first
+func SearchFiles(p api_helpers.PaginationParams, s api_helpers.FileSearch) ([]IndexedFile, error) {
+	slog.With(
+		slog.String("pattern", s.Pattern),
+	).Debug("Searching for files in index")
+
+	files := make([]IndexedFile, 0)
+
+	request := sq.Select(
+		"image_files.path",
+		"image_files.is_dir",
+		"image_files.size",
+		"image_files.mtime",
+		"images.uuid",
+		"images.created_at",
+		"images.client_name",
+		"images.module_name",
+		"images.repo_name",
+	).From(
+		"image_files",
+	).Join(
+		"images ON images.uuid = image_files.image_uuid",
+	)
+	if p.Limit > 0 {
+		request = request.Limit(p.Limit)
+	}
+	if p.Offset > 0 {
+		request = request.Offset(p.Offset)
+	}
+
+	request = applyFileSearchParams(request, s)
+	request = request.OrderBy("image_files.path ASC", "images.id DESC")
+
+	query, args, err := request.ToSql()
+	if err != nil {
+		return files, fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	rows, err := db.Handler().Query(query, args...)
+	if err != nil {
+		return files, fmt.Errorf("cannot search files from db: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f IndexedFile
+		if err := rows.Scan(
+			&f.Path,
+			&f.IsDir,
+			&f.Size,
+			&f.ModTime,
+			&f.ImageUuid,
+			&f.ImageCreatedAt,
+			&f.ClientName,
+			&f.ModuleName,
+			&f.RepoName,
+		); err != nil {
+			return files, fmt.Errorf("cannot parse indexed file from db: %w", err)
+		}
+		files = append(files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return files, fmt.Errorf("cannot read indexed files from db: %w", err)
+	}
+
+	return files, nil
+}
+
+// CountFiles counts indexed files matching search parameters
+func CountFiles(s api_helpers.FileSearch) (uint64, error) {
+	var count uint64
+
+	request := sq.Select(
+		"COUNT(*)",
+	).From(
+		"image_files",
+	).Join(
+		"images ON images.uuid = image_files.image_uuid",
+	)
+	request = applyFileSearchParams(request, s)
+
+	query, args, err := request.ToSql()
+	if err != nil {
+		return 0, fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	if err := db.Handler().QueryRow(query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("cannot count indexed files from db: %w", err)
+	}
+
+	return count, nil
+}
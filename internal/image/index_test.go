@@ -0,0 +1,106 @@
+package image
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/client"
+	"github.com/macarrie/relique/internal/db"
+)
+
+func TestImage_IndexFiles(t *testing.T) {
+	if err := db.Init(t.TempDir()); err != nil {
+		t.Fatalf("cannot init test database: %v", err)
+	}
+
+	img := setupTestImage(t)
+	img.Client = client.Client{Name: "web"}
+	if _, err := img.Save(); err != nil {
+		t.Fatalf("cannot save test image: %v", err)
+	}
+	if err := img.IndexFiles(); err != nil {
+		t.Fatalf("IndexFiles() error = %v", err)
+	}
+	// Indexing twice must not duplicate entries
+	if err := img.IndexFiles(); err != nil {
+		t.Fatalf("IndexFiles() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		search api_helpers.FileSearch
+		want   []string
+	}{
+		{
+			name:   "substring",
+			search: api_helpers.FileSearch{Pattern: "b.conf"},
+			want:   []string{"/etc/b.conf"},
+		},
+		{
+			name:   "glob",
+			search: api_helpers.FileSearch{Pattern: "/etc/*.conf"},
+			want:   []string{"/etc/a.conf", "/etc/b.conf", "/etc/c.conf"},
+		},
+		{
+			name:   "folder",
+			search: api_helpers.FileSearch{Pattern: "/etc/app"},
+			want:   []string{"/etc/app"},
+		},
+		{
+			name:   "client filter",
+			search: api_helpers.FileSearch{Pattern: "a.conf", ClientName: "web"},
+			want:   []string{"/etc/a.conf"},
+		},
+		{
+			name:   "other client",
+			search: api_helpers.FileSearch{Pattern: "a.conf", ClientName: "db"},
+			want:   []string{},
+		},
+		{
+			name:   "no match",
+			search: api_helpers.FileSearch{Pattern: "nginx"},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, err := SearchFiles(api_helpers.PaginationParams{}, tt.search)
+			if err != nil {
+				t.Fatalf("SearchFiles() error = %v", err)
+			}
+			got := make([]string, 0)
+			for _, f := range files {
+				if f.ImageUuid != img.Uuid {
+					t.Errorf("SearchFiles() image = %v, want %v", f.ImageUuid, img.Uuid)
+				}
+				got = append(got, f.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SearchFiles() = %v, want %v", got, tt.want)
+			}
+
+			count, err := CountFiles(tt.search)
+			if err != nil {
+				t.Fatalf("CountFiles() error = %v", err)
+			}
+			if count != uint64(len(tt.want)) {
+				t.Errorf("CountFiles() = %v, want %v", count, len(tt.want))
+			}
+		})
+	}
+
+	tx, err := db.Handler().Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteFileIndex(tx, img.Uuid); err != nil {
+		t.Fatalf("DeleteFileIndex() error = %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := CountFiles(api_helpers.FileSearch{Pattern: "conf"}); count != 0 {
+		t.Errorf("CountFiles() after index deletion = %v, want 0", count)
+	}
+}
@@ -0,0 +1,318 @@
+package image
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/macarrie/relique/internal/repo"
+)
+
+var ErrNoManifest = errors.New("image has no integrity manifest")
+
+const (
+	VerificationOK     = "ok"
+	VerificationFailed = "failed"
+)
+
+// Verification is the result of an image data check against its integrity manifest
+type Verification struct {
+	ImageUuid    string    `json:"image_uuid"`
+	VerifiedAt   time.Time `json:"verified_at"`
+	Status       string    `json:"status"`
+	CheckedFiles int       `json:"checked_files"`
+	// Files listed in manifest that cannot be found in image data
+	Missing []string `json:"missing"`
+	// Files whose content hash does not match the manifest
+	Changed []string `json:"changed"`
+	// Files found in image data that are not listed in manifest
+	Extra []string `json:"extra"`
+}
+
+// GetManifestPath returns the integrity manifest file path. Manifest uses sha256sum format so that it can also be checked with standard tools from the image data folder
+func (img *Image) GetManifestPath() string {
+	return fmt.Sprintf("%s/manifest.sha256", img.GetCatalogPath())
+}
+
+func (img *Image) getVerificationPath() string {
+	return fmt.Sprintf("%s/verification.json", img.GetCatalogPath())
+}
+
+// WriteManifest computes SHA-256 hashes of every regular file of the image and writes them into the image catalog folder
+func (img *Image) WriteManifest() error {
+	dataPath, err := img.GetDataPath()
+	if err != nil {
+		return err
+	}
+
+	return img.WriteManifestFrom(dataPath)
+}
+
+// WriteManifestFrom computes the integrity manifest from a local copy of image data, used when image data is not stored on the local filesystem
+func (img *Image) WriteManifestFrom(dataPath string) error {
+	img.GetLog().With(
+		slog.String("path", dataPath),
+	).Debug("Computing image integrity manifest")
+
+	hashes, err := hashTree(dataPath)
+	if err != nil {
+		return fmt.Errorf("cannot hash image files: %w", err)
+	}
+
+	manifestPath := img.GetManifestPath()
+	tmpPath := manifestPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("cannot create manifest file: %w", err)
+	}
+
+	w := bufio.NewWriter(f)
+	for _, p := range sortedKeys(hashes) {
+		if _, err := w.WriteString(formatManifestLine(p, hashes[p])); err != nil {
+			f.Close()
+			return fmt.Errorf("cannot write manifest file: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write manifest file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("cannot close manifest file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, manifestPath); err != nil {
+		return fmt.Errorf("cannot move manifest file into place: %w", err)
+	}
+
+	img.GetLog().With(
+		slog.Int("files", len(hashes)),
+	).Debug("Image integrity manifest written")
+
+	return nil
+}
+
+// ReadManifest loads file hashes from image integrity manifest
+func (img *Image) ReadManifest() (map[string]string, error) {
+	f, err := os.Open(img.GetManifestPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoManifest
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot open manifest file: %w", err)
+	}
+	defer f.Close()
+
+	hashes := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		p, hash, err := parseManifestLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse manifest file: %w", err)
+		}
+		hashes[p] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read manifest file: %w", err)
+	}
+
+	return hashes, nil
+}
+
+// Verify rehashes image data and compares it to the integrity manifest. Images stored in repositories able to hash their files in place, such as chunked repositories, are verified without fetching their data
+func (img *Image) Verify() (Verification, error) {
+	hasher, canHash := img.Repository.(repo.DataHasher)
+	if !canHash {
+		if err := img.requireLocalData(); err != nil {
+			return Verification{}, err
+		}
+	}
+
+	expected, err := img.ReadManifest()
+	if err != nil {
+		return Verification{}, err
+	}
+
+	img.GetLog().Info("Verifying image data integrity")
+	actual, err := img.hashData(hasher)
+	if err != nil {
+		return Verification{}, fmt.Errorf("cannot hash image files: %w", err)
+	}
+
+	v := Verification{
+		ImageUuid:    img.Uuid,
+		VerifiedAt:   time.Now(),
+		CheckedFiles: len(actual),
+		Missing:      make([]string, 0),
+		Changed:      make([]string, 0),
+		Extra:        make([]string, 0),
+	}
+	for _, p := range sortedKeys(expected) {
+		hash, ok := actual[p]
+		if !ok {
+			v.Missing = append(v.Missing, p)
+		} else if hash != expected[p] {
+			v.Changed = append(v.Changed, p)
+		}
+	}
+	for _, p := range sortedKeys(actual) {
+		if _, ok := expected[p]; !ok {
+			v.Extra = append(v.Extra, p)
+		}
+	}
+
+	v.Status = VerificationOK
+	if len(v.Missing) > 0 || len(v.Changed) > 0 || len(v.Extra) > 0 {
+		v.Status = VerificationFailed
+	}
+
+	img.GetLog().With(
+		slog.String("status", v.Status),
+		slog.Int("checked", v.CheckedFiles),
+		slog.Int("missing", len(v.Missing)),
+		slog.Int("changed", len(v.Changed)),
+		slog.Int("extra", len(v.Extra)),
+	).Info("Image data integrity verified")
+
+	return v, nil
+}
+
+func (img *Image) hashData(hasher repo.DataHasher) (map[string]string, error) {
+	if hasher != nil {
+		return hasher.HashImageFiles(img.Uuid)
+	}
+
+	dataPath, err := img.GetDataPath()
+	if err != nil {
+		return nil, err
+	}
+
+	return hashTree(dataPath)
+}
+
+// SaveVerification writes the detailed verification report into image catalog folder
+func (img *Image) SaveVerification(v Verification) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot serialize verification report: %w", err)
+	}
+
+	if err := os.WriteFile(img.getVerificationPath(), content, 0644); err != nil {
+		return fmt.Errorf("cannot write verification report: %w", err)
+	}
+
+	return nil
+}
+
+// ReadVerification loads the last verification report from image catalog folder
+func (img *Image) ReadVerification() (Verification, error) {
+	content, err := os.ReadFile(img.getVerificationPath())
+	if err != nil {
+		return Verification{}, fmt.Errorf("cannot read verification report: %w", err)
+	}
+
+	var v Verification
+	if err := json.Unmarshal(content, &v); err != nil {
+		return Verification{}, fmt.Errorf("cannot parse verification report: %w", err)
+	}
+
+	return v, nil
+}
+
+// hashTree computes SHA-256 hashes of regular files under root, indexed by their path relative to root
+func hashTree(root string) (map[string]string, error) {
+	hashes := make(map[string]string)
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		hash, err := hashFile(p)
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		hashes[filepath.ToSlash(relPath)] = hash
+
+		return nil
+	})
+
+	return hashes, err
+}
+
+func hashFile(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", fmt.Errorf("cannot open file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("cannot read file '%s': %w", p, err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// formatManifestLine formats a manifest entry like sha256sum does: file names containing backslashes or newlines are escaped and the line is prefixed by a backslash
+func formatManifestLine(p string, hash string) string {
+	if strings.ContainsAny(p, "\\\n") {
+		escaped := strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(p)
+		return fmt.Sprintf("\\%s  %s\n", hash, escaped)
+	}
+
+	return fmt.Sprintf("%s  %s\n", hash, p)
+}
+
+func parseManifestLine(line string) (string, string, error) {
+	escaped := strings.HasPrefix(line, "\\")
+	if escaped {
+		line = line[1:]
+	}
+
+	hash, p, ok := strings.Cut(line, "  ")
+	if !ok || len(hash) != sha256.Size*2 {
+		return "", "", fmt.Errorf("invalid manifest line '%s'", line)
+	}
+
+	if escaped {
+		p = strings.NewReplacer("\\\\", "\\", "\\n", "\n").Replace(p)
+	}
+
+	return p, hash, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,116 @@
+package image
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/macarrie/relique/internal/config"
+)
+
+func TestImage_Verify(t *testing.T) {
+	// Catalog path is resolved relative to the configuration file folder, which is the working directory when no file is loaded
+	wd, _ := os.Getwd()
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	config.Current.CatalogPath = "catalog"
+
+	img := setupTestImage(t)
+	if err := os.MkdirAll(img.GetCatalogPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	dataPath, _ := img.GetDataPath()
+
+	if _, err := img.Verify(); !errors.Is(err, ErrNoManifest) {
+		t.Fatalf("Verify() without manifest error = %v, want %v", err, ErrNoManifest)
+	}
+
+	if err := img.WriteManifest(); err != nil {
+		t.Fatalf("WriteManifest() error = %v", err)
+	}
+
+	v, err := img.Verify()
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if v.Status != VerificationOK || v.CheckedFiles != 3 {
+		t.Errorf("Verify() on untouched image = %+v, want ok with 3 checked files", v)
+	}
+
+	if err := os.WriteFile(filepath.Join(dataPath, "etc", "a.conf"), []byte("bitrot"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dataPath, "etc", "b.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dataPath, "etc", "app", "new.conf"), []byte("pouet"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err = img.Verify()
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if v.Status != VerificationFailed {
+		t.Errorf("Verify() status = %v, want %v", v.Status, VerificationFailed)
+	}
+	if !reflect.DeepEqual(v.Changed, []string{"etc/a.conf"}) {
+		t.Errorf("Verify() changed = %v, want %v", v.Changed, []string{"etc/a.conf"})
+	}
+	if !reflect.DeepEqual(v.Missing, []string{"etc/b.conf"}) {
+		t.Errorf("Verify() missing = %v, want %v", v.Missing, []string{"etc/b.conf"})
+	}
+	if !reflect.DeepEqual(v.Extra, []string{"etc/app/new.conf"}) {
+		t.Errorf("Verify() extra = %v, want %v", v.Extra, []string{"etc/app/new.conf"})
+	}
+}
+
+func TestManifestLine(t *testing.T) {
+	hash := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	tests := []struct {
+		name string
+		path string
+		want string
+	}{
+		{
+			name: "plain",
+			path: "etc/a.conf",
+			want: hash + "  etc/a.conf\n",
+		},
+		{
+			name: "spaces",
+			path: "etc/my  file",
+			want: hash + "  etc/my  file\n",
+		},
+		{
+			name: "newline",
+			path: "etc/a\nb",
+			want: "\\" + hash + "  etc/a\\nb\n",
+		},
+		{
+			name: "backslash",
+			path: "etc/a\\nb",
+			want: "\\" + hash + "  etc/a\\\\nb\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line := formatManifestLine(tt.path, hash)
+			if line != tt.want {
+				t.Errorf("formatManifestLine() = %q, want %q", line, tt.want)
+			}
+
+			p, h, err := parseManifestLine(line[:len(line)-1])
+			if err != nil {
+				t.Fatalf("parseManifestLine() error = %v", err)
+			}
+			if p != tt.path || h != hash {
+				t.Errorf("parseManifestLine() = %q, %q, want %q, %q", p, h, tt.path, hash)
+			}
+		})
+	}
+}
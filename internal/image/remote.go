@@ -0,0 +1,48 @@
+package image
+
+import (
+	"path"
+	"sort"
+
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/repo"
+)
+
+// listStagedFiles lists the content of a folder inside an image stored on a repository whose data cannot be read from the local filesystem
+func (img *Image) listStagedFiles(r repo.StagedRepository, imagePath string, p api_helpers.PaginationParams) (api_helpers.PaginatedResponse[File], error) {
+	entries, err := r.ListImageFolder(img.Uuid, imagePath)
+	if err != nil {
+		return api_helpers.PaginatedResponse[File]{}, err
+	}
+
+	files := make([]File, 0, len(entries))
+	for _, entry := range entries {
+		files = append(files, File{
+			Name:       path.Base(entry.Path),
+			Path:       entry.Path,
+			IsDir:      entry.Mode.IsDir(),
+			Size:       entry.Size,
+			Mode:       entry.Mode.String(),
+			ModTime:    entry.ModTime,
+			Uid:        entry.Uid,
+			Gid:        entry.Gid,
+			LinkTarget: entry.LinkTarget,
+		})
+	}
+	sort.Slice(files, func(i, k int) bool {
+		return files[i].Name < files[k].Name
+	})
+
+	count := uint64(len(files))
+	start := min(p.Offset, count)
+	end := count
+	if p.Limit > 0 {
+		end = min(start+p.Limit, count)
+	}
+
+	return api_helpers.PaginatedResponse[File]{
+		Pagination: p,
+		Count:      count,
+		Data:       files[start:end],
+	}, nil
+}
@@ -0,0 +1,231 @@
+package image
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/repo"
+	"github.com/macarrie/relique/internal/s3/s3test"
+)
+
+// setupRemoteTestImage returns the test image twice: stored on a local repository and on an ssh repository pointing to the same folder.
+// The ssh client is replaced by a script running commands locally, standing in for a remote sshd
+func setupRemoteTestImage(t *testing.T) (Image, Image) {
+	local := setupTestImage(t)
+	localRepo := local.Repository.(*repo.RepositoryLocal)
+
+	script := filepath.Join(t.TempDir(), "ssh")
+	content := `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-o|-p|-l) shift 2 ;;
+		-*) shift ;;
+		*) break ;;
+	esac
+done
+shift
+exec sh -c "$*"
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("cannot write ssh stand-in: %v", err)
+	}
+	previous := repo.SSH_COMMAND
+	repo.SSH_COMMAND = script
+	t.Cleanup(func() {
+		repo.SSH_COMMAND = previous
+	})
+
+	sshRepo := repo.RepoRemoteSSHNew("remote", "localhost", "", 0, localRepo.Path, false)
+	remote := local
+	remote.Repository = &sshRepo
+
+	return local, remote
+}
+
+func TestImage_ListFiles_Remote(t *testing.T) {
+	local, remote := setupRemoteTestImage(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		page    api_helpers.PaginationParams
+		wantErr error
+	}{
+		{
+			name: "root",
+			path: "/",
+		},
+		{
+			name: "folder",
+			path: "/etc",
+		},
+		{
+			name: "paginated",
+			path: "etc",
+			page: api_helpers.PaginationParams{Limit: 2, Offset: 2},
+		},
+		{
+			name:    "not_a_folder",
+			path:    "/etc/a.conf",
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name:    "symlink",
+			path:    "/etc/root",
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name:    "through_symlink",
+			path:    "/etc/root/etc",
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name:    "not_found",
+			path:    "/var",
+			wantErr: fs.ErrNotExist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := remote.ListFiles(tt.path, tt.page)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ListFiles() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ListFiles() error = %v", err)
+			}
+
+			// Remote listing must describe files exactly like local listing
+			want, err := local.ListFiles(tt.path, tt.page)
+			if err != nil {
+				t.Fatalf("ListFiles() on local repository error = %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ListFiles() got = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestImage_RemoteUnsupported(t *testing.T) {
+	_, remote := setupRemoteTestImage(t)
+
+	if _, err := remote.ResolvePath("/etc"); !errors.Is(err, ErrRemoteRepository) {
+		t.Errorf("ResolvePath() error = %v, want %v", err, ErrRemoteRepository)
+	}
+	if _, err := remote.Verify(); !errors.Is(err, ErrRemoteRepository) {
+		t.Errorf("Verify() error = %v, want %v", err, ErrRemoteRepository)
+	}
+	if _, err := Compare(remote, remote); !errors.Is(err, ErrRemoteRepository) {
+		t.Errorf("Compare() error = %v, want %v", err, ErrRemoteRepository)
+	}
+}
+
+// setupChunkedTestImage returns the test image twice: stored on a local repository and uploaded to an S3 repository backed by an in-process fake server
+func setupChunkedTestImage(t *testing.T) (Image, Image) {
+	local := setupTestImage(t)
+	repo.DATA_PATH = t.TempDir()
+
+	server := s3test.New("relique")
+	t.Cleanup(server.Close)
+
+	s3Repo := repo.RepoS3New("bucket", server.URL, "", "relique", "", "access", "secret", false)
+	remote := local
+	remote.Repository = &s3Repo
+
+	dataPath, err := local.GetDataPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s3Repo.Upload(dataPath, s3Repo.GetStoragePath(local.Uuid)); err != nil {
+		t.Fatalf("cannot upload test image: %v", err)
+	}
+
+	return local, remote
+}
+
+func TestImage_ListFiles_Chunked(t *testing.T) {
+	local, remote := setupChunkedTestImage(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		page    api_helpers.PaginationParams
+		wantErr error
+	}{
+		{
+			name: "root",
+			path: "/",
+		},
+		{
+			name: "folder",
+			path: "/etc",
+		},
+		{
+			name: "paginated",
+			path: "etc",
+			page: api_helpers.PaginationParams{Limit: 2, Offset: 2},
+		},
+		{
+			name:    "not_a_folder",
+			path:    "/etc/a.conf",
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name:    "symlink",
+			path:    "/etc/root",
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name:    "through_symlink",
+			path:    "/etc/root/etc",
+			wantErr: ErrInvalidPath,
+		},
+		{
+			name:    "not_found",
+			path:    "/var",
+			wantErr: fs.ErrNotExist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := remote.ListFiles(tt.path, tt.page)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("ListFiles() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ListFiles() error = %v", err)
+			}
+
+			// Index based listing must describe files exactly like local listing
+			want, err := local.ListFiles(tt.path, tt.page)
+			if err != nil {
+				t.Fatalf("ListFiles() on local repository error = %v", err)
+			}
+			for i := range want.Data {
+				want.Data[i].ModTime = want.Data[i].ModTime.UTC()
+			}
+			for i := range got.Data {
+				got.Data[i].ModTime = got.Data[i].ModTime.UTC()
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ListFiles() got = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	if _, err := remote.ResolvePath("/etc"); !errors.Is(err, ErrRemoteRepository) {
+		t.Errorf("ResolvePath() error = %v, want %v", err, ErrRemoteRepository)
+	}
+}
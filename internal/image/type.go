@@ -22,6 +22,19 @@ type Image struct {
 	NumberOfFiles    int             `json:"number_of_files"`
 	NumberOfFolders  int             `json:"number_of_folders"`
 	SizeOnDisk       uint64          `json:"size_on_disk"`
+	// Space freed in the repository if the image is deleted, and space it shares with other images through hardlinks or deduplicated chunks
+	UniqueSize uint64 `json:"unique_size"`
+	SharedSize uint64 `json:"shared_size"`
+	// Size of image data that was not already stored in the repository when the image was created
+	NewDataSize uint64 `json:"new_data_size"`
+	// Share of image data that was already stored in the repository, between 0 and 1
+	DedupRatio float64 `json:"dedup_ratio"`
+	// Image this image has been copied from by a copy job, empty for images generated by backups
+	SourceImageUuid string `json:"source_image_uuid"`
+
+	// Last integrity verification result, empty if image has never been verified
+	VerifiedAt         time.Time `json:"verified_at"`
+	VerificationStatus string    `json:"verification_status"`
 
 	ClientName string
 	ModuleName string
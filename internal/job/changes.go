@@ -0,0 +1,79 @@
+package job
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/macarrie/relique/internal/api_helpers"
+	rsync_lib "github.com/macarrie/relique/internal/rsync_task/lib"
+	"github.com/macarrie/relique/internal/utils"
+)
+
+func getChangesPath(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/changes.jsonl", utils.GetCatalogPath(uuid)))
+}
+
+// saveChanges writes file changes of every job task to the catalog, one JSON object per line
+func (j *Job) saveChanges() error {
+	f, err := os.Create(getChangesPath(j.Uuid))
+	if err != nil {
+		return fmt.Errorf("cannot create job changes file: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for i, _ := range j.Tasks {
+		for _, c := range j.Tasks[i].Changes {
+			if err := encoder.Encode(c); err != nil {
+				return fmt.Errorf("cannot write job change: %w", err)
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("cannot write job changes file: %w", err)
+	}
+
+	return nil
+}
+
+// ReadChanges reads file changes of a job from the catalog. Changes can be filtered on change type
+func ReadChanges(uuid string, p api_helpers.PaginationParams, changeType string) (api_helpers.PaginatedResponse[rsync_lib.Change], error) {
+	f, err := os.Open(getChangesPath(uuid))
+	if err != nil {
+		return api_helpers.PaginatedResponse[rsync_lib.Change]{}, fmt.Errorf("cannot open job changes file: %w", err)
+	}
+	defer f.Close()
+
+	changes := make([]rsync_lib.Change, 0)
+	var count uint64
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var c rsync_lib.Change
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return api_helpers.PaginatedResponse[rsync_lib.Change]{}, fmt.Errorf("cannot parse job change: %w", err)
+		}
+		if changeType != "" && c.Type != changeType {
+			continue
+		}
+
+		if count >= p.Offset && (p.Limit == 0 || uint64(len(changes)) < p.Limit) {
+			changes = append(changes, c)
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return api_helpers.PaginatedResponse[rsync_lib.Change]{}, fmt.Errorf("cannot read job changes file: %w", err)
+	}
+
+	return api_helpers.PaginatedResponse[rsync_lib.Change]{
+		Pagination: p,
+		Count:      count,
+		Data:       changes,
+	}, nil
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/Masterminds/squirrel"
 	sq "github.com/Masterminds/squirrel"
@@ -11,7 +12,10 @@ import (
 	"github.com/macarrie/relique/internal/api_helpers"
 	"github.com/macarrie/relique/internal/backup_type"
 	"github.com/macarrie/relique/internal/client"
+	"github.com/macarrie/relique/internal/config"
 	"github.com/macarrie/relique/internal/db"
+	"github.com/macarrie/relique/internal/job_status"
+	"github.com/macarrie/relique/internal/job_type"
 	"github.com/macarrie/relique/internal/module"
 	"github.com/macarrie/relique/internal/repo"
 	rsync_lib "github.com/macarrie/relique/internal/rsync_task/lib"
@@ -42,6 +46,7 @@ func (j *Job) Save() (int64, error) {
 		if err := tx.Commit(); err != nil {
 			return 0, fmt.Errorf("cannot commit job save transaction: %w", err)
 		}
+		j.saveRecordOrLog()
 
 		return id, err
 	}
@@ -51,6 +56,7 @@ func (j *Job) Save() (int64, error) {
 	request := sq.Insert("jobs").SetMap(sq.Eq{
 		"uuid":               j.Uuid,
 		"status":             j.Status.Status,
+		"status_message":     j.StatusMessage,
 		"backup_type":        j.BackupType.Type,
 		"job_type":           j.JobType.Type,
 		"done":               j.Done,
@@ -61,6 +67,7 @@ func (j *Job) Save() (int64, error) {
 		"repo_name":          j.Repository.GetName(),
 		"previous_job_uuid":  j.PreviousJobUuid,
 		"restore_image_uuid": j.RestoreImageUuid,
+		"source_image_uuid":  j.SourceImageUuid,
 	})
 	query, args, err := request.ToSql()
 	if err != nil {
@@ -81,15 +88,49 @@ func (j *Job) Save() (int64, error) {
 	if err := tx.Commit(); err != nil {
 		return 0, fmt.Errorf("cannot commit job save transaction: %w", err)
 	}
+	j.saveRecordOrLog()
 
 	return j.ID, nil
 }
 
+// saveRecordOrLog keeps job record in catalog and in repository metadata in sync with database. Failing to do so does not prevent the job from running
+func (j *Job) saveRecordOrLog() {
+	if err := j.saveRecord(); err != nil {
+		j.GetLog().With(
+			slog.Any("error", err),
+		).Warn("Cannot save job record to catalog")
+		return
+	}
+	if err := j.refreshMetadata(); err != nil {
+		j.GetLog().With(
+			slog.Any("error", err),
+		).Warn("Cannot update job metadata in repository")
+	}
+}
+
+// Exists checks if a job with this uuid is stored in database
+func Exists(uuid string) (bool, error) {
+	var count int
+
+	request := sq.Select("COUNT(*)").From("jobs").Where("uuid = ?", uuid)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return false, fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	if err := db.Handler().QueryRow(query, args...).Scan(&count); err != nil {
+		return false, fmt.Errorf("cannot count jobs from db: %w", err)
+	}
+
+	return count > 0, nil
+}
+
 func (j *Job) Update(tx *sql.Tx) (int64, error) {
 	j.GetLog().Debug("Updating job details into database")
 
 	request := sq.Update("jobs").SetMap(sq.Eq{
 		"status":             j.Status.Status,
+		"status_message":     j.StatusMessage,
 		"backup_type":        j.BackupType.Type,
 		"job_type":           j.JobType.Type,
 		"done":               j.Done,
@@ -100,6 +141,7 @@ func (j *Job) Update(tx *sql.Tx) (int64, error) {
 		"repo_name":          j.Repository.GetName(),
 		"previous_job_uuid":  j.PreviousJobUuid,
 		"restore_image_uuid": j.RestoreImageUuid,
+		"source_image_uuid":  j.SourceImageUuid,
 	}).Where(
 		"uuid = ?",
 		j.Uuid,
@@ -136,6 +178,7 @@ func GetByUuid(uuid string) (Job, error) {
 		"id",
 		"uuid",
 		"status",
+		"status_message",
 		"backup_type",
 		"job_type",
 		"done",
@@ -146,6 +189,7 @@ func GetByUuid(uuid string) (Job, error) {
 		"repo_name",
 		"previous_job_uuid",
 		"restore_image_uuid",
+		"source_image_uuid",
 	).From("jobs").Where("uuid = ?", uuid)
 	query, args, err := request.ToSql()
 	if err != nil {
@@ -158,6 +202,7 @@ func GetByUuid(uuid string) (Job, error) {
 	if err := row.Scan(&job.ID,
 		&job.Uuid,
 		&job.Status.Status,
+		&job.StatusMessage,
 		&job.BackupType.Type,
 		&job.JobType.Type,
 		&job.Done,
@@ -168,6 +213,7 @@ func GetByUuid(uuid string) (Job, error) {
 		&job.RepoName,
 		&job.PreviousJobUuid,
 		&job.RestoreImageUuid,
+		&job.SourceImageUuid,
 	); err == sql.ErrNoRows {
 		return Job{}, fmt.Errorf("no job with UUID '%s' found in db", uuid)
 	} else if err != nil {
@@ -191,7 +237,7 @@ func GetByUuid(uuid string) (Job, error) {
 	job.Client = cl
 
 	repoFilePath := fmt.Sprintf("%s/repo.toml", jobCatalogPath)
-	r, err := repo.LoadFromFile(repoFilePath)
+	r, err := repo.LoadDefinition(repoFilePath, config.Current.Repositories)
 	if err != nil {
 		return Job{}, fmt.Errorf("linked repo cannot be loaded from file: %w", err)
 	}
@@ -336,23 +382,43 @@ func Count(s api_helpers.JobSearch) (uint64, error) {
 	return count, nil
 }
 
+// GetPrevious returns the last backup job of the specified backup type that generated an image for the same client and module in the job repository
 func GetPrevious(j Job, backupType backup_type.BackupType) (Job, error) {
 	j.GetLog().With(
 		slog.String("backup_type", j.BackupType.String()),
 	).Debug("Looking for previous backup job")
 
+	return getLastImageJob(j, job_type.Backup, sq.Eq{"jobs.backup_type": backupType.Type})
+}
+
+// GetPreviousCopy returns the last copy job that generated an image for the same client and module in the job repository
+func GetPreviousCopy(j Job) (Job, error) {
+	j.GetLog().Debug("Looking for previous copy job")
+
+	return getLastImageJob(j, job_type.Copy, sq.Eq{})
+}
+
+func getLastImageJob(j Job, jobType uint8, filter sq.Eq) (Job, error) {
 	request := sq.Select(
 		"uuid",
 	).From(
 		"jobs",
 	).Where(
-		"jobs.backup_type = ?", backupType.Type,
+		"jobs.job_type = ?", jobType,
+	).Where(
+		filter,
 	).Where(
 		"jobs.done = ?", true,
+	).Where(
+		// Only jobs that generated an image can be used as diff reference
+		sq.Eq{"jobs.status": []uint8{job_status.Success, job_status.Incomplete}},
 	).Where(
 		"jobs.client_name = ?", j.Client.Name,
 	).Where(
 		"jobs.module_name = ?", j.Module.Name,
+	).Where(
+		// Diff references are looked up in the job repository
+		"jobs.repo_name = ?", j.Repository.GetName(),
 	).OrderBy(
 		"jobs.id DESC",
 	)
@@ -406,3 +472,261 @@ func GetPrevious(j Job, backupType backup_type.BackupType) (Job, error) {
 
 	return jobFromDB, nil
 }
+
+func GetLastBackupStartTime(clientName string, moduleName string) (time.Time, error) {
+	slog.With(
+		slog.String("client", clientName),
+		slog.String("module", moduleName),
+	).Debug("Looking for last backup job start time")
+
+	request := sq.Select(
+		"start_time",
+	).From(
+		"jobs",
+	).Where(
+		"jobs.job_type = ?", job_type.Backup,
+	).Where(
+		"jobs.client_name = ?", clientName,
+	).Where(
+		"jobs.module_name = ?", moduleName,
+	).OrderBy(
+		"jobs.id DESC",
+	).Limit(1)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	var startTime time.Time
+	if err := db.Handler().QueryRow(query, args...).Scan(&startTime); err == sql.ErrNoRows {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("cannot get last backup job start time from db: %w", err)
+	}
+
+	return startTime, nil
+}
+
+// GetByPreviousJobUuid lists jobs using the specified job as diff reference. Only database fields are loaded
+func GetByPreviousJobUuid(uuid string) ([]Job, error) {
+	slog.With(
+		slog.String("previous_job_uuid", uuid),
+	).Debug("Looking for jobs referencing previous job in database")
+
+	request := sq.Select(
+		"id",
+		"uuid",
+		"status",
+		"done",
+		"client_name",
+		"module_name",
+		"repo_name",
+		"previous_job_uuid",
+	).From(
+		"jobs",
+	).Where(
+		"jobs.previous_job_uuid = ?", uuid,
+	).OrderBy(
+		"jobs.id DESC",
+	)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return []Job{}, fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	rows, err := db.Handler().Query(query, args...)
+	if err != nil {
+		return []Job{}, fmt.Errorf("cannot query referencing jobs from db: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]Job, 0)
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(
+			&j.ID,
+			&j.Uuid,
+			&j.Status.Status,
+			&j.Done,
+			&j.ClientName,
+			&j.ModuleName,
+			&j.RepoName,
+			&j.PreviousJobUuid,
+		); err != nil {
+			return []Job{}, fmt.Errorf("cannot parse job from db: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// GetBySourceImageUuid lists copy jobs replicating the specified image. Only database fields are loaded
+func GetBySourceImageUuid(uuid string) ([]Job, error) {
+	slog.With(
+		slog.String("source_image_uuid", uuid),
+	).Debug("Looking for jobs copying image in database")
+
+	request := sq.Select(
+		"id",
+		"uuid",
+		"status",
+		"done",
+		"client_name",
+		"module_name",
+		"repo_name",
+		"source_image_uuid",
+	).From(
+		"jobs",
+	).Where(
+		"jobs.source_image_uuid = ?", uuid,
+	).OrderBy(
+		"jobs.id DESC",
+	)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return []Job{}, fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	rows, err := db.Handler().Query(query, args...)
+	if err != nil {
+		return []Job{}, fmt.Errorf("cannot query copy jobs from db: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]Job, 0)
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(
+			&j.ID,
+			&j.Uuid,
+			&j.Status.Status,
+			&j.Done,
+			&j.ClientName,
+			&j.ModuleName,
+			&j.RepoName,
+			&j.SourceImageUuid,
+		); err != nil {
+			return []Job{}, fmt.Errorf("cannot parse job from db: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// ReplacePreviousJobUuid makes jobs referencing oldUuid as diff reference point to newUuid instead
+func ReplacePreviousJobUuid(tx *sql.Tx, oldUuid string, newUuid string) error {
+	slog.With(
+		slog.String("old_previous_job_uuid", oldUuid),
+		slog.String("new_previous_job_uuid", newUuid),
+	).Debug("Updating previous job references in database")
+
+	request := sq.Update("jobs").Set(
+		"previous_job_uuid", newUuid,
+	).Where(
+		"previous_job_uuid = ?", oldUuid,
+	)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("cannot update previous job references into db: %w", err)
+	}
+
+	return nil
+}
+
+func DeleteByUuid(tx *sql.Tx, uuid string) error {
+	slog.With(
+		slog.String("uuid", uuid),
+	).Debug("Deleting job from database")
+
+	request := sq.Delete("jobs").Where("uuid = ?", uuid)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("cannot delete job from db: %w", err)
+	}
+
+	return nil
+}
+
+// GetUnfinished lists jobs that are not marked as done in database. Only database fields are loaded since catalog files may not have been written for jobs that stopped during setup
+func GetUnfinished() ([]Job, error) {
+	slog.Debug("Looking for unfinished jobs in database")
+
+	request := sq.Select(
+		"id",
+		"uuid",
+		"status",
+		"job_type",
+		"client_name",
+		"module_name",
+		"repo_name",
+	).From(
+		"jobs",
+	).Where(
+		"jobs.done = ?", false,
+	).OrderBy(
+		"jobs.id ASC",
+	)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	rows, err := db.Handler().Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot query unfinished jobs from db: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := make([]Job, 0)
+	for rows.Next() {
+		var j Job
+		if err := rows.Scan(
+			&j.ID,
+			&j.Uuid,
+			&j.Status.Status,
+			&j.JobType.Type,
+			&j.ClientName,
+			&j.ModuleName,
+			&j.RepoName,
+		); err != nil {
+			return nil, fmt.Errorf("cannot parse job from db: %w", err)
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+// MarkAsOrphaned sets an unfinished job in error with the specified reason
+func MarkAsOrphaned(uuid string, reason string) error {
+	request := sq.Update("jobs").SetMap(sq.Eq{
+		"status":         job_status.Error,
+		"status_message": reason,
+		"done":           true,
+		"end_time":       time.Now(),
+	}).Where(
+		"uuid = ?", uuid,
+	).Where(
+		"done = ?", false,
+	)
+	query, args, err := request.ToSql()
+	if err != nil {
+		return fmt.Errorf("cannot build sql query: %w", err)
+	}
+
+	if _, err := db.Handler().Exec(query, args...); err != nil {
+		return fmt.Errorf("cannot update job into db: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package job
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/macarrie/relique/internal/client"
+	"github.com/macarrie/relique/internal/job_type"
+	"github.com/macarrie/relique/internal/module"
+)
+
+func (j *Job) getPreHook() (string, module.Hook) {
+	switch j.JobType.Type {
+	case job_type.Restore:
+		return "pre_restore", j.Module.PreRestore
+	case job_type.Copy:
+		// Copy jobs do not run on clients
+		return "", module.Hook{}
+	}
+
+	return "pre_backup", j.Module.PreBackup
+}
+
+func (j *Job) getPostHook() (string, module.Hook) {
+	switch j.JobType.Type {
+	case job_type.Restore:
+		return "post_restore", j.Module.PostRestore
+	case job_type.Copy:
+		return "", module.Hook{}
+	}
+
+	return "post_backup", j.Module.PostBackup
+}
+
+// runHook executes hook commands on the client over SSH. Hook output is written to job logs folder
+func (j *Job) runHook(name string, h module.Hook) error {
+	logger := j.GetLog().With(
+		slog.String("hook", name),
+	)
+
+	content, err := h.GetContent(j.Module.ModuleType)
+	if err != nil {
+		return fmt.Errorf("cannot get hook content: %w", err)
+	}
+
+	sshUser := j.Client.SSHUser
+	if sshUser == "" {
+		sshUser = client.DEFAULT_SSH_USER
+	}
+	sshPort := j.Client.SSHPort
+	if sshPort == 0 {
+		sshPort = client.DEFAULT_SSH_PORT
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.GetTimeout())
+	defer cancel()
+
+	// Commands are sent through stdin so that scripts do not need to be installed on the client
+	cmd := exec.CommandContext(ctx, "ssh", "-o BatchMode=yes", "-p", fmt.Sprint(sshPort), fmt.Sprintf("%s@%s", sshUser, j.Client.Address), "sh -s")
+	cmd.Stdin = strings.NewReader(content)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	logger.Info("Running hook on client")
+	runErr := cmd.Run()
+
+	storagePath, err := j.GetWorkFolderPath()
+	if err != nil {
+		logger.With(slog.Any("error", err)).Error("Cannot get job storage path to write hook log")
+	} else {
+		logFile := filepath.Clean(fmt.Sprintf("%s/_logs/hook_%s.log", storagePath, name))
+		if err := os.WriteFile(logFile, output.Bytes(), 0644); err != nil {
+			logger.With(slog.Any("error", err)).Error("Cannot write hook log to file")
+		}
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("hook timed out after %s", h.GetTimeout())
+	}
+	if runErr != nil {
+		return fmt.Errorf("hook execution failed: %w", runErr)
+	}
+
+	logger.Info("Hook execution successful")
+	return nil
+}
@@ -52,9 +52,29 @@ func NewRestore(img image.Image, targetClient client.Client, restorePaths map[st
 	}
 }
 
+// NewCopy creates a job replicating an image into another repository. The copied image keeps the client and module of the source image
+func NewCopy(img image.Image, target repo.Repository) Job {
+	return Job{
+		Uuid:            uuid.New().String(),
+		JobType:         job_type.New(job_type.Copy),
+		Client:          img.Client,
+		Module:          img.Module,
+		Repository:      target,
+		Status:          job_status.New(job_status.Pending),
+		BackupType:      backup_type.New(backup_type.Full),
+		SourceImageUuid: img.Uuid,
+		copySource:      img.Repository,
+	}
+}
+
 func (j *Job) SetupBackup() error {
 	j.GetLog().Debug("Starting job setup")
 
+	// Lock has to be held before the job is saved as active to prevent it from being considered as orphaned by other relique processes
+	if err := j.acquireLock(); err != nil {
+		return fmt.Errorf("cannot acquire job lock: %w", err)
+	}
+
 	j.Status.Status = job_status.Active
 	j.StartTime = time.Now()
 
@@ -98,22 +118,33 @@ func (j *Job) SetupBackup() error {
 		}
 	}
 
+	if err := j.checkSpace(); err != nil {
+		return err
+	}
+
 	j.GetLog().Debug("Creating job storage folder")
-	jobFolderPath, err := j.GetStorageFolderPath()
+	jobFolderPath, err := j.GetWorkFolderPath()
 	if err != nil {
 		return fmt.Errorf("cannot determine job storage folder: %w", err)
 	}
 
-	if err := os.MkdirAll(fmt.Sprintf("%s/_data", jobFolderPath), 0755); err != nil {
-		return fmt.Errorf("cannot setup job data folder: %w", err)
+	if err := j.Repository.PrepareImage(j.Uuid); err != nil {
+		return fmt.Errorf("cannot prepare image location in repository: %w", err)
 	}
-	if err := os.MkdirAll(fmt.Sprintf("%s/_logs", jobFolderPath), 0755); err != nil {
-		return fmt.Errorf("cannot setup job logs folder: %w", err)
+
+	backupType := j.BackupType.Type
+	var linkDest string
+	if backupType == backup_type.Diff {
+		linkDest = j.Repository.GetLinkDest(j.PreviousJobUuid)
+		if linkDest == "" {
+			// Previous image cannot be referenced from job destination, deduplication against previous images is done by the repository when data is pushed
+			backupType = backup_type.Full
+		}
 	}
 
 	var tasks []rsync_task.RsyncTask
 	for _, backupPath := range j.Module.BackupPaths {
-		switch j.BackupType.Type {
+		switch backupType {
 		case backup_type.Full:
 			tasks = append(tasks, rsync_task.NewFullBackup(
 				// Source
@@ -131,17 +162,13 @@ func (j *Job) SetupBackup() error {
 			))
 
 		case backup_type.Diff:
-			previousJobFolderPath, err := j.PreviousJob.GetStorageFolderPath()
-			if err != nil {
-				return fmt.Errorf("cannot determine job storage folder: %w", err)
-			}
 			tasks = append(tasks, rsync_task.NewDiffBackup(
 				// Source
 				fmt.Sprintf("%s@%s:%s", j.Client.SSHUser, j.Client.Address, backupPath),
 				// Destination
 				filepath.Clean(fmt.Sprintf("%s/_data/", jobFolderPath)),
 				// Previous job folder for comparison
-				filepath.Clean(fmt.Sprintf("%s/_data/", previousJobFolderPath)),
+				linkDest,
 				// Log folder
 				filepath.Clean(fmt.Sprintf("%s/_logs/", jobFolderPath)),
 				// Backup path
@@ -157,26 +184,8 @@ func (j *Job) SetupBackup() error {
 	}
 	j.Tasks = tasks
 
-	j.GetLog().Debug("Creating job catalog folder")
-	jobCatalogPath := j.GetCatalogPath()
-
-	if err := os.MkdirAll(jobCatalogPath, 0755); err != nil {
-		return fmt.Errorf("cannot setup job catalog folder: %w", err)
-	}
-
-	// Save module used to file in job folder path. Modules configuration files can change so we need to keep trace of the exact module used for backup
-	if err := utils.SerializeToFile[module.Module](j.Module, fmt.Sprintf("%s/module.toml", jobCatalogPath)); err != nil {
-		return fmt.Errorf("cannot export module to file: %w", err)
-	}
-
-	// Save client to file in job folder path. Client configuration files can change so we need to keep trace of the exact client used for backup for later reference
-	if err := utils.SerializeToFile[client.Client](j.Client, fmt.Sprintf("%s/client.toml", jobCatalogPath)); err != nil {
-		return fmt.Errorf("cannot export client to file: %w", err)
-	}
-
-	// Save repo to file in job folder path. Repo configuration files can change so we need to keep trace of the exact repo used for backup for later reference
-	if err := utils.SerializeToFile[repo.Repository](j.Repository, fmt.Sprintf("%s/repo.toml", jobCatalogPath)); err != nil {
-		return fmt.Errorf("cannot export repository to file: %w", err)
+	if err := j.setupCatalog(); err != nil {
+		return err
 	}
 
 	if _, err := j.Save(); err != nil {
@@ -194,12 +203,25 @@ func (j *Job) SetupRestore() error {
 		return fmt.Errorf("restore job has no target image UUID to restore data from")
 	}
 
+	if err := j.acquireLock(); err != nil {
+		return fmt.Errorf("cannot acquire job lock: %w", err)
+	}
+
+	j.Status.Status = job_status.Active
+	j.StartTime = time.Now()
+
 	j.GetLog().Debug("Creating job storage folder")
-	jobFolderPath, err := j.GetStorageFolderPath()
+	jobFolderPath, err := j.GetWorkFolderPath()
 	if err != nil {
 		return fmt.Errorf("cannot determine job storage folder: %w", err)
 	}
 
+	if _, ok := j.Repository.(repo.StagedRepository); ok {
+		// Restored data is fetched decrypted into the staging folder, keep it private
+		if err := os.MkdirAll(jobFolderPath, 0700); err != nil {
+			return fmt.Errorf("cannot setup job staging folder: %w", err)
+		}
+	}
 	if err := os.MkdirAll(fmt.Sprintf("%s/_logs", jobFolderPath), 0755); err != nil {
 		return fmt.Errorf("cannot setup job logs folder: %w", err)
 	}
@@ -213,6 +235,11 @@ func (j *Job) SetupRestore() error {
 		return fmt.Errorf("cannot determine job storage folder: %w", err)
 	}
 
+	if _, ok := j.Repository.(repo.StagedRepository); ok {
+		// Image data cannot be sent from the repository to the client directly, restored paths are first fetched into job staging folder
+		restoreSourceFolderPath = jobFolderPath
+	}
+
 	var tasks []rsync_task.RsyncTask
 	if len(j.CustomRestorePaths) == 0 {
 		for _, backupPath := range j.Module.BackupPaths {
@@ -251,6 +278,108 @@ func (j *Job) SetupRestore() error {
 	}
 	j.Tasks = tasks
 
+	if err := j.setupCatalog(); err != nil {
+		return err
+	}
+
+	if _, err := j.Save(); err != nil {
+		return fmt.Errorf("cannot save job info to database after setup complete: %w", err)
+	}
+
+	// TODO: Add job setup event
+	return nil
+}
+
+func (j *Job) SetupCopy() error {
+	j.GetLog().Debug("Starting job setup")
+
+	if j.SourceImageUuid == "" || j.copySource == nil {
+		return fmt.Errorf("copy job has no source image to copy data from")
+	}
+	if j.copySource.GetName() == j.Repository.GetName() {
+		return fmt.Errorf("image is already stored in repository '%s'", j.Repository.GetName())
+	}
+
+	var sourceDataPath string
+	if stagedSource, ok := j.copySource.(repo.StagedRepository); ok {
+		// Image data cannot be read from the source repository directly, it is first fetched into the source repository staging folder
+		sourceDataPath = stagedSource.GetDestination(j.Uuid)
+		if sourceDataPath == j.Repository.GetDestination(j.Uuid) {
+			return fmt.Errorf("source repository '%s' and target repository '%s' use the same staging folder", j.copySource.GetName(), j.Repository.GetName())
+		}
+	} else {
+		sourceDataPath = j.copySource.GetStoragePath(j.SourceImageUuid)
+	}
+	if sourceDataPath == "" {
+		return fmt.Errorf("cannot determine source image storage folder: repo type not implemented")
+	}
+
+	if err := j.acquireLock(); err != nil {
+		return fmt.Errorf("cannot acquire job lock: %w", err)
+	}
+
+	j.Status.Status = job_status.Active
+	j.StartTime = time.Now()
+
+	if _, err := j.Save(); err != nil {
+		return fmt.Errorf("cannot save job info to database before start: %w", err)
+	}
+
+	// Data is deduplicated against the last image copied into the target repository for the same client and module
+	j.GetLog().Debug("Looking for previous copy job to deduplicate data against")
+	if previousCopyJob, err := GetPreviousCopy(*j); err == nil {
+		j.PreviousJobUuid = previousCopyJob.Uuid
+		j.PreviousJob = &previousCopyJob
+		j.BackupType.Type = backup_type.Diff
+		j.GetLog().With(
+			slog.String("previous_job_uuid", j.PreviousJobUuid),
+		).Debug("Previous copy job found for data deduplication")
+	} else {
+		j.GetLog().Info("No previous copy job found in target repository. All image data is copied")
+	}
+
+	j.GetLog().Debug("Creating job storage folder")
+	jobFolderPath, err := j.GetWorkFolderPath()
+	if err != nil {
+		return fmt.Errorf("cannot determine job storage folder: %w", err)
+	}
+
+	if err := j.Repository.PrepareImage(j.Uuid); err != nil {
+		return fmt.Errorf("cannot prepare image location in repository: %w", err)
+	}
+
+	var linkDest string
+	if j.BackupType.Type == backup_type.Diff {
+		// Empty for staged repositories, which deduplicate data when it is pushed
+		linkDest = j.Repository.GetLinkDest(j.PreviousJobUuid)
+	}
+
+	j.Tasks = []rsync_task.RsyncTask{
+		rsync_task.NewCopy(
+			// Source
+			fmt.Sprintf("%s/_data/", sourceDataPath),
+			// Destination
+			filepath.Clean(fmt.Sprintf("%s/_data/", jobFolderPath)),
+			// Previous copied image for comparison
+			linkDest,
+			// Log folder
+			filepath.Clean(fmt.Sprintf("%s/_logs/", jobFolderPath)),
+		),
+	}
+
+	if err := j.setupCatalog(); err != nil {
+		return err
+	}
+
+	if _, err := j.Save(); err != nil {
+		return fmt.Errorf("cannot save job info to database after setup complete: %w", err)
+	}
+
+	return nil
+}
+
+// setupCatalog creates job catalog folder and saves the module, client and repository used by the job into it
+func (j *Job) setupCatalog() error {
 	j.GetLog().Debug("Creating job catalog folder")
 	jobCatalogPath := j.GetCatalogPath()
 
@@ -268,37 +397,90 @@ func (j *Job) SetupRestore() error {
 		return fmt.Errorf("cannot export client to file: %w", err)
 	}
 
-	// Save repo to file in job folder path. Repo configuration files can change so we need to keep trace of the exact repo used for backup for later reference
-	if err := utils.SerializeToFile[repo.Repository](j.Repository, fmt.Sprintf("%s/repo.toml", jobCatalogPath)); err != nil {
+	// Save repo to file in job folder path. Repo configuration files can change so we need to keep trace of the exact repo used for backup for later reference. Repository credentials are not saved
+	if err := repo.WriteDefinition(j.Repository, fmt.Sprintf("%s/repo.toml", jobCatalogPath)); err != nil {
 		return fmt.Errorf("cannot export repository to file: %w", err)
 	}
 
-	if _, err := j.Save(); err != nil {
-		return fmt.Errorf("cannot save job info to database after setup complete: %w", err)
-	}
-
-	// TODO: Add job setup event
 	return nil
 }
 
 func (j *Job) Start() error {
+	defer j.ReleaseLock()
+	if stagedRepo, ok := j.Repository.(repo.StagedRepository); ok {
+		// Logs and metadata are sent to the repository whatever the job outcome
+		defer j.finishRemote(stagedRepo)
+	}
+	if j.JobType.Type == job_type.Copy {
+		defer j.removeCopyStaging()
+	}
+
+	if err := j.fetchSourceData(); err != nil {
+		j.GetLog().With(slog.Any("error", err)).Error("Cannot fetch job source data, aborting job")
+		if failErr := j.Fail(err.Error()); failErr != nil {
+			return fmt.Errorf("cannot mark job as failed: %w", failErr)
+		}
+		return err
+	}
+
+	if name, hook := j.getPreHook(); hook.IsDefined() {
+		if err := j.runHook(name, hook); err != nil {
+			if hook.GetOnErrorPolicy() == module.HookOnErrorAbort {
+				j.GetLog().With(
+					slog.Any("error", err),
+					slog.String("hook", name),
+				).Error("Hook failed, aborting job")
+				if failErr := j.Fail(fmt.Sprintf("%s hook failed: %s", name, err)); failErr != nil {
+					return fmt.Errorf("cannot mark job as failed: %w", failErr)
+				}
+				return fmt.Errorf("%s hook failed: %w", name, err)
+			}
+
+			j.GetLog().With(
+				slog.Any("error", err),
+				slog.String("hook", name),
+			).Warn("Hook failed, job continues as specified by hook error policy")
+		}
+	}
+
 	j.GetLog().Debug("Starting job sync tasks")
 
 	ticker := time.NewTicker(1 * time.Second)
+	monitorDone := make(chan struct{})
+	var monitorWg sync.WaitGroup
 	var wg sync.WaitGroup
 	syncHasIncomplete := false
 	syncHasError := false
 
+	if j.CancelRequested() {
+		j.cancelTasks()
+	}
+
+	// Log progress and watch for cancellation requests, which can be sent from another relique process
+	monitorWg.Add(1)
+	go func() {
+		defer monitorWg.Done()
+		for {
+			select {
+			case <-monitorDone:
+				return
+			case <-ticker.C:
+				if j.CancelRequested() {
+					j.cancelTasks()
+				}
+				for i, _ := range j.Tasks {
+					j.Tasks[i].GetProgressLog().Info("File sync in progress")
+				}
+				if err := j.saveProgress(); err != nil {
+					j.GetLog().With(slog.Any("error", err)).Error("Cannot save job progress")
+				}
+			}
+		}
+	}()
+
 	for i, _ := range j.Tasks {
 		wg.Add(1)
 
-		go func(task *rsync_task.RsyncTask) {
-			for {
-				<-ticker.C
-				task.GetProgressLog().Info("File sync in progress")
-			}
-		}(&j.Tasks[i])
-
 		go func(task *rsync_task.RsyncTask) {
 			defer wg.Done()
 
@@ -306,7 +488,7 @@ func (j *Job) Start() error {
 				slog.String("cmd", task.Task.Rsync.Cmd.String()),
 			).Debug("Running rsync command")
 
-			if err := task.Task.Run(); err != nil {
+			if err := task.Task.Run(); err != nil && !task.Task.Cancelled() {
 				slog.With(slog.Any("error", err)).Error("Error encountered during task run")
 
 				// Rsync exit codes 23,24,25 mean that some files still may have been transferred even if exit code is != 0.
@@ -323,6 +505,7 @@ func (j *Job) Start() error {
 				}
 			}
 
+			// Logs are written even for cancelled tasks to keep track of what has been transferred before cancellation
 			logStruct := task.Task.Log()
 			if err := os.WriteFile(task.LogFile, []byte(logStruct.Stdout), 0755); err != nil {
 				slog.With(slog.Any("error", err)).Error("Cannot write task log to file")
@@ -336,11 +519,22 @@ func (j *Job) Start() error {
 				slog.With(slog.Any("error", err)).Error("Cannot get task stats")
 			}
 
+			changes, err := rsync_lib.GetChangesFromRsyncLog(task.LogFile)
+			if err != nil {
+				slog.With(slog.Any("error", err)).Error("Cannot get task file changes")
+			}
+			for i, _ := range changes {
+				changes[i].BackupPath = task.BackupPath
+			}
+			task.Changes = changes
+
 		}(&j.Tasks[i])
 	}
 
 	wg.Wait()
 	ticker.Stop()
+	close(monitorDone)
+	monitorWg.Wait()
 
 	for i, _ := range j.Tasks {
 		// Print progress at least once at the end with sync stats
@@ -360,24 +554,65 @@ func (j *Job) Start() error {
 			)).Info("File sync complete")
 	}
 
-	if syncHasIncomplete || syncHasError {
+	cancelled := false
+	for i, _ := range j.Tasks {
+		if j.Tasks[i].Task.Cancelled() {
+			cancelled = true
+		}
+	}
+
+	// Post hooks are run even if file sync failed or has been cancelled since they usually revert changes made by pre hooks
+	postHookFailed := false
+	if name, hook := j.getPostHook(); hook.IsDefined() {
+		if err := j.runHook(name, hook); err != nil {
+			j.GetLog().With(
+				slog.Any("error", err),
+				slog.String("hook", name),
+			).Error("Hook failed")
+			if hook.GetOnErrorPolicy() == module.HookOnErrorAbort {
+				postHookFailed = true
+				j.StatusMessage = fmt.Sprintf("%s hook failed: %s", name, err)
+			}
+		}
+	}
+
+	if cancelled {
+		j.GetLog().Warn("Job has been cancelled")
+		j.Status.Status = job_status.Cancelled
+	} else if syncHasIncomplete || syncHasError {
 		if syncHasIncomplete {
 			j.Status.Status = job_status.Incomplete
 		} else {
 			j.Status.Status = job_status.Error
 		}
+	} else if postHookFailed {
+		// Files have been synced successfully, data is kept
+		j.Status.Status = job_status.Incomplete
 	} else {
 		j.Status.Status = job_status.Success
 	}
 
+	if stagedRepo, ok := j.Repository.(repo.StagedRepository); ok && j.generatesImage() {
+		if j.Status.Status == job_status.Success || j.Status.Status == job_status.Incomplete {
+			if err := j.pushData(stagedRepo); err != nil {
+				j.GetLog().With(slog.Any("error", err)).Error("Cannot push image data to repository")
+				j.Status.Status = job_status.Error
+				j.StatusMessage = err.Error()
+			}
+		}
+	}
+
 	j.Done = true
 	j.EndTime = time.Now()
 	if _, err := j.Save(); err != nil {
 		return fmt.Errorf("cannot save job info to database after completion: %w", err)
 	}
+	if err := j.saveProgress(); err != nil {
+		j.GetLog().With(slog.Any("error", err)).Error("Cannot save job progress")
+	}
 
 	catalogPath := j.GetCatalogPath()
-	storagePath, err := j.GetStorageFolderPath()
+	workPath, err := j.GetWorkFolderPath()
 	if err != nil {
 		return fmt.Errorf("cannot get job storage path: %w", err)
 	}
@@ -387,16 +622,55 @@ func (j *Job) Start() error {
 		return fmt.Errorf("cannot export job stats to file: %w", err)
 	}
 
-	if j.JobType.Type == job_type.Backup {
+	if err := j.saveChanges(); err != nil {
+		j.GetLog().With(slog.Any("error", err)).Error("Cannot export job file changes")
+	}
+
+	if err := os.Remove(j.getCancelRequestPath()); err != nil && !os.IsNotExist(err) {
+		j.GetLog().With(slog.Any("error", err)).Error("Cannot remove job cancel request file")
+	}
+
+	if j.generatesImage() && j.Status.Status == job_status.Cancelled {
+		// Partially transferred data is not usable as an image, only logs are kept
+		j.GetLog().Info("Removing data transferred by cancelled job")
+		if err := os.RemoveAll(fmt.Sprintf("%s/_data", workPath)); err != nil {
+			j.GetLog().With(slog.Any("error", err)).Error("Cannot remove data transferred by cancelled job")
+		}
+	}
+
+	if j.generatesImage() {
 		if j.Status.Status == job_status.Success || j.Status.Status == job_status.Incomplete {
-			j.GetLog().Info("Generating backup image from job")
+			j.GetLog().Info("Generating image from job")
 			img := image.New(j.Client, j.Module, j.Repository)
 			img.Uuid = j.Uuid
-			if err := img.FillStats(jobStats, storagePath); err != nil {
+			img.SourceImageUuid = j.SourceImageUuid
+			// Staged data is read for remote repositories, it has not been removed yet
+			dataPath := filepath.Clean(fmt.Sprintf("%s/_data", workPath))
+			if err := img.FillStats(jobStats, workPath); err != nil {
 				return fmt.Errorf("cannot get image stats: %w", err)
 			}
+			dataStats := j.dataStats(jobStats)
+			img.FillDedupStats(dataStats.DataBytes, dataStats.NewBytes)
+			if err := img.RefreshSpace(); err != nil {
+				img.GetLog().With(slog.Any("error", err)).Error("Cannot get image space usage in repository")
+			}
 			if _, err := img.Save(); err != nil {
 				slog.With(slog.Any("error", err)).Error("Cannot save generated image to database")
+			} else {
+				if related, err := img.GetRelatedSpace(); err != nil {
+					img.GetLog().With(slog.Any("error", err)).Error("Cannot find images sharing data with image")
+				} else if err := related.Refresh(false); err != nil {
+					img.GetLog().With(slog.Any("error", err)).Error("Cannot refresh space usage of related images")
+				}
+				if err := img.IndexFilesFrom(dataPath); err != nil {
+					img.GetLog().With(slog.Any("error", err)).Error("Cannot index image files")
+				}
+				if err := img.WriteManifestFrom(dataPath); err != nil {
+					img.GetLog().With(slog.Any("error", err)).Error("Cannot write image integrity manifest")
+				}
+				if err := j.exportMetadata(); err != nil {
+					img.GetLog().With(slog.Any("error", err)).Error("Cannot export image metadata to repository")
+				}
 			}
 		} else {
 			j.GetLog().Info("No image generated for unsuccessful job")
@@ -405,3 +679,64 @@ func (j *Job) Start() error {
 
 	return nil
 }
+
+// Fail marks job as done in error with the specified reason and releases job lock. It is used when the job cannot be started
+func (j *Job) Fail(reason string) error {
+	defer j.ReleaseLock()
+
+	j.Status.Status = job_status.Error
+	j.StatusMessage = reason
+	j.Done = true
+	j.EndTime = time.Now()
+	if j.ID == 0 {
+		// Job has not been saved to database yet
+		return nil
+	}
+
+	if _, err := j.Save(); err != nil {
+		return fmt.Errorf("cannot save failed job info: %w", err)
+	}
+
+	return nil
+}
+
+// RequestCancel asks for job cancellation. The request is stored as a file in job catalog folder so that it can be picked up by the relique process running the job
+func (j *Job) RequestCancel() error {
+	if err := os.MkdirAll(j.GetCatalogPath(), 0755); err != nil {
+		return fmt.Errorf("cannot create job catalog folder: %w", err)
+	}
+
+	if err := os.WriteFile(j.getCancelRequestPath(), []byte(time.Now().Format(time.RFC3339)), 0644); err != nil {
+		return fmt.Errorf("cannot write cancel request file: %w", err)
+	}
+
+	j.GetLog().Info("Job cancellation requested")
+	return nil
+}
+
+func (j *Job) CancelRequested() bool {
+	_, err := os.Stat(j.getCancelRequestPath())
+	return err == nil
+}
+
+func (j *Job) getCancelRequestPath() string {
+	return filepath.Clean(fmt.Sprintf("%s/cancel_request", j.GetCatalogPath()))
+}
+
+func (j *Job) cancelTasks() {
+	for i, _ := range j.Tasks {
+		if j.Tasks[i].Task.Cancelled() {
+			continue
+		}
+
+		j.GetLog().With(
+			slog.String("backup_path", j.Tasks[i].BackupPath),
+		).Info("Cancelling file sync task")
+		if err := j.Tasks[i].Task.Cancel(); err != nil {
+			j.GetLog().With(
+				slog.Any("error", err),
+				slog.String("backup_path", j.Tasks[i].BackupPath),
+			).Error("Cannot stop file sync task")
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/macarrie/relique/internal/utils"
+)
+
+// Lock files held by jobs running in the current process, indexed by job uuid.
+// The lock is a flock on a file in the job catalog folder: it is released by the kernel when the process holding it dies, which allows other relique processes to detect orphaned jobs
+var locks = make(map[string]*os.File)
+var locksMutex sync.Mutex
+
+func getLockPath(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/job.lock", utils.GetCatalogPath(uuid)))
+}
+
+func (j *Job) acquireLock() error {
+	locksMutex.Lock()
+	defer locksMutex.Unlock()
+
+	if _, ok := locks[j.Uuid]; ok {
+		return nil
+	}
+
+	if err := os.MkdirAll(j.GetCatalogPath(), 0755); err != nil {
+		return fmt.Errorf("cannot create job catalog folder: %w", err)
+	}
+
+	f, err := os.OpenFile(getLockPath(j.Uuid), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open job lock file: %w", err)
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot lock job lock file: %w", err)
+	}
+
+	// Informative only, liveness is checked with the lock itself
+	hostname, _ := os.Hostname()
+	f.Truncate(0)
+	fmt.Fprintf(f, "pid=%d\nhostname=%s\n", os.Getpid(), hostname)
+
+	locks[j.Uuid] = f
+	return nil
+}
+
+// ReleaseLock releases job lock. It has to be called once the job is marked as done
+func (j *Job) ReleaseLock() {
+	locksMutex.Lock()
+	defer locksMutex.Unlock()
+
+	f, ok := locks[j.Uuid]
+	if !ok {
+		return
+	}
+
+	if err := os.Remove(f.Name()); err != nil {
+		j.GetLog().With(slog.Any("error", err)).Error("Cannot remove job lock file")
+	}
+	unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	f.Close()
+	delete(locks, j.Uuid)
+}
+
+// IsLocked checks if a live process holds the job lock
+func IsLocked(uuid string) (bool, error) {
+	locksMutex.Lock()
+	_, ok := locks[uuid]
+	locksMutex.Unlock()
+	if ok {
+		return true, nil
+	}
+
+	f, err := os.Open(getLockPath(uuid))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("cannot open job lock file: %w", err)
+	}
+	defer f.Close()
+
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return true, nil
+	} else if err != nil {
+		return false, fmt.Errorf("cannot check job lock: %w", err)
+	}
+	unix.Flock(int(f.Fd()), unix.LOCK_UN)
+
+	return false, nil
+}
@@ -0,0 +1,133 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/macarrie/relique/internal/repo"
+	"github.com/macarrie/relique/internal/utils"
+)
+
+// metadataFiles lists catalog files copied into the repository, which are needed to import an image on another relique server.
+// Repository definition is written separately so that credentials never end up in the repository
+var metadataFiles = []string{
+	"module.toml",
+	"client.toml",
+	"stats.toml",
+	"job.toml",
+	"manifest.sha256",
+}
+
+func getMetadataPath(storagePath string) string {
+	return fmt.Sprintf("%s/%s", storagePath, repo.METADATA_FOLDER)
+}
+
+// exportMetadata copies job catalog files and the repository definition, without credentials, into job storage folder so that the repository is self describing
+func (j *Job) exportMetadata() error {
+	storagePath, err := j.GetWorkFolderPath()
+	if err != nil {
+		return fmt.Errorf("cannot get job storage path: %w", err)
+	}
+
+	metaPath := getMetadataPath(storagePath)
+	if err := os.MkdirAll(metaPath, 0755); err != nil {
+		return fmt.Errorf("cannot create job metadata folder: %w", err)
+	}
+
+	if err := copyMetadataFiles(j.GetCatalogPath(), metaPath); err != nil {
+		return err
+	}
+	if err := repo.WriteDefinition(j.Repository, fmt.Sprintf("%s/repo.toml", metaPath)); err != nil {
+		return fmt.Errorf("cannot export repository to metadata folder: %w", err)
+	}
+
+	return nil
+}
+
+// refreshMetadata exports metadata of a finished job again once it has been exported, so that the job record stored in the repository follows database updates
+func (j *Job) refreshMetadata() error {
+	if !j.Done {
+		return nil
+	}
+
+	workPath, err := j.GetWorkFolderPath()
+	if err != nil {
+		return fmt.Errorf("cannot get job work folder path: %w", err)
+	}
+	if _, err := os.Stat(getMetadataPath(workPath)); err == nil {
+		return j.exportMetadata()
+	}
+
+	stagedRepo, ok := j.Repository.(repo.StagedRepository)
+	if !ok {
+		return nil
+	}
+	if _, err := os.Stat(workPath); err == nil {
+		// Job files are still being sent to the repository
+		return nil
+	}
+	storagePath, err := j.GetStorageFolderPath()
+	if err != nil {
+		return fmt.Errorf("cannot get job storage path: %w", err)
+	}
+	exported, err := stagedRepo.Exists(getMetadataPath(storagePath))
+	if err != nil {
+		return fmt.Errorf("cannot check job metadata in repository: %w", err)
+	}
+	if !exported {
+		return nil
+	}
+
+	// Job staging folder is removed once job files are sent, metadata is staged again and sent on its own
+	defer os.RemoveAll(workPath)
+	if err := j.exportMetadata(); err != nil {
+		return err
+	}
+	if err := stagedRepo.PushFiles(j.Uuid); err != nil {
+		return fmt.Errorf("cannot send job metadata to repository: %w", err)
+	}
+
+	return nil
+}
+
+// ImportMetadata creates the catalog folder of a job from the metadata stored in the repository.
+// Repository definition is replaced by r since the repository may have been moved since the job ran
+func ImportMetadata(uuid string, storagePath string, r repo.Repository) error {
+	metaPath := getMetadataPath(storagePath)
+	if _, err := os.Stat(metaPath); err != nil {
+		return fmt.Errorf("cannot find job metadata folder: %w", err)
+	}
+
+	catalogPath := utils.GetCatalogPath(uuid)
+	if err := os.MkdirAll(catalogPath, 0755); err != nil {
+		return fmt.Errorf("cannot create job catalog folder: %w", err)
+	}
+	if err := copyMetadataFiles(metaPath, catalogPath); err != nil {
+		return err
+	}
+
+	if err := repo.WriteDefinition(r, fmt.Sprintf("%s/repo.toml", catalogPath)); err != nil {
+		return fmt.Errorf("cannot export repository to file: %w", err)
+	}
+
+	return nil
+}
+
+func copyMetadataFiles(from string, to string) error {
+	for _, name := range metadataFiles {
+		content, err := os.ReadFile(fmt.Sprintf("%s/%s", from, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("cannot read metadata file '%s': %w", name, err)
+		}
+
+		if err := os.WriteFile(fmt.Sprintf("%s/%s", to, name), content, 0644); err != nil {
+			return fmt.Errorf("cannot write metadata file '%s': %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,105 @@
+package job
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/macarrie/relique/internal/job_status"
+	"github.com/macarrie/relique/internal/utils"
+)
+
+// TaskProgress is the file sync progress of a single backup path
+type TaskProgress struct {
+	BackupPath string  `json:"backup_path"`
+	Progress   float64 `json:"progress"`
+	Remaining  int     `json:"elements_remaining"`
+	Total      int     `json:"elements_count"`
+	Speed      string  `json:"transfer_speed"`
+}
+
+// Progress is the live progress of a job. It is written to job catalog folder during job execution so that it can be read by any relique process
+type Progress struct {
+	Uuid      string               `json:"uuid"`
+	Status    job_status.JobStatus `json:"status"`
+	Done      bool                 `json:"done"`
+	Progress  float64              `json:"progress"`
+	Tasks     []TaskProgress       `json:"tasks"`
+	UpdatedAt time.Time            `json:"updated_at"`
+}
+
+func (j *Job) GetProgress() Progress {
+	p := Progress{
+		Uuid:      j.Uuid,
+		Status:    j.Status,
+		Done:      j.Done,
+		Tasks:     make([]TaskProgress, 0),
+		UpdatedAt: time.Now(),
+	}
+
+	copied := 0
+	total := 0
+	for i, _ := range j.Tasks {
+		state := j.Tasks[i].Task.State()
+		p.Tasks = append(p.Tasks, TaskProgress{
+			BackupPath: j.Tasks[i].BackupPath,
+			Progress:   state.Progress,
+			Remaining:  state.Remain,
+			Total:      state.Total,
+			Speed:      state.Speed,
+		})
+		copied += state.Total - state.Remain
+		total += state.Total
+	}
+
+	if total > 0 {
+		p.Progress = float64(copied) / float64(total) * 100
+	}
+	if j.Done && j.Status.Status == job_status.Success {
+		p.Progress = 100
+	}
+
+	return p
+}
+
+func (j *Job) saveProgress() error {
+	out, err := json.Marshal(j.GetProgress())
+	if err != nil {
+		return fmt.Errorf("cannot serialize job progress: %w", err)
+	}
+
+	// Write to a temporary file first to avoid readers getting partially written progress
+	tmpPath := fmt.Sprintf("%s.tmp", getProgressPath(j.Uuid))
+	if err := os.WriteFile(tmpPath, out, 0644); err != nil {
+		return fmt.Errorf("cannot write job progress file: %w", err)
+	}
+	if err := os.Rename(tmpPath, getProgressPath(j.Uuid)); err != nil {
+		return fmt.Errorf("cannot write job progress file: %w", err)
+	}
+
+	return nil
+}
+
+// ReadProgress gets last progress written by the process running the job. An empty progress is returned if the job did not start file sync yet
+func ReadProgress(uuid string) (Progress, error) {
+	content, err := os.ReadFile(getProgressPath(uuid))
+	if os.IsNotExist(err) {
+		return Progress{Uuid: uuid, Tasks: make([]TaskProgress, 0)}, nil
+	}
+	if err != nil {
+		return Progress{}, fmt.Errorf("cannot read job progress file: %w", err)
+	}
+
+	var p Progress
+	if err := json.Unmarshal(content, &p); err != nil {
+		return Progress{}, fmt.Errorf("cannot parse job progress file: %w", err)
+	}
+
+	return p, nil
+}
+
+func getProgressPath(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/progress.json", utils.GetCatalogPath(uuid)))
+}
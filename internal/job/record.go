@@ -0,0 +1,143 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/pelletier/go-toml"
+
+	"github.com/macarrie/relique/internal/backup_type"
+	"github.com/macarrie/relique/internal/client"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/job_status"
+	"github.com/macarrie/relique/internal/job_type"
+	"github.com/macarrie/relique/internal/module"
+	"github.com/macarrie/relique/internal/repo"
+	rsync_lib "github.com/macarrie/relique/internal/rsync_task/lib"
+	"github.com/macarrie/relique/internal/utils"
+)
+
+// Record holds job details stored in database. A copy is kept in job catalog folder so that database rows can be rebuilt from catalog contents
+type Record struct {
+	Uuid             string                 `toml:"uuid"`
+	Status           job_status.JobStatus   `toml:"status"`
+	StatusMessage    string                 `toml:"status_message"`
+	BackupType       backup_type.BackupType `toml:"backup_type"`
+	JobType          job_type.JobType       `toml:"job_type"`
+	Done             bool                   `toml:"done"`
+	StartTime        time.Time              `toml:"start_time"`
+	EndTime          time.Time              `toml:"end_time"`
+	PreviousJobUuid  string                 `toml:"previous_job_uuid"`
+	RestoreImageUuid string                 `toml:"restore_image_uuid"`
+	SourceImageUuid  string                 `toml:"source_image_uuid"`
+}
+
+func (j *Job) getRecordPath() string {
+	return fmt.Sprintf("%s/job.toml", j.GetCatalogPath())
+}
+
+// saveRecord exports job database fields to job catalog folder
+func (j *Job) saveRecord() error {
+	if err := os.MkdirAll(j.GetCatalogPath(), 0755); err != nil {
+		return fmt.Errorf("cannot create job catalog folder: %w", err)
+	}
+
+	r := Record{
+		Uuid:             j.Uuid,
+		Status:           j.Status,
+		StatusMessage:    j.StatusMessage,
+		BackupType:       j.BackupType,
+		JobType:          j.JobType,
+		Done:             j.Done,
+		StartTime:        j.StartTime,
+		EndTime:          j.EndTime,
+		PreviousJobUuid:  j.PreviousJobUuid,
+		RestoreImageUuid: j.RestoreImageUuid,
+		SourceImageUuid:  j.SourceImageUuid,
+	}
+	if err := utils.SerializeToFile[Record](r, j.getRecordPath()); err != nil {
+		return fmt.Errorf("cannot export job record to file: %w", err)
+	}
+
+	return nil
+}
+
+// LoadFromCatalog builds a job from the files stored in its catalog folder. Job is not saved into database.
+// When the catalog has no job record (jobs run by older relique versions), job details are inferred from catalog contents and inferred is set
+func LoadFromCatalog(uuid string) (j Job, inferred bool, err error) {
+	j = Job{Uuid: uuid}
+	catalogPath := j.GetCatalogPath()
+
+	mod, err := module.LoadFromFile(fmt.Sprintf("%s/module.toml", catalogPath))
+	if err != nil {
+		return Job{}, false, fmt.Errorf("linked module cannot be loaded from file: %w", err)
+	}
+	j.Module = mod
+
+	cl, err := client.LoadFromFile(fmt.Sprintf("%s/client.toml", catalogPath))
+	if err != nil {
+		return Job{}, false, fmt.Errorf("linked client cannot be loaded from file: %w", err)
+	}
+	j.Client = cl
+
+	r, err := repo.LoadDefinition(fmt.Sprintf("%s/repo.toml", catalogPath), config.Current.Repositories)
+	if err != nil {
+		return Job{}, false, fmt.Errorf("linked repo cannot be loaded from file: %w", err)
+	}
+	j.Repository = r
+
+	statsPath := fmt.Sprintf("%s/stats.toml", catalogPath)
+	statsInfo, statsErr := os.Stat(statsPath)
+	if statsErr == nil {
+		if stats, err := rsync_lib.LoadStatsFromFile(statsPath); err == nil {
+			j.Stats = stats
+		}
+	}
+
+	content, err := os.ReadFile(j.getRecordPath())
+	if err == nil {
+		var rec Record
+		if err := toml.Unmarshal(content, &rec); err != nil {
+			return Job{}, false, fmt.Errorf("cannot parse job record file: %w", err)
+		}
+		j.Status = rec.Status
+		j.StatusMessage = rec.StatusMessage
+		j.BackupType = rec.BackupType
+		j.JobType = rec.JobType
+		j.Done = rec.Done
+		j.StartTime = rec.StartTime
+		j.EndTime = rec.EndTime
+		j.PreviousJobUuid = rec.PreviousJobUuid
+		j.RestoreImageUuid = rec.RestoreImageUuid
+		j.SourceImageUuid = rec.SourceImageUuid
+
+		return j, false, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return Job{}, false, fmt.Errorf("cannot read job record file: %w", err)
+	}
+
+	// No job record, infer job details from catalog folder.
+	// Job type cannot be told from storage contents since cancelled backups remove their data folder. Jobs are assumed to be backups, restore jobs then show up as backups with missing image data
+	j.JobType = job_type.New(job_type.Backup)
+	j.BackupType = mod.BackupType
+
+	if modInfo, err := os.Stat(fmt.Sprintf("%s/module.toml", catalogPath)); err == nil {
+		j.StartTime = modInfo.ModTime()
+	}
+
+	j.Done = true
+	if statsErr == nil {
+		// Stats are written when all job tasks are finished. Incomplete jobs cannot be told apart from successful ones
+		j.Status = job_status.New(job_status.Success)
+		j.EndTime = statsInfo.ModTime()
+	} else {
+		j.Status = job_status.New(job_status.Error)
+		j.StatusMessage = "job did not complete (details rebuilt from catalog)"
+		j.EndTime = j.StartTime
+	}
+
+	return j, true, nil
+}
@@ -0,0 +1,131 @@
+package job
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/macarrie/relique/internal/backup_type"
+	"github.com/macarrie/relique/internal/client"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/job_status"
+	"github.com/macarrie/relique/internal/job_type"
+	"github.com/macarrie/relique/internal/module"
+	"github.com/macarrie/relique/internal/repo"
+)
+
+// setupCatalogJob points configuration to a temporary catalog and returns a backup job using a local repository
+func setupCatalogJob(t *testing.T) Job {
+	t.Helper()
+
+	cfgDir := t.TempDir()
+	viper.SetConfigFile(filepath.Join(cfgDir, "relique.toml"))
+	t.Cleanup(func() { viper.SetConfigFile("") })
+	config.Current.CatalogPath = "catalog"
+
+	r := repo.RepoLocalNew("local", t.TempDir(), false)
+	config.Current.Repositories = []repo.Repository{&r}
+
+	j := NewBackup(client.Client{Name: "client"}, module.Module{ModuleType: "generic", Name: "module", BackupType: backup_type.New(backup_type.Diff)}, &r)
+	if err := j.setupCatalog(); err != nil {
+		t.Fatalf("setupCatalog() error = %v", err)
+	}
+
+	return j
+}
+
+func TestRecord(t *testing.T) {
+	j := setupCatalogJob(t)
+	j.Status = job_status.New(job_status.Incomplete)
+	j.StatusMessage = "some files could not be read"
+	j.BackupType = backup_type.New(backup_type.Diff)
+	j.Done = true
+	j.StartTime = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	j.EndTime = time.Date(2024, 3, 1, 10, 30, 0, 0, time.UTC)
+	j.PreviousJobUuid = "previous"
+	j.SourceImageUuid = "source"
+	if err := j.saveRecord(); err != nil {
+		t.Fatalf("saveRecord() error = %v", err)
+	}
+
+	got, inferred, err := LoadFromCatalog(j.Uuid)
+	if err != nil || inferred {
+		t.Fatalf("LoadFromCatalog() inferred = %v, error = %v, want record loaded", inferred, err)
+	}
+	if got.Status != j.Status || got.StatusMessage != j.StatusMessage || got.BackupType != j.BackupType || got.JobType != j.JobType || got.Done != j.Done {
+		t.Errorf("LoadFromCatalog() = %+v, want %+v", got, j)
+	}
+	if !got.StartTime.Equal(j.StartTime) || !got.EndTime.Equal(j.EndTime) {
+		t.Errorf("LoadFromCatalog() times = %v - %v, want %v - %v", got.StartTime, got.EndTime, j.StartTime, j.EndTime)
+	}
+	if got.PreviousJobUuid != j.PreviousJobUuid || got.SourceImageUuid != j.SourceImageUuid || got.RestoreImageUuid != "" {
+		t.Errorf("LoadFromCatalog() references = %+v, want %+v", got, j)
+	}
+	if got.Client.Name != "client" || got.Module.Name != "module" || got.Repository.GetName() != "local" {
+		t.Errorf("LoadFromCatalog() = %v/%v/%v, want client/module/local", got.Client.Name, got.Module.Name, got.Repository.GetName())
+	}
+}
+
+func TestLoadFromCatalog_WithoutRecord(t *testing.T) {
+	j := setupCatalogJob(t)
+
+	// Cancelled backups have no data folder, job type is not taken from repository contents
+	got, inferred, err := LoadFromCatalog(j.Uuid)
+	if err != nil || !inferred {
+		t.Fatalf("LoadFromCatalog() inferred = %v, error = %v, want inferred job", inferred, err)
+	}
+	if got.JobType.Type != job_type.Backup || got.BackupType.Type != backup_type.Diff {
+		t.Errorf("LoadFromCatalog() type = %v/%v, want diff backup", got.JobType, got.BackupType)
+	}
+	if !got.Done || got.Status.Status != job_status.Error {
+		t.Errorf("LoadFromCatalog() without stats status = %v, want error", got.Status)
+	}
+
+	if err := os.WriteFile(filepath.Join(j.GetCatalogPath(), "stats.toml"), []byte(""), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := LoadFromCatalog(j.Uuid); err != nil || got.Status.Status != job_status.Success {
+		t.Errorf("LoadFromCatalog() with stats status = %v, %v, want success", got.Status, err)
+	}
+}
+
+func TestMetadata_ExportImport(t *testing.T) {
+	j := setupCatalogJob(t)
+	if err := j.Repository.PrepareImage(j.Uuid); err != nil {
+		t.Fatal(err)
+	}
+	j.Status = job_status.New(job_status.Success)
+	j.Done = true
+	if err := j.saveRecord(); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.exportMetadata(); err != nil {
+		t.Fatalf("exportMetadata() error = %v", err)
+	}
+
+	// Exported record follows database updates made after the image was created
+	j.StatusMessage = "updated after export"
+	j.saveRecordOrLog()
+
+	storagePath, err := j.GetStorageFolderPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(j.GetCatalogPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := ImportMetadata(j.Uuid, storagePath, j.Repository); err != nil {
+		t.Fatalf("ImportMetadata() error = %v", err)
+	}
+
+	got, inferred, err := LoadFromCatalog(j.Uuid)
+	if err != nil || inferred {
+		t.Fatalf("LoadFromCatalog() of imported job inferred = %v, error = %v, want record loaded", inferred, err)
+	}
+	if got.StatusMessage != j.StatusMessage || got.Status != j.Status || got.Module.Name != j.Module.Name || got.Repository.GetName() != j.Repository.GetName() {
+		t.Errorf("imported job = %+v, want %+v", got, j)
+	}
+}
@@ -0,0 +1,139 @@
+package job
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/macarrie/relique/internal/backup_type"
+	"github.com/macarrie/relique/internal/job_type"
+	"github.com/macarrie/relique/internal/repo"
+	rsync_lib "github.com/macarrie/relique/internal/rsync_task/lib"
+)
+
+// getRestoreSources lists the image paths restored by the job
+func (j *Job) getRestoreSources() []string {
+	if len(j.CustomRestorePaths) == 0 {
+		return j.Module.BackupPaths
+	}
+
+	sources := make([]string, 0, len(j.CustomRestorePaths))
+	for source := range j.CustomRestorePaths {
+		sources = append(sources, source)
+	}
+
+	return sources
+}
+
+// fetchSourceData copies the image data read by restore and copy jobs from a staged repository into a local staging folder
+func (j *Job) fetchSourceData() error {
+	switch j.JobType.Type {
+	case job_type.Restore:
+		return j.fetchRestoreData()
+	case job_type.Copy:
+		return j.fetchCopyData()
+	}
+
+	return nil
+}
+
+// fetchRestoreData copies the image data to restore from a staged repository into job staging folder
+func (j *Job) fetchRestoreData() error {
+	stagedRepo, ok := j.Repository.(repo.StagedRepository)
+	if !ok || j.JobType.Type != job_type.Restore {
+		return nil
+	}
+
+	j.GetLog().Info("Fetching data to restore from repository")
+	return stagedRepo.FetchData(j.RestoreImageUuid, j.getRestoreSources(), j.Uuid)
+}
+
+// fetchCopyData copies the whole source image of a copy job from a staged repository into the source repository staging folder
+func (j *Job) fetchCopyData() error {
+	stagedSource, ok := j.copySource.(repo.StagedRepository)
+	if !ok {
+		return nil
+	}
+
+	if err := stagedSource.PrepareImage(j.Uuid); err != nil {
+		return fmt.Errorf("cannot prepare source repository staging folder: %w", err)
+	}
+
+	j.GetLog().Info("Fetching image to copy from source repository")
+	return stagedSource.FetchData(j.SourceImageUuid, []string{"/"}, j.Uuid)
+}
+
+// removeCopyStaging removes source image data fetched by a copy job from a staged repository
+func (j *Job) removeCopyStaging() {
+	stagedSource, ok := j.copySource.(repo.StagedRepository)
+	if !ok {
+		return
+	}
+
+	stagingPath := stagedSource.GetDestination(j.Uuid)
+	if err := os.RemoveAll(stagingPath); err != nil {
+		j.GetLog().With(
+			slog.Any("error", err),
+			slog.String("path", stagingPath),
+		).Error("Cannot remove source repository staging folder")
+	}
+}
+
+// pushData sends image data from job staging folder to the repository, which deduplicates it against the previous image for diff backups and copies
+func (j *Job) pushData(stagedRepo repo.StagedRepository) error {
+	var referenceUuid string
+	if j.BackupType.Type == backup_type.Diff {
+		referenceUuid = j.PreviousJobUuid
+	}
+
+	j.GetLog().Info("Pushing image data to repository")
+	stats, err := stagedRepo.PushData(j.Uuid, referenceUuid)
+	if err != nil {
+		return err
+	}
+	j.pushStats = stats
+
+	return nil
+}
+
+// dataStats returns the size of image data and the size of the part of it that was not already stored in the repository.
+// Staged repositories report them when data is pushed, rsync stats are used for repositories written to directly
+func (j *Job) dataStats(jobStats rsync_lib.Stats) repo.PushStats {
+	if _, ok := j.Repository.(repo.StagedRepository); ok {
+		return j.pushStats
+	}
+
+	return repo.PushStats{
+		DataBytes: jobStats.TotalFileSize,
+		NewBytes:  jobStats.TotalTransferredFileSize,
+	}
+}
+
+// finishRemote sends job logs and metadata to the repository and removes job staging folder.
+// Backup data is pushed separately by pushData, staged data is removed before logs are sent
+func (j *Job) finishRemote(stagedRepo repo.StagedRepository) {
+	workPath := stagedRepo.GetDestination(j.Uuid)
+	if _, err := os.Stat(workPath); os.IsNotExist(err) {
+		return
+	}
+
+	if err := os.RemoveAll(fmt.Sprintf("%s/_data", workPath)); err != nil {
+		j.GetLog().With(slog.Any("error", err)).Error("Cannot remove staged job data")
+	}
+
+	if err := stagedRepo.PushFiles(j.Uuid); err != nil {
+		// Staging folder is kept so that logs can still be read
+		j.GetLog().With(
+			slog.Any("error", err),
+			slog.String("path", workPath),
+		).Error("Cannot send job logs to repository, job staging folder is kept")
+		return
+	}
+
+	if err := os.RemoveAll(workPath); err != nil {
+		j.GetLog().With(
+			slog.Any("error", err),
+			slog.String("path", workPath),
+		).Error("Cannot remove job staging folder")
+	}
+}
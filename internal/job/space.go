@@ -0,0 +1,46 @@
+package job
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/macarrie/relique/internal/backup_type"
+	"github.com/macarrie/relique/internal/image"
+	"github.com/macarrie/relique/internal/repo"
+)
+
+// checkSpace estimates the size of the image produced by the job from the previous image of the same client and module, and checks that it fits in repository available space.
+// Full backups are expected to store as much data as the previous image, diff backups as much new data as the previous image did
+func (j *Job) checkSpace() error {
+	if _, ok := j.Repository.(repo.CapacityReporter); !ok {
+		return nil
+	}
+
+	previous, err := image.GetLatest(j.Client.Name, j.Module.Name, j.Repository.GetName())
+	if err != nil {
+		j.GetLog().With(
+			slog.Any("error", err),
+		).Debug("No previous image to estimate backup size from, skipping space check")
+		return nil
+	}
+
+	estimate := previous.SizeOnDisk
+	if j.BackupType.Type == backup_type.Diff {
+		estimate = previous.UniqueSize
+	}
+	j.GetLog().With(
+		slog.String("previous_image_uuid", previous.Uuid),
+		slog.Uint64("estimated_size", estimate),
+	).Debug("Checking repository available space")
+
+	if err := repo.CheckSpace(j.Repository, int64(estimate)); errors.Is(err, repo.ErrNotEnoughSpace) {
+		return fmt.Errorf("backup refused by repository space check: %w", err)
+	} else if err != nil {
+		j.GetLog().With(
+			slog.Any("error", err),
+		).Warn("Cannot check repository available space")
+	}
+
+	return nil
+}
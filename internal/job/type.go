@@ -40,9 +40,16 @@ type Job struct {
 	Tasks              []rsync_task.RsyncTask `json:"-"`
 	PreviousJobUuid    string                 `json:"previous_job_uuid"`
 	RestoreImageUuid   string                 `json:"restore_image_uuid"`
+	SourceImageUuid    string                 `json:"source_image_uuid"`
 	PreviousJob        *Job                   `json:"previous_job"`
 	Stats              rsync_lib.Stats        `json:"stats"`
 	CustomRestorePaths map[string]string      `json:"custom_restore_paths"`
+	// Details about job status, ie reason why a job was marked as failed
+	StatusMessage string `json:"status_message"`
+	// Repository storing the image replicated by copy jobs
+	copySource repo.Repository
+	// Image data sent to staged repositories, used to compute image deduplication stats
+	pushStats repo.PushStats
 
 	// For DB storage
 	ClientName string `json:"-"`
@@ -79,10 +86,21 @@ func (j *Job) Duration() time.Duration {
 	return end.Sub(start).Truncate(time.Second)
 }
 
+// generatesImage tells if the job stores an image into its repository
+func (j *Job) generatesImage() bool {
+	return j.JobType.Type == job_type.Backup || j.JobType.Type == job_type.Copy
+}
+
 func (j *Job) GetStorageFolderPath() (string, error) {
 	return utils.GetStoragePath(j.Repository, j.RepoName, j.Uuid)
 }
 
+// GetWorkFolderPath returns the local folder where job data and logs are written while the job runs.
+// It is the job storage folder for local repositories, and a staging folder sent to the repository at job end for staged repositories
+func (j *Job) GetWorkFolderPath() (string, error) {
+	return utils.GetDestinationPath(j.Repository, j.RepoName, j.Uuid)
+}
+
 func (j *Job) GetCatalogPath() string {
 	return utils.GetCatalogPath(j.Uuid)
 }
@@ -49,6 +49,13 @@ func TestFromString(t *testing.T) {
 			},
 			want: JobStatus{Status: Error},
 		},
+		{
+			name: "cancelled",
+			args: args{
+				val: "cancelled",
+			},
+			want: JobStatus{Status: Cancelled},
+		},
 		{
 			name: "unknown",
 			args: args{
@@ -108,6 +115,11 @@ func TestJobStatus_String(t *testing.T) {
 			fields: fields{Error},
 			want:   "error",
 		},
+		{
+			name:   "cancelled",
+			fields: fields{Cancelled},
+			want:   "cancelled",
+		},
 		{
 			name:   "pouet",
 			fields: fields{123},
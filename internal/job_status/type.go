@@ -8,6 +8,8 @@ const (
 	Incomplete
 	Error
 	Unknown
+	// Values are stored in database, new statuses have to be appended to keep existing values unchanged
+	Cancelled
 )
 
 type JobStatus struct {
@@ -32,6 +34,8 @@ func (s *JobStatus) String() string {
 		return "incomplete"
 	case Error:
 		return "error"
+	case Cancelled:
+		return "cancelled"
 	default:
 		return "unknown"
 	}
@@ -50,6 +54,8 @@ func FromString(val string) JobStatus {
 		s.Status = Incomplete
 	case "error":
 		s.Status = Error
+	case "cancelled":
+		s.Status = Cancelled
 	default:
 		s.Status = Unknown
 	}
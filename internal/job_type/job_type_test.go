@@ -24,6 +24,11 @@ func TestNew(t1 *testing.T) {
 			fields: fields{Restore},
 			want:   Restore,
 		},
+		{
+			name:   "copy",
+			fields: fields{Copy},
+			want:   Copy,
+		},
 		{
 			name:   "unknown",
 			fields: fields{123},
@@ -59,6 +64,11 @@ func TestJobType_String(t1 *testing.T) {
 			fields: fields{Restore},
 			want:   "restore",
 		},
+		{
+			name:   "copy",
+			fields: fields{Copy},
+			want:   "copy",
+		},
 		{
 			name:   "unknown",
 			fields: fields{123},
@@ -96,6 +106,11 @@ func TestFromString(t *testing.T) {
 			args: args{"restore"},
 			want: JobType{Type: Restore},
 		},
+		{
+			name: "copy",
+			args: args{"copy"},
+			want: JobType{Type: Copy},
+		},
 		{
 			name: "unknown",
 			args: args{"pouet"},
@@ -174,6 +189,12 @@ func TestJobType_MarshalText(t *testing.T) {
 			want:    []byte("restore"),
 			wantErr: false,
 		},
+		{
+			name:    "copy",
+			bt:      JobType{Type: Copy},
+			want:    []byte("copy"),
+			wantErr: false,
+		},
 		{
 			name:    "invalid variant",
 			bt:      JobType{Type: 123},
@@ -5,6 +5,7 @@ const (
 	Unknown
 	Backup
 	Restore
+	Copy
 )
 
 type JobType struct {
@@ -21,6 +22,8 @@ func (t *JobType) String() string {
 		return "backup"
 	case Restore:
 		return "restore"
+	case Copy:
+		return "copy"
 	default:
 		return "unknown"
 	}
@@ -34,6 +37,8 @@ func FromString(val string) JobType {
 		t.Type = Backup
 	case "restore":
 		t.Type = Restore
+	case "copy":
+		t.Type = Copy
 	default:
 		t.Type = Unknown
 	}
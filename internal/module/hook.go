@@ -0,0 +1,113 @@
+package module
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+const (
+	// Pre hook failure stops the job before file sync. Post hook failure marks the job as incomplete
+	HookOnErrorAbort = "abort"
+	// Hook failure is only logged
+	HookOnErrorContinue = "continue"
+)
+
+var HOOK_DEFAULT_TIMEOUT = 1 * time.Hour
+
+// Hook is a shell script executed on the client over SSH before or after job file sync
+type Hook struct {
+	// Script path, relative to module install folder
+	Script string `json:"script" toml:"script"`
+	// Inline shell commands, used instead of a script file
+	Inline string `json:"inline" toml:"inline"`
+	// Behavior on hook failure: 'abort' (default) or 'continue'
+	OnError string `json:"on_error" toml:"on_error"`
+	// Maximum hook duration (Go duration format, ie '10m'). Defaults to 1h
+	Timeout string `json:"timeout" toml:"timeout"`
+}
+
+func (h *Hook) GetLog() *slog.Logger {
+	return slog.With(
+		slog.String("script", h.Script),
+		slog.Bool("inline", h.Inline != ""),
+		slog.String("on_error", h.GetOnErrorPolicy()),
+	)
+}
+
+func (h *Hook) IsDefined() bool {
+	return h.Script != "" || h.Inline != ""
+}
+
+func (h *Hook) GetOnErrorPolicy() string {
+	if h.OnError == "" {
+		return HookOnErrorAbort
+	}
+
+	return h.OnError
+}
+
+func (h *Hook) GetTimeout() time.Duration {
+	if h.Timeout == "" {
+		return HOOK_DEFAULT_TIMEOUT
+	}
+
+	timeout, err := time.ParseDuration(h.Timeout)
+	if err != nil || timeout <= 0 {
+		return HOOK_DEFAULT_TIMEOUT
+	}
+
+	return timeout
+}
+
+func (h *Hook) Valid() error {
+	var objErrors *multierror.Error
+
+	if h.Script != "" && h.Inline != "" {
+		objErrors = multierror.Append(objErrors, fmt.Errorf("script and inline commands cannot be both defined"))
+	}
+	if h.Script != "" && (filepath.IsAbs(h.Script) || !filepath.IsLocal(h.Script)) {
+		objErrors = multierror.Append(objErrors, fmt.Errorf("script path '%s' must be relative to module install folder", h.Script))
+	}
+	if h.OnError != "" && h.OnError != HookOnErrorAbort && h.OnError != HookOnErrorContinue {
+		objErrors = multierror.Append(objErrors, fmt.Errorf("unknown on_error policy '%s'", h.OnError))
+	}
+	if h.Timeout != "" {
+		timeout, err := time.ParseDuration(h.Timeout)
+		if err != nil {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("invalid timeout '%s': %w", h.Timeout, err))
+		} else if timeout <= 0 {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("timeout must be positive"))
+		}
+	}
+
+	return objErrors.ErrorOrNil()
+}
+
+// GetContent returns the shell commands to execute. Script files are read from the install folder of the specified module type
+func (h *Hook) GetContent(moduleType string) (string, error) {
+	if h.Inline != "" {
+		return h.Inline, nil
+	}
+
+	if MODULES_INSTALL_PATH == "" {
+		return "", fmt.Errorf("empty modules install path")
+	}
+
+	scriptPath := filepath.Join(MODULES_INSTALL_PATH, moduleType, h.Script)
+	if !strings.HasPrefix(scriptPath, filepath.Join(MODULES_INSTALL_PATH, moduleType)+string(filepath.Separator)) {
+		return "", fmt.Errorf("script path '%s' is outside of module install folder", h.Script)
+	}
+
+	content, err := os.ReadFile(scriptPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot read hook script: %w", err)
+	}
+
+	return string(content), nil
+}
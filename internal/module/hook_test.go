@@ -0,0 +1,54 @@
+package module
+
+import "testing"
+
+func TestHook_Valid(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    Hook
+		wantErr bool
+	}{
+		{
+			name:    "script",
+			hook:    Hook{Script: "scripts/dump.sh"},
+			wantErr: false,
+		},
+		{
+			name:    "inline",
+			hook:    Hook{Inline: "pg_dumpall > /var/backups/dump.sql", OnError: HookOnErrorContinue, Timeout: "10m"},
+			wantErr: false,
+		},
+		{
+			name:    "script_and_inline",
+			hook:    Hook{Script: "dump.sh", Inline: "echo 'pouet'"},
+			wantErr: true,
+		},
+		{
+			name:    "absolute_script_path",
+			hook:    Hook{Script: "/usr/local/bin/dump.sh"},
+			wantErr: true,
+		},
+		{
+			name:    "script_outside_module_folder",
+			hook:    Hook{Script: "../other_module/dump.sh"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown_on_error_policy",
+			hook:    Hook{Inline: "true", OnError: "pouet"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid_timeout",
+			hook:    Hook{Inline: "true", Timeout: "10 minutes"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.hook.Valid(); (err != nil) != tt.wantErr {
+				t.Errorf("Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
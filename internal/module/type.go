@@ -10,6 +10,8 @@ import (
 	"github.com/hashicorp/go-multierror"
 
 	"github.com/macarrie/relique/internal/backup_type"
+	"github.com/macarrie/relique/internal/retention"
+	"github.com/macarrie/relique/internal/schedule"
 )
 
 var MODULES_INSTALL_PATH string
@@ -24,6 +26,12 @@ type Module struct {
 	Include           []string               `json:"include" toml:"include"`
 	Exclude           []string               `json:"exclude" toml:"exclude"`
 	ExcludeCVS        bool                   `json:"exclude_cvs" toml:"exclude_cvs"`
+	Schedule          schedule.Schedule      `json:"schedule" toml:"schedule"`
+	Retention         retention.Policy       `json:"retention" toml:"retention"`
+	PreBackup         Hook                   `json:"pre_backup" toml:"pre_backup"`
+	PostBackup        Hook                   `json:"post_backup" toml:"post_backup"`
+	PreRestore        Hook                   `json:"pre_restore" toml:"pre_restore"`
+	PostRestore       Hook                   `json:"post_restore" toml:"post_restore"`
 }
 
 func (m *Module) String() string {
@@ -63,10 +71,39 @@ func (m *Module) Valid() error {
 	if m.BackupType.Type == backup_type.Unknown {
 		objErrors = multierror.Append(objErrors, fmt.Errorf("unknown backup type"))
 	}
+	if m.Schedule.IsEnabled() {
+		if err := m.Schedule.Valid(); err != nil {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("invalid schedule: %w", err))
+		}
+	}
+	if m.Retention.IsEnabled() {
+		if err := m.Retention.Valid(); err != nil {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("invalid retention policy: %w", err))
+		}
+	}
+
+	for name, h := range m.GetHooks() {
+		if !h.IsDefined() {
+			continue
+		}
+		if err := h.Valid(); err != nil {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("invalid %s hook: %w", name, err))
+		}
+	}
 
 	return objErrors.ErrorOrNil()
 }
 
+// GetHooks returns module hooks indexed by name
+func (m *Module) GetHooks() map[string]Hook {
+	return map[string]Hook{
+		"pre_backup":   m.PreBackup,
+		"post_backup":  m.PostBackup,
+		"pre_restore":  m.PreRestore,
+		"post_restore": m.PostRestore,
+	}
+}
+
 func (m *Module) GetAvailableVariants() error {
 	if MODULES_INSTALL_PATH == "" {
 		return fmt.Errorf("empty modules install path")
@@ -108,5 +145,18 @@ func (m *Module) LoadDefaultConfiguration() error {
 		m.Exclude = defaults.Exclude
 	}
 
+	if !m.PreBackup.IsDefined() {
+		m.PreBackup = defaults.PreBackup
+	}
+	if !m.PostBackup.IsDefined() {
+		m.PostBackup = defaults.PostBackup
+	}
+	if !m.PreRestore.IsDefined() {
+		m.PreRestore = defaults.PreRestore
+	}
+	if !m.PostRestore.IsDefined() {
+		m.PostRestore = defaults.PostRestore
+	}
+
 	return nil
 }
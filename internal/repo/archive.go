@@ -0,0 +1,217 @@
+package repo
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kennygrant/sanitize"
+	"github.com/pelletier/go-toml"
+)
+
+// RepositoryArchive stores images in a local folder in compressed archive format instead of file trees.
+// Image data is packed into gzip compressed tar segments, with an index giving the location of every file so that a file can be read without decompressing the whole image.
+// Job data is transferred from clients into a local staging folder and packed into the repository when the job ends. Images do not share data, diff backups are stored as full archives
+type RepositoryArchive struct {
+	Name string `json:"name" toml:"name"`
+	Type string `json:"type" toml:"type"`
+	Path string `json:"path" toml:"path"`
+	// Local folder holding job data until it is packed into the repository
+	StagingPath string `json:"staging_path" toml:"staging_path"`
+	Default     bool   `json:"default" toml:"default"`
+}
+
+func RepoArchiveNew(name string, path string, isDefault bool) RepositoryArchive {
+	return RepositoryArchive{
+		Name:    name,
+		Type:    "archive",
+		Path:    path,
+		Default: isDefault,
+	}
+}
+
+func (r *RepositoryArchive) GetName() string {
+	return r.Name
+}
+
+func (r *RepositoryArchive) GetType() string {
+	return r.Type
+}
+
+func (r *RepositoryArchive) GetLog() *slog.Logger {
+	return slog.With(
+		slog.String("name", r.GetName()),
+		slog.String("type", r.GetType()),
+		slog.String("path", r.Path),
+		slog.Bool("default", r.IsDefault()),
+	)
+}
+
+func (r *RepositoryArchive) Write(rootPath string) error {
+	var path string = filepath.Clean(fmt.Sprintf("%s/%s.toml",
+		rootPath,
+		strings.ToLower(sanitize.Accents(sanitize.BaseName(r.GetName()))),
+	))
+
+	repoToml, repoErr := toml.Marshal(r)
+	if repoErr != nil {
+		return fmt.Errorf("cannot serialize repository info to toml data: %w", repoErr)
+	}
+	if err := os.WriteFile(path, repoToml, 0644); err != nil {
+		return fmt.Errorf("cannot export repository info to file: %w", err)
+	}
+
+	r.GetLog().With(
+		slog.String("path", path),
+	).Debug("Saved repository to file")
+
+	return nil
+}
+
+func (r *RepositoryArchive) IsDefault() bool {
+	return r.Default
+}
+
+// GetStagingPath returns the local folder job data is staged in before being sent to the repository
+func (r *RepositoryArchive) GetStagingPath() string {
+	return getStagingPath(r.StagingPath, r.GetName())
+}
+
+func (r *RepositoryArchive) GetStoragePath(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/%s/", r.Path, uuid))
+}
+
+// GetDestination returns the image staging folder
+func (r *RepositoryArchive) GetDestination(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/%s", r.GetStagingPath(), uuid))
+}
+
+func (r *RepositoryArchive) PrepareImage(uuid string) error {
+	return prepareStagingFolders(r.GetDestination(uuid))
+}
+
+// GetLinkDest returns an empty string: archived images cannot be used as rsync reference
+func (r *RepositoryArchive) GetLinkDest(uuid string) string {
+	return ""
+}
+
+func (r *RepositoryArchive) Exists(p string) (bool, error) {
+	return pathExists(p)
+}
+
+func (r *RepositoryArchive) DeleteImage(uuid string) error {
+	if err := os.RemoveAll(r.GetStoragePath(uuid)); err != nil {
+		return fmt.Errorf("cannot remove image folder: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RepositoryArchive) GetSpace() (Space, error) {
+	used, err := diskUsage(r.Path)
+	if err != nil {
+		return Space{}, err
+	}
+	free, total, err := filesystemSpace(r.Path)
+	if err != nil {
+		return Space{}, err
+	}
+
+	return Space{
+		Used:  used,
+		Free:  free,
+		Total: total,
+	}, nil
+}
+
+// GetImageSpace returns the size of the image folder: archived images do not share data
+func (r *RepositoryArchive) GetImageSpace(uuid string) (ImageSpace, error) {
+	used, err := diskUsage(r.GetStoragePath(uuid))
+	if err != nil {
+		return ImageSpace{}, err
+	}
+
+	return ImageSpace{Unique: used}, nil
+}
+
+func (r *RepositoryArchive) HealthCheck() error {
+	if err := checkWritableFolder(r.Path); err != nil {
+		return fmt.Errorf("repository folder '%s' is not usable: %w", r.Path, err)
+	}
+
+	return nil
+}
+
+func (r *RepositoryArchive) getArchivePath(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/_data", r.GetStoragePath(uuid)))
+}
+
+// PushData packs staged image data into the repository. The archive is written next to its final location and moved into place once complete
+func (r *RepositoryArchive) PushData(uuid string, referenceUuid string) (PushStats, error) {
+	archivePath := r.getArchivePath(uuid)
+	tmpPath := archivePath + ".tmp"
+	if err := os.RemoveAll(tmpPath); err != nil {
+		return PushStats{}, fmt.Errorf("cannot clean previous archive: %w", err)
+	}
+
+	stats, err := writeArchive(fmt.Sprintf("%s/_data", r.GetDestination(uuid)), tmpPath)
+	if err != nil {
+		os.RemoveAll(tmpPath)
+		return PushStats{}, fmt.Errorf("cannot pack image data: %w", err)
+	}
+	if err := os.RemoveAll(archivePath); err != nil {
+		return PushStats{}, fmt.Errorf("cannot replace image data: %w", err)
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		return PushStats{}, fmt.Errorf("cannot move archive into place: %w", err)
+	}
+
+	r.GetLog().With(
+		slog.String("uuid", uuid),
+		slog.Int("entries", stats.Entries),
+		slog.Int("segments", stats.Segments),
+		slog.Int64("data_bytes", stats.DataBytes),
+		slog.Int64("stored_bytes", stats.StoredBytes),
+	).Info("Backup data packed into repository")
+
+	// Images do not share data, all of it is new
+	return PushStats{
+		DataBytes: stats.DataBytes,
+		NewBytes:  stats.DataBytes,
+	}, nil
+}
+
+// PushFiles copies the content of the image staging folder into the repository. Staged data is expected to be removed beforehand
+func (r *RepositoryArchive) PushFiles(uuid string) error {
+	if err := copyFiles(r.GetDestination(uuid), r.GetStoragePath(uuid)); err != nil {
+		return fmt.Errorf("cannot copy files into repository: %w", err)
+	}
+
+	return nil
+}
+
+// FetchData extracts image paths from the archive into the restore job staging folder
+func (r *RepositoryArchive) FetchData(imageUuid string, sources []string, jobUuid string) error {
+	if err := restoreArchive(r.getArchivePath(imageUuid), sources, fmt.Sprintf("%s/_data", r.GetDestination(jobUuid))); err != nil {
+		return fmt.Errorf("cannot extract data from archive: %w", err)
+	}
+
+	return nil
+}
+
+// ListImageFolder lists a folder of image data from the archive index
+func (r *RepositoryArchive) ListImageFolder(uuid string, imagePath string) ([]FileEntry, error) {
+	index, err := loadArchiveIndex(r.getArchivePath(uuid))
+	if err != nil {
+		return nil, fmt.Errorf("cannot load image index: %w", err)
+	}
+
+	return listIndexFolder(index.fileEntries(), imagePath)
+}
+
+// HashImageFiles hashes image files read from the archive
+func (r *RepositoryArchive) HashImageFiles(uuid string) (map[string]string, error) {
+	return hashArchive(r.getArchivePath(uuid))
+}
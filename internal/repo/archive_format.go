@@ -0,0 +1,462 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ARCHIVE_BLOCK_SIZE is the amount of uncompressed data after which archive segments start a new compressed block.
+// Blocks are independent gzip members, so reading a file only decompresses the block it starts in
+const ARCHIVE_BLOCK_SIZE = 1024 * 1024
+
+// ARCHIVE_SEGMENT_SIZE is the compressed size after which a new archive segment file is started
+const ARCHIVE_SEGMENT_SIZE = 256 * 1024 * 1024
+
+const ARCHIVE_INDEX_VERSION = 1
+
+const archiveIndexName = "index.json.gz"
+
+// ArchiveIndexEntry describes an element of an image stored in archive format, with the location of its tar header
+type ArchiveIndexEntry struct {
+	ChunkIndexEntry
+	Segment int `json:"segment"`
+	// Offset of the compressed block holding the entry header in segment file
+	Offset int64 `json:"offset"`
+}
+
+// ArchiveIndex lists every element of an image stored in archive format, sorted by path
+type ArchiveIndex struct {
+	Version int                 `json:"version"`
+	Entries []ArchiveIndexEntry `json:"entries"`
+}
+
+// ArchiveStats summarizes data packed into an image archive
+type ArchiveStats struct {
+	Entries     int
+	Segments    int
+	DataBytes   int64
+	StoredBytes int64
+}
+
+func archiveSegmentName(segment int) string {
+	return fmt.Sprintf("segment-%06d.tar.gz", segment)
+}
+
+func archiveIndexPath(archivePath string) string {
+	return filepath.Join(archivePath, archiveIndexName)
+}
+
+// countingWriter counts bytes written to the underlying writer
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// blockWriter sends tar data to the gzip member of the current block and counts uncompressed bytes written to it
+type blockWriter struct {
+	zw *gzip.Writer
+	n  int64
+}
+
+func (bw *blockWriter) Write(p []byte) (int, error) {
+	n, err := bw.zw.Write(p)
+	bw.n += int64(n)
+	return n, err
+}
+
+// archiveWriter packs files into segments: tar streams split into gzip compressed blocks.
+// Segments can be extracted with standard tools since concatenated gzip members form a single gzip stream
+type archiveWriter struct {
+	dir        string
+	segment    int
+	f          *os.File
+	cw         *countingWriter
+	bw         *blockWriter
+	tw         *tar.Writer
+	blockStart int64
+	stats      ArchiveStats
+}
+
+func (w *archiveWriter) openSegment() error {
+	w.segment++
+	f, err := os.Create(filepath.Join(w.dir, archiveSegmentName(w.segment)))
+	if err != nil {
+		return fmt.Errorf("cannot create archive segment: %w", err)
+	}
+
+	w.f = f
+	w.cw = &countingWriter{w: f}
+	w.bw = &blockWriter{zw: gzip.NewWriter(w.cw)}
+	w.tw = tar.NewWriter(w.bw)
+	w.blockStart = 0
+	w.stats.Segments++
+
+	return nil
+}
+
+func (w *archiveWriter) closeSegment() error {
+	if err := w.tw.Close(); err != nil {
+		w.f.Close()
+		return fmt.Errorf("cannot finish archive segment: %w", err)
+	}
+	if err := w.bw.zw.Close(); err != nil {
+		w.f.Close()
+		return fmt.Errorf("cannot compress archive segment: %w", err)
+	}
+	w.stats.StoredBytes += w.cw.n
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("cannot close archive segment: %w", err)
+	}
+
+	return nil
+}
+
+// nextBlock closes the current block once it is large enough, and starts a new segment once the current one is large enough
+func (w *archiveWriter) nextBlock() error {
+	if w.bw.n < ARCHIVE_BLOCK_SIZE {
+		return nil
+	}
+
+	if err := w.bw.zw.Close(); err != nil {
+		return fmt.Errorf("cannot compress archive block: %w", err)
+	}
+	if w.cw.n >= ARCHIVE_SEGMENT_SIZE {
+		// Tar trailer is written into an empty block
+		w.bw.zw.Reset(w.cw)
+		w.bw.n = 0
+		if err := w.closeSegment(); err != nil {
+			return err
+		}
+		return w.openSegment()
+	}
+
+	w.bw.zw.Reset(w.cw)
+	w.bw.n = 0
+	w.blockStart = w.cw.n
+
+	return nil
+}
+
+// add writes an entry into the archive and returns its location. Regular files content is read from p
+func (w *archiveWriter) add(hdr *tar.Header, p string) (int, int64, error) {
+	if err := w.nextBlock(); err != nil {
+		return 0, 0, err
+	}
+	segment, offset := w.segment, w.blockStart
+
+	if err := w.tw.WriteHeader(hdr); err != nil {
+		return 0, 0, fmt.Errorf("cannot write archive header of '%s': %w", hdr.Name, err)
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		f, err := os.Open(p)
+		if err != nil {
+			return 0, 0, fmt.Errorf("cannot open file: %w", err)
+		}
+		_, err = io.CopyN(w.tw, f, hdr.Size)
+		f.Close()
+		if err != nil {
+			return 0, 0, fmt.Errorf("cannot archive file '%s': %w", p, err)
+		}
+		w.stats.DataBytes += hdr.Size
+	}
+	// Blocks must end on a tar header boundary
+	if err := w.tw.Flush(); err != nil {
+		return 0, 0, fmt.Errorf("cannot write archive entry '%s': %w", hdr.Name, err)
+	}
+
+	return segment, offset, nil
+}
+
+// writeArchive packs the content of a local image data folder into compressed segments and an index written to archivePath
+func writeArchive(dataPath string, archivePath string) (ArchiveStats, error) {
+	if err := os.MkdirAll(archivePath, 0755); err != nil {
+		return ArchiveStats{}, fmt.Errorf("cannot create archive folder: %w", err)
+	}
+
+	w := &archiveWriter{dir: archivePath}
+	if err := w.openSegment(); err != nil {
+		return ArchiveStats{}, err
+	}
+
+	index := ArchiveIndex{
+		Version: ARCHIVE_INDEX_VERSION,
+		Entries: make([]ArchiveIndexEntry, 0),
+	}
+	err := filepath.WalkDir(dataPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dataPath {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dataPath, p)
+		if err != nil {
+			return err
+		}
+
+		entry := ArchiveIndexEntry{
+			ChunkIndexEntry: ChunkIndexEntry{
+				Path:    "/" + filepath.ToSlash(relPath),
+				Mode:    info.Mode(),
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			},
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			entry.Uid = stat.Uid
+			entry.Gid = stat.Gid
+		}
+
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(relPath),
+			Mode:    headerMode(info.Mode()),
+			Uid:     int(entry.Uid),
+			Gid:     int(entry.Gid),
+			ModTime: info.ModTime(),
+			Format:  tar.FormatPAX,
+		}
+		switch {
+		case info.IsDir():
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return fmt.Errorf("cannot read symbolic link '%s': %w", p, err)
+			}
+			entry.LinkTarget = target
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = target
+		case info.Mode().IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = info.Size()
+		default:
+			// Special files are only listed in the index
+			index.Entries = append(index.Entries, entry)
+			return nil
+		}
+		entry.Segment, entry.Offset, err = w.add(hdr, p)
+		if err != nil {
+			return err
+		}
+		index.Entries = append(index.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		w.closeSegment()
+		return w.stats, fmt.Errorf("cannot archive image files: %w", err)
+	}
+	if err := w.closeSegment(); err != nil {
+		return w.stats, err
+	}
+
+	if err := writeArchiveIndex(archivePath, index); err != nil {
+		return w.stats, err
+	}
+	w.stats.Entries = len(index.Entries)
+
+	return w.stats, nil
+}
+
+// headerMode converts file permissions and special bits into tar header mode bits
+func headerMode(mode fs.FileMode) int64 {
+	m := int64(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		m |= 04000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		m |= 02000
+	}
+	if mode&fs.ModeSticky != 0 {
+		m |= 01000
+	}
+
+	return m
+}
+
+func writeArchiveIndex(archivePath string, index ArchiveIndex) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(index); err != nil {
+		return fmt.Errorf("cannot serialize image index: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("cannot compress image index: %w", err)
+	}
+
+	if err := os.WriteFile(archiveIndexPath(archivePath), buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("cannot write image index: %w", err)
+	}
+
+	return nil
+}
+
+// loadArchiveIndex reads the index of an image archive. The returned error wraps fs.ErrNotExist if the image has no index
+func loadArchiveIndex(archivePath string) (ArchiveIndex, error) {
+	f, err := os.Open(archiveIndexPath(archivePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return ArchiveIndex{}, fmt.Errorf("cannot find image index: %w", fs.ErrNotExist)
+	} else if err != nil {
+		return ArchiveIndex{}, fmt.Errorf("cannot open image index: %w", err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return ArchiveIndex{}, fmt.Errorf("cannot decompress image index: %w", err)
+	}
+	defer zr.Close()
+
+	var index ArchiveIndex
+	if err := json.NewDecoder(zr).Decode(&index); err != nil {
+		return ArchiveIndex{}, fmt.Errorf("cannot parse image index: %w", err)
+	}
+
+	return index, nil
+}
+
+func (index ArchiveIndex) fileEntries() []ChunkIndexEntry {
+	entries := make([]ChunkIndexEntry, 0, len(index.Entries))
+	for _, entry := range index.Entries {
+		entries = append(entries, entry.ChunkIndexEntry)
+	}
+
+	return entries
+}
+
+// archiveReader reads files from the segments of an image archive, keeping segment files open until closed
+type archiveReader struct {
+	dir      string
+	segments map[int]*os.File
+}
+
+func newArchiveReader(archivePath string) *archiveReader {
+	return &archiveReader{
+		dir:      archivePath,
+		segments: make(map[int]*os.File),
+	}
+}
+
+// copyFile writes the content of a regular file of the archive to w. Only the block holding the file is decompressed
+func (r *archiveReader) copyFile(entry ArchiveIndexEntry, w io.Writer) error {
+	f, ok := r.segments[entry.Segment]
+	if !ok {
+		var err error
+		f, err = os.Open(filepath.Join(r.dir, archiveSegmentName(entry.Segment)))
+		if err != nil {
+			return fmt.Errorf("cannot open archive segment: %w", err)
+		}
+		r.segments[entry.Segment] = f
+	}
+
+	if _, err := f.Seek(entry.Offset, io.SeekStart); err != nil {
+		return fmt.Errorf("cannot seek archive segment: %w", err)
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("cannot decompress archive block of file '%s': %w", entry.Path, err)
+	}
+	defer zr.Close()
+
+	name := strings.TrimPrefix(entry.Path, "/")
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("cannot find file '%s' in archive segment %d", entry.Path, entry.Segment)
+		} else if err != nil {
+			return fmt.Errorf("cannot read archive segment %d: %w", entry.Segment, err)
+		}
+		if hdr.Name != name {
+			continue
+		}
+
+		if hdr.Size != entry.Size {
+			return fmt.Errorf("file '%s' size in archive does not match index", entry.Path)
+		}
+		if _, err := io.Copy(w, tr); err != nil {
+			return fmt.Errorf("cannot read file '%s' from archive: %w", entry.Path, err)
+		}
+		return nil
+	}
+}
+
+func (r *archiveReader) Close() {
+	for _, f := range r.segments {
+		f.Close()
+	}
+}
+
+// restoreArchive rebuilds the listed image paths from an image archive into a local folder, keeping their paths relative to image root
+func restoreArchive(archivePath string, sources []string, destination string) error {
+	index, err := loadArchiveIndex(archivePath)
+	if err != nil {
+		return err
+	}
+
+	r := newArchiveReader(archivePath)
+	defer r.Close()
+
+	return restoreEntries(index.fileEntries(), sources, destination, func(i int, target string) error {
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return fmt.Errorf("cannot create file: %w", err)
+		}
+		defer f.Close()
+
+		if err := r.copyFile(index.Entries[i], f); err != nil {
+			return err
+		}
+
+		return f.Close()
+	})
+}
+
+// hashArchive computes SHA-256 hashes of the regular files of an image archive, indexed by their path relative to image root like integrity manifests
+func hashArchive(archivePath string) (map[string]string, error) {
+	index, err := loadArchiveIndex(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := newArchiveReader(archivePath)
+	defer r.Close()
+
+	hashes := make(map[string]string)
+	for _, entry := range index.Entries {
+		if !entry.Mode.IsRegular() {
+			continue
+		}
+
+		h := sha256.New()
+		if err := r.copyFile(entry, h); err != nil {
+			return nil, err
+		}
+		hashes[strings.TrimPrefix(entry.Path, "/")] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return hashes, nil
+}
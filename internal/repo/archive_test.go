@@ -0,0 +1,174 @@
+package repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func setupArchiveRepo(t *testing.T) *RepositoryArchive {
+	t.Helper()
+
+	r := RepoArchiveNew("archive_repo", t.TempDir(), false)
+	r.StagingPath = t.TempDir()
+
+	return &r
+}
+
+// pushArchiveImage stages test data as image uuid, with a file large enough to span several compressed blocks, and pushes it to the repository
+func pushArchiveImage(t *testing.T, r *RepositoryArchive, uuid string) []byte {
+	t.Helper()
+
+	stagedData := stageImage(t, r, uuid)
+	large := bytes.Repeat([]byte("2024-01-01 00:00:00 INFO request served\n"), 2*ARCHIVE_BLOCK_SIZE/40)
+	if err := os.MkdirAll(filepath.Join(stagedData, "var", "log"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"app.log", "app.log.1"} {
+		if err := os.WriteFile(filepath.Join(stagedData, "var", "log", name), large, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pushImage(t, r, uuid)
+
+	return large
+}
+
+func TestRepositoryArchive_PushFetch(t *testing.T) {
+	r := setupArchiveRepo(t)
+	large := pushArchiveImage(t, r, "first")
+
+	size, err := diskUsage(r.GetStoragePath("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size >= int64(len(large)) {
+		t.Errorf("archived image size = %d, want less than %d", size, len(large))
+	}
+
+	index, err := loadArchiveIndex(r.getArchivePath("first"))
+	if err != nil {
+		t.Fatalf("loadArchiveIndex() error = %v", err)
+	}
+	offsets := make(map[int64]bool)
+	for _, entry := range index.Entries {
+		offsets[entry.Offset] = true
+	}
+	if len(offsets) < 2 {
+		t.Errorf("archive entries offsets = %v, want several compressed blocks", offsets)
+	}
+
+	entries, err := r.ListImageFolder("first", "/etc/app")
+	if err != nil {
+		t.Fatalf("ListImageFolder() error = %v", err)
+	}
+	paths := make([]string, 0)
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	if want := []string{"/etc/app/app.conf", "/etc/app/empty", "/etc/app/link"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("ListImageFolder() paths = %v, want %v", paths, want)
+	}
+	if _, err := r.ListImageFolder("first", "/etc/hosts"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("ListImageFolder() on file error = %v, want %v", err, ErrInvalidPath)
+	}
+
+	hashes, err := r.HashImageFiles("first")
+	if err != nil {
+		t.Fatalf("HashImageFiles() error = %v", err)
+	}
+	largeSum := sha256.Sum256(large)
+	if hashes["var/log/app.log.1"] != hex.EncodeToString(largeSum[:]) || len(hashes) != 5 {
+		t.Errorf("HashImageFiles() = %v, want hashes of the 5 image files", hashes)
+	}
+
+	if err := r.FetchData("first", []string{"/etc/app", "/var/log/app.log.1"}, "restore"); err != nil {
+		t.Fatalf("FetchData() error = %v", err)
+	}
+	restored := filepath.Join(r.GetDestination("restore"), "_data")
+	content, err := os.ReadFile(filepath.Join(restored, "etc", "app", "app.conf"))
+	if err != nil || string(content) != "127.0.0.1 localhost\n" {
+		t.Errorf("FetchData() file content = %q, %v, want original content", content, err)
+	}
+	if info, err := os.Stat(filepath.Join(restored, "etc", "app", "app.conf")); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("FetchData() file mode = %v, %v, want %v", info.Mode().Perm(), err, fs.FileMode(0640))
+	}
+	if target, err := os.Readlink(filepath.Join(restored, "etc", "app", "link")); err != nil || target != "app.conf" {
+		t.Errorf("FetchData() link target = %v, %v, want app.conf", target, err)
+	}
+	if content, err := os.ReadFile(filepath.Join(restored, "var", "log", "app.log.1")); err != nil || !bytes.Equal(content, large) {
+		t.Errorf("FetchData() of large file = %d bytes, %v, want original content", len(content), err)
+	}
+	if _, err := os.Lstat(filepath.Join(restored, "var", "log", "app.log")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("FetchData() restored a path outside of sources, error = %v", err)
+	}
+}
+
+func TestRepositoryArchive_SegmentFormat(t *testing.T) {
+	r := setupArchiveRepo(t)
+	pushArchiveImage(t, r, "first")
+
+	// Segments are regular tar.gz files readable from start to end
+	f, err := os.Open(filepath.Join(r.getArchivePath("first"), archiveSegmentName(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(zr)
+	names := make([]string, 0)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("cannot read segment as tar.gz: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	want := []string{"etc/", "etc/app/", "etc/app/app.conf", "etc/app/empty", "etc/app/link", "etc/hosts", "var/", "var/log/", "var/log/app.log", "var/log/app.log.1"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("segment entries = %v, want %v", names, want)
+	}
+}
+
+func TestRepositoryArchive_PushFiles(t *testing.T) {
+	r := setupArchiveRepo(t)
+	pushArchiveImage(t, r, "first")
+
+	if err := os.RemoveAll(filepath.Join(r.GetDestination("first"), "_data")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(r.GetDestination("first"), "_logs", "rsync.log"), []byte("log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.PushFiles("first"); err != nil {
+		t.Fatalf("PushFiles() error = %v", err)
+	}
+
+	if content, err := os.ReadFile(filepath.Join(r.GetStoragePath("first"), "_logs", "rsync.log")); err != nil || string(content) != "log" {
+		t.Errorf("PushFiles() log content = %q, %v, want %q", content, err, "log")
+	}
+	if _, err := loadArchiveIndex(r.getArchivePath("first")); err != nil {
+		t.Errorf("loadArchiveIndex() after PushFiles() error = %v", err)
+	}
+
+	if err := r.DeleteImage("first"); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	if exists, err := r.Exists(r.GetStoragePath("first")); err != nil || exists {
+		t.Errorf("Exists() after DeleteImage() = %v, %v, want false", exists, err)
+	}
+}
@@ -0,0 +1,102 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Chunk boundaries are found with a gear rolling hash over file content, using normalized chunking: a boundary is harder to find before CHUNK_AVG_SIZE and easier after it, which keeps chunk sizes close to the average.
+// Gear table and masks must never change: chunks of data stored with other values would not deduplicate against new data anymore
+const (
+	cdcMaskStrict uint64 = ^uint64(1<<(64-22) - 1)
+	cdcMaskLoose  uint64 = ^uint64(1<<(64-18) - 1)
+)
+
+var gearTable = newGearTable(0x72656c69717565)
+
+// newGearTable fills the gear table with pseudo-random values generated with splitmix64 from a fixed seed
+func newGearTable(seed uint64) [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+
+	return table
+}
+
+// chunker splits a stream into content-defined chunks of CHUNK_MIN_SIZE to CHUNK_MAX_SIZE bytes
+type chunker struct {
+	r   io.Reader
+	buf []byte
+	// Unread data is buf[start:end]
+	start int
+	end   int
+	eof   bool
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{
+		r:   r,
+		buf: make([]byte, 2*CHUNK_MAX_SIZE),
+	}
+}
+
+// Next returns the next chunk of the stream, or io.EOF once the stream is consumed. The returned slice is only valid until the next call
+func (c *chunker) Next() ([]byte, error) {
+	if c.end-c.start < CHUNK_MAX_SIZE && !c.eof {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+		for c.end < len(c.buf) && !c.eof {
+			n, err := c.r.Read(c.buf[c.end:])
+			c.end += n
+			if errors.Is(err, io.EOF) {
+				c.eof = true
+			} else if err != nil {
+				return nil, fmt.Errorf("cannot read data to split into chunks: %w", err)
+			}
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	size := cutPoint(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+size]
+	c.start += size
+
+	return chunk, nil
+}
+
+// cutPoint returns the size of the chunk starting at the beginning of data
+func cutPoint(data []byte) int {
+	n := len(data)
+	if n <= CHUNK_MIN_SIZE {
+		return n
+	}
+	if n > CHUNK_MAX_SIZE {
+		n = CHUNK_MAX_SIZE
+	}
+	normal := min(n, CHUNK_AVG_SIZE)
+
+	var hash uint64
+	i := CHUNK_MIN_SIZE
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&cdcMaskStrict == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&cdcMaskLoose == 0 {
+			return i + 1
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// splitChunks returns the hashes of the chunks data is split into, checking chunk sizes and that chunks rebuild data
+func splitChunks(t *testing.T, data []byte) []string {
+	t.Helper()
+
+	hashes := make([]string, 0)
+	rebuilt := make([]byte, 0, len(data))
+	c := newChunker(bytes.NewReader(data))
+	for {
+		chunk, err := c.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		if len(chunk) > CHUNK_MAX_SIZE || (len(chunk) < CHUNK_MIN_SIZE && len(rebuilt)+len(chunk) != len(data)) {
+			t.Errorf("chunk size = %d, want between %d and %d", len(chunk), CHUNK_MIN_SIZE, CHUNK_MAX_SIZE)
+		}
+		rebuilt = append(rebuilt, chunk...)
+		sum := sha256.Sum256(chunk)
+		hashes = append(hashes, hex.EncodeToString(sum[:]))
+	}
+	if !bytes.Equal(rebuilt, data) {
+		t.Fatalf("chunks do not rebuild original data")
+	}
+
+	return hashes
+}
+
+func TestChunker(t *testing.T) {
+	data := make([]byte, 24*1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	first := splitChunks(t, data)
+	if len(first) < 6 || len(first) > 24 {
+		t.Errorf("data split into %d chunks, want about %d", len(first), len(data)/CHUNK_AVG_SIZE)
+	}
+
+	// Inserting data at the beginning of the stream only changes the chunks around the insertion
+	second := splitChunks(t, append([]byte("inserted header\n"), data...))
+	known := make(map[string]bool)
+	for _, hash := range first {
+		known[hash] = true
+	}
+	shared := 0
+	for _, hash := range second {
+		if known[hash] {
+			shared++
+		}
+	}
+	if shared < len(first)-1 {
+		t.Errorf("%d chunks shared after insertion, want at least %d", shared, len(first)-1)
+	}
+
+	// Constant data is cut at maximum chunk size
+	if hashes := splitChunks(t, make([]byte, 2*CHUNK_MAX_SIZE+1)); len(hashes) != 3 {
+		t.Errorf("constant data split into %d chunks, want 3", len(hashes))
+	}
+	if hashes := splitChunks(t, nil); len(hashes) != 0 {
+		t.Errorf("empty data split into %d chunks, want none", len(hashes))
+	}
+}
@@ -0,0 +1,1070 @@
+package repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/macarrie/relique/internal/s3"
+)
+
+// Files are split into chunks at boundaries defined by their content, so that data inserted in a file only changes the chunks around the modification.
+// Chunks are stored once per repository, named after their SHA-256 hash, or a keyed hash in encrypted repositories
+const (
+	CHUNK_MIN_SIZE = 512 * 1024
+	CHUNK_AVG_SIZE = 1024 * 1024
+	CHUNK_MAX_SIZE = 4 * 1024 * 1024
+)
+
+const CHUNK_INDEX_VERSION = 1
+
+// CHUNK_GC_GRACE protects recently uploaded or released chunks from garbage collection, since a backup running on another host may reference them in an image whose index is not written yet
+const CHUNK_GC_GRACE = 24 * time.Hour
+
+const chunkIndexName = "index.json.gz"
+
+const chunkRefsName = "refs.json.gz"
+
+// Version 2 references also hold the sizes used for space accounting, references of older versions are counted again from the repository content
+const CHUNK_REFS_VERSION = 2
+
+// objectStore is the key/value storage holding chunked repositories content
+type objectStore interface {
+	Put(key string, data []byte) error
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	List(prefix string) ([]s3.Object, error)
+}
+
+// ChunkIndexEntry describes an element of an image stored in a chunked repository
+type ChunkIndexEntry struct {
+	// Path inside the image, which is the path of the file on the client
+	Path       string      `json:"path"`
+	Mode       fs.FileMode `json:"mode"`
+	Size       int64       `json:"size"`
+	ModTime    time.Time   `json:"mtime"`
+	Uid        uint32      `json:"uid"`
+	Gid        uint32      `json:"gid"`
+	LinkTarget string      `json:"link_target,omitempty"`
+	// Hashes of the chunks making up regular files content, in order
+	Chunks []string `json:"chunks,omitempty"`
+}
+
+// ChunkIndex lists every element of an image stored in a chunked repository, sorted by path
+type ChunkIndex struct {
+	Version int               `json:"version"`
+	Entries []ChunkIndexEntry `json:"entries"`
+}
+
+// ChunkRefs counts the images referencing each chunk of a repository, so that chunks can be removed once the last image using them is deleted
+type ChunkRefs struct {
+	Version int            `json:"version"`
+	Refs    map[string]int `json:"refs"`
+	// Date chunks stopped being referenced by any image, chunks are removed once CHUNK_GC_GRACE has elapsed
+	Released map[string]time.Time `json:"released,omitempty"`
+	// Stored size of referenced and released chunks, by chunk hash
+	Sizes map[string]int64 `json:"sizes,omitempty"`
+	// Storage folder names of the images referencing chunks used by one or two images, by chunk hash.
+	// Owners of a chunk going back to two references when an image is removed are unknown, and looked up in image indexes when needed
+	Owners map[string][]string `json:"owners,omitempty"`
+	// Stored size of image objects other than chunks, such as indexes and metadata, by object key
+	Objects map[string]int64 `json:"objects,omitempty"`
+}
+
+// UploadStats summarizes data sent to a chunked repository
+type UploadStats struct {
+	Chunks         int
+	NewChunks      int
+	UploadedBytes  int64
+	DedupedBytes   int64
+	IndexedEntries int
+}
+
+func (s UploadStats) pushStats() PushStats {
+	return PushStats{
+		DataBytes: s.UploadedBytes + s.DedupedBytes,
+		NewBytes:  s.UploadedBytes,
+	}
+}
+
+type chunkStore struct {
+	store objectStore
+	// Key prefix of the repository inside the object store, empty or ending with a slash
+	root string
+	// Encrypts chunks, indexes and image files of encrypted repositories, nil otherwise
+	cipher *repoCipher
+	// Identifies the repository storage, chunk store instances sharing an ID share their locks
+	id string
+}
+
+// Chunk stores are protected by two repository locks, shared by every relique process of the host.
+// Uploads hold the data lock shared, from the listing of stored chunks until image references are added, and image removal holds it exclusively since uploads may deduplicate data against chunks being released.
+// The refs lock is held exclusively while chunk references are read and written
+const (
+	chunkDataLock = "data"
+	chunkRefsLock = "refs"
+)
+
+// put stores an object, encrypted if the repository is encrypted
+func (c *chunkStore) put(key string, data []byte) error {
+	sealed, err := c.seal(key, data)
+	if err != nil {
+		return err
+	}
+
+	return c.store.Put(key, sealed)
+}
+
+// seal returns the content stored for an object: its data, encrypted if the repository is encrypted
+func (c *chunkStore) seal(key string, data []byte) ([]byte, error) {
+	if c.cipher == nil {
+		return data, nil
+	}
+
+	sealed, err := c.cipher.seal(strings.TrimPrefix(key, c.root), data)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt object: %w", err)
+	}
+
+	return sealed, nil
+}
+
+// get reads an object stored with put
+func (c *chunkStore) get(key string) ([]byte, error) {
+	data, err := c.store.Get(key)
+	if err != nil || c.cipher == nil {
+		return data, err
+	}
+
+	return c.cipher.open(strings.TrimPrefix(key, c.root), data)
+}
+
+// chunkID returns the name of a chunk: its SHA-256 hash, or a keyed hash in encrypted repositories
+func (c *chunkStore) chunkID(data []byte) string {
+	if c.cipher != nil {
+		return c.cipher.chunkID(data)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *chunkStore) chunksPrefix() string {
+	return c.root + "chunks/"
+}
+
+func (c *chunkStore) chunkKey(hash string) string {
+	return fmt.Sprintf("%s%s/%s", c.chunksPrefix(), hash[:2], hash)
+}
+
+func indexKey(storagePath string) string {
+	return fmt.Sprintf("%s/_data/%s", storagePath, chunkIndexName)
+}
+
+// listChunks returns the chunks stored in the repository, by hash
+func (c *chunkStore) listChunks() (map[string]s3.Object, error) {
+	objects, err := c.store.List(c.chunksPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("cannot list repository chunks: %w", err)
+	}
+
+	chunks := make(map[string]s3.Object, len(objects))
+	for _, o := range objects {
+		chunks[path.Base(o.Key)] = o
+	}
+
+	return chunks, nil
+}
+
+// uploadTree stores the content of a local image data folder as chunks and writes the image index. Chunks already stored in the repository are not sent again.
+// References to the image chunks are added before the index is written: an interrupted upload leaves chunks that are never removed, but never an image with missing chunks
+func (c *chunkStore) uploadTree(dataPath string, storagePath string) (UploadStats, error) {
+	var stats UploadStats
+
+	dataLock, err := lockRepository(c.id, chunkDataLock, false)
+	if err != nil {
+		return stats, err
+	}
+	defer dataLock.unlock()
+
+	known, err := c.listChunks()
+	if err != nil {
+		return stats, err
+	}
+
+	index := ChunkIndex{
+		Version: CHUNK_INDEX_VERSION,
+		Entries: make([]ChunkIndexEntry, 0),
+	}
+	err = filepath.WalkDir(dataPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dataPath {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dataPath, p)
+		if err != nil {
+			return err
+		}
+
+		entry := ChunkIndexEntry{
+			Path:    "/" + filepath.ToSlash(relPath),
+			Mode:    info.Mode(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			entry.Uid = stat.Uid
+			entry.Gid = stat.Gid
+		}
+
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return fmt.Errorf("cannot read symbolic link '%s': %w", p, err)
+			}
+			entry.LinkTarget = target
+		case info.Mode().IsRegular():
+			chunks, err := c.uploadFile(p, known, &stats)
+			if err != nil {
+				return err
+			}
+			entry.Chunks = chunks
+		}
+
+		index.Entries = append(index.Entries, entry)
+		return nil
+	})
+	if err != nil {
+		return stats, fmt.Errorf("cannot store image files: %w", err)
+	}
+
+	content, err := encodeIndex(index)
+	if err != nil {
+		return stats, err
+	}
+	sealed, err := c.seal(indexKey(storagePath), content)
+	if err != nil {
+		return stats, err
+	}
+	err = c.updateRefs(func(refs *ChunkRefs) {
+		hashes := indexChunks(index)
+		refs.add(path.Base(storagePath), hashes, 1)
+		for _, hash := range hashes {
+			refs.Sizes[hash] = known[hash].Size
+		}
+		refs.Objects[indexKey(storagePath)] = int64(len(sealed))
+	})
+	if err != nil {
+		return stats, fmt.Errorf("cannot add image chunks references: %w", err)
+	}
+
+	if err := c.store.Put(indexKey(storagePath), sealed); err != nil {
+		return stats, fmt.Errorf("cannot store image index: %w", err)
+	}
+	stats.IndexedEntries = len(index.Entries)
+
+	return stats, nil
+}
+
+func (c *chunkStore) uploadFile(p string, known map[string]s3.Object, stats *UploadStats) ([]string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open file: %w", err)
+	}
+	defer f.Close()
+
+	chunks := make([]string, 0)
+	chunker := newChunker(f)
+	for {
+		data, err := chunker.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("cannot read file '%s': %w", p, err)
+		}
+
+		hash := c.chunkID(data)
+		chunks = append(chunks, hash)
+		stats.Chunks++
+
+		if _, ok := known[hash]; ok {
+			stats.DedupedBytes += int64(len(data))
+		} else {
+			sealed, err := c.seal(c.chunkKey(hash), data)
+			if err != nil {
+				return nil, err
+			}
+			if err := c.store.Put(c.chunkKey(hash), sealed); err != nil {
+				return nil, err
+			}
+			known[hash] = s3.Object{
+				Key:          c.chunkKey(hash),
+				Size:         int64(len(sealed)),
+				LastModified: time.Now(),
+			}
+			stats.NewChunks++
+			stats.UploadedBytes += int64(len(data))
+		}
+	}
+
+	return chunks, nil
+}
+
+func encodeIndex(index ChunkIndex) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(index); err != nil {
+		return nil, fmt.Errorf("cannot serialize image index: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("cannot compress image index: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// loadIndex reads an image index. The returned error wraps fs.ErrNotExist if the image has no index
+func (c *chunkStore) loadIndex(storagePath string) (ChunkIndex, error) {
+	content, err := c.get(indexKey(storagePath))
+	if errors.Is(err, s3.ErrNotFound) {
+		return ChunkIndex{}, fmt.Errorf("cannot find image index: %w", fs.ErrNotExist)
+	} else if err != nil {
+		return ChunkIndex{}, err
+	}
+
+	return decodeIndex(content)
+}
+
+func decodeIndex(content []byte) (ChunkIndex, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return ChunkIndex{}, fmt.Errorf("cannot decompress image index: %w", err)
+	}
+	defer zr.Close()
+
+	var index ChunkIndex
+	if err := json.NewDecoder(zr).Decode(&index); err != nil {
+		return ChunkIndex{}, fmt.Errorf("cannot parse image index: %w", err)
+	}
+
+	return index, nil
+}
+
+// restoreTree rebuilds the listed image paths from chunks into a local folder, keeping their paths relative to image root
+func (c *chunkStore) restoreTree(storagePath string, sources []string, destination string) error {
+	index, err := c.loadIndex(storagePath)
+	if err != nil {
+		return err
+	}
+
+	return restoreEntries(index.Entries, sources, destination, func(i int, target string) error {
+		return c.restoreFile(index.Entries[i], target)
+	})
+}
+
+// restoreEntries rebuilds the listed image paths of an index into a local folder, keeping their paths relative to image root.
+// Regular files content is written by restoreFile from the index entry at position i
+func restoreEntries(entries []ChunkIndexEntry, sources []string, destination string, restoreFile func(i int, target string) error) error {
+	dirs := make([]ChunkIndexEntry, 0)
+	for i, entry := range entries {
+		if !matchesSources(entry.Path, sources) {
+			continue
+		}
+
+		target := filepath.Join(destination, filepath.FromSlash(entry.Path))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("cannot create folder: %w", err)
+		}
+
+		switch {
+		case entry.Mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return fmt.Errorf("cannot create folder: %w", err)
+			}
+			// Folder attributes are set once their content is restored
+			dirs = append(dirs, entry)
+			continue
+		case entry.Mode&fs.ModeSymlink != 0:
+			if err := os.Symlink(entry.LinkTarget, target); err != nil {
+				return fmt.Errorf("cannot create symbolic link: %w", err)
+			}
+		case entry.Mode.IsRegular():
+			if err := restoreFile(i, target); err != nil {
+				return err
+			}
+		default:
+			slog.With(
+				slog.String("path", entry.Path),
+				slog.String("mode", entry.Mode.String()),
+			).Warn("Special files are not stored in repository, skipping")
+			continue
+		}
+
+		if err := setAttributes(entry, target); err != nil {
+			return err
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := setAttributes(dirs[i], filepath.Join(destination, filepath.FromSlash(dirs[i].Path))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *chunkStore) restoreFile(entry ChunkIndexEntry, target string) error {
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot create file: %w", err)
+	}
+	defer f.Close()
+
+	for _, hash := range entry.Chunks {
+		data, err := c.getChunk(hash, entry.Path)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(data); err != nil {
+			return fmt.Errorf("cannot write file '%s': %w", target, err)
+		}
+	}
+
+	return f.Close()
+}
+
+// getChunk reads a chunk of the file at p and checks that its content matches its name
+func (c *chunkStore) getChunk(hash string, p string) ([]byte, error) {
+	data, err := c.get(c.chunkKey(hash))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get chunk of file '%s': %w", p, err)
+	}
+	if c.chunkID(data) != hash {
+		return nil, fmt.Errorf("chunk '%s' of file '%s' is corrupted", hash, p)
+	}
+
+	return data, nil
+}
+
+// hashFiles computes SHA-256 hashes of the regular files of an image from its chunks, indexed by their path relative to image root like integrity manifests
+func (c *chunkStore) hashFiles(storagePath string) (map[string]string, error) {
+	index, err := c.loadIndex(storagePath)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[string]string)
+	for _, entry := range index.Entries {
+		if !entry.Mode.IsRegular() {
+			continue
+		}
+
+		h := sha256.New()
+		for _, hash := range entry.Chunks {
+			data, err := c.getChunk(hash, entry.Path)
+			if err != nil {
+				return nil, err
+			}
+			h.Write(data)
+		}
+		hashes[strings.TrimPrefix(entry.Path, "/")] = hex.EncodeToString(h.Sum(nil))
+	}
+
+	return hashes, nil
+}
+
+// listFolder lists a folder of image data from the image index
+func (c *chunkStore) listFolder(storagePath string, imagePath string) ([]FileEntry, error) {
+	index, err := c.loadIndex(storagePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load image index: %w", err)
+	}
+
+	return listIndexFolder(index.Entries, imagePath)
+}
+
+// listIndexFolder lists the entries of an image index located directly inside a folder of the image
+func listIndexFolder(entries []ChunkIndexEntry, imagePath string) ([]FileEntry, error) {
+	if strings.ContainsRune(imagePath, 0) {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidPath, imagePath)
+	}
+	cleaned := path.Clean("/" + imagePath)
+
+	found := cleaned == "/"
+	files := make([]FileEntry, 0)
+	for _, entry := range entries {
+		if entry.Mode&fs.ModeSymlink != 0 && strings.HasPrefix(cleaned, entry.Path+"/") {
+			return nil, fmt.Errorf("%w: '%s' goes through a symbolic link", ErrInvalidPath, imagePath)
+		}
+		if entry.Path == cleaned {
+			if !entry.Mode.IsDir() {
+				return nil, fmt.Errorf("%w: '%s' is not a folder", ErrInvalidPath, imagePath)
+			}
+			found = true
+			continue
+		}
+		if path.Dir(entry.Path) != cleaned {
+			continue
+		}
+
+		files = append(files, FileEntry{
+			Path:       entry.Path,
+			Mode:       entry.Mode,
+			Size:       entry.Size,
+			ModTime:    entry.ModTime,
+			Uid:        entry.Uid,
+			Gid:        entry.Gid,
+			LinkTarget: entry.LinkTarget,
+		})
+	}
+	if !found {
+		return nil, fmt.Errorf("cannot get path info: %w", fs.ErrNotExist)
+	}
+
+	return files, nil
+}
+
+func setAttributes(entry ChunkIndexEntry, target string) error {
+	// Owner can only be restored when running as root. Restore tasks send files to clients with numeric IDs anyway
+	_ = os.Lchown(target, int(entry.Uid), int(entry.Gid))
+
+	if entry.Mode&fs.ModeSymlink != 0 {
+		return nil
+	}
+	if err := os.Chmod(target, entry.Mode.Perm()|(entry.Mode&(fs.ModeSetuid|fs.ModeSetgid|fs.ModeSticky))); err != nil {
+		return fmt.Errorf("cannot set file permissions: %w", err)
+	}
+	if err := os.Chtimes(target, entry.ModTime, entry.ModTime); err != nil {
+		return fmt.Errorf("cannot set file modification time: %w", err)
+	}
+
+	return nil
+}
+
+func matchesSources(p string, sources []string) bool {
+	for _, s := range sources {
+		s = path.Clean("/" + s)
+		if s == "/" || p == s || strings.HasPrefix(p, s+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// uploadFiles copies the files of a local folder under a key prefix, and records their stored size for space accounting
+func (c *chunkStore) uploadFiles(localPath string, keyPrefix string) error {
+	sizes := make(map[string]int64)
+	err := filepath.WalkDir(localPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("cannot read file: %w", err)
+		}
+		relPath, err := filepath.Rel(localPath, p)
+		if err != nil {
+			return err
+		}
+
+		key := fmt.Sprintf("%s/%s", keyPrefix, filepath.ToSlash(relPath))
+		sealed, err := c.seal(key, content)
+		if err != nil {
+			return err
+		}
+		if err := c.store.Put(key, sealed); err != nil {
+			return err
+		}
+		sizes[key] = int64(len(sealed))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(sizes) == 0 {
+		return nil
+	}
+
+	return c.updateRefs(func(refs *ChunkRefs) {
+		for key, size := range sizes {
+			refs.Objects[key] = size
+		}
+	})
+}
+
+// exists checks if objects are stored under p
+func (c *chunkStore) exists(p string) (bool, error) {
+	objects, err := c.store.List(p)
+	if err != nil {
+		return false, err
+	}
+	if p == "" {
+		return len(objects) > 0, nil
+	}
+
+	for _, o := range objects {
+		if o.Key == p || strings.HasPrefix(o.Key, p+"/") {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// usage returns the size of the chunks and image objects stored in the repository, from chunk references
+func (c *chunkStore) usage() (int64, error) {
+	refs, err := c.loadRefs()
+	if err != nil {
+		return 0, err
+	}
+
+	var used int64
+	for hash, size := range refs.Sizes {
+		if _, ok := refs.Refs[hash]; ok {
+			used += size
+		} else if _, ok := refs.Released[hash]; ok {
+			used += size
+		}
+	}
+	for _, size := range refs.Objects {
+		used += size
+	}
+
+	return used, nil
+}
+
+// imageSpace splits the space used by an image between its own objects and the chunks only it references, and the chunks it shares with other images.
+// Stored object sizes are used, which include encryption overhead in encrypted repositories
+func (c *chunkStore) imageSpace(storagePath string) (ImageSpace, error) {
+	var space ImageSpace
+
+	index, err := c.loadIndex(storagePath)
+	if err != nil {
+		return space, err
+	}
+	refs, err := c.loadRefs()
+	if err != nil {
+		return space, err
+	}
+
+	for _, hash := range indexChunks(index) {
+		if refs.Refs[hash] > 1 {
+			space.Shared += refs.Sizes[hash]
+		} else {
+			space.Unique += refs.Sizes[hash]
+		}
+	}
+	for key, size := range refs.Objects {
+		if strings.HasPrefix(key, storagePath+"/") {
+			space.Unique += size
+		}
+	}
+
+	return space, nil
+}
+
+// pairedSpace returns the size of the chunks referenced by the image stored under storagePath and by a single other image, by storage folder name of the other image.
+// Only these chunks change from unique to shared, or back, for other images when the image is added or removed, so it must be called while the image is referenced.
+// The other image is taken from chunk owners, image indexes are only read for chunks whose owners are unknown
+func (c *chunkStore) pairedSpace(storagePath string) (map[string]int64, error) {
+	paired := make(map[string]int64)
+
+	index, err := c.loadIndex(storagePath)
+	if err != nil {
+		return paired, err
+	}
+	refs, err := c.loadRefs()
+	if err != nil {
+		return paired, err
+	}
+	name := path.Base(storagePath)
+	remaining := make(map[string]bool)
+	for _, hash := range indexChunks(index) {
+		if refs.Refs[hash] != 2 {
+			continue
+		}
+
+		owners := refs.Owners[hash]
+		if len(owners) != 2 {
+			remaining[hash] = true
+			continue
+		}
+		for _, owner := range owners {
+			if owner != name {
+				paired[owner] += refs.Sizes[hash]
+			}
+		}
+	}
+	if len(remaining) == 0 {
+		return paired, nil
+	}
+
+	objects, err := c.store.List(c.root)
+	if err != nil {
+		return paired, fmt.Errorf("cannot list repository objects: %w", err)
+	}
+	for _, o := range objects {
+		if len(remaining) == 0 {
+			break
+		}
+		otherPath := strings.TrimSuffix(o.Key, "/_data/"+chunkIndexName)
+		if otherPath == o.Key || otherPath == storagePath {
+			continue
+		}
+
+		other, err := c.loadIndex(otherPath)
+		if err != nil {
+			return paired, fmt.Errorf("cannot read index '%s': %w", o.Key, err)
+		}
+		// Each paired chunk is referenced by a single other image
+		for _, hash := range indexChunks(other) {
+			if remaining[hash] {
+				paired[path.Base(otherPath)] += refs.Sizes[hash]
+				delete(remaining, hash)
+			}
+		}
+	}
+
+	return paired, nil
+}
+
+// healthCheck writes and removes an object at repository root
+func (c *chunkStore) healthCheck() error {
+	key := c.root + ".relique-healthcheck"
+	if err := c.store.Put(key, []byte(time.Now().UTC().Format(time.RFC3339))); err != nil {
+		return err
+	}
+
+	return c.store.Delete(key)
+}
+
+// removeAll deletes every object stored under p and releases the chunks of the image index stored under p, if any.
+// Unreferenced chunks released or uploaded more than CHUNK_GC_GRACE ago are collected
+func (c *chunkStore) removeAll(p string) error {
+	dataLock, err := lockRepository(c.id, chunkDataLock, true)
+	if err != nil {
+		return err
+	}
+	defer dataLock.unlock()
+	refsLock, err := lockRepository(c.id, chunkRefsLock, true)
+	if err != nil {
+		return err
+	}
+	defer refsLock.unlock()
+
+	index, err := c.loadIndex(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		// Chunks used by the image cannot be released, image is kept
+		return fmt.Errorf("cannot read image index: %w", err)
+	}
+	// References are loaded while the image index exists, since they may be counted from indexes
+	refs, err := c.loadRefs()
+	if err != nil {
+		return err
+	}
+
+	objects, err := c.store.List(p + "/")
+	if err != nil {
+		return err
+	}
+	for _, o := range objects {
+		if err := c.store.Delete(o.Key); err != nil {
+			return err
+		}
+		delete(refs.Objects, o.Key)
+	}
+
+	refs.add(path.Base(p), indexChunks(index), -1)
+	if err := c.saveRefs(refs); err != nil {
+		return fmt.Errorf("cannot release image chunks: %w", err)
+	}
+	if _, err := c.collectGarbage(CHUNK_GC_GRACE); err != nil {
+		return fmt.Errorf("cannot remove unused chunks: %w", err)
+	}
+
+	return nil
+}
+
+// collectGarbage removes chunks not referenced by any image. Unreferenced chunks uploaded or released less than grace ago are kept since they may belong to a backup running on another host.
+// It must be called with the data and refs locks held. The number of removed chunks is returned
+func (c *chunkStore) collectGarbage(grace time.Duration) (int, error) {
+	chunks, err := c.listChunks()
+	if err != nil {
+		return 0, err
+	}
+	refs, err := c.loadRefs()
+	if err != nil {
+		return 0, err
+	}
+
+	hashes := make([]string, 0, len(chunks))
+	for hash := range chunks {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	removed := 0
+	for _, hash := range hashes {
+		if refs.Refs[hash] > 0 || time.Since(chunks[hash].LastModified) < grace {
+			continue
+		}
+		if released, ok := refs.Released[hash]; ok && time.Since(released) < grace {
+			continue
+		}
+		if err := c.store.Delete(c.chunkKey(hash)); err != nil {
+			return removed, err
+		}
+		delete(chunks, hash)
+		removed++
+	}
+
+	// Release dates and sizes are only needed until chunks are removed
+	cleaned := 0
+	for hash := range refs.Released {
+		if _, ok := chunks[hash]; !ok || time.Since(refs.Released[hash]) >= grace {
+			delete(refs.Released, hash)
+			cleaned++
+		}
+	}
+	for hash := range refs.Sizes {
+		if _, ok := chunks[hash]; !ok && refs.Refs[hash] == 0 {
+			delete(refs.Sizes, hash)
+			cleaned++
+		}
+	}
+	if removed > 0 || cleaned > 0 {
+		if err := c.saveRefs(refs); err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// indexChunks returns the distinct chunks used by an image
+func indexChunks(index ChunkIndex) []string {
+	seen := make(map[string]bool)
+	hashes := make([]string, 0)
+	for _, entry := range index.Entries {
+		for _, hash := range entry.Chunks {
+			if !seen[hash] {
+				seen[hash] = true
+				hashes = append(hashes, hash)
+			}
+		}
+	}
+
+	return hashes
+}
+
+func (c *chunkStore) refsKey() string {
+	return c.root + chunkRefsName
+}
+
+// loadRefs reads chunk references. References of repositories created before reference counting was introduced, or stored with an older version, are counted from repository content
+func (c *chunkStore) loadRefs() (ChunkRefs, error) {
+	content, err := c.get(c.refsKey())
+	if errors.Is(err, s3.ErrNotFound) {
+		return c.countRefs()
+	} else if err != nil {
+		return ChunkRefs{}, fmt.Errorf("cannot get chunk references: %w", err)
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return ChunkRefs{}, fmt.Errorf("cannot decompress chunk references: %w", err)
+	}
+	defer zr.Close()
+
+	var refs ChunkRefs
+	if err := json.NewDecoder(zr).Decode(&refs); err != nil {
+		return ChunkRefs{}, fmt.Errorf("cannot parse chunk references: %w", err)
+	}
+	if refs.Version < CHUNK_REFS_VERSION {
+		counted, err := c.countRefs()
+		if err != nil {
+			return counted, err
+		}
+		// Release dates cannot be found from repository content
+		for hash, released := range refs.Released {
+			if _, ok := counted.Refs[hash]; !ok {
+				counted.Released[hash] = released
+			}
+		}
+		return counted, nil
+	}
+	refs.init()
+
+	return refs, nil
+}
+
+// init creates the maps missing from references read from repository
+func (refs *ChunkRefs) init() {
+	if refs.Refs == nil {
+		refs.Refs = make(map[string]int)
+	}
+	if refs.Released == nil {
+		refs.Released = make(map[string]time.Time)
+	}
+	if refs.Sizes == nil {
+		refs.Sizes = make(map[string]int64)
+	}
+	if refs.Owners == nil {
+		refs.Owners = make(map[string][]string)
+	}
+	if refs.Objects == nil {
+		refs.Objects = make(map[string]int64)
+	}
+}
+
+// countRefs counts chunk references from every image index stored in the repository, and gets chunks and image objects sizes from the repository listing
+func (c *chunkStore) countRefs() (ChunkRefs, error) {
+	refs := ChunkRefs{Version: CHUNK_REFS_VERSION}
+	refs.init()
+
+	objects, err := c.store.List(c.root)
+	if err != nil {
+		return refs, fmt.Errorf("cannot list repository objects: %w", err)
+	}
+	owners := make(map[string][]string)
+	for _, o := range objects {
+		relKey := strings.TrimPrefix(o.Key, c.root)
+		switch {
+		case strings.HasPrefix(o.Key, c.chunksPrefix()):
+			refs.Sizes[path.Base(o.Key)] = o.Size
+			continue
+		case strings.HasPrefix(o.Key, c.keysPrefix()) || !strings.Contains(relKey, "/"):
+			// Encryption keys and repository level objects do not belong to images
+			continue
+		}
+		refs.Objects[o.Key] = o.Size
+
+		if !strings.HasSuffix(o.Key, "/_data/"+chunkIndexName) {
+			continue
+		}
+		storagePath := strings.TrimSuffix(o.Key, "/_data/"+chunkIndexName)
+		index, err := c.loadIndex(storagePath)
+		if err != nil {
+			// Chunks of an unreadable index cannot be told apart from unused chunks
+			return refs, fmt.Errorf("cannot read index '%s': %w", o.Key, err)
+		}
+		for _, hash := range indexChunks(index) {
+			refs.Refs[hash]++
+			if refs.Refs[hash] <= 2 {
+				owners[hash] = append(owners[hash], path.Base(storagePath))
+			}
+		}
+	}
+	for hash, o := range owners {
+		if refs.Refs[hash] <= 2 {
+			refs.Owners[hash] = o
+		}
+	}
+
+	return refs, nil
+}
+
+// add adds a reference from the image stored in the owner folder to the listed chunks if delta is 1, or removes it if delta is -1. Chunks no longer referenced are marked as released
+func (refs *ChunkRefs) add(owner string, hashes []string, delta int) {
+	now := time.Now()
+	for _, hash := range hashes {
+		previous := refs.Refs[hash]
+		owners, known := refs.Owners[hash]
+		known = previous == 0 || (known && len(owners) == previous)
+
+		refs.Refs[hash] += delta
+		count := refs.Refs[hash]
+		delete(refs.Owners, hash)
+		if count <= 0 {
+			delete(refs.Refs, hash)
+			refs.Released[hash] = now
+			continue
+		}
+		delete(refs.Released, hash)
+
+		if !known || count > 2 {
+			continue
+		}
+		updated := make([]string, 0, count)
+		for _, o := range owners {
+			if delta > 0 || o != owner {
+				updated = append(updated, o)
+			}
+		}
+		if delta > 0 {
+			updated = append(updated, owner)
+		}
+		if len(updated) == count {
+			refs.Owners[hash] = updated
+		}
+	}
+}
+
+// updateRefs applies update to chunk references, holding the refs lock
+func (c *chunkStore) updateRefs(update func(refs *ChunkRefs)) error {
+	refsLock, err := lockRepository(c.id, chunkRefsLock, true)
+	if err != nil {
+		return err
+	}
+	defer refsLock.unlock()
+
+	refs, err := c.loadRefs()
+	if err != nil {
+		return err
+	}
+	update(&refs)
+
+	return c.saveRefs(refs)
+}
+
+// saveRefs writes chunk references. The references object is removed once no chunk or image object is referenced or waiting for removal anymore
+func (c *chunkStore) saveRefs(refs ChunkRefs) error {
+	if len(refs.Refs) == 0 && len(refs.Released) == 0 && len(refs.Objects) == 0 {
+		if err := c.store.Delete(c.refsKey()); err != nil {
+			return fmt.Errorf("cannot remove chunk references: %w", err)
+		}
+		return nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(refs); err != nil {
+		return fmt.Errorf("cannot serialize chunk references: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("cannot compress chunk references: %w", err)
+	}
+	if err := c.put(c.refsKey(), buf.Bytes()); err != nil {
+		return fmt.Errorf("cannot store chunk references: %w", err)
+	}
+
+	return nil
+}
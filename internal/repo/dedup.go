@@ -0,0 +1,200 @@
+package repo
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kennygrant/sanitize"
+	"github.com/pelletier/go-toml"
+)
+
+// RepositoryDedup stores images in a local folder as content-addressed chunks. Files are split into chunks at boundaries defined by their content, and each chunk is stored once in the repository whatever the client, module or image it belongs to.
+// Chunks count the images referencing them and are removed once the last of these images is deleted, after CHUNK_GC_GRACE.
+// Job data is transferred from clients into a local staging folder and split into the repository when the job ends
+type RepositoryDedup struct {
+	Name string `json:"name" toml:"name"`
+	Type string `json:"type" toml:"type"`
+	Path string `json:"path" toml:"path"`
+	// Local folder holding job data until it is split into the repository
+	StagingPath string `json:"staging_path" toml:"staging_path"`
+	Default     bool   `json:"default" toml:"default"`
+}
+
+func RepoDedupNew(name string, path string, isDefault bool) RepositoryDedup {
+	return RepositoryDedup{
+		Name:    name,
+		Type:    "dedup",
+		Path:    path,
+		Default: isDefault,
+	}
+}
+
+func (r *RepositoryDedup) GetName() string {
+	return r.Name
+}
+
+func (r *RepositoryDedup) GetType() string {
+	return r.Type
+}
+
+func (r *RepositoryDedup) GetLog() *slog.Logger {
+	return slog.With(
+		slog.String("name", r.GetName()),
+		slog.String("type", r.GetType()),
+		slog.String("path", r.Path),
+		slog.Bool("default", r.IsDefault()),
+	)
+}
+
+func (r *RepositoryDedup) Write(rootPath string) error {
+	var path string = filepath.Clean(fmt.Sprintf("%s/%s.toml",
+		rootPath,
+		strings.ToLower(sanitize.Accents(sanitize.BaseName(r.GetName()))),
+	))
+
+	repoToml, repoErr := toml.Marshal(r)
+	if repoErr != nil {
+		return fmt.Errorf("cannot serialize repository info to toml data: %w", repoErr)
+	}
+	if err := os.WriteFile(path, repoToml, 0644); err != nil {
+		return fmt.Errorf("cannot export repository info to file: %w", err)
+	}
+
+	r.GetLog().With(
+		slog.String("path", path),
+	).Debug("Saved repository to file")
+
+	return nil
+}
+
+func (r *RepositoryDedup) IsDefault() bool {
+	return r.Default
+}
+
+// GetStagingPath returns the local folder job data is staged in before being sent to the repository
+func (r *RepositoryDedup) GetStagingPath() string {
+	return getStagingPath(r.StagingPath, r.GetName())
+}
+
+// GetStoragePath returns the key prefix of image objects, relative to repository folder
+func (r *RepositoryDedup) GetStoragePath(uuid string) string {
+	return uuid
+}
+
+// GetDestination returns the image staging folder
+func (r *RepositoryDedup) GetDestination(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/%s", r.GetStagingPath(), uuid))
+}
+
+func (r *RepositoryDedup) PrepareImage(uuid string) error {
+	return prepareStagingFolders(r.GetDestination(uuid))
+}
+
+// GetLinkDest returns an empty string: data is deduplicated against every stored image when it is split into the repository
+func (r *RepositoryDedup) GetLinkDest(uuid string) string {
+	return ""
+}
+
+// Exists checks if objects are stored under p
+func (r *RepositoryDedup) Exists(p string) (bool, error) {
+	return r.getChunkStore().exists(p)
+}
+
+// DeleteImage removes image objects and releases the chunks no other image references
+func (r *RepositoryDedup) DeleteImage(uuid string) error {
+	if err := r.getChunkStore().removeAll(r.GetStoragePath(uuid)); err != nil {
+		return fmt.Errorf("cannot remove image from repository: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RepositoryDedup) GetSpace() (Space, error) {
+	used, err := r.getChunkStore().usage()
+	if err != nil {
+		return Space{}, fmt.Errorf("cannot compute space used in '%s': %w", r.Path, err)
+	}
+	free, total, err := filesystemSpace(r.Path)
+	if err != nil {
+		return Space{}, err
+	}
+
+	return Space{
+		Used:  used,
+		Free:  free,
+		Total: total,
+	}, nil
+}
+
+// GetImageSpace splits the space used by image objects and chunks between chunks only referenced by the image and chunks shared with other images
+func (r *RepositoryDedup) GetImageSpace(uuid string) (ImageSpace, error) {
+	return r.getChunkStore().imageSpace(r.GetStoragePath(uuid))
+}
+
+// GetPairedSpace returns the size of the chunks referenced by image uuid and a single other image, by image uuid
+func (r *RepositoryDedup) GetPairedSpace(uuid string) (map[string]int64, error) {
+	return r.getChunkStore().pairedSpace(r.GetStoragePath(uuid))
+}
+
+func (r *RepositoryDedup) HealthCheck() error {
+	if err := checkWritableFolder(r.Path); err != nil {
+		return fmt.Errorf("repository folder '%s' is not usable: %w", r.Path, err)
+	}
+
+	return nil
+}
+
+// PushData splits staged image data into the repository. Only chunks not already stored in the repository are written
+func (r *RepositoryDedup) PushData(uuid string, referenceUuid string) (PushStats, error) {
+	stats, err := r.getChunkStore().uploadTree(fmt.Sprintf("%s/_data", r.GetDestination(uuid)), r.GetStoragePath(uuid))
+	if err != nil {
+		return PushStats{}, fmt.Errorf("cannot store data into repository: %w", err)
+	}
+	r.GetLog().With(
+		slog.String("uuid", uuid),
+		slog.Int("chunks", stats.Chunks),
+		slog.Int("new_chunks", stats.NewChunks),
+		slog.Int64("uploaded_bytes", stats.UploadedBytes),
+		slog.Int64("deduplicated_bytes", stats.DedupedBytes),
+	).Info("Backup data stored into repository")
+
+	return stats.pushStats(), nil
+}
+
+// PushFiles copies the content of the image staging folder into the repository. Staged data is expected to be removed beforehand
+func (r *RepositoryDedup) PushFiles(uuid string) error {
+	if err := r.getChunkStore().uploadFiles(r.GetDestination(uuid), r.GetStoragePath(uuid)); err != nil {
+		return fmt.Errorf("cannot store files into repository: %w", err)
+	}
+
+	return nil
+}
+
+// FetchData rebuilds image paths from chunks into the restore job staging folder
+func (r *RepositoryDedup) FetchData(imageUuid string, sources []string, jobUuid string) error {
+	if err := r.getChunkStore().restoreTree(r.GetStoragePath(imageUuid), sources, fmt.Sprintf("%s/_data", r.GetDestination(jobUuid))); err != nil {
+		return fmt.Errorf("cannot fetch data from repository: %w", err)
+	}
+
+	return nil
+}
+
+// ListImageFolder lists a folder of image data from the image index
+func (r *RepositoryDedup) ListImageFolder(uuid string, imagePath string) ([]FileEntry, error) {
+	return r.getChunkStore().listFolder(r.GetStoragePath(uuid), imagePath)
+}
+
+// HashImageFiles hashes image files from their chunks
+func (r *RepositoryDedup) HashImageFiles(uuid string) (map[string]string, error) {
+	return r.getChunkStore().hashFiles(r.GetStoragePath(uuid))
+}
+
+func (r *RepositoryDedup) getChunkStore() *chunkStore {
+	return &chunkStore{
+		store: &fsStore{root: r.Path},
+		id:    "fs:" + filepath.Clean(r.Path),
+	}
+}
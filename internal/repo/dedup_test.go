@@ -0,0 +1,334 @@
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func setupDedupRepo(t *testing.T) *RepositoryDedup {
+	t.Helper()
+
+	r := RepoDedupNew("dedup_repo", t.TempDir(), false)
+	r.StagingPath = t.TempDir()
+
+	return &r
+}
+
+// pushDedupImage stages files along with test data as image uuid and pushes them to the repository
+func pushDedupImage(t *testing.T, r *RepositoryDedup, uuid string, files map[string][]byte) PushStats {
+	t.Helper()
+
+	stagedData := stageImage(t, r, uuid)
+	for p, content := range files {
+		target := filepath.Join(stagedData, p)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(target, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return pushImage(t, r, uuid)
+}
+
+// repoFiles lists the files stored in the repository folder
+func repoFiles(t *testing.T, r *RepositoryDedup) []string {
+	t.Helper()
+
+	files := make([]string, 0)
+	err := filepath.WalkDir(r.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestRepositoryDedup_PushDelete(t *testing.T) {
+	r := setupDedupRepo(t)
+
+	database := make([]byte, 6*1024*1024)
+	rand.New(rand.NewSource(1)).Read(database)
+
+	// Images of different clients and modules share data
+	first := pushDedupImage(t, r, "first", map[string][]byte{
+		"etc/hostname":        []byte("first\n"),
+		"var/lib/db/data.bin": database,
+	})
+	// Staged test data holds the same 20 bytes twice
+	if want := (PushStats{DataBytes: int64(len(database) + 6 + 40), NewBytes: int64(len(database) + 6 + 20)}); first != want {
+		t.Errorf("PushData() of first image stats = %+v, want %+v", first, want)
+	}
+	modified := append([]byte("new record\n"), database...)
+	second := pushDedupImage(t, r, "second", map[string][]byte{
+		"etc/hostname":        []byte("second\n"),
+		"srv/backup/dump.bin": modified,
+	})
+	if second.DataBytes != int64(len(modified)+7+40) || second.NewBytes > second.DataBytes/2 {
+		t.Errorf("PushData() of second image stats = %+v, want most of the data deduplicated", second)
+	}
+
+	c := r.getChunkStore()
+	refs, err := c.loadRefs()
+	if err != nil {
+		t.Fatalf("loadRefs() error = %v", err)
+	}
+	shared := 0
+	for _, count := range refs.Refs {
+		if count == 2 {
+			shared++
+		}
+	}
+	if shared == 0 {
+		t.Errorf("chunk references = %v, want chunks referenced by both images", refs.Refs)
+	}
+
+	firstSpace, err := r.GetImageSpace("first")
+	if err != nil {
+		t.Fatalf("GetImageSpace() error = %v", err)
+	}
+	if firstSpace.Shared == 0 || firstSpace.Unique == 0 || firstSpace.Unique+firstSpace.Shared < first.DataBytes {
+		t.Errorf("GetImageSpace() = %+v, want both unique and shared chunks", firstSpace)
+	}
+	// Chunks shared by two images only are unique to first image without second image
+	if paired, err := r.GetPairedSpace("second"); err != nil || len(paired) != 1 || paired["first"] != firstSpace.Shared {
+		t.Errorf("GetPairedSpace() = %v, %v, want %d bytes for first image", paired, err, firstSpace.Shared)
+	}
+
+	// References of repositories created before reference counting are counted from image indexes
+	if err := c.store.Delete(c.refsKey()); err != nil {
+		t.Fatal(err)
+	}
+	if counted, err := c.loadRefs(); err != nil || len(counted.Refs) != len(refs.Refs) {
+		t.Errorf("loadRefs() without references object = %d chunks, %v, want %d chunks", len(counted.Refs), err, len(refs.Refs))
+	}
+
+	// Chunks only used by first image are kept during garbage collection grace period
+	chunks, err := c.listChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteImage("first"); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	remaining, err := c.listChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != len(chunks) {
+		t.Errorf("chunks after DeleteImage() = %d, want %d", len(remaining), len(chunks))
+	}
+	if refs, err := c.loadRefs(); err != nil || len(refs.Released) == 0 {
+		t.Errorf("released chunks after DeleteImage() = %v, %v, want chunks of first image", refs.Released, err)
+	}
+	if removed, err := c.collectGarbage(0); err != nil || removed == 0 || removed >= len(chunks) {
+		t.Errorf("collectGarbage() = %d, %v, want chunks only used by first image removed", removed, err)
+	}
+
+	if space, err := r.GetImageSpace("second"); err != nil || space.Shared != 0 {
+		t.Errorf("GetImageSpace() after deleting first image = %+v, %v, want no shared chunk", space, err)
+	}
+
+	if err := r.FetchData("second", []string{"/"}, "restore"); err != nil {
+		t.Fatalf("FetchData() error = %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(r.GetDestination("restore"), "_data", "srv", "backup", "dump.bin")); err != nil || !bytes.Equal(content, modified) {
+		t.Errorf("FetchData() after deleting first image = %d bytes, %v, want original content", len(content), err)
+	}
+
+	if err := r.DeleteImage("second"); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	if _, err := c.collectGarbage(0); err != nil {
+		t.Fatalf("collectGarbage() error = %v", err)
+	}
+	if files := repoFiles(t, r); len(files) != 0 {
+		t.Errorf("files left after removing every image: %v", files)
+	}
+}
+
+// storedSize returns the size of the chunks and image objects stored in the repository folder
+func storedSize(t *testing.T, c *chunkStore) int64 {
+	t.Helper()
+
+	objects, err := c.store.List(c.root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, o := range objects {
+		if o.Key != c.refsKey() && !strings.HasPrefix(o.Key, c.keysPrefix()) {
+			size += o.Size
+		}
+	}
+
+	return size
+}
+
+func TestRepositoryDedup_SpaceAccounting(t *testing.T) {
+	r := setupDedupRepo(t)
+	c := r.getChunkStore()
+
+	database := make([]byte, 6*1024*1024)
+	rand.New(rand.NewSource(1)).Read(database)
+	for _, uuid := range []string{"first", "second", "third"} {
+		pushDedupImage(t, r, uuid, map[string][]byte{
+			"etc/hostname":        []byte(uuid + "\n"),
+			"var/lib/db/data.bin": database,
+		})
+	}
+	if used, err := c.usage(); err != nil || used != storedSize(t, c) {
+		t.Errorf("usage() = %d, %v, want %d", used, err, storedSize(t, c))
+	}
+	if paired, err := r.GetPairedSpace("second"); err != nil || len(paired) != 0 {
+		t.Errorf("GetPairedSpace() = %v, %v, want no chunk shared by two images only", paired, err)
+	}
+
+	// Owners of chunks shared by the two remaining images are looked up in image indexes
+	if err := r.DeleteImage("third"); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	firstSpace, err := r.GetImageSpace("first")
+	if err != nil || firstSpace.Shared == 0 {
+		t.Fatalf("GetImageSpace() = %+v, %v, want shared chunks", firstSpace, err)
+	}
+	if paired, err := r.GetPairedSpace("second"); err != nil || len(paired) != 1 || paired["first"] != firstSpace.Shared {
+		t.Errorf("GetPairedSpace() after deleting third image = %v, %v, want %d bytes for first image", paired, err, firstSpace.Shared)
+	}
+	if _, err := c.collectGarbage(0); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := c.usage(); err != nil || used != storedSize(t, c) {
+		t.Errorf("usage() after deleting third image = %d, %v, want %d", used, err, storedSize(t, c))
+	}
+
+	// References stored by older versions are counted again with sizes and owners
+	refs, err := c.loadRefs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs.Version = 1
+	refs.Sizes, refs.Owners, refs.Objects = nil, nil, nil
+	if err := c.saveRefs(refs); err != nil {
+		t.Fatal(err)
+	}
+	if used, err := c.usage(); err != nil || used != storedSize(t, c) {
+		t.Errorf("usage() from older references = %d, %v, want %d", used, err, storedSize(t, c))
+	}
+	if counted, err := c.loadRefs(); err != nil || len(counted.Owners) != len(counted.Refs) {
+		t.Errorf("loadRefs() from older references = %d owned chunks, %v, want %d", len(counted.Owners), err, len(counted.Refs))
+	}
+	if space, err := r.GetImageSpace("first"); err != nil || space != firstSpace {
+		t.Errorf("GetImageSpace() from older references = %+v, %v, want %+v", space, err, firstSpace)
+	}
+}
+
+func TestChunkRefs_Add(t *testing.T) {
+	refs := ChunkRefs{Version: CHUNK_REFS_VERSION}
+	refs.init()
+
+	steps := []struct {
+		owner      string
+		delta      int
+		wantRefs   int
+		wantOwners []string
+	}{
+		{owner: "first", delta: 1, wantRefs: 1, wantOwners: []string{"first"}},
+		{owner: "second", delta: 1, wantRefs: 2, wantOwners: []string{"first", "second"}},
+		{owner: "third", delta: 1, wantRefs: 3, wantOwners: nil},
+		// Remaining owners are unknown once a third image is removed
+		{owner: "third", delta: -1, wantRefs: 2, wantOwners: nil},
+		{owner: "second", delta: -1, wantRefs: 1, wantOwners: nil},
+		{owner: "first", delta: -1, wantRefs: 0, wantOwners: nil},
+		{owner: "first", delta: 1, wantRefs: 1, wantOwners: []string{"first"}},
+		{owner: "second", delta: 1, wantRefs: 2, wantOwners: []string{"first", "second"}},
+		{owner: "first", delta: -1, wantRefs: 1, wantOwners: []string{"second"}},
+	}
+	for i, step := range steps {
+		refs.add(step.owner, []string{"hash"}, step.delta)
+		if refs.Refs["hash"] != step.wantRefs || fmt.Sprint(refs.Owners["hash"]) != fmt.Sprint(step.wantOwners) {
+			t.Errorf("step %d: add(%s, %d) = %d references owned by %v, want %d owned by %v", i, step.owner, step.delta, refs.Refs["hash"], refs.Owners["hash"], step.wantRefs, step.wantOwners)
+		}
+		if _, released := refs.Released["hash"]; released != (step.wantRefs == 0) {
+			t.Errorf("step %d: chunk released = %v, want %v", i, released, step.wantRefs == 0)
+		}
+	}
+}
+
+func TestRepositoryDedup_ConcurrentStores(t *testing.T) {
+	r := setupDedupRepo(t)
+	pushDedupImage(t, r, "first", map[string][]byte{
+		"etc/hostname": []byte("first\n"),
+	})
+	refs, err := r.getChunkStore().loadRefs()
+	if err != nil || len(refs.Refs) == 0 {
+		t.Fatalf("loadRefs() = %v, %v, want image chunks", refs.Refs, err)
+	}
+	var hash string
+	for h := range refs.Refs {
+		hash = h
+	}
+	count := refs.Refs[hash]
+
+	// Chunk stores of different relique processes share no state but the repository lock files
+	first := &chunkStore{store: &fsStore{root: r.Path}, id: "fs:" + r.Path}
+	second := &chunkStore{store: &fsStore{root: r.Path}, id: "fs:" + r.Path}
+
+	// No reference update is lost when stores add references concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(c *chunkStore, i int) {
+			defer wg.Done()
+			err := c.updateRefs(func(refs *ChunkRefs) {
+				refs.add(fmt.Sprintf("image_%d", i), []string{hash}, 1)
+			})
+			if err != nil {
+				t.Errorf("updateRefs() error = %v", err)
+			}
+		}([]*chunkStore{first, second}[i%2], i)
+	}
+	wg.Wait()
+	if refs, err := first.loadRefs(); err != nil || refs.Refs[hash] != count+20 {
+		t.Errorf("chunk references = %v, %v, want %d", refs.Refs[hash], err, count+20)
+	}
+
+	// Image removal waits for uploads, which may deduplicate data against chunks being released
+	dataLock, err := lockRepository(first.id, chunkDataLock, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := make(chan error)
+	go func() {
+		removed <- second.removeAll("first")
+	}()
+	select {
+	case err := <-removed:
+		t.Fatalf("removeAll() returned during an upload, error = %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	dataLock.unlock()
+	if err := <-removed; err != nil {
+		t.Fatalf("removeAll() error = %v", err)
+	}
+	if refs, err := first.loadRefs(); err != nil || refs.Refs[hash] != count+19 {
+		t.Errorf("chunk references after removeAll() = %v, %v, want %d", refs.Refs[hash], err, count+19)
+	}
+}
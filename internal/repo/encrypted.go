@@ -0,0 +1,318 @@
+package repo
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kennygrant/sanitize"
+	"github.com/pelletier/go-toml"
+)
+
+// RepositoryEncrypted stores images in a local folder, encrypted with a repository key protected by a passphrase or a key file.
+// Images are stored like in S3 repositories: files are split into chunks, and chunks, indexes and image files are encrypted before being written.
+// Job data is transferred from clients into a local staging folder and encrypted into the repository when the job ends
+type RepositoryEncrypted struct {
+	Name string `json:"name" toml:"name"`
+	Type string `json:"type" toml:"type"`
+	Path string `json:"path" toml:"path"`
+	// Local folder holding job data in clear until it is encrypted into the repository
+	StagingPath string `json:"staging_path" toml:"staging_path"`
+	// Only one of passphrase and key file is set. Key file content is used as passphrase
+	EncryptionPassphrase string `json:"-" toml:"encryption_passphrase,omitempty"`
+	EncryptionKeyFile    string `json:"encryption_key_file" toml:"encryption_key_file,omitempty"`
+	Default              bool   `json:"default" toml:"default"`
+}
+
+func RepoEncryptedNew(name string, path string, passphrase string, keyFile string, isDefault bool) RepositoryEncrypted {
+	return RepositoryEncrypted{
+		Name:                 name,
+		Type:                 "encrypted",
+		Path:                 path,
+		EncryptionPassphrase: passphrase,
+		EncryptionKeyFile:    keyFile,
+		Default:              isDefault,
+	}
+}
+
+func (r *RepositoryEncrypted) GetName() string {
+	return r.Name
+}
+
+func (r *RepositoryEncrypted) GetType() string {
+	return r.Type
+}
+
+func (r *RepositoryEncrypted) GetLog() *slog.Logger {
+	return slog.With(
+		slog.String("name", r.GetName()),
+		slog.String("type", r.GetType()),
+		slog.String("path", r.Path),
+		slog.Bool("default", r.IsDefault()),
+	)
+}
+
+func (r *RepositoryEncrypted) Write(rootPath string) error {
+	var path string = filepath.Clean(fmt.Sprintf("%s/%s.toml",
+		rootPath,
+		strings.ToLower(sanitize.Accents(sanitize.BaseName(r.GetName()))),
+	))
+
+	repoToml, repoErr := toml.Marshal(r)
+	if repoErr != nil {
+		return fmt.Errorf("cannot serialize repository info to toml data: %w", repoErr)
+	}
+	// File may hold the encryption passphrase
+	if err := os.WriteFile(path, repoToml, 0600); err != nil {
+		return fmt.Errorf("cannot export repository info to file: %w", err)
+	}
+
+	r.GetLog().With(
+		slog.String("path", path),
+	).Debug("Saved repository to file")
+
+	return nil
+}
+
+func (r *RepositoryEncrypted) IsDefault() bool {
+	return r.Default
+}
+
+// GetStagingPath returns the local folder job data is staged in before being sent to the repository
+func (r *RepositoryEncrypted) GetStagingPath() string {
+	return getStagingPath(r.StagingPath, r.GetName())
+}
+
+// GetStoragePath returns the key prefix of image objects, relative to repository folder
+func (r *RepositoryEncrypted) GetStoragePath(uuid string) string {
+	return uuid
+}
+
+// GetDestination returns the image staging folder
+func (r *RepositoryEncrypted) GetDestination(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/%s", r.GetStagingPath(), uuid))
+}
+
+func (r *RepositoryEncrypted) PrepareImage(uuid string) error {
+	return prepareStagingFolders(r.GetDestination(uuid))
+}
+
+// GetLinkDest returns an empty string: data is deduplicated against every stored image when it is encrypted into the repository
+func (r *RepositoryEncrypted) GetLinkDest(uuid string) string {
+	return ""
+}
+
+// Exists checks if objects are stored under p
+func (r *RepositoryEncrypted) Exists(p string) (bool, error) {
+	return r.newChunkStore().exists(p)
+}
+
+func (r *RepositoryEncrypted) DeleteImage(uuid string) error {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return err
+	}
+	if err := c.removeAll(r.GetStoragePath(uuid)); err != nil {
+		return fmt.Errorf("cannot remove image from repository: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RepositoryEncrypted) GetSpace() (Space, error) {
+	used, err := r.newChunkStore().usage()
+	if err != nil {
+		return Space{}, fmt.Errorf("cannot compute space used in '%s': %w", r.Path, err)
+	}
+	free, total, err := filesystemSpace(r.Path)
+	if err != nil {
+		return Space{}, err
+	}
+
+	return Space{
+		Used:  used,
+		Free:  free,
+		Total: total,
+	}, nil
+}
+
+// GetImageSpace splits the space used by image objects and chunks between chunks only referenced by the image and chunks shared with other images
+func (r *RepositoryEncrypted) GetImageSpace(uuid string) (ImageSpace, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return ImageSpace{}, err
+	}
+
+	return c.imageSpace(r.GetStoragePath(uuid))
+}
+
+// GetPairedSpace returns the size of the chunks referenced by image uuid and a single other image, by image uuid
+func (r *RepositoryEncrypted) GetPairedSpace(uuid string) (map[string]int64, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.pairedSpace(r.GetStoragePath(uuid))
+}
+
+// HealthCheck checks that repository folder can be written to and that the repository key can be opened
+func (r *RepositoryEncrypted) HealthCheck() error {
+	if err := checkWritableFolder(r.Path); err != nil {
+		return fmt.Errorf("repository folder '%s' is not usable: %w", r.Path, err)
+	}
+	if _, err := r.getChunkStore(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// PushData encrypts staged image data into the repository. Only chunks not already stored in the repository are written
+func (r *RepositoryEncrypted) PushData(uuid string, referenceUuid string) (PushStats, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return PushStats{}, err
+	}
+
+	stats, err := c.uploadTree(fmt.Sprintf("%s/_data", r.GetDestination(uuid)), r.GetStoragePath(uuid))
+	if err != nil {
+		return PushStats{}, fmt.Errorf("cannot store data into repository: %w", err)
+	}
+	r.GetLog().With(
+		slog.String("uuid", uuid),
+		slog.Int("chunks", stats.Chunks),
+		slog.Int("new_chunks", stats.NewChunks),
+		slog.Int64("uploaded_bytes", stats.UploadedBytes),
+		slog.Int64("deduplicated_bytes", stats.DedupedBytes),
+	).Info("Backup data encrypted into repository")
+
+	return stats.pushStats(), nil
+}
+
+// PushFiles encrypts the content of the image staging folder into the repository. Staged data is expected to be removed beforehand
+func (r *RepositoryEncrypted) PushFiles(uuid string) error {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return err
+	}
+	if err := c.uploadFiles(r.GetDestination(uuid), r.GetStoragePath(uuid)); err != nil {
+		return fmt.Errorf("cannot store files into repository: %w", err)
+	}
+
+	return nil
+}
+
+// FetchData decrypts image paths from the repository into the restore job staging folder
+func (r *RepositoryEncrypted) FetchData(imageUuid string, sources []string, jobUuid string) error {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return err
+	}
+	if err := c.restoreTree(r.GetStoragePath(imageUuid), sources, fmt.Sprintf("%s/_data", r.GetDestination(jobUuid))); err != nil {
+		return fmt.Errorf("cannot fetch data from repository: %w", err)
+	}
+
+	return nil
+}
+
+// ListImageFolder lists a folder of image data from the image index
+func (r *RepositoryEncrypted) ListImageFolder(uuid string, imagePath string) ([]FileEntry, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.listFolder(r.GetStoragePath(uuid), imagePath)
+}
+
+// HashImageFiles hashes image files from their decrypted chunks
+func (r *RepositoryEncrypted) HashImageFiles(uuid string) (map[string]string, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.hashFiles(r.GetStoragePath(uuid))
+}
+
+func (r *RepositoryEncrypted) IsEncrypted() bool {
+	return true
+}
+
+func (r *RepositoryEncrypted) SetEncryption(passphrase string, keyFile string) {
+	r.EncryptionPassphrase = passphrase
+	r.EncryptionKeyFile = keyFile
+}
+
+// WithoutSecrets returns a copy of the repository without its encryption passphrase
+func (r *RepositoryEncrypted) WithoutSecrets() Repository {
+	c := *r
+	c.EncryptionPassphrase = ""
+
+	return &c
+}
+
+// CopySecrets sets the encryption passphrase and key file of the configured repository
+func (r *RepositoryEncrypted) CopySecrets(configured Repository) {
+	if c, ok := configured.(*RepositoryEncrypted); ok {
+		r.SetEncryption(c.EncryptionPassphrase, c.EncryptionKeyFile)
+	}
+}
+
+func (r *RepositoryEncrypted) InitEncryption() error {
+	secret, err := encryptionSecret(r.EncryptionPassphrase, r.EncryptionKeyFile)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("no encryption passphrase or key file configured")
+	}
+
+	return r.newChunkStore().initEncryption(secret)
+}
+
+func (r *RepositoryEncrypted) AddKey(passphrase string, keyFile string) (string, error) {
+	return addRepoKey(r.newChunkStore(), r.EncryptionPassphrase, r.EncryptionKeyFile, passphrase, keyFile)
+}
+
+func (r *RepositoryEncrypted) RemoveOtherKeys(keepID string) error {
+	secret, err := encryptionSecret(r.EncryptionPassphrase, r.EncryptionKeyFile)
+	if err != nil {
+		return err
+	}
+
+	return r.newChunkStore().removeOtherKeys(secret, keepID)
+}
+
+func (r *RepositoryEncrypted) RotateKey() (string, error) {
+	return rotateRepoKey(r.newChunkStore(), r.EncryptionPassphrase, r.EncryptionKeyFile)
+}
+
+// newChunkStore returns the repository chunk store without setting up encryption, for operations that do not read or write object content
+func (r *RepositoryEncrypted) newChunkStore() *chunkStore {
+	return &chunkStore{
+		store: &fsStore{root: r.Path},
+		id:    "fs:" + filepath.Clean(r.Path),
+	}
+}
+
+// getChunkStore returns the repository chunk store unlocked with the configured passphrase or key file. Repository data is never written in clear
+func (r *RepositoryEncrypted) getChunkStore() (*chunkStore, error) {
+	secret, err := encryptionSecret(r.EncryptionPassphrase, r.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil {
+		return nil, fmt.Errorf("no encryption passphrase or key file configured for repository '%s'", r.Name)
+	}
+
+	c := r.newChunkStore()
+	if err := c.unlock(secret); err != nil {
+		return nil, fmt.Errorf("cannot unlock repository: %w", err)
+	}
+
+	return c, nil
+}
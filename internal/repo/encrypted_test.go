@@ -0,0 +1,417 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/macarrie/relique/internal/s3"
+)
+
+func setupEncryptedRepo(t *testing.T) *RepositoryEncrypted {
+	t.Helper()
+
+	r := RepoEncryptedNew("encrypted_repo", filepath.Join(t.TempDir(), "repo"), "first passphrase", "", false)
+	r.StagingPath = t.TempDir()
+	if err := os.Mkdir(r.Path, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.InitEncryption(); err != nil {
+		t.Fatalf("InitEncryption() error = %v", err)
+	}
+
+	return &r
+}
+
+func TestRepositoryEncrypted_PushFetch(t *testing.T) {
+	r := setupEncryptedRepo(t)
+	stageImage(t, r, "first")
+	pushImage(t, r, "first")
+
+	err := filepath.WalkDir(r.Path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if relPath := strings.TrimPrefix(p, r.Path); strings.Contains(relPath, "etc") || strings.Contains(relPath, "app") {
+			t.Errorf("repository path '%s' discloses image file names", p)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(content, []byte("localhost")) {
+			t.Errorf("repository file '%s' holds image data in clear", p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := r.ListImageFolder("first", "/etc/app")
+	if err != nil {
+		t.Fatalf("ListImageFolder() error = %v", err)
+	}
+	paths := make([]string, 0)
+	for _, entry := range entries {
+		paths = append(paths, entry.Path)
+	}
+	if want := []string{"/etc/app/app.conf", "/etc/app/empty", "/etc/app/link"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("ListImageFolder() paths = %v, want %v", paths, want)
+	}
+
+	hashes, err := r.HashImageFiles("first")
+	if err != nil {
+		t.Fatalf("HashImageFiles() error = %v", err)
+	}
+	sum := sha256.Sum256([]byte("127.0.0.1 localhost\n"))
+	emptySum := sha256.Sum256(nil)
+	wantHashes := map[string]string{
+		"etc/hosts":        hex.EncodeToString(sum[:]),
+		"etc/app/app.conf": hex.EncodeToString(sum[:]),
+		"etc/app/empty":    hex.EncodeToString(emptySum[:]),
+	}
+	if !reflect.DeepEqual(hashes, wantHashes) {
+		t.Errorf("HashImageFiles() = %v, want %v", hashes, wantHashes)
+	}
+
+	if err := r.FetchData("first", []string{"/etc/app"}, "restore"); err != nil {
+		t.Fatalf("FetchData() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(r.GetDestination("restore"), "_data", "etc", "app", "app.conf"))
+	if err != nil || string(content) != "127.0.0.1 localhost\n" {
+		t.Errorf("FetchData() file content = %q, %v, want original content", content, err)
+	}
+
+	if err := r.DeleteImage("first"); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	if exists, err := r.Exists(r.GetStoragePath("first")); err != nil || exists {
+		t.Errorf("Exists() after DeleteImage() = %v, %v, want false", exists, err)
+	}
+}
+
+func TestRepositoryEncrypted_Unlock(t *testing.T) {
+	r := setupEncryptedRepo(t)
+	stageImage(t, r, "first")
+	pushImage(t, r, "first")
+
+	keyFile := filepath.Join(t.TempDir(), "repo.key")
+	if err := os.WriteFile(keyFile, []byte("first passphrase"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		keyFile    string
+		wantErr    bool
+	}{
+		{
+			name:       "passphrase",
+			passphrase: "first passphrase",
+			wantErr:    false,
+		},
+		{
+			name:    "key_file",
+			keyFile: keyFile,
+			wantErr: false,
+		},
+		{
+			name:       "wrong_passphrase",
+			passphrase: "other passphrase",
+			wantErr:    true,
+		},
+		{
+			name:    "missing_key_file",
+			keyFile: filepath.Join(t.TempDir(), "missing.key"),
+			wantErr: true,
+		},
+		{
+			name:    "no_passphrase",
+			wantErr: true,
+		},
+		{
+			name:       "passphrase_and_key_file",
+			passphrase: "first passphrase",
+			keyFile:    keyFile,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other := *r
+			other.SetEncryption(tt.passphrase, tt.keyFile)
+			if _, err := other.ListImageFolder("first", "/"); (err != nil) != tt.wantErr {
+				t.Errorf("ListImageFolder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRepositoryEncrypted_ChangePassphrase(t *testing.T) {
+	r := setupEncryptedRepo(t)
+	stageImage(t, r, "first")
+	pushImage(t, r, "first")
+
+	keyFile := filepath.Join(t.TempDir(), "repo.key")
+	if err := os.WriteFile(keyFile, []byte("random key content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.AddKey("", ""); err == nil {
+		t.Errorf("AddKey() without new passphrase or key file error = nil, want error")
+	}
+	keyID, err := r.AddKey("", keyFile)
+	if err != nil {
+		t.Fatalf("AddKey() error = %v", err)
+	}
+
+	// Previous passphrase is valid until other keys are removed
+	if _, err := r.ListImageFolder("first", "/"); err != nil {
+		t.Errorf("ListImageFolder() with previous passphrase error = %v", err)
+	}
+
+	previous := *r
+	r.SetEncryption("", keyFile)
+	if err := previous.RemoveOtherKeys(keyID); err == nil {
+		t.Errorf("RemoveOtherKeys() with previous passphrase error = nil, want error")
+	}
+	if err := r.RemoveOtherKeys(keyID); err != nil {
+		t.Fatalf("RemoveOtherKeys() error = %v", err)
+	}
+
+	if _, err := r.ListImageFolder("first", "/"); err != nil {
+		t.Errorf("ListImageFolder() with new key file error = %v", err)
+	}
+	if _, err := previous.ListImageFolder("first", "/"); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("ListImageFolder() with revoked passphrase error = %v, want %v", err, ErrWrongKey)
+	}
+
+	// Data stored before passphrase change deduplicates data stored after it
+	stageImage(t, r, "second")
+	pushImage(t, r, "second")
+	c, err := r.getChunkStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := c.listChunks()
+	if err != nil || len(chunks) != 1 {
+		t.Errorf("chunks after passphrase change = %v, %v, want a single chunk", chunks, err)
+	}
+}
+
+// failingPutStore fails to store objects whose key ends with suffix
+type failingPutStore struct {
+	objectStore
+	suffix string
+}
+
+func (s failingPutStore) Put(key string, data []byte) error {
+	if strings.HasSuffix(key, s.suffix) {
+		return errors.New("interrupted")
+	}
+
+	return s.objectStore.Put(key, data)
+}
+
+func TestRepositoryEncrypted_RotateKey(t *testing.T) {
+	r := setupEncryptedRepo(t)
+	for _, uuid := range []string{"first", "second"} {
+		stageImage(t, r, uuid)
+		pushImage(t, r, uuid)
+		if err := os.RemoveAll(filepath.Join(r.GetDestination(uuid), "_data")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(r.GetDestination(uuid), METADATA_FOLDER), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(r.GetDestination(uuid), METADATA_FOLDER, "job.toml"), []byte(uuid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.PushFiles(uuid); err != nil {
+			t.Fatalf("PushFiles() error = %v", err)
+		}
+	}
+	keyFile := filepath.Join(t.TempDir(), "repo.key")
+	if err := os.WriteFile(keyFile, []byte("random key content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.AddKey("", keyFile); err != nil {
+		t.Fatal(err)
+	}
+
+	previous, err := r.getChunkStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousChunks, err := previous.listChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes, err := r.HashImageFiles("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Interrupted rotation locks repository until it is resumed
+	interrupted := r.newChunkStore()
+	interrupted.store = failingPutStore{objectStore: interrupted.store, suffix: chunkIndexName}
+	if _, err := interrupted.rotateKey([]byte("first passphrase")); err == nil {
+		t.Fatalf("rotateKey() with failing store error = nil, want error")
+	}
+	if _, err := r.ListImageFolder("first", "/"); err == nil {
+		t.Errorf("ListImageFolder() during key rotation error = nil, want error")
+	}
+	if _, err := r.AddKey("", keyFile); err == nil {
+		t.Errorf("AddKey() during key rotation error = nil, want error")
+	}
+
+	keyID, err := r.RotateKey()
+	if err != nil {
+		t.Fatalf("RotateKey() error = %v", err)
+	}
+	c, err := r.getChunkStore()
+	if err != nil {
+		t.Fatalf("getChunkStore() after key rotation error = %v", err)
+	}
+	if keys, err := c.listKeys(); err != nil || len(keys) != 1 || keys[keyID].KDF == "" {
+		t.Errorf("keys after RotateKey() = %v, %v, want only key '%s'", keys, err, keyID)
+	}
+	if exists, err := r.Exists(keyRotationName); err != nil || exists {
+		t.Errorf("key rotation state after RotateKey() exists = %v, %v, want removed", exists, err)
+	}
+
+	// Chunks are renamed after keyed hashes of the new key
+	chunks, err := c.listChunks()
+	if err != nil || len(chunks) != len(previousChunks) {
+		t.Fatalf("chunks after RotateKey() = %d, %v, want %d", len(chunks), err, len(previousChunks))
+	}
+	for hash := range chunks {
+		if _, ok := previousChunks[hash]; ok {
+			t.Errorf("chunk '%s' kept its name after RotateKey()", hash)
+		}
+	}
+	if got, err := r.HashImageFiles("first"); err != nil || !reflect.DeepEqual(got, hashes) {
+		t.Errorf("HashImageFiles() after RotateKey() = %v, %v, want %v", got, err, hashes)
+	}
+	if content, err := c.get("second/" + METADATA_FOLDER + "/job.toml"); err != nil || string(content) != "second" {
+		t.Errorf("image file after RotateKey() = %q, %v, want %q", content, err, "second")
+	}
+	if used, err := c.usage(); err != nil || used != storedSize(t, c) {
+		t.Errorf("usage() after RotateKey() = %d, %v, want %d", used, err, storedSize(t, c))
+	}
+
+	// Previous key cannot read data anymore, and other key files are revoked
+	if _, err := previous.loadIndex("first"); err == nil {
+		t.Errorf("loadIndex() with previous key error = nil, want error")
+	}
+	other := *r
+	other.SetEncryption("", keyFile)
+	if _, err := other.ListImageFolder("first", "/"); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("ListImageFolder() with revoked key file error = %v, want %v", err, ErrWrongKey)
+	}
+
+	// Data stored after rotation is deduplicated against rotated chunks
+	stageImage(t, r, "third")
+	if stats := pushImage(t, r, "third"); stats.NewBytes != 0 {
+		t.Errorf("PushData() after RotateKey() stats = %+v, want data deduplicated", stats)
+	}
+}
+
+func TestRepositoryEncrypted_Definition(t *testing.T) {
+	r := setupEncryptedRepo(t)
+	stageImage(t, r, "first")
+	pushImage(t, r, "first")
+
+	// Definition files written by older versions held the passphrase and were readable by everyone
+	file := filepath.Join(t.TempDir(), "repo.toml")
+	if err := os.WriteFile(file, []byte("first passphrase"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteDefinition(r, file); err != nil {
+		t.Fatalf("WriteDefinition() error = %v", err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("WriteDefinition() file mode = %v, %v, want %v", info.Mode().Perm(), err, fs.FileMode(0600))
+	}
+	if content, err := os.ReadFile(file); err != nil || strings.Contains(string(content), "first passphrase") {
+		t.Errorf("WriteDefinition() content = %q, %v, want no passphrase", content, err)
+	}
+	if r.EncryptionPassphrase != "first passphrase" {
+		t.Errorf("WriteDefinition() changed repository passphrase to %q", r.EncryptionPassphrase)
+	}
+
+	loaded, err := LoadDefinition(file, []Repository{&RepositoryLocal{Name: "encrypted_repo", Type: "local"}, r})
+	if err != nil {
+		t.Fatalf("LoadDefinition() error = %v", err)
+	}
+	if _, err := loaded.(*RepositoryEncrypted).ListImageFolder("first", "/"); err != nil {
+		t.Errorf("ListImageFolder() with configured passphrase error = %v", err)
+	}
+
+	unknown, err := LoadDefinition(file, nil)
+	if err != nil {
+		t.Fatalf("LoadDefinition() error = %v", err)
+	}
+	if passphrase := unknown.(*RepositoryEncrypted).EncryptionPassphrase; passphrase != "" {
+		t.Errorf("LoadDefinition() of unconfigured repository passphrase = %q, want none", passphrase)
+	}
+}
+
+func TestFsStore(t *testing.T) {
+	s := &fsStore{root: t.TempDir()}
+
+	if err := s.Put("images/uuid/_data/index", []byte("index")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if err := s.Put("images/uuid2/file", []byte("file")); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+
+	if content, err := s.Get("images/uuid/_data/index"); err != nil || string(content) != "index" {
+		t.Errorf("Get() = %q, %v, want %q", content, err, "index")
+	}
+	if _, err := s.Get("images/missing"); !errors.Is(err, s3.ErrNotFound) {
+		t.Errorf("Get() on missing object error = %v, want %v", err, s3.ErrNotFound)
+	}
+
+	objects, err := s.List("images/uuid")
+	if err != nil {
+		t.Fatalf("List() error = %v", err)
+	}
+	keys := make([]string, 0)
+	for _, o := range objects {
+		keys = append(keys, o.Key)
+	}
+	if want := []string{"images/uuid/_data/index", "images/uuid2/file"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() keys = %v, want %v", keys, want)
+	}
+	if objects, err := s.List("missing/"); err != nil || len(objects) != 0 {
+		t.Errorf("List() on missing prefix = %v, %v, want no object", objects, err)
+	}
+
+	if err := s.Delete("images/uuid/_data/index"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := s.Delete("images/uuid/_data/index"); err != nil {
+		t.Errorf("Delete() on missing object error = %v", err)
+	}
+	// Folders left empty are removed with the object
+	if _, err := os.Stat(filepath.Join(s.root, "images", "uuid")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("image folder left after deleting its last object, error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(s.root, "images", "uuid2")); err != nil {
+		t.Errorf("Delete() removed another folder, error = %v", err)
+	}
+}
@@ -0,0 +1,721 @@
+package repo
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/macarrie/relique/internal/s3"
+)
+
+// ENCRYPTION_KEY_VERSION is the version of repository key objects
+const ENCRYPTION_KEY_VERSION = 1
+
+// ENCRYPTED_OBJECT_VERSION is the version of the encrypted object format: magic, version byte, AES-GCM nonce and sealed content
+const ENCRYPTED_OBJECT_VERSION = 1
+
+// Scrypt parameters used to derive the key protecting the repository master key from a passphrase or key file
+const (
+	KDF_SCRYPT_N = 1 << 15
+	KDF_SCRYPT_R = 8
+	KDF_SCRYPT_P = 1
+)
+
+// masterKeySize is the size of repository master keys: an AES-256 key encrypting objects followed by an HMAC-SHA256 key naming chunks
+const masterKeySize = 64
+
+var encryptedObjectMagic = []byte("RLQE")
+
+var ErrWrongKey = errors.New("no repository key can be opened with provided passphrase or key file")
+
+// keyRotationName is the object recording an unfinished key rotation, stored unencrypted at repository root
+const keyRotationName = "key-rotation.json"
+
+// repoKey is stored unencrypted in the keys folder of an encrypted repository. It holds the repository master key, sealed with a key derived from a passphrase or key file.
+// Several keys can protect the same master key, which allows changing the passphrase without encrypting data again
+type repoKey struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	KDF       string    `json:"kdf"`
+	N         int       `json:"n"`
+	R         int       `json:"r"`
+	P         int       `json:"p"`
+	Salt      []byte    `json:"salt"`
+	// Master key sealed with the derived key, prefixed by its nonce
+	Data []byte `json:"data"`
+}
+
+// keyRotation is written when a key rotation starts and removed once it is over. It holds the new master key, so that an interrupted rotation is resumed with the same key
+type keyRotation struct {
+	Version int `json:"version"`
+	// Ids of the keys protecting the previous master key, removed once every object is encrypted with the new master key
+	PreviousKeys []string `json:"previous_keys"`
+	Key          repoKey  `json:"key"`
+}
+
+// repoCipher encrypts and authenticates objects of an encrypted repository
+type repoCipher struct {
+	aead  cipher.AEAD
+	idKey []byte
+}
+
+// encryptionSecret returns the secret protecting the repository key, read from a passphrase or from a key file content. It is nil if the repository is not encrypted
+func encryptionSecret(passphrase string, keyFile string) ([]byte, error) {
+	switch {
+	case passphrase != "" && keyFile != "":
+		return nil, fmt.Errorf("encryption passphrase and key file cannot be both set")
+	case keyFile != "":
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read encryption key file: %w", err)
+		}
+		if len(content) == 0 {
+			return nil, fmt.Errorf("encryption key file '%s' is empty", keyFile)
+		}
+		return content, nil
+	case passphrase != "":
+		return []byte(passphrase), nil
+	}
+
+	return nil, nil
+}
+
+func randomBytes(size int) ([]byte, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("cannot generate random data: %w", err)
+	}
+
+	return b, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cannot setup cipher: %w", err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func newRepoCipher(masterKey []byte) (*repoCipher, error) {
+	if len(masterKey) != masterKeySize {
+		return nil, fmt.Errorf("invalid repository master key size %d", len(masterKey))
+	}
+
+	aead, err := newAEAD(masterKey[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	return &repoCipher{
+		aead:  aead,
+		idKey: masterKey[32:],
+	}, nil
+}
+
+// seal encrypts an object. The object name is authenticated with its content so that objects cannot be swapped
+func (rc *repoCipher) seal(name string, plaintext []byte) ([]byte, error) {
+	nonce, err := randomBytes(rc.aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(encryptedObjectMagic)+1+len(nonce)+len(plaintext)+rc.aead.Overhead())
+	out = append(out, encryptedObjectMagic...)
+	out = append(out, ENCRYPTED_OBJECT_VERSION)
+	out = append(out, nonce...)
+
+	return rc.aead.Seal(out, nonce, plaintext, []byte(name)), nil
+}
+
+// open decrypts an object sealed under the same name
+func (rc *repoCipher) open(name string, data []byte) ([]byte, error) {
+	header := len(encryptedObjectMagic) + 1
+	if len(data) < header+rc.aead.NonceSize() || !bytes.HasPrefix(data, encryptedObjectMagic) {
+		return nil, fmt.Errorf("object '%s' is not encrypted", name)
+	}
+	if version := data[len(encryptedObjectMagic)]; version != ENCRYPTED_OBJECT_VERSION {
+		return nil, fmt.Errorf("object '%s' uses unsupported encryption format version %d", name, version)
+	}
+
+	nonce := data[header : header+rc.aead.NonceSize()]
+	plaintext, err := rc.aead.Open(nil, nonce, data[header+len(nonce):], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt object '%s', repository key does not match or object is corrupted", name)
+	}
+
+	return plaintext, nil
+}
+
+// chunkID names chunks after a keyed hash of their content, so that chunk names do not disclose the hash of stored data
+func (rc *repoCipher) chunkID(data []byte) string {
+	mac := hmac.New(sha256.New, rc.idKey)
+	mac.Write(data)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (k repoKey) deriveKey(secret []byte) ([]byte, error) {
+	if k.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key derivation function '%s'", k.KDF)
+	}
+
+	key, err := scrypt.Key(secret, k.Salt, k.N, k.R, k.P, 32)
+	if err != nil {
+		return nil, fmt.Errorf("cannot derive key: %w", err)
+	}
+
+	return key, nil
+}
+
+func sealMasterKey(masterKey []byte, secret []byte) (repoKey, error) {
+	salt, err := randomBytes(16)
+	if err != nil {
+		return repoKey{}, err
+	}
+	k := repoKey{
+		Version:   ENCRYPTION_KEY_VERSION,
+		CreatedAt: time.Now().UTC(),
+		KDF:       "scrypt",
+		N:         KDF_SCRYPT_N,
+		R:         KDF_SCRYPT_R,
+		P:         KDF_SCRYPT_P,
+		Salt:      salt,
+	}
+
+	derived, err := k.deriveKey(secret)
+	if err != nil {
+		return repoKey{}, err
+	}
+	aead, err := newAEAD(derived)
+	if err != nil {
+		return repoKey{}, err
+	}
+	nonce, err := randomBytes(aead.NonceSize())
+	if err != nil {
+		return repoKey{}, err
+	}
+	k.Data = aead.Seal(nonce, nonce, masterKey, nil)
+
+	return k, nil
+}
+
+func (k repoKey) openMasterKey(secret []byte) ([]byte, error) {
+	derived, err := k.deriveKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(derived)
+	if err != nil {
+		return nil, err
+	}
+	if len(k.Data) < aead.NonceSize() {
+		return nil, fmt.Errorf("repository key is truncated")
+	}
+
+	masterKey, err := aead.Open(nil, k.Data[:aead.NonceSize()], k.Data[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+
+	return masterKey, nil
+}
+
+func (c *chunkStore) keysPrefix() string {
+	return c.root + "keys/"
+}
+
+// listKeys returns the keys stored in the repository, by id
+func (c *chunkStore) listKeys() (map[string]repoKey, error) {
+	objects, err := c.store.List(c.keysPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("cannot list repository keys: %w", err)
+	}
+
+	keys := make(map[string]repoKey, len(objects))
+	for _, o := range objects {
+		content, err := c.store.Get(o.Key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot get repository key: %w", err)
+		}
+
+		var k repoKey
+		if err := json.Unmarshal(content, &k); err != nil {
+			return nil, fmt.Errorf("cannot parse repository key '%s': %w", o.Key, err)
+		}
+		keys[path.Base(o.Key)] = k
+	}
+
+	return keys, nil
+}
+
+// openMasterKey tries every repository key with secret and returns the id of the first matching key with the master key
+func openMasterKey(keys map[string]repoKey, secret []byte) (string, []byte, error) {
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		masterKey, err := keys[id].openMasterKey(secret)
+		if errors.Is(err, ErrWrongKey) {
+			continue
+		} else if err != nil {
+			return "", nil, fmt.Errorf("cannot open repository key '%s': %w", id, err)
+		}
+
+		return id, masterKey, nil
+	}
+
+	return "", nil, ErrWrongKey
+}
+
+// unlock enables object encryption if repository keys are stored. Secret is required for encrypted repositories and must be empty for the others,
+// so that an encrypted repository is never written to in plain text
+func (c *chunkStore) unlock(secret []byte) error {
+	keys, err := c.listKeys()
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		if secret != nil {
+			return fmt.Errorf("repository encryption is configured but repository holds no key")
+		}
+		return nil
+	}
+	if secret == nil {
+		return fmt.Errorf("repository is encrypted, an encryption passphrase or key file is needed")
+	}
+	if err := c.checkKeyRotation(); err != nil {
+		return err
+	}
+
+	_, masterKey, err := openMasterKey(keys, secret)
+	if err != nil {
+		return err
+	}
+	rc, err := newRepoCipher(masterKey)
+	if err != nil {
+		return err
+	}
+	c.cipher = rc
+
+	return nil
+}
+
+// initEncryption generates the repository master key and stores it protected by secret
+func (c *chunkStore) initEncryption(secret []byte) error {
+	keys, err := c.listKeys()
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return fmt.Errorf("repository encryption is already initialized")
+	}
+
+	masterKey, err := randomBytes(masterKeySize)
+	if err != nil {
+		return err
+	}
+	_, err = c.storeKey(masterKey, secret)
+
+	return err
+}
+
+// addKey protects the repository master key with newSecret, in addition to existing keys. The id of the new key is returned
+func (c *chunkStore) addKey(secret []byte, newSecret []byte) (string, error) {
+	if err := c.checkKeyRotation(); err != nil {
+		return "", err
+	}
+	keys, err := c.listKeys()
+	if err != nil {
+		return "", err
+	}
+	_, masterKey, err := openMasterKey(keys, secret)
+	if err != nil {
+		return "", err
+	}
+
+	return c.storeKey(masterKey, newSecret)
+}
+
+func (c *chunkStore) storeKey(masterKey []byte, secret []byte) (string, error) {
+	k, err := sealMasterKey(masterKey, secret)
+	if err != nil {
+		return "", fmt.Errorf("cannot seal repository master key: %w", err)
+	}
+
+	return c.putKey(k)
+}
+
+func (c *chunkStore) putKey(k repoKey) (string, error) {
+	content, err := json.MarshalIndent(k, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("cannot serialize repository key: %w", err)
+	}
+
+	id, err := randomBytes(8)
+	if err != nil {
+		return "", err
+	}
+	if err := c.store.Put(c.keysPrefix()+hex.EncodeToString(id), content); err != nil {
+		return "", fmt.Errorf("cannot store repository key: %w", err)
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// removeOtherKeys deletes every repository key except keepID, after checking that keepID can be opened with secret
+func (c *chunkStore) removeOtherKeys(secret []byte, keepID string) error {
+	keys, err := c.listKeys()
+	if err != nil {
+		return err
+	}
+	k, ok := keys[keepID]
+	if !ok {
+		return fmt.Errorf("cannot find repository key '%s'", keepID)
+	}
+	if _, err := k.openMasterKey(secret); err != nil {
+		return fmt.Errorf("cannot open repository key '%s': %w", keepID, err)
+	}
+
+	for id := range keys {
+		if id == keepID {
+			continue
+		}
+		if err := c.store.Delete(c.keysPrefix() + id); err != nil {
+			return fmt.Errorf("cannot remove repository key '%s': %w", id, err)
+		}
+	}
+
+	return nil
+}
+
+// addRepoKey adds a repository key protected by a new passphrase or key file, after opening the master key with the current ones
+func addRepoKey(c *chunkStore, passphrase string, keyFile string, newPassphrase string, newKeyFile string) (string, error) {
+	secret, err := encryptionSecret(passphrase, keyFile)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("repository is not encrypted")
+	}
+
+	newSecret, err := encryptionSecret(newPassphrase, newKeyFile)
+	if err != nil {
+		return "", err
+	}
+	if newSecret == nil {
+		return "", fmt.Errorf("a new encryption passphrase or key file is needed")
+	}
+
+	return c.addKey(secret, newSecret)
+}
+
+func (c *chunkStore) keyRotationKey() string {
+	return c.root + keyRotationName
+}
+
+// loadKeyRotation reads the unfinished key rotation of the repository, if any
+func (c *chunkStore) loadKeyRotation() (*keyRotation, error) {
+	content, err := c.store.Get(c.keyRotationKey())
+	if errors.Is(err, s3.ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot get key rotation state: %w", err)
+	}
+
+	var rotation keyRotation
+	if err := json.Unmarshal(content, &rotation); err != nil {
+		return nil, fmt.Errorf("cannot parse key rotation state: %w", err)
+	}
+
+	return &rotation, nil
+}
+
+// checkKeyRotation returns an error if a key rotation was interrupted, since repository objects may be encrypted with either master key until it is resumed
+func (c *chunkStore) checkKeyRotation() error {
+	rotation, err := c.loadKeyRotation()
+	if err != nil {
+		return err
+	}
+	if rotation != nil {
+		return fmt.Errorf("repository key rotation was interrupted, rotate repository key again to complete it")
+	}
+
+	return nil
+}
+
+// rotateKey generates a new master key, encrypts every repository object again with it and retires the previous master key. The id of the new key is returned.
+// The new master key is only protected by secret: other passphrases and key files of the repository are revoked. Chunks are renamed after keyed hashes of the new master key, and image indexes and chunk references are updated accordingly.
+// Uploads and image removals wait for the rotation to end. An interrupted rotation is resumed with the same new master key by rotating the key again
+func (c *chunkStore) rotateKey(secret []byte) (string, error) {
+	dataLock, err := lockRepository(c.id, chunkDataLock, true)
+	if err != nil {
+		return "", err
+	}
+	defer dataLock.unlock()
+	refsLock, err := lockRepository(c.id, chunkRefsLock, true)
+	if err != nil {
+		return "", err
+	}
+	defer refsLock.unlock()
+
+	keys, err := c.listKeys()
+	if err != nil {
+		return "", err
+	}
+	rotation, err := c.loadKeyRotation()
+	if err != nil {
+		return "", err
+	}
+	if rotation == nil {
+		if len(keys) == 0 {
+			return "", fmt.Errorf("repository is not encrypted")
+		}
+		if _, _, err := openMasterKey(keys, secret); err != nil {
+			return "", err
+		}
+		if rotation, err = c.startKeyRotation(keys, secret); err != nil {
+			return "", err
+		}
+	}
+
+	newMasterKey, err := rotation.Key.openMasterKey(secret)
+	if err != nil {
+		return "", fmt.Errorf("cannot open new repository key: %w", err)
+	}
+	newCipher, err := newRepoCipher(newMasterKey)
+	if err != nil {
+		return "", err
+	}
+
+	previous := make(map[string]repoKey)
+	for _, id := range rotation.PreviousKeys {
+		if k, ok := keys[id]; ok {
+			previous[id] = k
+		}
+	}
+	// The new key is only stored once every object is encrypted with the new master key, an interrupted rotation may have stored it already
+	id := ""
+	for otherID, k := range keys {
+		if _, ok := previous[otherID]; !ok && bytes.Equal(k.Data, rotation.Key.Data) {
+			id = otherID
+		}
+	}
+
+	if id == "" {
+		_, oldMasterKey, err := openMasterKey(previous, secret)
+		if err != nil {
+			return "", err
+		}
+		oldCipher, err := newRepoCipher(oldMasterKey)
+		if err != nil {
+			return "", err
+		}
+		if err := c.reencrypt(oldCipher, newCipher); err != nil {
+			return "", fmt.Errorf("cannot encrypt repository objects with new key: %w", err)
+		}
+
+		if id, err = c.putKey(rotation.Key); err != nil {
+			return "", err
+		}
+	}
+	for prevID := range previous {
+		if err := c.store.Delete(c.keysPrefix() + prevID); err != nil {
+			return "", fmt.Errorf("cannot remove previous repository key '%s': %w", prevID, err)
+		}
+	}
+	if err := c.store.Delete(c.keyRotationKey()); err != nil {
+		return "", fmt.Errorf("cannot remove key rotation state: %w", err)
+	}
+
+	return id, nil
+}
+
+// startKeyRotation generates the new master key, protected by secret, and records the rotation before any object is encrypted with it
+func (c *chunkStore) startKeyRotation(keys map[string]repoKey, secret []byte) (*keyRotation, error) {
+	masterKey, err := randomBytes(masterKeySize)
+	if err != nil {
+		return nil, err
+	}
+	k, err := sealMasterKey(masterKey, secret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot seal repository master key: %w", err)
+	}
+
+	rotation := keyRotation{
+		Version:      ENCRYPTION_KEY_VERSION,
+		PreviousKeys: make([]string, 0, len(keys)),
+		Key:          k,
+	}
+	for id := range keys {
+		rotation.PreviousKeys = append(rotation.PreviousKeys, id)
+	}
+	sort.Strings(rotation.PreviousKeys)
+
+	content, err := json.MarshalIndent(rotation, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot serialize key rotation state: %w", err)
+	}
+	if err := c.store.Put(c.keyRotationKey(), content); err != nil {
+		return nil, fmt.Errorf("cannot store key rotation state: %w", err)
+	}
+
+	return &rotation, nil
+}
+
+// reencrypt encrypts every chunk and image object of the repository with updated instead of previous. Objects already encrypted with updated are left untouched, so that an interrupted rotation can be resumed.
+// Chunks are copied under their new name, and previous chunks are only removed once image indexes and chunk references use the new names
+func (c *chunkStore) reencrypt(previous *repoCipher, updated *repoCipher) error {
+	objects, err := c.store.List(c.root)
+	if err != nil {
+		return fmt.Errorf("cannot list repository objects: %w", err)
+	}
+
+	renamed := make(map[string]string)
+	imageObjects := make([]string, 0)
+	for _, o := range objects {
+		relKey := strings.TrimPrefix(o.Key, c.root)
+		switch {
+		case strings.HasPrefix(o.Key, c.chunksPrefix()):
+			hash := path.Base(o.Key)
+			plaintext, done, err := reencryptedContent(c.store, relKey, o.Key, previous, updated)
+			if err != nil {
+				return err
+			}
+			if done {
+				continue
+			}
+			if previous.chunkID(plaintext) != hash {
+				return fmt.Errorf("chunk '%s' is corrupted", hash)
+			}
+
+			newHash := updated.chunkID(plaintext)
+			newKey := c.chunkKey(newHash)
+			sealed, err := updated.seal(strings.TrimPrefix(newKey, c.root), plaintext)
+			if err != nil {
+				return err
+			}
+			if err := c.store.Put(newKey, sealed); err != nil {
+				return err
+			}
+			renamed[hash] = newHash
+		case strings.HasPrefix(o.Key, c.keysPrefix()) || !strings.Contains(relKey, "/"):
+			// Keys, rotation state and chunk references are not image objects
+			continue
+		default:
+			imageObjects = append(imageObjects, o.Key)
+		}
+	}
+
+	for _, key := range imageObjects {
+		relKey := strings.TrimPrefix(key, c.root)
+		plaintext, done, err := reencryptedContent(c.store, relKey, key, previous, updated)
+		if err != nil {
+			return err
+		}
+		if done {
+			continue
+		}
+
+		if strings.HasSuffix(key, "/_data/"+chunkIndexName) {
+			if plaintext, err = renameIndexChunks(plaintext, renamed); err != nil {
+				return fmt.Errorf("cannot update index '%s': %w", key, err)
+			}
+		}
+		sealed, err := updated.seal(relKey, plaintext)
+		if err != nil {
+			return err
+		}
+		if err := c.store.Put(key, sealed); err != nil {
+			return err
+		}
+	}
+
+	// Chunk references are counted again from the updated indexes. Chunks no longer referenced were copied recently, so they are still protected by garbage collection grace period
+	updatedStore := &chunkStore{store: c.store, root: c.root, cipher: updated, id: c.id}
+	refs, err := updatedStore.countRefs()
+	if err != nil {
+		return err
+	}
+	if err := updatedStore.saveRefs(refs); err != nil {
+		return err
+	}
+
+	for hash := range renamed {
+		if err := c.store.Delete(c.chunkKey(hash)); err != nil {
+			return fmt.Errorf("cannot remove previous chunk '%s': %w", hash, err)
+		}
+	}
+
+	return nil
+}
+
+// reencryptedContent decrypts an object encrypted with previous. done is set if the object is already encrypted with updated
+func reencryptedContent(store objectStore, name string, key string, previous *repoCipher, updated *repoCipher) ([]byte, bool, error) {
+	data, err := store.Get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := updated.open(name, data); err == nil {
+		return nil, true, nil
+	}
+
+	plaintext, err := previous.open(name, data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return plaintext, false, nil
+}
+
+// renameIndexChunks replaces chunk names in image index content
+func renameIndexChunks(content []byte, renamed map[string]string) ([]byte, error) {
+	index, err := decodeIndex(content)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range index.Entries {
+		for j, hash := range entry.Chunks {
+			newHash, ok := renamed[hash]
+			if !ok {
+				return nil, fmt.Errorf("cannot find chunk '%s' of file '%s'", hash, entry.Path)
+			}
+			index.Entries[i].Chunks[j] = newHash
+		}
+	}
+
+	return encodeIndex(index)
+}
+
+// rotateRepoKey rotates the master key of a repository, protecting the new master key with the configured passphrase or key file
+func rotateRepoKey(c *chunkStore, passphrase string, keyFile string) (string, error) {
+	secret, err := encryptionSecret(passphrase, keyFile)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil {
+		return "", fmt.Errorf("repository is not encrypted")
+	}
+
+	return c.rotateKey(secret)
+}
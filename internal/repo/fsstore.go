@@ -0,0 +1,124 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/macarrie/relique/internal/s3"
+)
+
+// fsStoreTempPrefix names objects being written, which are not listed
+const fsStoreTempPrefix = ".relique-tmp-"
+
+// fsStore is an objectStore keeping each object in a file of a local folder, named after its key
+type fsStore struct {
+	root string
+}
+
+func (s *fsStore) objectPath(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+// Put writes the object into a temporary file renamed once complete, so that partially written objects are never read
+func (s *fsStore) Put(key string, data []byte) error {
+	p := s.objectPath(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return fmt.Errorf("cannot create object folder: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), fsStoreTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("cannot create object file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("cannot write object '%s': %w", key, err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("cannot close object file: %w", err)
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("cannot move object '%s' into place: %w", key, err)
+	}
+
+	return nil
+}
+
+// Get reads an object. The returned error wraps s3.ErrNotFound if the object does not exist
+func (s *fsStore) Get(key string) ([]byte, error) {
+	content, err := os.ReadFile(s.objectPath(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: '%s'", s3.ErrNotFound, key)
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot read object '%s': %w", key, err)
+	}
+
+	return content, nil
+}
+
+// Delete removes an object and the folders left empty. Deleting a missing object is not an error
+func (s *fsStore) Delete(key string) error {
+	p := s.objectPath(key)
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("cannot remove object '%s': %w", key, err)
+	}
+
+	root := filepath.Clean(s.root)
+	for dir := filepath.Dir(p); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// List returns the objects whose key starts with prefix
+func (s *fsStore) List(prefix string) ([]s3.Object, error) {
+	objects := make([]s3.Object, 0)
+
+	// Only the deepest folder containing every matching key is walked
+	base := s.objectPath(prefix[:strings.LastIndex(prefix, "/")+1])
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == base {
+			return filepath.SkipAll
+		} else if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasPrefix(d.Name(), fsStoreTempPrefix) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(s.root, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relPath)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, s3.Object{
+			Key:          key,
+			Size:         info.Size(),
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list objects under '%s': %w", prefix, err)
+	}
+
+	return objects, nil
+}
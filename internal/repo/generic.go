@@ -25,3 +25,35 @@ func (g *GenericRepository) Write(path string) error {
 func (g *GenericRepository) IsDefault() bool {
 	return g.Default
 }
+
+func (g *GenericRepository) GetStoragePath(uuid string) string {
+	return ""
+}
+
+func (g *GenericRepository) GetDestination(uuid string) string {
+	return ""
+}
+
+func (g *GenericRepository) PrepareImage(uuid string) error {
+	return fmt.Errorf("repository type '%s' does not support storage operations", g.Type)
+}
+
+func (g *GenericRepository) GetLinkDest(uuid string) string {
+	return ""
+}
+
+func (g *GenericRepository) Exists(p string) (bool, error) {
+	return false, fmt.Errorf("repository type '%s' does not support storage operations", g.Type)
+}
+
+func (g *GenericRepository) DeleteImage(uuid string) error {
+	return fmt.Errorf("repository type '%s' does not support storage operations", g.Type)
+}
+
+func (g *GenericRepository) GetSpace() (Space, error) {
+	return Space{}, fmt.Errorf("repository type '%s' does not support storage operations", g.Type)
+}
+
+func (g *GenericRepository) HealthCheck() error {
+	return fmt.Errorf("repository type '%s' does not support storage operations", g.Type)
+}
@@ -16,6 +16,12 @@ type RepositoryLocal struct {
 	Type    string `json:"type" toml:"type"`
 	Path    string `json:"path" toml:"path"`
 	Default bool   `json:"default" toml:"default"`
+	// Maximum space the repository may use, such as "500GB". The repository is not limited if empty
+	Quota string `json:"quota" toml:"quota,omitempty"`
+	// Free space to keep on the filesystem holding the repository, such as "10GB"
+	MinFreeSpace string `json:"min_free_space" toml:"min_free_space,omitempty"`
+	// Backups whose estimated size exceeds available space are refused with SPACE_CHECK_REFUSE, a warning is logged otherwise
+	SpaceCheck string `json:"space_check" toml:"space_check,omitempty"`
 }
 
 func RepoLocalNew(name string, path string, isDefault bool) RepositoryLocal {
@@ -68,3 +74,112 @@ func (r *RepositoryLocal) Write(rootPath string) error {
 func (r *RepositoryLocal) IsDefault() bool {
 	return r.Default
 }
+
+func (r *RepositoryLocal) GetStoragePath(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/%s/", r.Path, uuid))
+}
+
+// GetDestination returns the image storage folder, rsync writes job data directly into repository storage
+func (r *RepositoryLocal) GetDestination(uuid string) string {
+	return r.GetStoragePath(uuid)
+}
+
+func (r *RepositoryLocal) PrepareImage(uuid string) error {
+	if err := prepareFolders(r.GetDestination(uuid)); err != nil {
+		return err
+	}
+	if err := r.WriteMetadata(); err != nil {
+		r.GetLog().With(slog.Any("error", err)).Warn("Cannot write repository metadata")
+	}
+
+	return nil
+}
+
+func (r *RepositoryLocal) GetLinkDest(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/_data/", r.GetStoragePath(uuid)))
+}
+
+func (r *RepositoryLocal) Exists(p string) (bool, error) {
+	return pathExists(p)
+}
+
+func (r *RepositoryLocal) DeleteImage(uuid string) error {
+	if err := os.RemoveAll(r.GetStoragePath(uuid)); err != nil {
+		return fmt.Errorf("cannot remove image folder: %w", err)
+	}
+
+	return nil
+}
+
+func (r *RepositoryLocal) GetSpace() (Space, error) {
+	used, err := diskUsage(r.Path)
+	if err != nil {
+		return Space{}, err
+	}
+	free, total, err := filesystemSpace(r.Path)
+	if err != nil {
+		return Space{}, err
+	}
+
+	return Space{
+		Used:  used,
+		Free:  free,
+		Total: total,
+	}, nil
+}
+
+// GetImageSpace splits image size between files only linked from the image and files hardlinked with other images
+func (r *RepositoryLocal) GetImageSpace(uuid string) (ImageSpace, error) {
+	return folderSpace(r.GetStoragePath(uuid))
+}
+
+// GetCapacity returns filesystem space, repository limits and the space left for new images. Repository usage is only scanned when a quota is set
+func (r *RepositoryLocal) GetCapacity() (Capacity, error) {
+	quota, err := parseSize(r.Quota)
+	if err != nil {
+		return Capacity{}, fmt.Errorf("cannot parse repository quota: %w", err)
+	}
+	minFreeSpace, err := parseSize(r.MinFreeSpace)
+	if err != nil {
+		return Capacity{}, fmt.Errorf("cannot parse repository minimum free space: %w", err)
+	}
+
+	free, total, err := filesystemSpace(r.Path)
+	if err != nil {
+		return Capacity{}, err
+	}
+	used := SPACE_UNKNOWN
+	if quota > 0 {
+		if used, err = diskUsage(r.Path); err != nil {
+			return Capacity{}, err
+		}
+	}
+
+	return newCapacity(free, total, used, quota, minFreeSpace), nil
+}
+
+func (r *RepositoryLocal) GetSpaceCheck() string {
+	if r.SpaceCheck == SPACE_CHECK_REFUSE {
+		return SPACE_CHECK_REFUSE
+	}
+
+	return SPACE_CHECK_WARN
+}
+
+// HealthCheck checks that repository folder can be written to and that repository limits are valid
+func (r *RepositoryLocal) HealthCheck() error {
+	if err := checkWritableFolder(r.Path); err != nil {
+		return fmt.Errorf("repository folder '%s' is not usable: %w", r.Path, err)
+	}
+	if _, err := parseSize(r.Quota); err != nil {
+		return fmt.Errorf("repository quota is invalid: %w", err)
+	}
+	if _, err := parseSize(r.MinFreeSpace); err != nil {
+		return fmt.Errorf("repository minimum free space is invalid: %w", err)
+	}
+	if r.SpaceCheck != "" && r.SpaceCheck != SPACE_CHECK_WARN && r.SpaceCheck != SPACE_CHECK_REFUSE {
+		return fmt.Errorf("unknown space check '%s', expected '%s' or '%s'", r.SpaceCheck, SPACE_CHECK_WARN, SPACE_CHECK_REFUSE)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// DATA_PATH is the local folder relique keeps its state in, such as repository lock files and staged job data. It is set from configuration
+var DATA_PATH string = "/var/lib/relique"
+
+// repoLock is a flock held on a repository lock file in DATA_PATH.
+// Locks are attached to open file descriptions, so that they exclude each other between relique processes of the host as well as inside a process
+type repoLock struct {
+	f *os.File
+}
+
+func getLockPath(id string, name string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(DATA_PATH, "locks", fmt.Sprintf("%s.%s.lock", hex.EncodeToString(sum[:8]), name))
+}
+
+// lockRepository waits for lock name of the repository identified by id. Shared locks exclude exclusive locks only
+func lockRepository(id string, name string, exclusive bool) (*repoLock, error) {
+	p := getLockPath(id, name)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, fmt.Errorf("cannot create repository locks folder: %w", err)
+	}
+
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open repository lock file: %w", err)
+	}
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+	if err := unix.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("cannot lock repository lock file: %w", err)
+	}
+
+	return &repoLock{f: f}, nil
+}
+
+// unlock releases the lock. Lock files are kept since other processes may be waiting on them
+func (l *repoLock) unlock() {
+	unix.Flock(int(l.f.Fd()), unix.LOCK_UN)
+	l.f.Close()
+}
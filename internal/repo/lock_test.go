@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	dataPath, err := os.MkdirTemp("", "relique-test-data-")
+	if err != nil {
+		panic(err)
+	}
+	DATA_PATH = dataPath
+
+	code := m.Run()
+	os.RemoveAll(dataPath)
+	os.Exit(code)
+}
+
+func TestLockRepository(t *testing.T) {
+	first, err := lockRepository("fs:/srv/relique", "data", false)
+	if err != nil {
+		t.Fatalf("lockRepository() error = %v", err)
+	}
+	second, err := lockRepository("fs:/srv/relique", "data", false)
+	if err != nil {
+		t.Fatalf("lockRepository() with shared lock held error = %v", err)
+	}
+
+	acquired := make(chan *repoLock)
+	go func() {
+		l, err := lockRepository("fs:/srv/relique", "data", true)
+		if err != nil {
+			t.Errorf("lockRepository() error = %v", err)
+		}
+		acquired <- l
+	}()
+
+	first.unlock()
+	select {
+	case <-acquired:
+		t.Fatalf("exclusive lock acquired while a shared lock is held")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	second.unlock()
+	select {
+	case l := <-acquired:
+		l.unlock()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("exclusive lock not acquired once shared locks are released")
+	}
+}
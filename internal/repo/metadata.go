@@ -0,0 +1,73 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pelletier/go-toml"
+)
+
+// METADATA_FILE is stored at the root of a repository to describe it, so that a repository can be attached to another relique server
+const METADATA_FILE = "relique-repository.toml"
+
+// METADATA_FOLDER is the folder inside each image storage folder holding a copy of the image catalog files
+const METADATA_FOLDER = "_meta"
+
+const METADATA_VERSION = 1
+
+type Metadata struct {
+	Name      string    `toml:"name"`
+	Type      string    `toml:"type"`
+	CreatedAt time.Time `toml:"created_at"`
+	Version   int       `toml:"version"`
+}
+
+func getMetadataPath(root string) string {
+	return filepath.Clean(fmt.Sprintf("%s/%s", root, METADATA_FILE))
+}
+
+// WriteMetadata writes repository metadata file at the root of the repository if it does not exist yet
+func (r *RepositoryLocal) WriteMetadata() error {
+	path := getMetadataPath(r.Path)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	m := Metadata{
+		Name:      r.Name,
+		Type:      r.Type,
+		CreatedAt: time.Now(),
+		Version:   METADATA_VERSION,
+	}
+	content, err := toml.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("cannot serialize repository metadata to toml data: %w", err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		return fmt.Errorf("cannot write repository metadata file: %w", err)
+	}
+
+	r.GetLog().Debug("Repository metadata written")
+	return nil
+}
+
+// LoadMetadata reads metadata of the repository stored in root folder. An empty metadata is returned if the repository has no metadata file
+func LoadMetadata(root string) (Metadata, error) {
+	content, err := os.ReadFile(getMetadataPath(root))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Metadata{}, nil
+	} else if err != nil {
+		return Metadata{}, fmt.Errorf("cannot read repository metadata file: %w", err)
+	}
+
+	var m Metadata
+	if err := toml.Unmarshal(content, &m); err != nil {
+		return Metadata{}, fmt.Errorf("cannot parse repository metadata file: %w", err)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,47 @@
+package repo
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRepositoryLocal_WriteMetadata(t *testing.T) {
+	root := t.TempDir()
+
+	empty, err := LoadMetadata(root)
+	if err != nil {
+		t.Fatalf("LoadMetadata() without metadata file error = %v", err)
+	}
+	if empty.Name != "" {
+		t.Errorf("LoadMetadata() without metadata file = %v, want empty metadata", empty)
+	}
+
+	r := RepoLocalNew("disk", root, false)
+	if err := r.WriteMetadata(); err != nil {
+		t.Fatalf("WriteMetadata() error = %v", err)
+	}
+
+	got, err := LoadMetadata(root)
+	if err != nil {
+		t.Fatalf("LoadMetadata() error = %v", err)
+	}
+	if got.Name != "disk" || got.Type != "local" || got.Version != METADATA_VERSION || got.CreatedAt.IsZero() {
+		t.Errorf("LoadMetadata() = %v, want metadata of repository 'disk'", got)
+	}
+
+	// Existing metadata must be kept when the repository is registered under another name
+	renamed := RepoLocalNew("renamed", root, false)
+	if err := renamed.WriteMetadata(); err != nil {
+		t.Fatalf("WriteMetadata() error = %v", err)
+	}
+	if got, _ := LoadMetadata(root); got.Name != "disk" {
+		t.Errorf("LoadMetadata() after second write name = %v, want %v", got.Name, "disk")
+	}
+
+	if err := os.WriteFile(getMetadataPath(root), []byte("name = "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadMetadata(root); err == nil {
+		t.Errorf("LoadMetadata() on invalid file error = nil, want error")
+	}
+}
@@ -1,20 +1,272 @@
 package repo
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
+	"math"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/pelletier/go-toml"
 )
 
+// Repository describes where images are stored and owns the storage operations performed on them, so that jobs and images do not depend on repository types
 type Repository interface {
 	GetName() string
 	GetType() string
 	Write(path string) error
 	IsDefault() bool
+
+	// GetStoragePath returns the location of an image in repository storage: a folder for local and remote repositories, an object key prefix for object storage
+	GetStoragePath(uuid string) string
+	// GetDestination returns the local folder rsync writes job data and logs to
+	GetDestination(uuid string) string
+	// PrepareImage creates the image data and logs folders in job destination
+	PrepareImage(uuid string) error
+	// GetLinkDest returns the data folder of an image usable as rsync link-dest reference from job destination. It is empty when images cannot be hardlinked from job destination
+	GetLinkDest(uuid string) string
+	// Exists checks if a path exists in repository storage
+	Exists(p string) (bool, error)
+	// DeleteImage removes image data, logs and metadata from repository storage
+	DeleteImage(uuid string) error
+	// GetSpace returns space used by the repository and space left on its storage
+	GetSpace() (Space, error)
+	// HealthCheck checks that repository storage can be reached and written to
+	HealthCheck() error
+}
+
+// StagedRepository is implemented by repositories whose storage cannot be written by rsync directly.
+// Job data is transferred into a local staging folder and sent to the repository when the job ends
+type StagedRepository interface {
+	Repository
+	GetStagingPath() string
+	// PushData sends image data from job destination to repository storage. Data is deduplicated against the reference image, or the whole repository, when the repository supports it
+	PushData(uuid string, referenceUuid string) (PushStats, error)
+	// PushFiles sends logs and metadata of an image from job destination to repository storage
+	PushFiles(uuid string) error
+	// FetchData copies the listed paths of an image from repository storage into the data folder of restore job destination
+	FetchData(imageUuid string, sources []string, jobUuid string) error
+	// ListImageFolder lists a folder inside image data without fetching it. Paths going through a symbolic link or not pointing to a folder return ErrInvalidPath
+	ListImageFolder(uuid string, imagePath string) ([]FileEntry, error)
+}
+
+// PushStats summarizes image data sent to a staged repository
+type PushStats struct {
+	// Size of image data
+	DataBytes int64
+	// Size of image data that was not already stored in the repository
+	NewBytes int64
+}
+
+// DataHasher is implemented by staged repositories able to hash image files where they are stored, so that images can be verified without fetching their data.
+// Hashes are indexed by file path relative to image data root, like in integrity manifests
+type DataHasher interface {
+	HashImageFiles(uuid string) (map[string]string, error)
+}
+
+// EncryptedRepository is implemented by repositories able to encrypt image data at rest. Data is encrypted with a repository key, itself protected by a passphrase or a key file
+type EncryptedRepository interface {
+	Repository
+	IsEncrypted() bool
+	// InitEncryption generates the repository key and protects it with the configured passphrase or key file
+	InitEncryption() error
+	// AddKey protects the repository key with a new passphrase or key file in addition to the configured one, and returns the id of the new key
+	AddKey(passphrase string, keyFile string) (string, error)
+	// SetEncryption replaces the configured passphrase and key file
+	SetEncryption(passphrase string, keyFile string)
+	// RemoveOtherKeys removes every repository key except keepID, which must be opened by the configured passphrase or key file
+	RemoveOtherKeys(keepID string) error
+	// RotateKey replaces the repository key with a new one protected by the configured passphrase or key file, encrypts stored data again with it and returns the id of the new key
+	RotateKey() (string, error)
+}
+
+var ErrInvalidPath = errors.New("invalid path")
+
+var ErrNotEnoughSpace = errors.New("not enough space available in repository")
+
+// FileEntry describes an element stored in an image, with its path inside the image
+type FileEntry struct {
+	Path       string
+	Mode       fs.FileMode
+	Size       int64
+	ModTime    time.Time
+	Uid        uint32
+	Gid        uint32
+	LinkTarget string
+}
+
+// SpaceAccounter is implemented by repositories able to tell apart the space used by an image only from the space it shares with other images, such as files hardlinked between diff images or deduplicated chunks
+type SpaceAccounter interface {
+	GetImageSpace(uuid string) (ImageSpace, error)
+}
+
+// PairedSpaceReporter is implemented by repositories sharing data between images of any client and module, such as deduplicated chunks
+type PairedSpaceReporter interface {
+	// GetPairedSpace returns the size of the data each other image shares with image uuid only, by image uuid.
+	// This data is unique to the other image without image uuid, so it turns shared when image uuid is added and unique again when image uuid is removed
+	GetPairedSpace(uuid string) (map[string]int64, error)
+}
+
+// ImageSpace splits the space used by an image in repository storage, in bytes.
+// Unique space is freed when the image is deleted, shared space is used by other images as well
+type ImageSpace struct {
+	Unique int64 `json:"unique"`
+	Shared int64 `json:"shared"`
+}
+
+// SPACE_UNKNOWN is used for space values that cannot be determined, such as object storage capacity
+const SPACE_UNKNOWN int64 = -1
+
+// Space describes repository storage usage, in bytes. Data shared by several images is only counted once in used space
+type Space struct {
+	Used  int64 `json:"used"`
+	Free  int64 `json:"free"`
+	Total int64 `json:"total"`
+}
+
+const (
+	// SPACE_CHECK_WARN logs a warning when a backup may not fit in repository available space
+	SPACE_CHECK_WARN = "warn"
+	// SPACE_CHECK_REFUSE refuses to start backups that may not fit in repository available space
+	SPACE_CHECK_REFUSE = "refuse"
+)
+
+// CapacityReporter is implemented by repositories able to tell how much space is left for new images
+type CapacityReporter interface {
+	GetCapacity() (Capacity, error)
+	// GetSpaceCheck returns how backups are handled when their estimated size exceeds available space, SPACE_CHECK_WARN or SPACE_CHECK_REFUSE
+	GetSpaceCheck() string
+}
+
+// Capacity describes the space left for new images in a repository, in bytes
+type Capacity struct {
+	// Size and free space of the filesystem holding the repository
+	Total int64 `json:"total"`
+	Free  int64 `json:"free"`
+	// Maximum space the repository may use, 0 if unlimited
+	Quota int64 `json:"quota"`
+	// Space used by the repository. It requires scanning the repository and is only computed when a quota is set, SPACE_UNKNOWN otherwise
+	Used int64 `json:"used"`
+	// Free space kept on the filesystem for other uses
+	MinFreeSpace int64 `json:"min_free_space"`
+	// Space new images can use without exceeding the quota or going below the free space threshold
+	Available int64 `json:"available"`
+}
+
+// newCapacity computes the space available to new images from filesystem space and repository limits
+func newCapacity(free int64, total int64, used int64, quota int64, minFreeSpace int64) Capacity {
+	available := max(free-minFreeSpace, 0)
+	if quota > 0 {
+		available = min(available, max(quota-used, 0))
+	}
+
+	return Capacity{
+		Total:        total,
+		Free:         free,
+		Quota:        quota,
+		Used:         used,
+		MinFreeSpace: minFreeSpace,
+		Available:    available,
+	}
+}
+
+// parseSize parses a size limit from repository configuration, such as "500GB" or "1.5TiB". Empty limits are 0
+func parseSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+
+	bytes, err := humanize.ParseBytes(size)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s': %w", size, err)
+	}
+	if bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("size '%s' is too large", size)
+	}
+
+	return int64(bytes), nil
+}
+
+// CheckSpace compares the estimated size of a new image with the space available in repository. Depending on repository space check, an ErrNotEnoughSpace error is returned or a warning is logged when the image may not fit.
+// Repositories that cannot report their capacity are not checked
+func CheckSpace(r Repository, size int64) error {
+	reporter, ok := r.(CapacityReporter)
+	if !ok {
+		return nil
+	}
+
+	capacity, err := reporter.GetCapacity()
+	if err != nil {
+		return fmt.Errorf("cannot get repository capacity: %w", err)
+	}
+	if size <= capacity.Available {
+		return nil
+	}
+
+	if reporter.GetSpaceCheck() == SPACE_CHECK_REFUSE {
+		return fmt.Errorf("%w: estimated image size is %s but only %s are available", ErrNotEnoughSpace, humanize.Bytes(uint64(size)), humanize.Bytes(uint64(capacity.Available)))
+	}
+	slog.With(
+		slog.String("repository", r.GetName()),
+		slog.Int64("estimated_size", size),
+		slog.Int64("available", capacity.Available),
+	).Warn("Estimated image size exceeds space available in repository, backup may fail")
+
+	return nil
+}
+
+// SecretHolder is implemented by repositories whose configuration holds credentials. Credentials are only kept in repository configuration files:
+// copies of repository definitions, such as the ones stored in job catalog folders, are written without them and get them back from the configured repository when loaded
+type SecretHolder interface {
+	// WithoutSecrets returns a copy of the repository without its credentials
+	WithoutSecrets() Repository
+	// CopySecrets sets repository credentials from the configured repository
+	CopySecrets(configured Repository)
+}
+
+// WriteDefinition writes a copy of the repository definition without its credentials into file, only readable by its owner
+func WriteDefinition(r Repository, file string) error {
+	if holder, ok := r.(SecretHolder); ok {
+		r = holder.WithoutSecrets()
+	}
+
+	content, err := toml.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("cannot serialize repository definition to toml data: %w", err)
+	}
+	if err := os.WriteFile(file, content, 0600); err != nil {
+		return fmt.Errorf("cannot export repository definition to file: %w", err)
+	}
+	// Permissions of files written by older versions are restricted as well
+	if err := os.Chmod(file, 0600); err != nil {
+		return fmt.Errorf("cannot restrict repository definition file permissions: %w", err)
+	}
+
+	return nil
+}
+
+// LoadDefinition reads a repository definition written by WriteDefinition. Credentials are taken from the configured repository with the same name and type, if any
+func LoadDefinition(file string, configured []Repository) (Repository, error) {
+	r, err := LoadFromFile(file)
+	if err != nil {
+		return r, err
+	}
+
+	if holder, ok := r.(SecretHolder); ok {
+		for _, c := range configured {
+			if c.GetName() == r.GetName() && c.GetType() == r.GetType() {
+				holder.CopySecrets(c)
+				break
+			}
+		}
+	}
+
+	return r, nil
 }
 
 func LoadFromFile(file string) (r Repository, err error) {
@@ -44,6 +296,36 @@ func LoadFromFile(file string) (r Repository, err error) {
 			return &RepositoryLocal{}, fmt.Errorf("cannot parse toml file: %w", err)
 		}
 		return &localRepo, nil
+	case "ssh":
+		var sshRepo RepositoryRemoteSSH
+		if err := toml.Unmarshal(content, &sshRepo); err != nil {
+			return &RepositoryRemoteSSH{}, fmt.Errorf("cannot parse toml file: %w", err)
+		}
+		return &sshRepo, nil
+	case "s3":
+		var s3Repo RepositoryS3
+		if err := toml.Unmarshal(content, &s3Repo); err != nil {
+			return &RepositoryS3{}, fmt.Errorf("cannot parse toml file: %w", err)
+		}
+		return &s3Repo, nil
+	case "archive":
+		var archiveRepo RepositoryArchive
+		if err := toml.Unmarshal(content, &archiveRepo); err != nil {
+			return &RepositoryArchive{}, fmt.Errorf("cannot parse toml file: %w", err)
+		}
+		return &archiveRepo, nil
+	case "dedup":
+		var dedupRepo RepositoryDedup
+		if err := toml.Unmarshal(content, &dedupRepo); err != nil {
+			return &RepositoryDedup{}, fmt.Errorf("cannot parse toml file: %w", err)
+		}
+		return &dedupRepo, nil
+	case "encrypted":
+		var encryptedRepo RepositoryEncrypted
+		if err := toml.Unmarshal(content, &encryptedRepo); err != nil {
+			return &RepositoryEncrypted{}, fmt.Errorf("cannot parse toml file: %w", err)
+		}
+		return &encryptedRepo, nil
 	default:
 		return &GenericRepository{}, fmt.Errorf("unknown repository type retrieved from file: '%s'", repoType)
 	}
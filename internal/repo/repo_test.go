@@ -26,6 +26,89 @@ func TestLoadFromFile(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "limited",
+			args: args{file: "../../test/repo/limited.toml"},
+			want: &RepositoryLocal{
+				Name:         "limited_repo",
+				Type:         "local",
+				Path:         "/srv/relique",
+				Default:      false,
+				Quota:        "500GB",
+				MinFreeSpace: "10GB",
+				SpaceCheck:   "refuse",
+			},
+			wantErr: false,
+		},
+		{
+			name: "ssh",
+			args: args{file: "../../test/repo/ssh.toml"},
+			want: &RepositoryRemoteSSH{
+				Name:        "remote_repo",
+				Type:        "ssh",
+				Host:        "backup.example.com",
+				User:        "relique",
+				Port:        2222,
+				Path:        "/srv/relique",
+				StagingPath: "/var/lib/relique/staging",
+				Default:     false,
+			},
+			wantErr: false,
+		},
+		{
+			name: "s3",
+			args: args{file: "../../test/repo/s3.toml"},
+			want: &RepositoryS3{
+				Name:        "bucket_repo",
+				Type:        "s3",
+				Endpoint:    "https://s3.example.com",
+				Region:      "eu-west-3",
+				Bucket:      "relique",
+				Prefix:      "backups",
+				AccessKey:   "access",
+				SecretKey:   "secret",
+				StagingPath: "/var/lib/relique/staging",
+				Default:     false,
+			},
+			wantErr: false,
+		},
+		{
+			name: "archive",
+			args: args{file: "../../test/repo/archive.toml"},
+			want: &RepositoryArchive{
+				Name:        "archive_repo",
+				Type:        "archive",
+				Path:        "/srv/relique-archives",
+				StagingPath: "/var/lib/relique/staging",
+				Default:     false,
+			},
+			wantErr: false,
+		},
+		{
+			name: "dedup",
+			args: args{file: "../../test/repo/dedup.toml"},
+			want: &RepositoryDedup{
+				Name:        "dedup_repo",
+				Type:        "dedup",
+				Path:        "/srv/relique-dedup",
+				StagingPath: "/var/lib/relique/staging",
+				Default:     false,
+			},
+			wantErr: false,
+		},
+		{
+			name: "encrypted",
+			args: args{file: "../../test/repo/encrypted.toml"},
+			want: &RepositoryEncrypted{
+				Name:              "encrypted_repo",
+				Type:              "encrypted",
+				Path:              "/srv/relique",
+				StagingPath:       "/var/lib/relique/staging",
+				EncryptionKeyFile: "/etc/relique/repo.key",
+				Default:           false,
+			},
+			wantErr: false,
+		},
 		{
 			name:    "default_values",
 			args:    args{file: "../../test/repo/empty.toml"},
@@ -72,11 +155,58 @@ func TestLoadFromPath(t *testing.T) {
 		{
 			name: "example",
 			args: args{path: "../../test/repo/"},
-			want: []Repository{&RepositoryLocal{
+			want: []Repository{&RepositoryArchive{
+				Name:        "archive_repo",
+				Type:        "archive",
+				Path:        "/srv/relique-archives",
+				StagingPath: "/var/lib/relique/staging",
+				Default:     false,
+			}, &RepositoryDedup{
+				Name:        "dedup_repo",
+				Type:        "dedup",
+				Path:        "/srv/relique-dedup",
+				StagingPath: "/var/lib/relique/staging",
+				Default:     false,
+			}, &RepositoryEncrypted{
+				Name:              "encrypted_repo",
+				Type:              "encrypted",
+				Path:              "/srv/relique",
+				StagingPath:       "/var/lib/relique/staging",
+				EncryptionKeyFile: "/etc/relique/repo.key",
+				Default:           false,
+			}, &RepositoryLocal{
 				Name:    "test_repo",
 				Type:    "local",
 				Path:    "/tmp/test_repo",
 				Default: false,
+			}, &RepositoryLocal{
+				Name:         "limited_repo",
+				Type:         "local",
+				Path:         "/srv/relique",
+				Default:      false,
+				Quota:        "500GB",
+				MinFreeSpace: "10GB",
+				SpaceCheck:   "refuse",
+			}, &RepositoryS3{
+				Name:        "bucket_repo",
+				Type:        "s3",
+				Endpoint:    "https://s3.example.com",
+				Region:      "eu-west-3",
+				Bucket:      "relique",
+				Prefix:      "backups",
+				AccessKey:   "access",
+				SecretKey:   "secret",
+				StagingPath: "/var/lib/relique/staging",
+				Default:     false,
+			}, &RepositoryRemoteSSH{
+				Name:        "remote_repo",
+				Type:        "ssh",
+				Host:        "backup.example.com",
+				User:        "relique",
+				Port:        2222,
+				Path:        "/srv/relique",
+				StagingPath: "/var/lib/relique/staging",
+				Default:     false,
 			},
 			},
 			wantErr: false,
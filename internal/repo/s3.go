@@ -0,0 +1,366 @@
+package repo
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/kennygrant/sanitize"
+	"github.com/pelletier/go-toml"
+
+	"github.com/macarrie/relique/internal/s3"
+)
+
+// RepositoryS3 stores images in an S3 compatible bucket. Files are split into chunks stored once per repository, and each image is described by an index listing its files and their chunks.
+// Like remote SSH repositories, job data is transferred from clients into a local staging folder before being sent to the bucket
+type RepositoryS3 struct {
+	Name     string `json:"name" toml:"name"`
+	Type     string `json:"type" toml:"type"`
+	Endpoint string `json:"endpoint" toml:"endpoint"`
+	Region   string `json:"region" toml:"region"`
+	Bucket   string `json:"bucket" toml:"bucket"`
+	// Key prefix under which repository objects are stored, the whole bucket is used if empty
+	Prefix    string `json:"prefix" toml:"prefix"`
+	AccessKey string `json:"access_key" toml:"access_key"`
+	SecretKey string `json:"-" toml:"secret_key"`
+	// Local folder holding job data until it is sent to the bucket
+	StagingPath string `json:"staging_path" toml:"staging_path"`
+	// Image data is encrypted before being sent to the bucket when a passphrase or a key file is set
+	EncryptionPassphrase string `json:"-" toml:"encryption_passphrase,omitempty"`
+	EncryptionKeyFile    string `json:"encryption_key_file" toml:"encryption_key_file,omitempty"`
+	Default              bool   `json:"default" toml:"default"`
+}
+
+func RepoS3New(name string, endpoint string, region string, bucket string, prefix string, accessKey string, secretKey string, isDefault bool) RepositoryS3 {
+	return RepositoryS3{
+		Name:      name,
+		Type:      "s3",
+		Endpoint:  endpoint,
+		Region:    region,
+		Bucket:    bucket,
+		Prefix:    prefix,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Default:   isDefault,
+	}
+}
+
+func (r *RepositoryS3) GetName() string {
+	return r.Name
+}
+
+func (r *RepositoryS3) GetType() string {
+	return r.Type
+}
+
+func (r *RepositoryS3) GetLog() *slog.Logger {
+	return slog.With(
+		slog.String("name", r.GetName()),
+		slog.String("type", r.GetType()),
+		slog.String("endpoint", r.Endpoint),
+		slog.String("bucket", r.Bucket),
+		slog.String("prefix", r.Prefix),
+		slog.Bool("default", r.IsDefault()),
+	)
+}
+
+func (r *RepositoryS3) Write(rootPath string) error {
+	var path string = filepath.Clean(fmt.Sprintf("%s/%s.toml",
+		rootPath,
+		strings.ToLower(sanitize.Accents(sanitize.BaseName(r.GetName()))),
+	))
+
+	repoToml, repoErr := toml.Marshal(r)
+	if repoErr != nil {
+		return fmt.Errorf("cannot serialize repository info to toml data: %w", repoErr)
+	}
+	// File holds repository credentials
+	if err := os.WriteFile(path, repoToml, 0600); err != nil {
+		return fmt.Errorf("cannot export repository info to file: %w", err)
+	}
+
+	r.GetLog().With(
+		slog.String("path", path),
+	).Debug("Saved repository to file")
+
+	return nil
+}
+
+func (r *RepositoryS3) IsDefault() bool {
+	return r.Default
+}
+
+// GetStagingPath returns the local folder job data is staged in before being sent to the repository
+func (r *RepositoryS3) GetStagingPath() string {
+	return getStagingPath(r.StagingPath, r.GetName())
+}
+
+// GetStoragePath returns the key prefix of image objects in the bucket
+func (r *RepositoryS3) GetStoragePath(uuid string) string {
+	return path.Join(strings.Trim(r.Prefix, "/"), uuid)
+}
+
+// GetDestination returns the image staging folder
+func (r *RepositoryS3) GetDestination(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/%s", r.GetStagingPath(), uuid))
+}
+
+func (r *RepositoryS3) PrepareImage(uuid string) error {
+	return prepareStagingFolders(r.GetDestination(uuid))
+}
+
+// GetLinkDest returns an empty string: data is deduplicated against every stored image when it is uploaded to the bucket
+func (r *RepositoryS3) GetLinkDest(uuid string) string {
+	return ""
+}
+
+func (r *RepositoryS3) DeleteImage(uuid string) error {
+	return r.RemoveAll(r.GetStoragePath(uuid))
+}
+
+// GetSpace returns the size of objects stored by the repository. Bucket capacity is unknown
+func (r *RepositoryS3) GetSpace() (Space, error) {
+	used, err := r.newChunkStore().usage()
+	if err != nil {
+		return Space{}, fmt.Errorf("cannot get repository space usage from bucket: %w", err)
+	}
+
+	return Space{
+		Used:  used,
+		Free:  SPACE_UNKNOWN,
+		Total: SPACE_UNKNOWN,
+	}, nil
+}
+
+// GetImageSpace splits the space used by image objects and chunks between chunks only referenced by the image and chunks shared with other images
+func (r *RepositoryS3) GetImageSpace(uuid string) (ImageSpace, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return ImageSpace{}, err
+	}
+
+	return c.imageSpace(r.GetStoragePath(uuid))
+}
+
+// GetPairedSpace returns the size of the chunks referenced by image uuid and a single other image, by image uuid
+func (r *RepositoryS3) GetPairedSpace(uuid string) (map[string]int64, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.pairedSpace(r.GetStoragePath(uuid))
+}
+
+func (r *RepositoryS3) HealthCheck() error {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return err
+	}
+	if err := c.healthCheck(); err != nil {
+		return fmt.Errorf("bucket '%s' cannot be reached or written to: %w", r.Bucket, err)
+	}
+
+	return nil
+}
+
+// PushData uploads staged image data to the bucket. Only chunks not already stored in the repository are sent
+func (r *RepositoryS3) PushData(uuid string, referenceUuid string) (PushStats, error) {
+	stats, err := r.Upload(fmt.Sprintf("%s/_data", r.GetDestination(uuid)), r.GetStoragePath(uuid))
+	if err != nil {
+		return PushStats{}, fmt.Errorf("cannot upload data to bucket: %w", err)
+	}
+	r.GetLog().With(
+		slog.String("uuid", uuid),
+		slog.Int("chunks", stats.Chunks),
+		slog.Int("new_chunks", stats.NewChunks),
+		slog.Int64("uploaded_bytes", stats.UploadedBytes),
+		slog.Int64("deduplicated_bytes", stats.DedupedBytes),
+	).Info("Backup data uploaded to bucket")
+
+	return stats.pushStats(), nil
+}
+
+// PushFiles uploads the content of the image staging folder to the bucket. Staged data is expected to be removed beforehand
+func (r *RepositoryS3) PushFiles(uuid string) error {
+	if err := r.UploadFiles(r.GetDestination(uuid), r.GetStoragePath(uuid)); err != nil {
+		return fmt.Errorf("cannot upload files to bucket: %w", err)
+	}
+
+	return nil
+}
+
+// FetchData rebuilds image paths from the bucket into the restore job staging folder
+func (r *RepositoryS3) FetchData(imageUuid string, sources []string, jobUuid string) error {
+	if err := r.Restore(r.GetStoragePath(imageUuid), sources, fmt.Sprintf("%s/_data", r.GetDestination(jobUuid))); err != nil {
+		return fmt.Errorf("cannot fetch data from bucket: %w", err)
+	}
+
+	return nil
+}
+
+// newChunkStore returns the bucket chunk store without setting up encryption, for operations that do not read or write object content
+func (r *RepositoryS3) newChunkStore() *chunkStore {
+	root := strings.Trim(r.Prefix, "/")
+	if root != "" {
+		root += "/"
+	}
+
+	return &chunkStore{
+		store: s3.New(r.Endpoint, r.Region, r.Bucket, r.AccessKey, r.SecretKey),
+		root:  root,
+		id:    fmt.Sprintf("s3:%s/%s/%s", r.Endpoint, r.Bucket, root),
+	}
+}
+
+// getChunkStore returns the bucket chunk store, unlocked with the configured passphrase or key file if the repository is encrypted
+func (r *RepositoryS3) getChunkStore() (*chunkStore, error) {
+	secret, err := encryptionSecret(r.EncryptionPassphrase, r.EncryptionKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	c := r.newChunkStore()
+	if err := c.unlock(secret); err != nil {
+		return nil, fmt.Errorf("cannot unlock repository: %w", err)
+	}
+
+	return c, nil
+}
+
+// Upload stores local image data into the bucket and writes the image index
+func (r *RepositoryS3) Upload(dataPath string, storagePath string) (UploadStats, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return UploadStats{}, err
+	}
+
+	return c.uploadTree(dataPath, storagePath)
+}
+
+// UploadFiles copies the files of a local folder into the bucket under p, without chunking. It is used for job logs and metadata
+func (r *RepositoryS3) UploadFiles(localPath string, p string) error {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return err
+	}
+
+	return c.uploadFiles(localPath, p)
+}
+
+// LoadIndex reads the index of the image stored under storagePath
+func (r *RepositoryS3) LoadIndex(storagePath string) (ChunkIndex, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return ChunkIndex{}, err
+	}
+
+	return c.loadIndex(storagePath)
+}
+
+// Restore rebuilds the listed image paths from the bucket into a local folder
+func (r *RepositoryS3) Restore(storagePath string, sources []string, destination string) error {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return err
+	}
+
+	return c.restoreTree(storagePath, sources, destination)
+}
+
+// Exists checks if objects are stored under p
+func (r *RepositoryS3) Exists(p string) (bool, error) {
+	return r.newChunkStore().exists(p)
+}
+
+// RemoveAll deletes objects stored under p and the chunks that are not used by other images anymore
+func (r *RepositoryS3) RemoveAll(p string) error {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return err
+	}
+	if err := c.removeAll(p); err != nil {
+		return fmt.Errorf("cannot remove '%s' from bucket: %w", p, err)
+	}
+
+	return nil
+}
+
+// ListImageFolder lists a folder of image data from the image index
+func (r *RepositoryS3) ListImageFolder(uuid string, imagePath string) ([]FileEntry, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.listFolder(r.GetStoragePath(uuid), imagePath)
+}
+
+// HashImageFiles hashes image files from the chunks stored in the bucket
+func (r *RepositoryS3) HashImageFiles(uuid string) (map[string]string, error) {
+	c, err := r.getChunkStore()
+	if err != nil {
+		return nil, err
+	}
+
+	return c.hashFiles(r.GetStoragePath(uuid))
+}
+
+func (r *RepositoryS3) IsEncrypted() bool {
+	return r.EncryptionPassphrase != "" || r.EncryptionKeyFile != ""
+}
+
+func (r *RepositoryS3) SetEncryption(passphrase string, keyFile string) {
+	r.EncryptionPassphrase = passphrase
+	r.EncryptionKeyFile = keyFile
+}
+
+// WithoutSecrets returns a copy of the repository without its secret key and encryption passphrase
+func (r *RepositoryS3) WithoutSecrets() Repository {
+	c := *r
+	c.SecretKey = ""
+	c.EncryptionPassphrase = ""
+
+	return &c
+}
+
+// CopySecrets sets the credentials, encryption passphrase and key file of the configured repository
+func (r *RepositoryS3) CopySecrets(configured Repository) {
+	if c, ok := configured.(*RepositoryS3); ok {
+		r.AccessKey = c.AccessKey
+		r.SecretKey = c.SecretKey
+		r.SetEncryption(c.EncryptionPassphrase, c.EncryptionKeyFile)
+	}
+}
+
+func (r *RepositoryS3) InitEncryption() error {
+	secret, err := encryptionSecret(r.EncryptionPassphrase, r.EncryptionKeyFile)
+	if err != nil {
+		return err
+	}
+	if secret == nil {
+		return fmt.Errorf("no encryption passphrase or key file configured")
+	}
+
+	return r.newChunkStore().initEncryption(secret)
+}
+
+func (r *RepositoryS3) AddKey(passphrase string, keyFile string) (string, error) {
+	return addRepoKey(r.newChunkStore(), r.EncryptionPassphrase, r.EncryptionKeyFile, passphrase, keyFile)
+}
+
+func (r *RepositoryS3) RemoveOtherKeys(keepID string) error {
+	secret, err := encryptionSecret(r.EncryptionPassphrase, r.EncryptionKeyFile)
+	if err != nil {
+		return err
+	}
+
+	return r.newChunkStore().removeOtherKeys(secret, keepID)
+}
+
+func (r *RepositoryS3) RotateKey() (string, error) {
+	return rotateRepoKey(r.newChunkStore(), r.EncryptionPassphrase, r.EncryptionKeyFile)
+}
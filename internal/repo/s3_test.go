@@ -0,0 +1,254 @@
+package repo
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/macarrie/relique/internal/s3/s3test"
+)
+
+func setupS3Repo(t *testing.T) (*RepositoryS3, *s3test.Server) {
+	t.Helper()
+
+	server := s3test.New("relique")
+	t.Cleanup(server.Close)
+
+	r := RepoS3New("bucket_repo", server.URL, "", "relique", "/backups/", "access", "secret", false)
+	return &r, server
+}
+
+func setupS3Data(t *testing.T) string {
+	t.Helper()
+
+	dataPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dataPath, "etc", "app"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"etc/hosts":        "127.0.0.1 localhost\n",
+		"etc/app/app.conf": "127.0.0.1 localhost\n",
+		"etc/app/empty":    "",
+	}
+	for p, content := range files {
+		if err := os.WriteFile(filepath.Join(dataPath, p), []byte(content), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("app.conf", filepath.Join(dataPath, "etc", "app", "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	return dataPath
+}
+
+func TestRepositoryS3_GetStoragePath(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		want   string
+	}{
+		{
+			name:   "no_prefix",
+			prefix: "",
+			want:   "uuid",
+		},
+		{
+			name:   "prefix",
+			prefix: "/backups/relique/",
+			want:   "backups/relique/uuid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := RepoS3New("bucket_repo", "http://localhost", "", "relique", tt.prefix, "", "", false)
+			if got := r.GetStoragePath("uuid"); got != tt.want {
+				t.Errorf("GetStoragePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepositoryS3_UploadRestore(t *testing.T) {
+	r, _ := setupS3Repo(t)
+	dataPath := setupS3Data(t)
+
+	stats, err := r.Upload(dataPath, r.GetStoragePath("first"))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	// Both files with the same content share a single chunk, empty files have no chunk
+	if want := (UploadStats{Chunks: 2, NewChunks: 1, UploadedBytes: 20, DedupedBytes: 20, IndexedEntries: 6}); stats != want {
+		t.Errorf("Upload() stats = %+v, want %+v", stats, want)
+	}
+
+	stats, err = r.Upload(dataPath, r.GetStoragePath("second"))
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if stats.NewChunks != 0 || stats.UploadedBytes != 0 {
+		t.Errorf("Upload() of unchanged data stats = %+v, want no uploaded chunk", stats)
+	}
+
+	index, err := r.LoadIndex(r.GetStoragePath("first"))
+	if err != nil {
+		t.Fatalf("LoadIndex() error = %v", err)
+	}
+	paths := make([]string, 0)
+	for _, entry := range index.Entries {
+		paths = append(paths, entry.Path)
+	}
+	if want := []string{"/etc", "/etc/app", "/etc/app/app.conf", "/etc/app/empty", "/etc/app/link", "/etc/hosts"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("LoadIndex() paths = %v, want %v", paths, want)
+	}
+	if _, err := r.LoadIndex(r.GetStoragePath("missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadIndex() on missing image error = %v, want %v", err, fs.ErrNotExist)
+	}
+
+	dest := t.TempDir()
+	if err := r.Restore(r.GetStoragePath("first"), []string{"/etc/app"}, dest); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dest, "etc", "app", "app.conf"))
+	if err != nil || string(content) != "127.0.0.1 localhost\n" {
+		t.Errorf("Restore() file content = %q, %v, want original content", content, err)
+	}
+	if info, err := os.Stat(filepath.Join(dest, "etc", "app", "app.conf")); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("Restore() file mode = %v, %v, want %v", info.Mode().Perm(), err, fs.FileMode(0640))
+	}
+	if target, err := os.Readlink(filepath.Join(dest, "etc", "app", "link")); err != nil || target != "app.conf" {
+		t.Errorf("Restore() link target = %v, %v, want app.conf", target, err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "etc", "hosts")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Restore() restored a path outside of sources, error = %v", err)
+	}
+}
+
+func TestRepositoryS3_RemoveAll(t *testing.T) {
+	r, server := setupS3Repo(t)
+	dataPath := setupS3Data(t)
+
+	for _, uuid := range []string{"first", "second"} {
+		if _, err := r.Upload(dataPath, r.GetStoragePath(uuid)); err != nil {
+			t.Fatalf("Upload() error = %v", err)
+		}
+		if err := r.UploadFiles(dataPath, r.GetStoragePath(uuid)+"/_meta"); err != nil {
+			t.Fatalf("UploadFiles() error = %v", err)
+		}
+	}
+	// Chunks are older than garbage collection grace period
+	chunks := server.Keys("relique", "backups/chunks/")
+	if len(chunks) != 1 {
+		t.Fatalf("stored chunks = %v, want a single chunk", chunks)
+	}
+	server.SetLastModified("relique", chunks[0], time.Now().Add(-2*CHUNK_GC_GRACE))
+
+	if err := r.RemoveAll(r.GetStoragePath("first")); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if exists, err := r.Exists(r.GetStoragePath("first")); err != nil || exists {
+		t.Errorf("Exists() after RemoveAll() = %v, %v, want false", exists, err)
+	}
+	if exists, err := r.Exists(r.GetStoragePath("second")); err != nil || !exists {
+		t.Errorf("Exists() on other image = %v, %v, want true", exists, err)
+	}
+	// Chunk is still used by second image
+	if keys := server.Keys("relique", "backups/chunks/"); !reflect.DeepEqual(keys, chunks) {
+		t.Errorf("chunks after first RemoveAll() = %v, want %v", keys, chunks)
+	}
+
+	if err := r.RemoveAll(r.GetStoragePath("second")); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	// Released chunk is kept during garbage collection grace period
+	if keys := server.Keys("relique", "backups/chunks/"); !reflect.DeepEqual(keys, chunks) {
+		t.Errorf("chunks after last RemoveAll() = %v, want %v", keys, chunks)
+	}
+
+	if _, err := r.newChunkStore().collectGarbage(0); err != nil {
+		t.Fatalf("collectGarbage() error = %v", err)
+	}
+	for _, k := range server.Keys("relique", "") {
+		if strings.HasPrefix(k, "backups/") {
+			t.Errorf("object '%s' left after removing every image", k)
+		}
+	}
+}
+
+func TestRepositoryS3_Encrypted(t *testing.T) {
+	r, server := setupS3Repo(t)
+	dataPath := setupS3Data(t)
+
+	r.SetEncryption("passphrase", "")
+	if err := r.InitEncryption(); err != nil {
+		t.Fatalf("InitEncryption() error = %v", err)
+	}
+	if err := r.InitEncryption(); err == nil {
+		t.Errorf("InitEncryption() on encrypted repository error = nil, want error")
+	}
+
+	if _, err := r.Upload(dataPath, r.GetStoragePath("first")); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	store := r.newChunkStore().store
+	for _, k := range server.Keys("relique", "backups/") {
+		if strings.HasPrefix(k, "backups/keys/") {
+			continue
+		}
+		content, err := store.Get(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(content), string(encryptedObjectMagic)) || strings.Contains(string(content), "localhost") {
+			t.Errorf("object '%s' is not encrypted", k)
+		}
+	}
+
+	dest := t.TempDir()
+	if err := r.Restore(r.GetStoragePath("first"), []string{"/"}, dest); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dest, "etc", "hosts"))
+	if err != nil || string(content) != "127.0.0.1 localhost\n" {
+		t.Errorf("Restore() file content = %q, %v, want original content", content, err)
+	}
+
+	// Encrypted repositories are never written to in clear
+	r.SetEncryption("", "")
+	if _, err := r.Upload(dataPath, r.GetStoragePath("second")); err == nil {
+		t.Errorf("Upload() without passphrase error = nil, want error")
+	}
+}
+
+func TestRepositoryS3_Definition(t *testing.T) {
+	r, _ := setupS3Repo(t)
+	r.SecretKey = "bucket secret key"
+	r.SetEncryption("bucket passphrase", "")
+
+	file := filepath.Join(t.TempDir(), "repo.toml")
+	if err := WriteDefinition(r, file); err != nil {
+		t.Fatalf("WriteDefinition() error = %v", err)
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{r.SecretKey, r.EncryptionPassphrase} {
+		if strings.Contains(string(content), secret) {
+			t.Errorf("WriteDefinition() content = %q, want no '%s'", content, secret)
+		}
+	}
+
+	loaded, err := LoadDefinition(file, []Repository{r})
+	if err != nil {
+		t.Fatalf("LoadDefinition() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded, r) {
+		t.Errorf("LoadDefinition() = %+v, want %+v", loaded, r)
+	}
+}
@@ -0,0 +1,320 @@
+package repo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kennygrant/sanitize"
+	"github.com/pelletier/go-toml"
+
+	"github.com/macarrie/relique/internal/rsync_task"
+)
+
+// SSH_COMMAND is the ssh client binary used to reach remote repositories
+var SSH_COMMAND = "ssh"
+
+const SSH_DEFAULT_PORT = 22
+
+// RepositoryRemoteSSH stores images on another host reached over SSH. Backups are transferred from clients into a local staging folder
+// and then pushed to the repository host, where unchanged files of diff backups are hardlinked to the reference image
+type RepositoryRemoteSSH struct {
+	Name string `json:"name" toml:"name"`
+	Type string `json:"type" toml:"type"`
+	Host string `json:"host" toml:"host"`
+	User string `json:"user" toml:"user"`
+	Port int    `json:"port" toml:"port"`
+	// Path of the repository on the remote host
+	Path string `json:"path" toml:"path"`
+	// Local folder holding job data until it is pushed to the remote host
+	StagingPath string `json:"staging_path" toml:"staging_path"`
+	Default     bool   `json:"default" toml:"default"`
+}
+
+func RepoRemoteSSHNew(name string, host string, user string, port int, path string, isDefault bool) RepositoryRemoteSSH {
+	return RepositoryRemoteSSH{
+		Name:    name,
+		Type:    "ssh",
+		Host:    host,
+		User:    user,
+		Port:    port,
+		Path:    path,
+		Default: isDefault,
+	}
+}
+
+func (r *RepositoryRemoteSSH) GetName() string {
+	return r.Name
+}
+
+func (r *RepositoryRemoteSSH) GetType() string {
+	return r.Type
+}
+
+func (r *RepositoryRemoteSSH) GetLog() *slog.Logger {
+	return slog.With(
+		slog.String("name", r.GetName()),
+		slog.String("type", r.GetType()),
+		slog.String("host", r.Host),
+		slog.String("path", r.Path),
+		slog.Bool("default", r.IsDefault()),
+	)
+}
+
+func (r *RepositoryRemoteSSH) Write(rootPath string) error {
+	var path string = filepath.Clean(fmt.Sprintf("%s/%s.toml",
+		rootPath,
+		strings.ToLower(sanitize.Accents(sanitize.BaseName(r.GetName()))),
+	))
+
+	repoToml, repoErr := toml.Marshal(r)
+	if repoErr != nil {
+		return fmt.Errorf("cannot serialize repository info to toml data: %w", repoErr)
+	}
+	if err := os.WriteFile(path, repoToml, 0644); err != nil {
+		return fmt.Errorf("cannot export repository info to file: %w", err)
+	}
+
+	r.GetLog().With(
+		slog.String("path", path),
+	).Debug("Saved repository to file")
+
+	return nil
+}
+
+func (r *RepositoryRemoteSSH) IsDefault() bool {
+	return r.Default
+}
+
+// GetStagingPath returns the local folder job data is staged in before being sent to the repository
+func (r *RepositoryRemoteSSH) GetStagingPath() string {
+	return getStagingPath(r.StagingPath, r.GetName())
+}
+
+func (r *RepositoryRemoteSSH) getTarget() string {
+	if r.User == "" {
+		return r.Host
+	}
+
+	return fmt.Sprintf("%s@%s", r.User, r.Host)
+}
+
+func (r *RepositoryRemoteSSH) getSSHArgs() []string {
+	port := r.Port
+	if port == 0 {
+		port = SSH_DEFAULT_PORT
+	}
+
+	return []string{"-o", "BatchMode=yes", "-p", fmt.Sprint(port)}
+}
+
+// GetRsh returns the remote shell command given to rsync to reach the repository host
+func (r *RepositoryRemoteSSH) GetRsh() string {
+	return strings.Join(append([]string{SSH_COMMAND}, r.getSSHArgs()...), " ")
+}
+
+// GetRemotePath formats a repository host path as an rsync remote location
+func (r *RepositoryRemoteSSH) GetRemotePath(p string) string {
+	return fmt.Sprintf("%s:%s", r.getTarget(), p)
+}
+
+// Run executes a command on the repository host and returns its standard output. Arguments are quoted for the remote shell
+func (r *RepositoryRemoteSSH) Run(args ...string) ([]byte, error) {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, shellQuote(arg))
+	}
+
+	cmdArgs := append(r.getSSHArgs(), r.getTarget(), strings.Join(quoted, " "))
+	cmd := exec.Command(SSH_COMMAND, cmdArgs...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return stdout.Bytes(), fmt.Errorf("remote command failed (%s): %w", strings.TrimSpace(stderr.String()), err)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Exists checks if path exists on the repository host
+func (r *RepositoryRemoteSSH) Exists(p string) (bool, error) {
+	_, err := r.Run("test", "-e", p)
+	if err == nil {
+		return true, nil
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+
+	return false, err
+}
+
+// RemoveAll removes path and its content from the repository host
+func (r *RepositoryRemoteSSH) RemoveAll(p string) error {
+	if _, err := r.Run("rm", "-rf", "--", p); err != nil {
+		return fmt.Errorf("cannot remove '%s' from repository host: %w", p, err)
+	}
+
+	return nil
+}
+
+func (r *RepositoryRemoteSSH) GetStoragePath(uuid string) string {
+	// Path on the repository host
+	return path.Clean(fmt.Sprintf("%s/%s/", r.Path, uuid))
+}
+
+// GetDestination returns the image staging folder
+func (r *RepositoryRemoteSSH) GetDestination(uuid string) string {
+	return filepath.Clean(fmt.Sprintf("%s/%s", r.GetStagingPath(), uuid))
+}
+
+func (r *RepositoryRemoteSSH) PrepareImage(uuid string) error {
+	return prepareStagingFolders(r.GetDestination(uuid))
+}
+
+// GetLinkDest returns an empty string: previous images are on the repository host and cannot be used as reference by transfers into the staging folder.
+// Unchanged files are hardlinked when data is pushed to the repository host instead
+func (r *RepositoryRemoteSSH) GetLinkDest(uuid string) string {
+	return ""
+}
+
+func (r *RepositoryRemoteSSH) DeleteImage(uuid string) error {
+	return r.RemoveAll(r.GetStoragePath(uuid))
+}
+
+// GetSpace returns space used by the repository folder and space left on its filesystem. Hardlinked files shared by images are counted once
+func (r *RepositoryRemoteSSH) GetSpace() (Space, error) {
+	out, err := r.Run("sh", "-c", `du -sk -- "$1" | cut -f 1 && df -Pk -- "$1" | tail -n 1`, "sh", r.Path)
+	if err != nil {
+		return Space{}, fmt.Errorf("cannot get repository space usage from repository host: %w", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 {
+		return Space{}, fmt.Errorf("cannot parse space usage from repository host: unexpected output '%s'", out)
+	}
+	used, err := strconv.ParseInt(strings.TrimSpace(lines[0]), 10, 64)
+	if err != nil {
+		return Space{}, fmt.Errorf("cannot parse used space '%s': %w", lines[0], err)
+	}
+	// df -P columns: filesystem, size, used, available, capacity, mount point
+	fields := strings.Fields(lines[1])
+	if len(fields) < 6 {
+		return Space{}, fmt.Errorf("cannot parse filesystem info '%s'", lines[1])
+	}
+	total, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return Space{}, fmt.Errorf("cannot parse filesystem size '%s': %w", fields[1], err)
+	}
+	free, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return Space{}, fmt.Errorf("cannot parse filesystem free space '%s': %w", fields[3], err)
+	}
+
+	return Space{
+		Used:  used * 1024,
+		Free:  free * 1024,
+		Total: total * 1024,
+	}, nil
+}
+
+func (r *RepositoryRemoteSSH) HealthCheck() error {
+	if _, err := r.Run("sh", "-c", `test -d "$1" && test -w "$1"`, "sh", r.Path); err != nil {
+		return fmt.Errorf("repository folder '%s' on '%s' is not a writable folder or cannot be reached: %w", r.Path, r.Host, err)
+	}
+
+	return nil
+}
+
+// PushData pushes staged image data to the repository host. Unchanged files are hardlinked to the reference image data on the repository host
+func (r *RepositoryRemoteSSH) PushData(uuid string, referenceUuid string) (PushStats, error) {
+	var referencePath string
+	if referenceUuid != "" {
+		referencePath = fmt.Sprintf("%s/_data/", r.GetStoragePath(referenceUuid))
+	}
+
+	destination := r.GetDestination(uuid)
+	task := rsync_task.NewPush(
+		// Source
+		fmt.Sprintf("%s/_data/", destination),
+		// Destination
+		r.GetRemotePath(fmt.Sprintf("%s/_data/", r.GetStoragePath(uuid))),
+		// Reference image on repository host
+		referencePath,
+		// Log folder
+		fmt.Sprintf("%s/_logs", destination),
+		r.GetRsh(),
+	)
+	if err := task.RunToCompletion(); err != nil {
+		return PushStats{}, fmt.Errorf("cannot push data to repository host: %w", err)
+	}
+
+	// Files hardlinked to the reference image are not transferred
+	if err := task.Task.Stats.GetFromRsyncLog(task.LogFile); err != nil {
+		r.GetLog().With(slog.Any("error", err)).Warn("Cannot read push stats from rsync log")
+	}
+
+	return PushStats{
+		DataBytes: task.Task.Stats.TotalFileSize,
+		NewBytes:  task.Task.Stats.TotalTransferredFileSize,
+	}, nil
+}
+
+// PushFiles pushes the content of the image staging folder to the repository host. Staged data is expected to be removed beforehand
+func (r *RepositoryRemoteSSH) PushFiles(uuid string) error {
+	destination := r.GetDestination(uuid)
+	task := rsync_task.NewPush(
+		// Source
+		fmt.Sprintf("%s/", destination),
+		// Destination
+		r.GetRemotePath(fmt.Sprintf("%s/", r.GetStoragePath(uuid))),
+		// Reference
+		"",
+		// Log folder
+		fmt.Sprintf("%s/_logs", destination),
+		r.GetRsh(),
+	)
+	if err := task.RunToCompletion(); err != nil {
+		return fmt.Errorf("cannot push files to repository host: %w", err)
+	}
+
+	return nil
+}
+
+// FetchData copies image paths from the repository host into the restore job staging folder
+func (r *RepositoryRemoteSSH) FetchData(imageUuid string, sources []string, jobUuid string) error {
+	destination := r.GetDestination(jobUuid)
+	for _, source := range sources {
+		task := rsync_task.NewFetch(
+			// Source, '/./' keeps paths relative to image data folder
+			r.GetRemotePath(fmt.Sprintf("%s/_data/./%s", r.GetStoragePath(imageUuid), strings.TrimPrefix(source, "/"))),
+			// Destination
+			filepath.Clean(fmt.Sprintf("%s/_data/", destination)),
+			// Log folder
+			filepath.Clean(fmt.Sprintf("%s/_logs/", destination)),
+			// Backup path
+			source,
+			r.GetRsh(),
+		)
+		if err := task.RunToCompletion(); err != nil {
+			return fmt.Errorf("cannot fetch '%s' from repository host: %w", source, err)
+		}
+	}
+
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,162 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// remoteListScript lists a folder of image data on the repository host with the same path checks as ResolvePath.
+// Arguments are the image data path and the cleaned image path. Entries are printed as NUL separated fields (requires GNU find)
+const remoteListScript = `cd -- "$1" || exit 2
+data=$(pwd -P)
+target="$1"
+if [ "$2" != "/" ]; then
+	parent=$(dirname -- "$2")
+	real=$(cd -- "$1$parent" 2>/dev/null && pwd -P) || exit 2
+	case "$parent" in
+		/) expected="$data" ;;
+		*) expected="$data$parent" ;;
+	esac
+	[ "$real" = "$expected" ] || exit 3
+	target="$1$2"
+	[ -L "$target" ] && exit 4
+fi
+[ -e "$target" ] || exit 2
+[ -d "$target" ] || exit 4
+find "$target" -mindepth 1 -maxdepth 1 -printf '%y\0%s\0%m\0%T@\0%U\0%G\0%l\0%f\0'`
+
+const remoteListFields = 8
+
+// ListImageFolder lists a folder of image data on the repository host
+func (r *RepositoryRemoteSSH) ListImageFolder(uuid string, imagePath string) ([]FileEntry, error) {
+	if strings.ContainsRune(imagePath, 0) {
+		return nil, fmt.Errorf("%w: '%s'", ErrInvalidPath, imagePath)
+	}
+	cleaned := path.Clean("/" + imagePath)
+
+	out, err := r.Run("sh", "-c", remoteListScript, "sh", fmt.Sprintf("%s/_data", r.GetStoragePath(uuid)), cleaned)
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			switch exitErr.ExitCode() {
+			case 2:
+				return nil, fmt.Errorf("cannot get path info: %w", fs.ErrNotExist)
+			case 3:
+				return nil, fmt.Errorf("%w: '%s' goes through a symbolic link", ErrInvalidPath, imagePath)
+			case 4:
+				return nil, fmt.Errorf("%w: '%s' is not a folder", ErrInvalidPath, imagePath)
+			}
+		}
+		return nil, fmt.Errorf("cannot list folder content on repository host: %w", err)
+	}
+
+	return parseRemoteList(cleaned, out)
+}
+
+func parseRemoteList(parentPath string, out []byte) ([]FileEntry, error) {
+	fields := strings.Split(string(out), "\x00")
+	// Output ends with a separator
+	fields = fields[:len(fields)-1]
+	if len(fields)%remoteListFields != 0 {
+		return nil, fmt.Errorf("cannot parse folder listing from repository host: unexpected number of fields")
+	}
+
+	files := make([]FileEntry, 0, len(fields)/remoteListFields)
+	for i := 0; i < len(fields); i += remoteListFields {
+		entry := fields[i : i+remoteListFields]
+
+		size, err := strconv.ParseInt(entry[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse file size '%s': %w", entry[1], err)
+		}
+		modTime, err := parseFindTime(entry[3])
+		if err != nil {
+			return nil, err
+		}
+		uid, err := strconv.ParseUint(entry[4], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse file owner '%s': %w", entry[4], err)
+		}
+		gid, err := strconv.ParseUint(entry[5], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse file group '%s': %w", entry[5], err)
+		}
+		mode, err := parseFindMode(entry[0], entry[2])
+		if err != nil {
+			return nil, err
+		}
+
+		files = append(files, FileEntry{
+			Path:       path.Join(parentPath, entry[7]),
+			Mode:       mode,
+			Size:       size,
+			ModTime:    modTime,
+			Uid:        uint32(uid),
+			Gid:        uint32(gid),
+			LinkTarget: entry[6],
+		})
+	}
+
+	return files, nil
+}
+
+// parseFindTime parses find '%T@' output: seconds since epoch with a fractional part
+func parseFindTime(s string) (time.Time, error) {
+	secStr, fracStr, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot parse file modification time '%s': %w", s, err)
+	}
+
+	var nsec int64
+	if fracStr != "" {
+		fracStr = (fracStr + "000000000")[:9]
+		if nsec, err = strconv.ParseInt(fracStr, 10, 64); err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse file modification time '%s': %w", s, err)
+		}
+	}
+
+	return time.Unix(sec, nsec), nil
+}
+
+// parseFindMode builds a file mode from find '%y' file type and '%m' octal permissions
+func parseFindMode(fileType string, perm string) (fs.FileMode, error) {
+	bits, err := strconv.ParseUint(perm, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse file permissions '%s': %w", perm, err)
+	}
+
+	mode := fs.FileMode(bits & 0777)
+	if bits&04000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if bits&02000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if bits&01000 != 0 {
+		mode |= fs.ModeSticky
+	}
+
+	switch fileType {
+	case "d":
+		mode |= fs.ModeDir
+	case "l":
+		mode |= fs.ModeSymlink
+	case "p":
+		mode |= fs.ModeNamedPipe
+	case "s":
+		mode |= fs.ModeSocket
+	case "c":
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case "b":
+		mode |= fs.ModeDevice
+	}
+
+	return mode, nil
+}
@@ -0,0 +1,127 @@
+package repo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeSSH replaces the ssh client by a script running commands locally, standing in for a remote sshd
+func fakeSSH(t *testing.T) {
+	t.Helper()
+
+	script := filepath.Join(t.TempDir(), "ssh")
+	content := `#!/bin/sh
+while [ $# -gt 0 ]; do
+	case "$1" in
+		-o|-p|-l) shift 2 ;;
+		-*) shift ;;
+		*) break ;;
+	esac
+done
+shift
+exec sh -c "$*"
+`
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("cannot write ssh stand-in: %v", err)
+	}
+
+	previous := SSH_COMMAND
+	SSH_COMMAND = script
+	t.Cleanup(func() {
+		SSH_COMMAND = previous
+	})
+}
+
+func TestRepositoryRemoteSSH_GetRsh(t *testing.T) {
+	tests := []struct {
+		name string
+		repo RepositoryRemoteSSH
+		want string
+	}{
+		{
+			name: "default_port",
+			repo: RepoRemoteSSHNew("remote", "backup.example.com", "relique", 0, "/srv/relique", false),
+			want: "ssh -o BatchMode=yes -p 22",
+		},
+		{
+			name: "custom_port",
+			repo: RepoRemoteSSHNew("remote", "backup.example.com", "relique", 2222, "/srv/relique", false),
+			want: "ssh -o BatchMode=yes -p 2222",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.repo.GetRsh(); got != tt.want {
+				t.Errorf("GetRsh() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepositoryRemoteSSH_GetRemotePath(t *testing.T) {
+	tests := []struct {
+		name string
+		repo RepositoryRemoteSSH
+		want string
+	}{
+		{
+			name: "with_user",
+			repo: RepoRemoteSSHNew("remote", "backup.example.com", "relique", 0, "/srv/relique", false),
+			want: "relique@backup.example.com:/srv/relique/uuid",
+		},
+		{
+			name: "without_user",
+			repo: RepoRemoteSSHNew("remote", "backup.example.com", "", 0, "/srv/relique", false),
+			want: "backup.example.com:/srv/relique/uuid",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.repo.GetRemotePath("/srv/relique/uuid"); got != tt.want {
+				t.Errorf("GetRemotePath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepositoryRemoteSSH_Commands(t *testing.T) {
+	fakeSSH(t)
+
+	root := t.TempDir()
+	r := RepoRemoteSSHNew("remote", "localhost", "", 0, root, false)
+	target := filepath.Join(root, "it's a folder")
+
+	exists, err := r.Exists(target)
+	if err != nil || exists {
+		t.Fatalf("Exists() before creation = %v, %v, want false", exists, err)
+	}
+
+	// Arguments must reach the remote shell unchanged
+	if _, err := r.Run("mkdir", "-p", "--", target); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Fatalf("Run() did not create folder: %v", err)
+	}
+	out, err := r.Run("echo", "$HOME;", "`id`")
+	if err != nil || string(out) != "$HOME; `id`\n" {
+		t.Errorf("Run() output = %q, %v, want arguments printed verbatim", out, err)
+	}
+
+	exists, err = r.Exists(target)
+	if err != nil || !exists {
+		t.Fatalf("Exists() after creation = %v, %v, want true", exists, err)
+	}
+
+	if err := r.RemoveAll(target); err != nil {
+		t.Fatalf("RemoveAll() error = %v", err)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Errorf("RemoveAll() did not remove folder")
+	}
+
+	if _, err := r.Run("false"); err == nil {
+		t.Errorf("Run() of failing command error = nil, want error")
+	}
+}
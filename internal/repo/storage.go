@@ -0,0 +1,201 @@
+package repo
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// prepareFolders creates image data and logs folders in a local job destination
+func prepareFolders(destination string) error {
+	if err := os.MkdirAll(fmt.Sprintf("%s/_data", destination), 0755); err != nil {
+		return fmt.Errorf("cannot setup job data folder: %w", err)
+	}
+	if err := os.MkdirAll(fmt.Sprintf("%s/_logs", destination), 0755); err != nil {
+		return fmt.Errorf("cannot setup job logs folder: %w", err)
+	}
+
+	return nil
+}
+
+// getStagingPath returns the configured staging folder of a staged repository, defaulting to a folder of the repository in DATA_PATH
+func getStagingPath(stagingPath string, name string) string {
+	if stagingPath != "" {
+		return filepath.Clean(stagingPath)
+	}
+
+	return filepath.Join(DATA_PATH, "staging", name)
+}
+
+// prepareStagingFolders creates image folders in a staging folder. Staged data is not encrypted yet, so the image folder is only accessible by its owner
+func prepareStagingFolders(destination string) error {
+	if err := os.MkdirAll(destination, 0700); err != nil {
+		return fmt.Errorf("cannot setup job staging folder: %w", err)
+	}
+	if err := os.Chmod(destination, 0700); err != nil {
+		return fmt.Errorf("cannot restrict job staging folder permissions: %w", err)
+	}
+
+	return prepareFolders(destination)
+}
+
+// pathExists checks if a local path exists
+func pathExists(p string) (bool, error) {
+	if _, err := os.Lstat(p); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// inodeKey identifies a file on a local filesystem, hardlinks to a file share its key
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+type inodeInfo struct {
+	size int64
+	// Number of hardlinks to the file on the filesystem
+	links uint64
+	// Number of hardlinks to the file found in the scanned folder
+	found uint64
+}
+
+// scanInodes lists the regular files stored in a local folder by inode, so that hardlinked files are counted once
+func scanInodes(root string) (map[inodeKey]*inodeInfo, error) {
+	inodes := make(map[inodeKey]*inodeInfo)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok {
+			// Without inode info, every file is counted as a file without hardlinks
+			inodes[inodeKey{dev: ^uint64(0), ino: uint64(len(inodes))}] = &inodeInfo{size: info.Size(), links: 1, found: 1}
+			return nil
+		}
+
+		key := inodeKey{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}
+		if inode, ok := inodes[key]; ok {
+			inode.found++
+			return nil
+		}
+		inodes[key] = &inodeInfo{size: info.Size(), links: uint64(stat.Nlink), found: 1}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot scan files in '%s': %w", root, err)
+	}
+
+	return inodes, nil
+}
+
+// diskUsage returns the size of the files stored in a local folder. Hardlinked files are counted once
+func diskUsage(root string) (int64, error) {
+	inodes, err := scanInodes(root)
+	if err != nil {
+		return 0, fmt.Errorf("cannot compute space used in '%s': %w", root, err)
+	}
+
+	var used int64
+	for _, inode := range inodes {
+		used += inode.size
+	}
+
+	return used, nil
+}
+
+// folderSpace splits the size of the files stored in a local folder between files only linked from the folder and files hardlinked from outside of it
+func folderSpace(root string) (ImageSpace, error) {
+	inodes, err := scanInodes(root)
+	if err != nil {
+		return ImageSpace{}, fmt.Errorf("cannot compute space used in '%s': %w", root, err)
+	}
+
+	var space ImageSpace
+	for _, inode := range inodes {
+		if inode.found >= inode.links {
+			space.Unique += inode.size
+		} else {
+			space.Shared += inode.size
+		}
+	}
+
+	return space, nil
+}
+
+// filesystemSpace returns free space available to unprivileged users and total size of the filesystem holding p
+func filesystemSpace(p string) (int64, int64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(p, &stat); err != nil {
+		return 0, 0, fmt.Errorf("cannot get filesystem info for '%s': %w", p, err)
+	}
+
+	return int64(stat.Bavail) * int64(stat.Bsize), int64(stat.Blocks) * int64(stat.Bsize), nil
+}
+
+// checkWritableFolder checks that p is a folder in which files can be created
+func checkWritableFolder(p string) error {
+	info, err := os.Stat(p)
+	if err != nil {
+		return fmt.Errorf("cannot access folder: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a folder", p)
+	}
+
+	f, err := os.CreateTemp(p, ".relique-healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("cannot write to folder: %w", err)
+	}
+	f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("cannot remove health check file: %w", err)
+	}
+
+	return nil
+}
+
+// copyFiles copies the regular files of a local folder into another folder, keeping their paths relative to the folder root
+func copyFiles(from string, to string) error {
+	return filepath.WalkDir(from, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(from, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, relPath)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("cannot create folder: %w", err)
+		}
+
+		content, err := os.ReadFile(p)
+		if err != nil {
+			return fmt.Errorf("cannot read file: %w", err)
+		}
+		if err := os.WriteFile(target, content, 0644); err != nil {
+			return fmt.Errorf("cannot write file: %w", err)
+		}
+
+		return nil
+	})
+}
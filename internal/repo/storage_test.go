@@ -0,0 +1,382 @@
+package repo
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepositoryLocal_Storage(t *testing.T) {
+	root := t.TempDir()
+	r := RepoLocalNew("local", root, false)
+
+	if err := r.HealthCheck(); err != nil {
+		t.Fatalf("HealthCheck() error = %v", err)
+	}
+	if got, want := r.GetDestination("uuid"), filepath.Join(root, "uuid"); got != want {
+		t.Errorf("GetDestination() = %v, want %v", got, want)
+	}
+	if got, want := r.GetLinkDest("previous"), filepath.Join(root, "previous", "_data"); got != want {
+		t.Errorf("GetLinkDest() = %v, want %v", got, want)
+	}
+
+	if err := r.PrepareImage("uuid"); err != nil {
+		t.Fatalf("PrepareImage() error = %v", err)
+	}
+	for _, folder := range []string{"_data", "_logs"} {
+		if exists, err := r.Exists(filepath.Join(r.GetStoragePath("uuid"), folder)); err != nil || !exists {
+			t.Errorf("Exists() on prepared '%s' folder = %v, %v, want true", folder, exists, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, METADATA_FILE)); err != nil {
+		t.Errorf("PrepareImage() did not write repository metadata: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "uuid", "_data", "file"), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	space, err := r.GetSpace()
+	if err != nil {
+		t.Fatalf("GetSpace() error = %v", err)
+	}
+	if space.Used < 4096 || space.Total <= 0 || space.Free < 0 || space.Free > space.Total {
+		t.Errorf("GetSpace() = %+v, want consistent values", space)
+	}
+
+	if err := r.DeleteImage("uuid"); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	if exists, err := r.Exists(r.GetStoragePath("uuid")); err != nil || exists {
+		t.Errorf("Exists() after DeleteImage() = %v, %v, want false", exists, err)
+	}
+
+	missing := RepoLocalNew("missing", filepath.Join(root, "missing"), false)
+	if err := missing.HealthCheck(); err == nil {
+		t.Errorf("HealthCheck() on missing folder error = nil, want error")
+	}
+}
+
+func TestRepositoryLocal_ImageSpace(t *testing.T) {
+	root := t.TempDir()
+	r := RepoLocalNew("local", root, false)
+
+	// Diff image hardlinks unchanged files to the previous image
+	files := map[string]int{
+		"first/_data/unchanged":  4096,
+		"first/_data/removed":    1024,
+		"second/_data/added":     512,
+		"second/_logs/rsync.log": 16,
+	}
+	for p, size := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, p)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, p), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(filepath.Join(root, "first/_data/unchanged"), filepath.Join(root, "second/_data/unchanged")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(root, "second/_data/added"), filepath.Join(root, "second/_data/added.copy")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		uuid string
+		want ImageSpace
+	}{
+		{
+			uuid: "first",
+			want: ImageSpace{Unique: 1024, Shared: 4096},
+		},
+		{
+			uuid: "second",
+			want: ImageSpace{Unique: 528, Shared: 4096},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uuid, func(t *testing.T) {
+			if got, err := r.GetImageSpace(tt.uuid); err != nil || got != tt.want {
+				t.Errorf("GetImageSpace() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+
+	// Hardlinked files are counted once in repository usage
+	space, err := r.GetSpace()
+	if err != nil {
+		t.Fatalf("GetSpace() error = %v", err)
+	}
+	if want := int64(4096 + 1024 + 512 + 16); space.Used != want {
+		t.Errorf("GetSpace() used = %d, want %d", space.Used, want)
+	}
+
+	if err := os.RemoveAll(filepath.Join(root, "first")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.GetImageSpace("second"); err != nil || got != (ImageSpace{Unique: 4096 + 528}) {
+		t.Errorf("GetImageSpace() after removing previous image = %+v, %v, want all data unique", got, err)
+	}
+}
+
+func TestNewCapacity(t *testing.T) {
+	tests := []struct {
+		name         string
+		free         int64
+		used         int64
+		quota        int64
+		minFreeSpace int64
+		want         int64
+	}{
+		{
+			name: "no limits",
+			free: 1000,
+			used: SPACE_UNKNOWN,
+			want: 1000,
+		},
+		{
+			name:         "free space threshold",
+			free:         1000,
+			used:         SPACE_UNKNOWN,
+			minFreeSpace: 300,
+			want:         700,
+		},
+		{
+			name:         "free space below threshold",
+			free:         200,
+			used:         SPACE_UNKNOWN,
+			minFreeSpace: 300,
+			want:         0,
+		},
+		{
+			name:  "quota",
+			free:  1000,
+			used:  400,
+			quota: 500,
+			want:  100,
+		},
+		{
+			name:  "quota exceeded",
+			free:  1000,
+			used:  600,
+			quota: 500,
+			want:  0,
+		},
+		{
+			name:         "filesystem fuller than quota",
+			free:         400,
+			used:         100,
+			quota:        2000,
+			minFreeSpace: 100,
+			want:         300,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newCapacity(tt.free, 2000, tt.used, tt.quota, tt.minFreeSpace); got.Available != tt.want {
+				t.Errorf("newCapacity() available = %d, want %d", got.Available, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepositoryLocal_Capacity(t *testing.T) {
+	root := t.TempDir()
+	r := RepoLocalNew("local", root, false)
+	if err := os.WriteFile(filepath.Join(root, "file"), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	capacity, err := r.GetCapacity()
+	if err != nil {
+		t.Fatalf("GetCapacity() error = %v", err)
+	}
+	if capacity.Used != SPACE_UNKNOWN || capacity.Available != capacity.Free {
+		t.Errorf("GetCapacity() without limits = %+v, want filesystem free space available", capacity)
+	}
+
+	r.Quota = "10KiB"
+	capacity, err = r.GetCapacity()
+	if err != nil {
+		t.Fatalf("GetCapacity() error = %v", err)
+	}
+	if capacity.Quota != 10240 || capacity.Used < 4096 || capacity.Available > 10240-4096 {
+		t.Errorf("GetCapacity() with quota = %+v, want space left in quota available", capacity)
+	}
+
+	if err := CheckSpace(&r, 8192); err != nil {
+		t.Errorf("CheckSpace() with warn space check error = %v, want nil", err)
+	}
+	r.SpaceCheck = SPACE_CHECK_REFUSE
+	if err := CheckSpace(&r, 8192); !errors.Is(err, ErrNotEnoughSpace) {
+		t.Errorf("CheckSpace() with refuse space check error = %v, want %v", err, ErrNotEnoughSpace)
+	}
+	if err := CheckSpace(&r, 1024); err != nil {
+		t.Errorf("CheckSpace() of image fitting in quota error = %v, want nil", err)
+	}
+
+	invalid := []RepositoryLocal{
+		{Name: "quota", Path: root, Quota: "a lot"},
+		{Name: "min_free_space", Path: root, MinFreeSpace: "-1GB"},
+		{Name: "space_check", Path: root, SpaceCheck: "ignore"},
+	}
+	for _, repo := range invalid {
+		if err := repo.HealthCheck(); err == nil {
+			t.Errorf("HealthCheck() with invalid %s error = nil, want error", repo.Name)
+		}
+	}
+}
+
+func TestRepositoryRemoteSSH_Storage(t *testing.T) {
+	fakeSSH(t)
+
+	root := t.TempDir()
+	r := RepoRemoteSSHNew("remote", "localhost", "", 0, root, false)
+	r.StagingPath = t.TempDir()
+
+	if err := r.HealthCheck(); err != nil {
+		t.Fatalf("HealthCheck() error = %v", err)
+	}
+	// Transfers into the staging folder cannot hardlink to images stored on the repository host
+	if got := r.GetLinkDest("previous"); got != "" {
+		t.Errorf("GetLinkDest() = %v, want empty", got)
+	}
+
+	if err := r.PrepareImage("uuid"); err != nil {
+		t.Fatalf("PrepareImage() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(r.StagingPath, "uuid", "_data")); err != nil {
+		t.Errorf("PrepareImage() did not create staging data folder: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, "uuid", "_data"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "uuid", "_data", "file"), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	space, err := r.GetSpace()
+	if err != nil {
+		t.Fatalf("GetSpace() error = %v", err)
+	}
+	if space.Used < 4096 || space.Total <= 0 || space.Free < 0 || space.Free > space.Total {
+		t.Errorf("GetSpace() = %+v, want consistent values", space)
+	}
+
+	if err := r.DeleteImage("uuid"); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "uuid")); !os.IsNotExist(err) {
+		t.Errorf("DeleteImage() did not remove image folder")
+	}
+
+	missing := RepoRemoteSSHNew("missing", "localhost", "", 0, filepath.Join(root, "missing"), false)
+	if err := missing.HealthCheck(); err == nil {
+		t.Errorf("HealthCheck() on missing folder error = nil, want error")
+	}
+}
+
+func TestRepositoryDedup_StagingPath(t *testing.T) {
+	r := RepoDedupNew("dedup", t.TempDir(), false)
+	if got, want := r.GetStagingPath(), filepath.Join(DATA_PATH, "staging", "dedup"); got != want {
+		t.Errorf("GetStagingPath() = %v, want %v", got, want)
+	}
+
+	if err := r.PrepareImage("uuid"); err != nil {
+		t.Fatalf("PrepareImage() error = %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(r.GetStagingPath()) })
+	info, err := os.Stat(r.GetDestination("uuid"))
+	if err != nil {
+		t.Fatalf("PrepareImage() did not create staging folder: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0700 {
+		t.Errorf("staging folder permissions = %o, want 700", perm)
+	}
+
+	r.StagingPath = "/srv/staging/"
+	if got := r.GetStagingPath(); got != "/srv/staging" {
+		t.Errorf("GetStagingPath() = %v, want /srv/staging", got)
+	}
+}
+
+func TestRepositoryS3_Storage(t *testing.T) {
+	r, server := setupS3Repo(t)
+	r.StagingPath = t.TempDir()
+
+	if err := r.HealthCheck(); err != nil {
+		t.Fatalf("HealthCheck() error = %v", err)
+	}
+	if keys := server.Keys("relique", ""); len(keys) != 0 {
+		t.Errorf("HealthCheck() left objects %v", keys)
+	}
+
+	if err := r.PrepareImage("uuid"); err != nil {
+		t.Fatalf("PrepareImage() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(r.GetDestination("uuid"), "_data", "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PushData("uuid", ""); err != nil {
+		t.Fatalf("PushData() error = %v", err)
+	}
+
+	space, err := r.GetSpace()
+	if err != nil {
+		t.Fatalf("GetSpace() error = %v", err)
+	}
+	if space.Used < int64(len("content")) || space.Free != SPACE_UNKNOWN || space.Total != SPACE_UNKNOWN {
+		t.Errorf("GetSpace() = %+v, want used space and unknown capacity", space)
+	}
+
+	if err := r.FetchData("uuid", []string{"/"}, "restore"); err != nil {
+		t.Fatalf("FetchData() error = %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(r.GetDestination("restore"), "_data", "file")); err != nil || string(content) != "content" {
+		t.Errorf("FetchData() file content = %q, %v, want pushed content", content, err)
+	}
+
+	if err := r.DeleteImage("uuid"); err != nil {
+		t.Fatalf("DeleteImage() error = %v", err)
+	}
+	if exists, err := r.Exists(r.GetStoragePath("uuid")); err != nil || exists {
+		t.Errorf("Exists() after DeleteImage() = %v, %v, want false", exists, err)
+	}
+
+	unreachable := RepoS3New("unreachable", server.URL, "", "missing_bucket", "", "access", "secret", false)
+	if err := unreachable.HealthCheck(); err == nil {
+		t.Errorf("HealthCheck() on missing bucket error = nil, want error")
+	}
+}
+
+// stageImage prepares image uuid in the staging folder of a staged repository, filled with test data from setupS3Data, and returns its data folder
+func stageImage(t *testing.T, r StagedRepository, uuid string) string {
+	t.Helper()
+
+	if err := r.PrepareImage(uuid); err != nil {
+		t.Fatal(err)
+	}
+	stagedData := filepath.Join(r.GetDestination(uuid), "_data")
+	if err := os.Remove(stagedData); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(setupS3Data(t), stagedData); err != nil {
+		t.Fatal(err)
+	}
+
+	return stagedData
+}
+
+// pushImage pushes staged image uuid to the repository
+func pushImage(t *testing.T, r StagedRepository, uuid string) PushStats {
+	t.Helper()
+
+	stats, err := r.PushData(uuid, "")
+	if err != nil {
+		t.Fatalf("PushData() error = %v", err)
+	}
+
+	return stats
+}
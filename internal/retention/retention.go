@@ -0,0 +1,129 @@
+package retention
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+func (p *Policy) IsEnabled() bool {
+	return p.KeepLast > 0 ||
+		p.KeepDaily > 0 ||
+		p.KeepWeekly > 0 ||
+		p.KeepMonthly > 0 ||
+		p.KeepYearly > 0 ||
+		p.MaxAge != ""
+}
+
+func (p *Policy) hasBucketRules() bool {
+	return p.KeepLast > 0 ||
+		p.KeepDaily > 0 ||
+		p.KeepWeekly > 0 ||
+		p.KeepMonthly > 0 ||
+		p.KeepYearly > 0
+}
+
+func (p *Policy) Valid() error {
+	var objErrors *multierror.Error
+
+	if p.KeepLast < 0 || p.KeepDaily < 0 || p.KeepWeekly < 0 || p.KeepMonthly < 0 || p.KeepYearly < 0 {
+		objErrors = multierror.Append(objErrors, fmt.Errorf("retention counts cannot be negative"))
+	}
+	if p.MaxAge != "" {
+		maxAge, err := time.ParseDuration(p.MaxAge)
+		if err != nil {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("invalid max age '%s': %w", p.MaxAge, err))
+		} else if maxAge <= 0 {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("max age must be positive"))
+		}
+	}
+
+	return objErrors.ErrorOrNil()
+}
+
+// Apply splits images into kept and removed lists according to the policy.
+// The most recent image is always kept since it is used as reference for the next diff backup, as well as images used by running jobs.
+// A disabled policy keeps every image.
+func (p *Policy) Apply(candidates []Candidate, now time.Time) ([]Candidate, []Candidate, error) {
+	if err := p.Valid(); err != nil {
+		return nil, nil, fmt.Errorf("invalid retention policy: %w", err)
+	}
+
+	sorted := slices.Clone(candidates)
+	// Most recent first
+	slices.SortStableFunc(sorted, func(a, b Candidate) int {
+		return cmp.Compare(b.CreatedAt.UnixNano(), a.CreatedAt.UnixNano())
+	})
+
+	if !p.IsEnabled() {
+		return sorted, []Candidate{}, nil
+	}
+
+	var limit time.Time
+	if p.MaxAge != "" {
+		maxAge, _ := time.ParseDuration(p.MaxAge)
+		limit = now.Add(-maxAge)
+	}
+
+	keepMap := make(map[string]bool)
+	if p.hasBucketRules() {
+		p.keepBuckets(sorted, keepMap, p.KeepLast, func(t time.Time) string { return "" })
+		p.keepBuckets(sorted, keepMap, p.KeepDaily, func(t time.Time) string { return t.Format("2006-01-02") })
+		p.keepBuckets(sorted, keepMap, p.KeepWeekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-%02d", year, week)
+		})
+		p.keepBuckets(sorted, keepMap, p.KeepMonthly, func(t time.Time) string { return t.Format("2006-01") })
+		p.keepBuckets(sorted, keepMap, p.KeepYearly, func(t time.Time) string { return t.Format("2006") })
+
+		if !limit.IsZero() {
+			for _, c := range sorted {
+				if c.CreatedAt.Before(limit) {
+					keepMap[c.Uuid] = false
+				}
+			}
+		}
+	} else {
+		for _, c := range sorted {
+			keepMap[c.Uuid] = !c.CreatedAt.Before(limit)
+		}
+	}
+
+	var keep []Candidate
+	var remove []Candidate
+	for i, c := range sorted {
+		if i == 0 || c.InUse || keepMap[c.Uuid] {
+			keep = append(keep, c)
+		} else {
+			remove = append(remove, c)
+		}
+	}
+
+	return keep, remove, nil
+}
+
+// keepBuckets keeps the most recent image of the n most recent buckets. An empty bucket key means one bucket per image
+func (p *Policy) keepBuckets(sorted []Candidate, keepMap map[string]bool, n int, bucketKey func(time.Time) string) {
+	if n <= 0 {
+		return
+	}
+
+	seen := make(map[string]bool)
+	count := 0
+	for _, c := range sorted {
+		if count >= n {
+			return
+		}
+
+		key := bucketKey(c.CreatedAt)
+		if key != "" && seen[key] {
+			continue
+		}
+		seen[key] = true
+		keepMap[c.Uuid] = true
+		count++
+	}
+}
@@ -0,0 +1,173 @@
+package retention
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// dailyCandidates generates one candidate per day, the most recent one being created on 'last'
+func dailyCandidates(last time.Time, count int) []Candidate {
+	var candidates []Candidate
+	for i := 0; i < count; i++ {
+		candidates = append(candidates, Candidate{
+			Uuid:      fmt.Sprintf("img-%03d", i),
+			CreatedAt: last.AddDate(0, 0, -i),
+		})
+	}
+
+	return candidates
+}
+
+func uuids(candidates []Candidate) []string {
+	list := make([]string, 0)
+	for _, c := range candidates {
+		list = append(list, c.Uuid)
+	}
+
+	return list
+}
+
+func TestPolicy_Valid(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		wantErr bool
+	}{
+		{
+			name:    "empty",
+			policy:  Policy{},
+			wantErr: false,
+		},
+		{
+			name:    "valid",
+			policy:  Policy{KeepLast: 3, KeepDaily: 7, MaxAge: "8760h"},
+			wantErr: false,
+		},
+		{
+			name:    "negative_count",
+			policy:  Policy{KeepWeekly: -1},
+			wantErr: true,
+		},
+		{
+			name:    "invalid_max_age",
+			policy:  Policy{MaxAge: "1 year"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Valid(); (err != nil) != tt.wantErr {
+				t.Errorf("Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicy_Apply(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	inUse := dailyCandidates(now, 5)
+	inUse[3].InUse = true
+
+	tests := []struct {
+		name       string
+		policy     Policy
+		candidates []Candidate
+		wantKeep   []string
+		wantRemove []string
+	}{
+		{
+			name:       "disabled",
+			policy:     Policy{},
+			candidates: dailyCandidates(now, 3),
+			wantKeep:   []string{"img-000", "img-001", "img-002"},
+			wantRemove: []string{},
+		},
+		{
+			name:       "keep_last",
+			policy:     Policy{KeepLast: 2},
+			candidates: dailyCandidates(now, 4),
+			wantKeep:   []string{"img-000", "img-001"},
+			wantRemove: []string{"img-002", "img-003"},
+		},
+		{
+			name:   "keep_daily_multiple_per_day",
+			policy: Policy{KeepDaily: 2},
+			candidates: []Candidate{
+				{Uuid: "a", CreatedAt: now},
+				{Uuid: "b", CreatedAt: now.Add(-time.Hour)},
+				{Uuid: "c", CreatedAt: now.AddDate(0, 0, -1)},
+				{Uuid: "d", CreatedAt: now.AddDate(0, 0, -1).Add(-time.Hour)},
+				{Uuid: "e", CreatedAt: now.AddDate(0, 0, -2)},
+			},
+			wantKeep:   []string{"a", "c"},
+			wantRemove: []string{"b", "d", "e"},
+		},
+		{
+			name:       "keep_weekly",
+			policy:     Policy{KeepWeekly: 2},
+			candidates: dailyCandidates(now, 14),
+			// 2024-03-15 is a friday: most recent images of the current and previous ISO weeks
+			wantKeep:   []string{"img-000", "img-005"},
+			wantRemove: []string{"img-001", "img-002", "img-003", "img-004", "img-006", "img-007", "img-008", "img-009", "img-010", "img-011", "img-012", "img-013"},
+		},
+		{
+			name:   "keep_monthly_and_yearly",
+			policy: Policy{KeepMonthly: 2, KeepYearly: 2},
+			candidates: []Candidate{
+				{Uuid: "a", CreatedAt: now},
+				{Uuid: "b", CreatedAt: now.AddDate(0, 0, -2)},
+				{Uuid: "c", CreatedAt: now.AddDate(0, -1, 0)},
+				{Uuid: "d", CreatedAt: now.AddDate(0, -2, 0)},
+				{Uuid: "e", CreatedAt: now.AddDate(-1, 0, 0)},
+				{Uuid: "f", CreatedAt: now.AddDate(-1, -1, 0)},
+			},
+			wantKeep:   []string{"a", "c", "e"},
+			wantRemove: []string{"b", "d", "f"},
+		},
+		{
+			name:       "max_age_only",
+			policy:     Policy{MaxAge: "60h"},
+			candidates: dailyCandidates(now, 5),
+			wantKeep:   []string{"img-000", "img-001", "img-002"},
+			wantRemove: []string{"img-003", "img-004"},
+		},
+		{
+			name:       "max_age_overrides_buckets",
+			policy:     Policy{KeepDaily: 5, MaxAge: "36h"},
+			candidates: dailyCandidates(now, 5),
+			wantKeep:   []string{"img-000", "img-001"},
+			wantRemove: []string{"img-002", "img-003", "img-004"},
+		},
+		{
+			name:       "latest_always_kept",
+			policy:     Policy{MaxAge: "1h"},
+			candidates: dailyCandidates(now.AddDate(0, 0, -10), 3),
+			wantKeep:   []string{"img-000"},
+			wantRemove: []string{"img-001", "img-002"},
+		},
+		{
+			name:       "in_use_kept",
+			policy:     Policy{KeepLast: 1},
+			candidates: inUse,
+			wantKeep:   []string{"img-000", "img-003"},
+			wantRemove: []string{"img-001", "img-002", "img-004"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, remove, err := tt.policy.Apply(tt.candidates, now)
+			if err != nil {
+				t.Errorf("Apply() error = %v", err)
+				return
+			}
+			if got := uuids(keep); !reflect.DeepEqual(got, tt.wantKeep) {
+				t.Errorf("Apply() keep = %v, want %v", got, tt.wantKeep)
+			}
+			if got := uuids(remove); !reflect.DeepEqual(got, tt.wantRemove) {
+				t.Errorf("Apply() remove = %v, want %v", got, tt.wantRemove)
+			}
+		})
+	}
+}
@@ -0,0 +1,40 @@
+package retention
+
+import (
+	"log/slog"
+	"time"
+)
+
+type Policy struct {
+	// Number of most recent images to keep
+	KeepLast int `json:"keep_last" toml:"keep_last"`
+	// Number of days for which the most recent image of the day is kept
+	KeepDaily int `json:"keep_daily" toml:"keep_daily"`
+	// Number of weeks for which the most recent image of the week is kept
+	KeepWeekly int `json:"keep_weekly" toml:"keep_weekly"`
+	// Number of months for which the most recent image of the month is kept
+	KeepMonthly int `json:"keep_monthly" toml:"keep_monthly"`
+	// Number of years for which the most recent image of the year is kept
+	KeepYearly int `json:"keep_yearly" toml:"keep_yearly"`
+	// Images older than this duration are removed (Go duration format, ie '720h'). Used alone, every image younger than max age is kept
+	MaxAge string `json:"max_age" toml:"max_age"`
+}
+
+// Candidate is the minimal image description needed to apply a retention policy
+type Candidate struct {
+	Uuid      string
+	CreatedAt time.Time
+	// Set if a running job uses this image as diff reference
+	InUse bool
+}
+
+func (p *Policy) GetLog() *slog.Logger {
+	return slog.With(
+		slog.Int("keep_last", p.KeepLast),
+		slog.Int("keep_daily", p.KeepDaily),
+		slog.Int("keep_weekly", p.KeepWeekly),
+		slog.Int("keep_monthly", p.KeepMonthly),
+		slog.Int("keep_yearly", p.KeepYearly),
+		slog.String("max_age", p.MaxAge),
+	)
+}
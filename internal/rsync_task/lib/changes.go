@@ -0,0 +1,119 @@
+package rsync_lib
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	ChangeCreated    = "created"
+	ChangeUpdated    = "updated"
+	ChangeDeleted    = "deleted"
+	ChangeAttributes = "attributes"
+)
+
+// Itemized change line (--itemize-changes): YXcstpoguax followed by file name
+//
+//	>f+++++++++ etc/hosts
+//	.f...p..... etc/passwd
+//	cL+++++++++ etc/localtime -> /usr/share/zoneinfo/UTC
+var regexItemizedChange = regexp.MustCompile(`^([<>ch.])([fdLDS])([.+ ?a-zA-Z]{9}) (.+)$`)
+var regexItemizedDeletion = regexp.MustCompile(`^\*deleting\s+(.+)$`)
+
+// Change describes a single element change reported by rsync
+type Change struct {
+	Path string `json:"path"`
+	// Change type: created, updated, deleted or attributes
+	Type string `json:"type"`
+	// Element type: file, directory, symlink, device or special
+	FileType string `json:"file_type"`
+	// Raw rsync itemized changes string
+	Flags      string `json:"flags"`
+	BackupPath string `json:"backup_path"`
+}
+
+// ParseItemizedChange parses an rsync itemized output line. False is returned if the line does not describe a change
+func ParseItemizedChange(line string) (Change, bool) {
+	line = strings.TrimRight(line, "\r\n")
+
+	if matches := regexItemizedDeletion.FindStringSubmatch(line); matches != nil {
+		name := matches[1]
+		fileType := "file"
+		if strings.HasSuffix(name, "/") {
+			fileType = "directory"
+		}
+		return Change{
+			Path:     name,
+			Type:     ChangeDeleted,
+			FileType: fileType,
+			Flags:    "*deleting",
+		}, true
+	}
+
+	matches := regexItemizedChange.FindStringSubmatch(line)
+	if matches == nil {
+		return Change{}, false
+	}
+	updateType, fileType, attributes, name := matches[1], matches[2], matches[3], matches[4]
+
+	c := Change{
+		Flags: updateType + fileType + attributes,
+	}
+	switch fileType {
+	case "f":
+		c.FileType = "file"
+	case "d":
+		c.FileType = "directory"
+	case "L":
+		c.FileType = "symlink"
+		name, _, _ = strings.Cut(name, " -> ")
+	case "D":
+		c.FileType = "device"
+	case "S":
+		c.FileType = "special"
+	}
+	if updateType == "h" {
+		name, _, _ = strings.Cut(name, " => ")
+	}
+	c.Path = name
+
+	switch {
+	case strings.Trim(attributes, "+") == "":
+		c.Type = ChangeCreated
+	case updateType == "<" || updateType == ">" || updateType == "c" || updateType == "h":
+		c.Type = ChangeUpdated
+	case strings.Trim(attributes, ". ") != "":
+		c.Type = ChangeAttributes
+	default:
+		// Unchanged element, only listed with increased verbosity
+		return Change{}, false
+	}
+
+	return c, true
+}
+
+// GetChangesFromRsyncLog lists changes from rsync itemized output
+func GetChangesFromRsyncLog(path string) ([]Change, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open log file: %w", err)
+	}
+	defer f.Close()
+
+	changes := make([]Change, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if c, ok := ParseItemizedChange(scanner.Text()); ok {
+			changes = append(changes, c)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read log file: %w", err)
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,82 @@
+package rsync_lib
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseItemizedChange(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   Change
+		wantOk bool
+	}{
+		{
+			name:   "created_file",
+			line:   ">f+++++++++ etc/hosts",
+			want:   Change{Path: "etc/hosts", Type: ChangeCreated, FileType: "file", Flags: ">f+++++++++"},
+			wantOk: true,
+		},
+		{
+			name:   "created_directory",
+			line:   "cd+++++++++ etc/app/",
+			want:   Change{Path: "etc/app/", Type: ChangeCreated, FileType: "directory", Flags: "cd+++++++++"},
+			wantOk: true,
+		},
+		{
+			name:   "updated_file",
+			line:   ">f.st...... etc/passwd",
+			want:   Change{Path: "etc/passwd", Type: ChangeUpdated, FileType: "file", Flags: ">f.st......"},
+			wantOk: true,
+		},
+		{
+			name:   "attributes_only",
+			line:   ".f...p..... etc/shadow",
+			want:   Change{Path: "etc/shadow", Type: ChangeAttributes, FileType: "file", Flags: ".f...p....."},
+			wantOk: true,
+		},
+		{
+			name:   "symlink",
+			line:   "cL+++++++++ etc/localtime -> /usr/share/zoneinfo/UTC",
+			want:   Change{Path: "etc/localtime", Type: ChangeCreated, FileType: "symlink", Flags: "cL+++++++++"},
+			wantOk: true,
+		},
+		{
+			name:   "deleted",
+			line:   "*deleting   etc/old.conf",
+			want:   Change{Path: "etc/old.conf", Type: ChangeDeleted, FileType: "file", Flags: "*deleting"},
+			wantOk: true,
+		},
+		{
+			name:   "unchanged",
+			line:   ".d          etc/",
+			want:   Change{},
+			wantOk: false,
+		},
+		{
+			name:   "progress",
+			line:   "          1,234 100%    1.18MB/s    0:00:00 (xfr#1, to-chk=10/12)",
+			want:   Change{},
+			wantOk: false,
+		},
+		{
+			name:   "stats",
+			line:   "Number of files: 12 (reg: 10, dir: 2)",
+			want:   Change{},
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := ParseItemizedChange(tt.line)
+			if ok != tt.wantOk {
+				t.Errorf("ParseItemizedChange() ok = %v, want %v", ok, tt.wantOk)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseItemizedChange() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -203,6 +203,8 @@ type RsyncOptions struct {
 
 	//out-format
 	OutFormat bool
+	// ItemizeChanges output a change-summary for all updates
+	ItemizeChanges bool
 }
 
 // StdoutPipe returns a pipe that will be connected to the command's
@@ -219,7 +221,8 @@ func (r Rsync) StderrPipe() (io.ReadCloser, error) {
 
 // Start starts an rsync command
 func (r Rsync) Start() error {
-	if !isExist(r.Destination) {
+	// Remote destinations are created by rsync on the remote side if needed
+	if !isRemote(r.Destination) && !isExist(r.Destination) {
 		if err := createDir(r.Destination); err != nil {
 			return err
 		}
@@ -597,6 +600,10 @@ func getArguments(options RsyncOptions) []string {
 		arguments = append(arguments, "--out-format=\"%n\"")
 	}
 
+	if options.ItemizeChanges {
+		arguments = append(arguments, "--itemize-changes")
+	}
+
 	if options.Mkpath {
 		arguments = append(arguments, "--mkpath")
 	}
@@ -632,6 +639,17 @@ func createDir(dir string) error {
 	return cmd.Wait()
 }
 
+// isRemote tells if p uses rsync remote location syntax (host:path), where the colon comes before any slash
+func isRemote(p string) bool {
+	colon := strings.Index(p, ":")
+	if colon == -1 {
+		return false
+	}
+	slash := strings.Index(p, "/")
+
+	return slash == -1 || colon < slash
+}
+
 func isExist(p string) bool {
 	stat, err := os.Stat(p)
 	return os.IsExist(err) && stat.IsDir()
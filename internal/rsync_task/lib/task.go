@@ -2,8 +2,10 @@ package rsync_lib
 
 import (
 	"bufio"
+	"errors"
 	"io"
 	"math"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,11 +16,15 @@ type Task struct {
 	Rsync *Rsync
 	Stats Stats
 
-	state *State
-	log   *Log
-	mutex sync.Mutex
+	state     *State
+	log       *Log
+	mutex     sync.Mutex
+	cancelled bool
 }
 
+// ErrCancelled is returned by Run when the task has been cancelled before rsync process start
+var ErrCancelled = errors.New("task cancelled")
+
 // State contains information about rsync process
 type State struct {
 	Remain   int     `json:"remain"`
@@ -71,7 +77,14 @@ func (t *Task) Run() error {
 	go processStderr(&wg, t, stderr)
 	wg.Add(2)
 
-	if err = t.Rsync.Start(); err != nil {
+	t.mutex.Lock()
+	if t.cancelled {
+		err = ErrCancelled
+	} else {
+		err = t.Rsync.Start()
+	}
+	t.mutex.Unlock()
+	if err != nil {
 		// Close pipes to unblock goroutines
 		stdout.Close()
 		stderr.Close()
@@ -84,6 +97,32 @@ func (t *Task) Run() error {
 	return t.Rsync.Wait()
 }
 
+// Cancel kills rsync process if it is running and prevents it from being started otherwise.
+// Output already read from rsync process is kept in task log
+func (t *Task) Cancel() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.cancelled = true
+	if t.Rsync.Cmd.Process == nil {
+		return nil
+	}
+
+	if err := t.Rsync.Cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+
+	return nil
+}
+
+// Cancelled returns true if Cancel has been called on task
+func (t *Task) Cancelled() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.cancelled
+}
+
 // NewTask returns new rsync task
 func NewTask(source, destination string, rsyncOptions RsyncOptions) *Task {
 	// Force set required options
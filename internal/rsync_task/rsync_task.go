@@ -3,6 +3,7 @@ package rsync_task
 import (
 	"fmt"
 	"log/slog"
+	"os"
 	"path/filepath"
 
 	"github.com/kennygrant/sanitize"
@@ -15,6 +16,7 @@ type RsyncTask struct {
 	LogFile      string
 	LogErrorFile string
 	BackupPath   string
+	Changes      []rsync_lib.Change
 }
 
 func newBackup(source string, destination string, logsRootFolder string, backupPath string, options rsync_lib.RsyncOptions) RsyncTask {
@@ -43,6 +45,8 @@ func NewRestore(source string, destination string, logsRootFolder string, backup
 		Exclude:      exclude,
 		CVSExclude:   excludeCVS,
 		Include:      include,
+
+		ItemizeChanges: true,
 	}
 
 	return newBackup(source, destination, logsRootFolder, backupPath, rsyncOptions)
@@ -64,6 +68,8 @@ func NewFullBackup(source string, destination string, logsRootFolder string, bac
 		Exclude:      exclude,
 		CVSExclude:   excludeCVS,
 		Include:      include,
+
+		ItemizeChanges: true,
 	}
 
 	return newBackup(source, destination, logsRootFolder, backupPath, rsyncOptions)
@@ -86,11 +92,91 @@ func NewDiffBackup(source string, destination string, referencePath string, logs
 		Exclude:      exclude,
 		CVSExclude:   excludeCVS,
 		Include:      include,
+
+		ItemizeChanges: true,
 	}
 
 	return newBackup(source, destination, logsRootFolder, backupPath, rsyncOptions)
 }
 
+// NewPush transfers job staging folder content to a remote repository. Unchanged files are hardlinked to referencePath on the remote side if set
+func NewPush(source string, destination string, referencePath string, logsRootFolder string, rsh string) RsyncTask {
+	rsyncOptions := rsync_lib.RsyncOptions{
+		Archive:      true,
+		DelayUpdates: true,
+		LinkDest:     referencePath,
+		Mkpath:       true,
+		NumericIDs:   true,
+		Perms:        true,
+		Quiet:        false,
+		Recursive:    true,
+		Relative:     false,
+		Rsh:          rsh,
+		Stats:        true,
+		Verbose:      true,
+	}
+
+	return newBackup(source, destination, logsRootFolder, "push", rsyncOptions)
+}
+
+// NewFetch copies image data from a remote repository into job staging folder. Source path must use the rsync '/./' marker to keep paths relative to image data folder
+func NewFetch(source string, destination string, logsRootFolder string, backupPath string, rsh string) RsyncTask {
+	rsyncOptions := rsync_lib.RsyncOptions{
+		Archive:      true,
+		DelayUpdates: true,
+		NumericIDs:   true,
+		Perms:        true,
+		Quiet:        false,
+		Recursive:    true,
+		Relative:     true,
+		Rsh:          rsh,
+		Stats:        true,
+		Verbose:      true,
+	}
+
+	return newBackup(source, destination, logsRootFolder, fmt.Sprintf("fetch_%s", backupPath), rsyncOptions)
+}
+
+// NewCopy replicates image data between two local folders. Hardlinks inside the image are preserved and unchanged files are hardlinked to referencePath if set
+func NewCopy(source string, destination string, referencePath string, logsRootFolder string) RsyncTask {
+	rsyncOptions := rsync_lib.RsyncOptions{
+		Archive:      true,
+		DelayUpdates: true,
+		HardLinks:    true,
+		LinkDest:     referencePath,
+		Mkpath:       true,
+		NumericIDs:   true,
+		Perms:        true,
+		Progress:     true,
+		Quiet:        false,
+		Recursive:    true,
+		Relative:     false,
+		Stats:        true,
+		Verbose:      true,
+	}
+
+	return newBackup(source, destination, logsRootFolder, "copy", rsyncOptions)
+}
+
+// RunToCompletion runs the task until completion and writes its output to task log files
+func (t *RsyncTask) RunToCompletion() error {
+	slog.With(
+		slog.String("cmd", t.Task.Rsync.Cmd.String()),
+	).Debug("Running rsync command")
+
+	runErr := t.Task.Run()
+
+	logStruct := t.Task.Log()
+	if err := os.WriteFile(t.LogFile, []byte(logStruct.Stdout), 0644); err != nil {
+		slog.With(slog.Any("error", err)).Error("Cannot write task log to file")
+	}
+	if err := os.WriteFile(t.LogErrorFile, []byte(logStruct.Stderr), 0644); err != nil {
+		slog.With(slog.Any("error", err)).Error("Cannot write task error log to file")
+	}
+
+	return runErr
+}
+
 func (t *RsyncTask) GetProgressLog() *slog.Logger {
 	state := t.Task.State()
 	return slog.With(
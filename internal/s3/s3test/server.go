@@ -0,0 +1,170 @@
+// Package s3test provides an in-process fake S3 server for tests
+package s3test
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type object struct {
+	data         []byte
+	lastModified time.Time
+}
+
+// Server stores objects in memory and answers path-style object and ListObjectsV2 requests. Request signatures are not checked
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]map[string]object
+	// Maximum number of keys returned by a listing page
+	PageSize int
+}
+
+type listBucketResult struct {
+	XMLName               xml.Name        `xml:"ListBucketResult"`
+	Contents              []listBucketKey `xml:"Contents"`
+	IsTruncated           bool            `xml:"IsTruncated"`
+	NextContinuationToken string          `xml:"NextContinuationToken,omitempty"`
+}
+
+type listBucketKey struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// New starts a fake server holding the listed empty buckets
+func New(buckets ...string) *Server {
+	s := &Server{
+		buckets:  make(map[string]map[string]object),
+		PageSize: 1000,
+	}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string]object)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+
+	return s
+}
+
+// Keys lists object keys stored in bucket starting with prefix
+func (s *Server) Keys(bucket string, prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0)
+	for k := range s.buckets[bucket] {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// SetLastModified changes an object modification date
+func (s *Server) SetLastModified(bucket string, key string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if o, ok := s.buckets[bucket][key]; ok {
+		o.lastModified = t
+		s.buckets[bucket][key] = o
+	}
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ") {
+		writeError(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+
+	bucketName, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bucket, ok := s.buckets[bucketName]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	if key == "" {
+		if r.Method != http.MethodGet || r.URL.Query().Get("list-type") != "2" {
+			writeError(w, http.StatusNotImplemented, "NotImplemented")
+			return
+		}
+		s.list(w, bucket, r.URL.Query().Get("prefix"), r.URL.Query().Get("continuation-token"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		bucket[key] = object{data: data, lastModified: time.Now().UTC()}
+	case http.MethodGet, http.MethodHead:
+		o, ok := bucket[key]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Write(o.data)
+		}
+	case http.MethodDelete:
+		delete(bucket, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (s *Server) list(w http.ResponseWriter, bucket map[string]object, prefix string, token string) {
+	keys := make([]string, 0)
+	for k := range bucket {
+		if strings.HasPrefix(k, prefix) && k > token {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	result := listBucketResult{}
+	if len(keys) > s.PageSize {
+		keys = keys[:s.PageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = keys[len(keys)-1]
+	}
+	for _, k := range keys {
+		result.Contents = append(result.Contents, listBucketKey{
+			Key:          k,
+			Size:         int64(len(bucket[k].data)),
+			LastModified: bucket[k].lastModified,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: code})
+}
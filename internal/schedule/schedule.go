@@ -0,0 +1,164 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/robfig/cron/v3"
+)
+
+// Upper bound when counting missed runs to avoid looping forever on very frequent schedules
+const MAX_MISSED_RUNS_COUNT = 1000
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+func (s *Schedule) IsEnabled() bool {
+	return s.Cron != "" || s.Interval != ""
+}
+
+func (s *Schedule) GetCatchUpPolicy() string {
+	if s.CatchUp == "" {
+		return CatchUpOnce
+	}
+
+	return s.CatchUp
+}
+
+func (s *Schedule) Valid() error {
+	var objErrors *multierror.Error
+
+	if s.Cron != "" {
+		if _, err := cronParser.Parse(s.Cron); err != nil {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("invalid cron expression '%s': %w", s.Cron, err))
+		}
+	}
+	if s.Interval != "" {
+		interval, err := time.ParseDuration(s.Interval)
+		if err != nil {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("invalid interval '%s': %w", s.Interval, err))
+		} else if interval <= 0 {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("interval must be positive"))
+		}
+	}
+	for _, w := range s.Windows {
+		if _, _, err := parseWindow(w); err != nil {
+			objErrors = multierror.Append(objErrors, err)
+		}
+	}
+	switch s.GetCatchUpPolicy() {
+	case CatchUpSkip, CatchUpOnce:
+	default:
+		objErrors = multierror.Append(objErrors, fmt.Errorf("unknown catch up policy '%s'", s.CatchUp))
+	}
+	copyTargets := make(map[string]bool)
+	for _, r := range s.CopyTo {
+		if r == "" {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("empty repository name in copy targets"))
+		} else if r == s.Repository {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("backup repository '%s' cannot be a copy target", r))
+		} else if copyTargets[r] {
+			objErrors = multierror.Append(objErrors, fmt.Errorf("repository '%s' is listed several times in copy targets", r))
+		}
+		copyTargets[r] = true
+	}
+
+	return objErrors.ErrorOrNil()
+}
+
+// Next returns the first planned run strictly after the specified time
+func (s *Schedule) Next(after time.Time) (time.Time, error) {
+	if s.Cron != "" {
+		sched, err := cronParser.Parse(s.Cron)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse cron expression: %w", err)
+		}
+		return sched.Next(after), nil
+	}
+
+	if s.Interval != "" {
+		interval, err := time.ParseDuration(s.Interval)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot parse interval: %w", err)
+		}
+		if interval <= 0 {
+			return time.Time{}, fmt.Errorf("interval must be positive")
+		}
+		return after.Add(interval), nil
+	}
+
+	return time.Time{}, fmt.Errorf("schedule has neither cron expression nor interval defined")
+}
+
+// MissedRuns counts the planned runs between lastRun (excluded) and now (included)
+func (s *Schedule) MissedRuns(lastRun time.Time, now time.Time) (int, error) {
+	count := 0
+	next := lastRun
+	for count < MAX_MISSED_RUNS_COUNT {
+		n, err := s.Next(next)
+		if err != nil {
+			return 0, err
+		}
+		if n.After(now) {
+			break
+		}
+		count++
+		next = n
+	}
+
+	return count, nil
+}
+
+// InWindow checks if backups are allowed to start at specified time. A schedule without windows is always open
+func (s *Schedule) InWindow(t time.Time) (bool, error) {
+	if len(s.Windows) == 0 {
+		return true, nil
+	}
+
+	minutes := t.Hour()*60 + t.Minute()
+	for _, w := range s.Windows {
+		start, end, err := parseWindow(w)
+		if err != nil {
+			return false, err
+		}
+
+		if start <= end {
+			if minutes >= start && minutes < end {
+				return true, nil
+			}
+		} else {
+			// Window spanning over midnight
+			if minutes >= start || minutes < end {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// parseWindow returns window boundaries as minutes since midnight
+func parseWindow(w string) (int, int, error) {
+	rawStart, rawEnd, found := strings.Cut(w, "-")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid window '%s': expected 'HH:MM-HH:MM' format", w)
+	}
+
+	start, err := time.Parse("15:04", strings.TrimSpace(rawStart))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid window start '%s': %w", rawStart, err)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(rawEnd))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid window end '%s': %w", rawEnd, err)
+	}
+
+	startMinutes := start.Hour()*60 + start.Minute()
+	endMinutes := end.Hour()*60 + end.Minute()
+	if startMinutes == endMinutes {
+		return 0, 0, fmt.Errorf("invalid window '%s': start and end are identical", w)
+	}
+
+	return startMinutes, endMinutes, nil
+}
@@ -0,0 +1,266 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_Valid(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		wantErr  bool
+	}{
+		{
+			name:     "cron",
+			schedule: Schedule{Cron: "0 3 * * *"},
+			wantErr:  false,
+		},
+		{
+			name:     "cron_descriptor",
+			schedule: Schedule{Cron: "@daily"},
+			wantErr:  false,
+		},
+		{
+			name:     "invalid_cron",
+			schedule: Schedule{Cron: "0 3 * *"},
+			wantErr:  true,
+		},
+		{
+			name:     "interval",
+			schedule: Schedule{Interval: "6h"},
+			wantErr:  false,
+		},
+		{
+			name:     "invalid_interval",
+			schedule: Schedule{Interval: "6 hours"},
+			wantErr:  true,
+		},
+		{
+			name:     "negative_interval",
+			schedule: Schedule{Interval: "-1h"},
+			wantErr:  true,
+		},
+		{
+			name:     "windows",
+			schedule: Schedule{Interval: "1h", Windows: []string{"22:00-06:00", "12:00-13:00"}},
+			wantErr:  false,
+		},
+		{
+			name:     "invalid_window",
+			schedule: Schedule{Interval: "1h", Windows: []string{"22:00"}},
+			wantErr:  true,
+		},
+		{
+			name:     "empty_window",
+			schedule: Schedule{Interval: "1h", Windows: []string{"22:00-22:00"}},
+			wantErr:  true,
+		},
+		{
+			name:     "catch_up_skip",
+			schedule: Schedule{Interval: "1h", CatchUp: CatchUpSkip},
+			wantErr:  false,
+		},
+		{
+			name:     "unknown_catch_up",
+			schedule: Schedule{Interval: "1h", CatchUp: "pouet"},
+			wantErr:  true,
+		},
+		{
+			name:     "copy_to",
+			schedule: Schedule{Interval: "1h", Repository: "local", CopyTo: []string{"offsite", "cloud"}},
+			wantErr:  false,
+		},
+		{
+			name:     "copy_to_backup_repository",
+			schedule: Schedule{Interval: "1h", Repository: "local", CopyTo: []string{"local"}},
+			wantErr:  true,
+		},
+		{
+			name:     "copy_to_duplicate",
+			schedule: Schedule{Interval: "1h", CopyTo: []string{"offsite", "offsite"}},
+			wantErr:  true,
+		},
+		{
+			name:     "copy_to_empty",
+			schedule: Schedule{Interval: "1h", CopyTo: []string{""}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.schedule.Valid(); (err != nil) != tt.wantErr {
+				t.Errorf("Valid() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	ref := time.Date(2024, 1, 10, 12, 30, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		schedule Schedule
+		after    time.Time
+		want     time.Time
+		wantErr  bool
+	}{
+		{
+			name:     "cron",
+			schedule: Schedule{Cron: "0 3 * * *"},
+			after:    ref,
+			want:     time.Date(2024, 1, 11, 3, 0, 0, 0, time.Local),
+			wantErr:  false,
+		},
+		{
+			name:     "interval",
+			schedule: Schedule{Interval: "6h"},
+			after:    ref,
+			want:     ref.Add(6 * time.Hour),
+			wantErr:  false,
+		},
+		{
+			name:     "cron_has_priority",
+			schedule: Schedule{Cron: "0 13 * * *", Interval: "6h"},
+			after:    ref,
+			want:     time.Date(2024, 1, 10, 13, 0, 0, 0, time.Local),
+			wantErr:  false,
+		},
+		{
+			name:     "disabled",
+			schedule: Schedule{},
+			after:    ref,
+			want:     time.Time{},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.schedule.Next(tt.after)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Next() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("Next() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedule_MissedRuns(t *testing.T) {
+	ref := time.Date(2024, 1, 10, 12, 30, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		schedule Schedule
+		lastRun  time.Time
+		now      time.Time
+		want     int
+	}{
+		{
+			name:     "none_missed",
+			schedule: Schedule{Interval: "6h"},
+			lastRun:  ref,
+			now:      ref.Add(5 * time.Hour),
+			want:     0,
+		},
+		{
+			name:     "several_missed",
+			schedule: Schedule{Interval: "6h"},
+			lastRun:  ref,
+			now:      ref.Add(25 * time.Hour),
+			want:     4,
+		},
+		{
+			name:     "cron_missed",
+			schedule: Schedule{Cron: "@daily"},
+			lastRun:  ref,
+			now:      ref.AddDate(0, 0, 3),
+			want:     3,
+		},
+		{
+			name:     "capped",
+			schedule: Schedule{Interval: "1m"},
+			lastRun:  ref,
+			now:      ref.AddDate(1, 0, 0),
+			want:     MAX_MISSED_RUNS_COUNT,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.schedule.MissedRuns(tt.lastRun, tt.now)
+			if err != nil {
+				t.Errorf("MissedRuns() error = %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("MissedRuns() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedule_InWindow(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []string
+		time    time.Time
+		want    bool
+	}{
+		{
+			name:    "no_windows",
+			windows: []string{},
+			time:    time.Date(2024, 1, 10, 12, 30, 0, 0, time.Local),
+			want:    true,
+		},
+		{
+			name:    "inside",
+			windows: []string{"12:00-13:00"},
+			time:    time.Date(2024, 1, 10, 12, 30, 0, 0, time.Local),
+			want:    true,
+		},
+		{
+			name:    "outside",
+			windows: []string{"12:00-13:00"},
+			time:    time.Date(2024, 1, 10, 13, 0, 0, 0, time.Local),
+			want:    false,
+		},
+		{
+			name:    "over_midnight_before",
+			windows: []string{"22:00-06:00"},
+			time:    time.Date(2024, 1, 10, 23, 0, 0, 0, time.Local),
+			want:    true,
+		},
+		{
+			name:    "over_midnight_after",
+			windows: []string{"22:00-06:00"},
+			time:    time.Date(2024, 1, 10, 5, 59, 0, 0, time.Local),
+			want:    true,
+		},
+		{
+			name:    "over_midnight_outside",
+			windows: []string{"22:00-06:00"},
+			time:    time.Date(2024, 1, 10, 12, 0, 0, 0, time.Local),
+			want:    false,
+		},
+		{
+			name:    "multiple_windows",
+			windows: []string{"22:00-06:00", "12:00-13:00"},
+			time:    time.Date(2024, 1, 10, 12, 10, 0, 0, time.Local),
+			want:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Schedule{Interval: "1h", Windows: tt.windows}
+			got, err := s.InWindow(tt.time)
+			if err != nil {
+				t.Errorf("InWindow() error = %v", err)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("InWindow() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
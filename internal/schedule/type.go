@@ -0,0 +1,36 @@
+package schedule
+
+import (
+	"log/slog"
+)
+
+const (
+	// Do not run missed backups, wait for the next planned run
+	CatchUpSkip = "skip"
+	// Run a single backup to catch up, whatever the number of missed runs
+	CatchUpOnce = "once"
+)
+
+type Schedule struct {
+	// Cron expression (standard 5 fields format or descriptors like @daily)
+	Cron string `json:"cron" toml:"cron"`
+	// Fixed interval between two backups (Go duration format, ie '6h' or '90m'). Ignored if cron is set
+	Interval string `json:"interval" toml:"interval"`
+	// Time windows during which backups are allowed to start ('HH:MM-HH:MM'). Windows can span over midnight
+	Windows []string `json:"windows" toml:"windows"`
+	// Policy to apply when runs have been missed (server downtime, closed windows)
+	CatchUp string `json:"catch_up" toml:"catch_up"`
+	// Repository to store backups in. Default repository is used if empty
+	Repository string `json:"repository" toml:"repository"`
+	// Repositories the backup image is copied to once the backup is done
+	CopyTo []string `json:"copy_to" toml:"copy_to"`
+}
+
+func (s *Schedule) GetLog() *slog.Logger {
+	return slog.With(
+		slog.String("cron", s.Cron),
+		slog.String("interval", s.Interval),
+		slog.Any("windows", s.Windows),
+		slog.String("catch_up", s.GetCatchUpPolicy()),
+	)
+}
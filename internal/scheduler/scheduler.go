@@ -0,0 +1,369 @@
+package scheduler
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+
+	"github.com/macarrie/relique/api"
+	"github.com/macarrie/relique/internal/api_helpers"
+	"github.com/macarrie/relique/internal/client"
+	"github.com/macarrie/relique/internal/config"
+	"github.com/macarrie/relique/internal/image"
+	"github.com/macarrie/relique/internal/job"
+	"github.com/macarrie/relique/internal/job_status"
+	"github.com/macarrie/relique/internal/module"
+	"github.com/macarrie/relique/internal/repo"
+	"github.com/macarrie/relique/internal/schedule"
+)
+
+var TICK_INTERVAL = 30 * time.Second
+
+type task struct {
+	Name     string
+	Schedule schedule.Schedule
+	NextRun  time.Time
+	Running  bool
+	Run      func() error
+}
+
+func (t *task) GetLog() *slog.Logger {
+	return slog.With(
+		slog.String("task", t.Name),
+		slog.Time("next_run", t.NextRun),
+		slog.Bool("running", t.Running),
+	)
+}
+
+var tasks []*task
+var mutex sync.Mutex
+var stop chan struct{}
+var done chan struct{}
+
+// Start registers scheduled tasks (module backups from client configuration and repository maintenance tasks), computes their next runs (catching up missed runs if needed) and starts the scheduling loop in background
+func Start(cfg config.Configuration) error {
+	if stop != nil {
+		return fmt.Errorf("scheduler is already running")
+	}
+
+	now := time.Now()
+	mutex.Lock()
+	tasks = make([]*task, 0)
+	for _, cl := range cfg.Clients {
+		for _, mod := range cl.Modules {
+			if !mod.Schedule.IsEnabled() {
+				continue
+			}
+
+			if t, err := newBackupTask(cl, mod, now); err != nil {
+				slog.With(
+					slog.Any("error", err),
+					slog.String("client", cl.Name),
+					slog.String("module", mod.Name),
+				).Error("Cannot schedule module backup. This module will not be scheduled")
+			} else {
+				register(t)
+			}
+		}
+	}
+
+	if t, err := newPruneTask(cfg.PruneSchedule, now); err != nil {
+		slog.With(
+			slog.Any("error", err),
+		).Error("Cannot schedule image pruning. Retention policies will not be applied automatically")
+	} else {
+		register(t)
+	}
+
+	if t, err := newScrubTask(cfg.ScrubSchedule, now); err != nil {
+		slog.With(
+			slog.Any("error", err),
+		).Error("Cannot schedule image scrubbing. Image integrity will not be verified automatically")
+	} else {
+		register(t)
+	}
+	mutex.Unlock()
+
+	stop = make(chan struct{})
+	done = make(chan struct{})
+	go loop()
+
+	slog.With(
+		slog.Int("scheduled_tasks", len(tasks)),
+	).Info("Scheduler started")
+	return nil
+}
+
+func Stop() {
+	if stop == nil {
+		return
+	}
+
+	slog.Info("Stopping scheduler")
+	close(stop)
+	<-done
+	stop = nil
+	done = nil
+}
+
+func register(t *task) {
+	t.GetLog().Info("Scheduled task")
+	tasks = append(tasks, t)
+}
+
+func newBackupTask(cl client.Client, mod module.Module, now time.Time) (*task, error) {
+	lastRun, err := job.GetLastBackupStartTime(cl.Name, mod.Name)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("client", cl.Name),
+			slog.String("module", mod.Name),
+		).Error("Cannot get last backup time for scheduled module, ignoring previous runs")
+	}
+
+	nextRun, err := planFirstRun(mod.Schedule, lastRun, now)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute next run: %w", err)
+	}
+
+	return &task{
+		Name:     fmt.Sprintf("backup %s/%s", cl.Name, mod.Name),
+		Schedule: mod.Schedule,
+		NextRun:  nextRun,
+		Run: func() error {
+			return startBackup(cl, mod)
+		},
+	}, nil
+}
+
+func newPruneTask(cron string, now time.Time) (*task, error) {
+	if cron == "" {
+		cron = config.PRUNE_DEFAULT_SCHEDULE
+	}
+
+	s := schedule.Schedule{Cron: cron, CatchUp: schedule.CatchUpSkip}
+	if err := s.Valid(); err != nil {
+		return nil, fmt.Errorf("invalid prune schedule: %w", err)
+	}
+	nextRun, err := s.Next(now)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute next run: %w", err)
+	}
+
+	return &task{
+		Name:     "prune",
+		Schedule: s,
+		NextRun:  nextRun,
+		Run: func() error {
+			pruned, err := api.ImagePrune("", "", false)
+			slog.With(
+				slog.Int("pruned_images", len(pruned)),
+			).Info("Retention policies applied")
+			return err
+		},
+	}, nil
+}
+
+func newScrubTask(cron string, now time.Time) (*task, error) {
+	if cron == "" {
+		cron = config.SCRUB_DEFAULT_SCHEDULE
+	}
+
+	s := schedule.Schedule{Cron: cron, CatchUp: schedule.CatchUpSkip}
+	if err := s.Valid(); err != nil {
+		return nil, fmt.Errorf("invalid scrub schedule: %w", err)
+	}
+	nextRun, err := s.Next(now)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compute next run: %w", err)
+	}
+
+	return &task{
+		Name:     "scrub",
+		Schedule: s,
+		NextRun:  nextRun,
+		Run: func() error {
+			verifications, err := api.ImageScrub()
+			failed := 0
+			for _, v := range verifications {
+				if v.Status != image.VerificationOK {
+					failed++
+				}
+			}
+			slog.With(
+				slog.Int("verified_images", len(verifications)),
+				slog.Int("failed_images", failed),
+			).Info("Image integrity verified")
+			return err
+		},
+	}, nil
+}
+
+// planFirstRun computes the first run of a schedule after scheduler startup, applying catch up policy if runs were missed since last run
+func planFirstRun(s schedule.Schedule, lastRun time.Time, now time.Time) (time.Time, error) {
+	if lastRun.IsZero() {
+		return s.Next(now)
+	}
+
+	missed, err := s.MissedRuns(lastRun, now)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("cannot count missed runs: %w", err)
+	}
+	if missed == 0 {
+		return s.Next(lastRun)
+	}
+
+	logger := s.GetLog().With(
+		slog.Int("missed_runs", missed),
+		slog.Time("last_run", lastRun),
+	)
+	switch s.GetCatchUpPolicy() {
+	case schedule.CatchUpOnce:
+		logger.Warn("Missed scheduled runs detected, catching up with a single run")
+		return now, nil
+	case schedule.CatchUpSkip:
+		logger.Warn("Missed scheduled runs detected, skipping them as specified by catch up policy")
+		return s.Next(now)
+	default:
+		return time.Time{}, fmt.Errorf("unknown catch up policy '%s'", s.CatchUp)
+	}
+}
+
+func loop() {
+	defer close(done)
+
+	ticker := time.NewTicker(TICK_INTERVAL)
+	defer ticker.Stop()
+
+	tick(time.Now())
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			tick(now)
+		}
+	}
+}
+
+func tick(now time.Time) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, t := range tasks {
+		due, err := t.isDue(now)
+		if err != nil {
+			t.GetLog().With(slog.Any("error", err)).Error("Cannot check if scheduled task is due")
+			continue
+		}
+		if !due {
+			continue
+		}
+
+		t.Running = true
+		go run(t)
+	}
+}
+
+// isDue checks if task has to be started at specified time. Runs due while schedule windows are closed are either held until the next window opening or skipped, depending on schedule catch up policy
+func (t *task) isDue(now time.Time) (bool, error) {
+	if t.Running || t.NextRun.IsZero() || now.Before(t.NextRun) {
+		return false, nil
+	}
+
+	open, err := t.Schedule.InWindow(now)
+	if err != nil {
+		return false, fmt.Errorf("cannot check schedule time windows: %w", err)
+	}
+	if open {
+		return true, nil
+	}
+
+	switch t.Schedule.GetCatchUpPolicy() {
+	case schedule.CatchUpOnce:
+		// Run is held until the next window opening and then run once
+		return false, nil
+	case schedule.CatchUpSkip:
+		t.GetLog().Info("Scheduled run is outside of schedule windows, skipping it as specified by catch up policy")
+		t.planNextRun(now)
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown catch up policy '%s'", t.Schedule.CatchUp)
+	}
+}
+
+// planNextRun sets task next run after specified time. Tasks whose next run cannot be computed are disabled with a zero next run
+func (t *task) planNextRun(after time.Time) {
+	nextRun, err := t.Schedule.Next(after)
+	if err != nil {
+		t.GetLog().With(slog.Any("error", err)).Error("Cannot compute next run for scheduled task. This task will not be run anymore")
+		nextRun = time.Time{}
+	}
+	t.NextRun = nextRun
+}
+
+func run(t *task) {
+	t.GetLog().Info("Starting scheduled task")
+
+	if err := t.Run(); err != nil {
+		t.GetLog().With(slog.Any("error", err)).Error("Error during scheduled task")
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	t.Running = false
+	t.planNextRun(time.Now())
+	t.GetLog().Info("Scheduled task")
+}
+
+func startBackup(c client.Client, m module.Module) error {
+	var r repo.Repository
+	var err error
+	if m.Schedule.Repository == "" {
+		r, err = repo.GetDefault(config.Current.Repositories)
+	} else {
+		r, err = repo.GetByName(config.Current.Repositories, m.Schedule.Repository)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot get repository for scheduled backup: %w", err)
+	}
+
+	j, err := api.BackupStart(c, m, r)
+	if err != nil {
+		return err
+	}
+
+	if len(m.Schedule.CopyTo) == 0 {
+		return nil
+	}
+	if j.Status.Status != job_status.Success && j.Status.Status != job_status.Incomplete {
+		j.GetLog().Warn("No image generated by scheduled backup, image copies are skipped")
+		return nil
+	}
+
+	return copyImage(j.Uuid, m.Schedule.CopyTo)
+}
+
+// copyImage replicates an image into each listed repository, one copy after another
+func copyImage(imageUuid string, repoNames []string) error {
+	var errorList *multierror.Error
+	for _, name := range repoNames {
+		img, r, err := api.CopyResolve(api_helpers.CopyParams{
+			ImageUuid: imageUuid,
+			RepoName:  name,
+		})
+		if err != nil {
+			errorList = multierror.Append(errorList, fmt.Errorf("cannot prepare copy to repository '%s': %w", name, err))
+			continue
+		}
+
+		if err := api.CopyStart(img, r); err != nil {
+			errorList = multierror.Append(errorList, fmt.Errorf("cannot copy image to repository '%s': %w", name, err))
+		}
+	}
+
+	return errorList.ErrorOrNil()
+}
@@ -0,0 +1,255 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/macarrie/relique/internal/schedule"
+)
+
+func TestPlanFirstRun(t *testing.T) {
+	now := time.Date(2024, 1, 10, 12, 30, 0, 0, time.Local)
+	tests := []struct {
+		name     string
+		schedule schedule.Schedule
+		lastRun  time.Time
+		want     time.Time
+		wantErr  bool
+	}{
+		{
+			name:     "never_run",
+			schedule: schedule.Schedule{Cron: "0 3 * * *"},
+			lastRun:  time.Time{},
+			want:     time.Date(2024, 1, 11, 3, 0, 0, 0, time.Local),
+			wantErr:  false,
+		},
+		{
+			name:     "no_missed_run",
+			schedule: schedule.Schedule{Interval: "6h"},
+			lastRun:  now.Add(-time.Hour),
+			want:     now.Add(5 * time.Hour),
+			wantErr:  false,
+		},
+		{
+			name:     "missed_runs_catch_up_once",
+			schedule: schedule.Schedule{Cron: "0 3 * * *", CatchUp: schedule.CatchUpOnce},
+			lastRun:  time.Date(2024, 1, 7, 3, 0, 0, 0, time.Local),
+			want:     now,
+			wantErr:  false,
+		},
+		{
+			name:     "missed_runs_default_catch_up",
+			schedule: schedule.Schedule{Cron: "0 3 * * *"},
+			lastRun:  time.Date(2024, 1, 7, 3, 0, 0, 0, time.Local),
+			want:     now,
+			wantErr:  false,
+		},
+		{
+			name:     "missed_runs_skipped",
+			schedule: schedule.Schedule{Cron: "0 3 * * *", CatchUp: schedule.CatchUpSkip},
+			lastRun:  time.Date(2024, 1, 7, 3, 0, 0, 0, time.Local),
+			want:     time.Date(2024, 1, 11, 3, 0, 0, 0, time.Local),
+			wantErr:  false,
+		},
+		{
+			name:     "unknown_catch_up_policy",
+			schedule: schedule.Schedule{Cron: "0 3 * * *", CatchUp: "unknown"},
+			lastRun:  time.Date(2024, 1, 7, 3, 0, 0, 0, time.Local),
+			want:     time.Time{},
+			wantErr:  true,
+		},
+		{
+			name:     "invalid_schedule",
+			schedule: schedule.Schedule{Cron: "invalid"},
+			lastRun:  time.Date(2024, 1, 7, 3, 0, 0, 0, time.Local),
+			want:     time.Time{},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planFirstRun(tt.schedule, tt.lastRun, now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("planFirstRun() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("planFirstRun() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTask_IsDue(t *testing.T) {
+	due := time.Date(2024, 1, 10, 3, 0, 0, 0, time.Local)
+	night := []string{"22:00-06:00"}
+	tests := []struct {
+		name        string
+		task        task
+		now         time.Time
+		want        bool
+		wantNextRun time.Time
+		wantErr     bool
+	}{
+		{
+			name:        "not_due",
+			task:        task{Schedule: schedule.Schedule{Cron: "0 3 * * *"}, NextRun: due},
+			now:         due.Add(-time.Minute),
+			want:        false,
+			wantNextRun: due,
+			wantErr:     false,
+		},
+		{
+			name:        "due",
+			task:        task{Schedule: schedule.Schedule{Cron: "0 3 * * *"}, NextRun: due},
+			now:         due,
+			want:        true,
+			wantNextRun: due,
+			wantErr:     false,
+		},
+		{
+			name:        "running",
+			task:        task{Schedule: schedule.Schedule{Cron: "0 3 * * *"}, NextRun: due, Running: true},
+			now:         due,
+			want:        false,
+			wantNextRun: due,
+			wantErr:     false,
+		},
+		{
+			name:        "disabled",
+			task:        task{Schedule: schedule.Schedule{Cron: "0 3 * * *"}},
+			now:         due,
+			want:        false,
+			wantNextRun: time.Time{},
+			wantErr:     false,
+		},
+		{
+			name:        "window_open",
+			task:        task{Schedule: schedule.Schedule{Cron: "0 3 * * *", Windows: night}, NextRun: due},
+			now:         due,
+			want:        true,
+			wantNextRun: due,
+			wantErr:     false,
+		},
+		{
+			name:        "window_closed_held",
+			task:        task{Schedule: schedule.Schedule{Cron: "0 3 * * *", Windows: night, CatchUp: schedule.CatchUpOnce}, NextRun: due},
+			now:         due.Add(5 * time.Hour),
+			want:        false,
+			wantNextRun: due,
+			wantErr:     false,
+		},
+		{
+			name:        "held_run_at_window_opening",
+			task:        task{Schedule: schedule.Schedule{Cron: "0 3 * * *", Windows: night, CatchUp: schedule.CatchUpOnce}, NextRun: due},
+			now:         due.Add(19 * time.Hour),
+			want:        true,
+			wantNextRun: due,
+			wantErr:     false,
+		},
+		{
+			name:        "window_closed_skipped",
+			task:        task{Schedule: schedule.Schedule{Cron: "0 3 * * *", Windows: night, CatchUp: schedule.CatchUpSkip}, NextRun: due},
+			now:         due.Add(5 * time.Hour),
+			want:        false,
+			wantNextRun: due.AddDate(0, 0, 1),
+			wantErr:     false,
+		},
+		{
+			name:        "window_closed_skipped_invalid_schedule",
+			task:        task{Schedule: schedule.Schedule{Cron: "invalid", Windows: night, CatchUp: schedule.CatchUpSkip}, NextRun: due},
+			now:         due.Add(5 * time.Hour),
+			want:        false,
+			wantNextRun: time.Time{},
+			wantErr:     false,
+		},
+		{
+			name:        "invalid_window",
+			task:        task{Schedule: schedule.Schedule{Cron: "0 3 * * *", Windows: []string{"invalid"}}, NextRun: due},
+			now:         due,
+			want:        false,
+			wantNextRun: due,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.task.isDue(tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("isDue() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("isDue() got = %v, want %v", got, tt.want)
+			}
+			if !tt.task.NextRun.Equal(tt.wantNextRun) {
+				t.Errorf("isDue() next run = %v, want %v", tt.task.NextRun, tt.wantNextRun)
+			}
+		})
+	}
+}
+
+func TestTick(t *testing.T) {
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.Local)
+	ran := make(chan string, 3)
+	newTask := func(name string, nextRun time.Time, windows []string) *task {
+		return &task{
+			Name:     name,
+			Schedule: schedule.Schedule{Interval: "1h", Windows: windows},
+			NextRun:  nextRun,
+			Run: func() error {
+				ran <- name
+				return nil
+			},
+		}
+	}
+
+	mutex.Lock()
+	tasks = []*task{
+		newTask("due", now.Add(-time.Minute), nil),
+		newTask("not_due", now.Add(time.Minute), nil),
+		newTask("disabled", time.Time{}, nil),
+		newTask("window_closed", now.Add(-time.Minute), []string{"22:00-06:00"}),
+	}
+	mutex.Unlock()
+	t.Cleanup(func() {
+		mutex.Lock()
+		tasks = nil
+		mutex.Unlock()
+	})
+
+	tick(now)
+	select {
+	case name := <-ran:
+		if name != "due" {
+			t.Errorf("tick() ran task '%s', want 'due'", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("tick() did not run due task")
+	}
+
+	// Wait for run to plan next run of finished task
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mutex.Lock()
+		running := tasks[0].Running
+		nextRun := tasks[0].NextRun
+		mutex.Unlock()
+		if !running {
+			if !nextRun.After(now) {
+				t.Errorf("next run after tick = %v, want planned after %v", nextRun, now)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("task still running after tick")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	select {
+	case name := <-ran:
+		t.Errorf("tick() ran task '%s', want only 'due' task run", name)
+	default:
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/macarrie/relique/api"
+	"github.com/macarrie/relique/internal/api_helpers"
+)
+
+func webAPIStartBackup(c *gin.Context) {
+	var params api_helpers.BackupParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		slog.With(
+			slog.Any("error", err),
+		).Error("Cannot parse backup parameters")
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	cl, mod, r, err := api.BackupResolve(params)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("client", params.ClientName),
+			slog.String("module", params.ModuleName),
+			slog.String("repository", params.RepoName),
+		).Error("Cannot prepare backup")
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	j, err := api.BackupStartAsync(cl, mod, r)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("client", cl.Name),
+			slog.String("module", mod.Name),
+			slog.String("repository", r.GetName()),
+		).Error("Cannot start backup job")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"uuid": j.Uuid,
+	})
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/macarrie/relique/api"
+	"github.com/macarrie/relique/internal/api_helpers"
+)
+
+func webAPIStartCopy(c *gin.Context) {
+	var params api_helpers.CopyParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		slog.With(
+			slog.Any("error", err),
+		).Error("Cannot parse copy parameters")
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	img, r, err := api.CopyResolve(params)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("image", params.ImageUuid),
+			slog.String("repository", params.RepoName),
+		).Error("Cannot prepare copy")
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	j, err := api.CopyStartAsync(img, r)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("image", img.Uuid),
+			slog.String("repository", r.GetName()),
+		).Error("Cannot start copy job")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"uuid": j.Uuid,
+	})
+}
@@ -1,8 +1,12 @@
 package server
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"os"
 
 	"github.com/gin-gonic/gin"
 	"github.com/macarrie/relique/api"
@@ -39,6 +43,165 @@ func webAPIGetImage(c *gin.Context) {
 	c.JSON(http.StatusOK, img)
 }
 
+func webAPIListImageFiles(c *gin.Context) {
+	uuid := c.Param("uuid")
+	path := c.DefaultQuery("path", "/")
+	page := getPagination(c)
+
+	if _, err := api.ImageGet(uuid); err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+		).Error("Cannot find image in database")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	files, err := api.ImageListFiles(uuid, path, page)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+			slog.String("path", path),
+		).Error("Cannot list image files")
+		if errors.Is(err, image.ErrInvalidPath) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, files)
+}
+
+// webAPIDownloadImageFile streams a single file from an image, or an archive if the requested path is a folder
+func webAPIDownloadImageFile(c *gin.Context) {
+	uuid := c.Param("uuid")
+	path := c.DefaultQuery("path", "/")
+	format := c.DefaultQuery("format", image.ArchiveFormatTar)
+	logger := slog.With(
+		slog.String("uuid", uuid),
+		slog.String("path", path),
+	)
+
+	img, err := api.ImageGet(uuid)
+	if err != nil {
+		logger.With(slog.Any("error", err)).Error("Cannot find image in database")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	info, err := img.GetFileInfo(path)
+	if err != nil {
+		logger.With(slog.Any("error", err)).Error("Cannot get image file info")
+		if errors.Is(err, image.ErrRemoteRepository) {
+			c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, image.ErrInvalidPath) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, os.ErrNotExist) {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if info.IsDir {
+		var contentType string
+		switch format {
+		case image.ArchiveFormatTar:
+			contentType = "application/x-tar"
+		case image.ArchiveFormatZip:
+			contentType = "application/zip"
+		default:
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("unknown archive format '%s'", format),
+			})
+			return
+		}
+
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": fmt.Sprintf("%s.%s", img.GetArchiveRootName(path), format),
+		}))
+		c.Status(http.StatusOK)
+		if err := img.WriteArchive(c.Writer, path, format); err != nil {
+			// Headers are already sent, the client gets a truncated archive
+			logger.With(slog.Any("error", err)).Error("Cannot write image folder archive")
+		}
+		return
+	}
+
+	f, err := img.OpenFile(path)
+	if err != nil {
+		logger.With(slog.Any("error", err)).Error("Cannot open image file")
+		if errors.Is(err, image.ErrInvalidPath) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": info.Name,
+	}))
+	http.ServeContent(c.Writer, c.Request, info.Name, info.ModTime, f)
+}
+
+func webAPIDiffImages(c *gin.Context) {
+	uuid := c.Param("uuid")
+	otherUuid := c.Param("other_uuid")
+
+	for _, u := range []string{uuid, otherUuid} {
+		if _, err := api.ImageGet(u); err != nil {
+			slog.With(
+				slog.Any("error", err),
+				slog.String("uuid", u),
+			).Error("Cannot find image in database")
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+	}
+
+	diff, err := api.ImageDiff(uuid, otherUuid)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("from", uuid),
+			slog.String("to", otherUuid),
+		).Error("Cannot compare images")
+		if errors.Is(err, image.ErrRemoteRepository) {
+			c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
 func webAPIGetImageStats(c *gin.Context) {
 	page := getPagination(c)
 	search := getImageSearchParams(c)
@@ -51,11 +214,87 @@ func webAPIGetImageStats(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}
 
-	totalSize := lo.SumBy(imgs.Data, func(img image.Image) uint64 {
-		return img.SizeOnDisk
-	})
+	// Images share hardlinked files or chunks: summing their sizes counts shared data several times
 	c.JSON(http.StatusOK, gin.H{
 		"count":      imgs.Count,
-		"total_size": totalSize,
+		"total_size": image.TotalSize(imgs.Data),
+		"data_size": lo.SumBy(imgs.Data, func(img image.Image) uint64 {
+			return img.SizeOnDisk
+		}),
+		"unique_size": lo.SumBy(imgs.Data, func(img image.Image) uint64 {
+			return img.UniqueSize
+		}),
+		"shared_size": lo.SumBy(imgs.Data, func(img image.Image) uint64 {
+			return img.SharedSize
+		}),
 	})
 }
+
+func webAPIDeleteImage(c *gin.Context) {
+	uuid := c.Param("uuid")
+	force := c.DefaultQuery("force", "false") == "true"
+
+	if _, err := api.ImageGet(uuid); err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+		).Error("Cannot find image in database")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if err := api.ImageDelete(uuid, force); err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+			slog.Bool("force", force),
+		).Error("Cannot delete image")
+		if errors.Is(err, api.ErrImageReferenced) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func webAPIVerifyImage(c *gin.Context) {
+	uuid := c.Param("uuid")
+
+	if _, err := api.ImageGet(uuid); err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+		).Error("Cannot find image in database")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	v, err := api.ImageVerify(uuid)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+		).Error("Cannot verify image")
+		if errors.Is(err, image.ErrRemoteRepository) {
+			c.AbortWithStatusJSON(http.StatusNotImplemented, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if errors.Is(err, image.ErrNoManifest) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, v)
+}
@@ -1,8 +1,12 @@
 package server
 
 import (
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/macarrie/relique/api"
@@ -36,3 +40,108 @@ func webAPIGetJob(c *gin.Context) {
 
 	c.JSON(http.StatusOK, job)
 }
+
+func webAPIGetJobChanges(c *gin.Context) {
+	uuid := c.Param("uuid")
+	page := getPagination(c)
+	changeType := c.DefaultQuery("type", "")
+
+	if _, err := api.JobGet(uuid); err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+		).Error("Cannot find job in database")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	changes, err := api.JobChanges(uuid, page, changeType)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+		).Error("Cannot get job changes")
+		if errors.Is(err, os.ErrNotExist) {
+			// Job is still running or has been run before change lists were recorded
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, changes)
+}
+
+var JOB_PROGRESS_REFRESH_INTERVAL = 1 * time.Second
+
+// webAPIGetJobProgress streams job progress as server-sent events until the job is done or the client disconnects
+func webAPIGetJobProgress(c *gin.Context) {
+	uuid := c.Param("uuid")
+	if _, err := api.JobGet(uuid); err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+		).Error("Cannot find job in database")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	ticker := time.NewTicker(JOB_PROGRESS_REFRESH_INTERVAL)
+	defer ticker.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		p, err := api.JobProgress(uuid)
+		if err != nil {
+			slog.With(
+				slog.Any("error", err),
+				slog.String("uuid", uuid),
+			).Error("Cannot get job progress")
+			c.SSEvent("error", gin.H{
+				"error": err.Error(),
+			})
+			return false
+		}
+
+		c.SSEvent("progress", p)
+		if p.Done {
+			return false
+		}
+
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+			return true
+		}
+	})
+}
+
+func webAPICancelJob(c *gin.Context) {
+	uuid := c.Param("uuid")
+	if _, err := api.JobGet(uuid); err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+		).Error("Cannot find job in database")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if err := api.JobCancel(uuid); err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("uuid", uuid),
+		).Error("Cannot cancel job")
+		if errors.Is(err, api.ErrJobNotRunning) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
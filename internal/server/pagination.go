@@ -52,6 +52,23 @@ func getImageSearchParams(c *gin.Context) api_helpers.ImageSearch {
 	return search
 }
 
+func getFileSearchParams(c *gin.Context) api_helpers.FileSearch {
+	search := api_helpers.FileSearch{}
+	query := c.Request.URL.Query()
+
+	if pattern, ok := query["pattern"]; ok {
+		search.Pattern = pattern[0]
+	}
+	if client, ok := query["client"]; ok {
+		search.ClientName = client[0]
+	}
+	if mod, ok := query["module"]; ok {
+		search.ModuleName = mod[0]
+	}
+
+	return search
+}
+
 func getPagination(c *gin.Context) api_helpers.PaginationParams {
 	limitFromQuery := c.DefaultQuery("limit", fmt.Sprintf("%d", DEFAULT_LIMIT))
 	limit, err := strconv.Atoi(limitFromQuery)
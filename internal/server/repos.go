@@ -18,7 +18,7 @@ func webAPIListRepos(c *gin.Context) {
 
 func webAPIGetRepo(c *gin.Context) {
 	name := c.Param("name")
-	repo, err := api.RepoGet(name)
+	details, err := api.RepoGetDetails(name)
 	if err != nil {
 		slog.With(
 			slog.Any("error", err),
@@ -27,5 +27,19 @@ func webAPIGetRepo(c *gin.Context) {
 		c.AbortWithStatus(http.StatusNotFound)
 		return
 	}
-	c.JSON(http.StatusOK, repo)
+	c.JSON(http.StatusOK, details)
+}
+
+func webAPIGetRepoStatus(c *gin.Context) {
+	name := c.Param("name")
+	status, err := api.RepoGetStatus(name)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("name", name),
+		).Error("Cannot find repo in config")
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	c.JSON(http.StatusOK, status)
 }
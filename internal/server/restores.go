@@ -0,0 +1,53 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/macarrie/relique/api"
+	"github.com/macarrie/relique/internal/api_helpers"
+)
+
+func webAPIStartRestore(c *gin.Context) {
+	var params api_helpers.RestoreParams
+	if err := c.ShouldBindJSON(&params); err != nil {
+		slog.With(
+			slog.Any("error", err),
+		).Error("Cannot parse restore parameters")
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	img, cl, err := api.RestoreResolve(params)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("image", params.ImageUuid),
+			slog.String("client", params.ClientName),
+		).Error("Cannot prepare restore")
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	j, err := api.RestoreStartAsync(cl, img, params.Paths)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("image", img.Uuid),
+			slog.String("client", cl.Name),
+		).Error("Cannot start restore job")
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"uuid": j.Uuid,
+	})
+}
@@ -45,6 +45,9 @@ func getRoutes() *gin.Engine {
 
 		v1.GET("/jobs", webAPIListJobs)
 		v1.GET("/jobs/:uuid", webAPIGetJob)
+		v1.GET("/jobs/:uuid/progress", webAPIGetJobProgress)
+		v1.GET("/jobs/:uuid/changes", webAPIGetJobChanges)
+		v1.POST("/jobs/:uuid/cancel", webAPICancelJob)
 
 		v1.GET("/clients", webAPIListClients)
 		v1.GET("/clients/:name", webAPIGetClient)
@@ -56,9 +59,21 @@ func getRoutes() *gin.Engine {
 		v1.GET("/images", webAPIListImages)
 		v1.GET("/images/:uuid", webAPIGetImage)
 		v1.GET("/images/stats", webAPIGetImageStats)
+		v1.GET("/images/:uuid/files", webAPIListImageFiles)
+		v1.GET("/images/:uuid/download", webAPIDownloadImageFile)
+		v1.GET("/images/:uuid/diff/:other_uuid", webAPIDiffImages)
+		v1.POST("/images/:uuid/verify", webAPIVerifyImage)
+		v1.DELETE("/images/:uuid", webAPIDeleteImage)
 
 		v1.GET("/repositories", webAPIListRepos)
 		v1.GET("/repositories/:name", webAPIGetRepo)
+		v1.GET("/repositories/:name/status", webAPIGetRepoStatus)
+
+		v1.POST("/backups", webAPIStartBackup)
+		v1.POST("/restores", webAPIStartRestore)
+		v1.POST("/copies", webAPIStartCopy)
+
+		v1.GET("/search", webAPISearch)
 
 	}
 
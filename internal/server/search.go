@@ -0,0 +1,33 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/macarrie/relique/api"
+)
+
+func webAPISearch(c *gin.Context) {
+	page := getPagination(c)
+	search := getFileSearchParams(c)
+
+	if search.Pattern == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "missing search pattern",
+		})
+		return
+	}
+
+	files, err := api.Search(page, search)
+	if err != nil {
+		slog.With(
+			slog.Any("error", err),
+			slog.String("pattern", search.Pattern),
+		).Error("Cannot search files")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, files)
+}
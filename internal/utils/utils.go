@@ -18,30 +18,50 @@ import (
 	"github.com/macarrie/relique/internal/repo"
 )
 
+func getRepository(r repo.Repository, repoName string) (repo.Repository, error) {
+	if r != nil {
+		return r, nil
+	}
+
+	if repoName == "" {
+		return nil, fmt.Errorf("cannot get storage path because used repository is unknown")
+	}
+
+	repoFromConfig, err := repo.GetByName(config.Current.Repositories, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get repository from configuration: %w", err)
+	}
+
+	return repoFromConfig, nil
+}
+
 func GetStoragePath(r repo.Repository, repoName string, uuid string) (string, error) {
-	var repository repo.Repository
+	repository, err := getRepository(r, repoName)
+	if err != nil {
+		return "", err
+	}
 
-	if r == nil {
-		if repoName == "" {
-			return "", fmt.Errorf("cannot get storage path because used repository is unknown")
-		}
+	storagePath := repository.GetStoragePath(uuid)
+	if storagePath == "" {
+		return "", fmt.Errorf("repo type not implemented")
+	}
 
-		repoFromConfig, err := repo.GetByName(config.Current.Repositories, repoName)
-		if err != nil {
-			return "", fmt.Errorf("cannot get repository from configuration: %w", err)
-		}
+	return storagePath, nil
+}
 
-		repository = repoFromConfig
-	} else {
-		repository = r
+// GetDestinationPath returns the local folder where job data and logs are written by rsync
+func GetDestinationPath(r repo.Repository, repoName string, uuid string) (string, error) {
+	repository, err := getRepository(r, repoName)
+	if err != nil {
+		return "", err
 	}
 
-	if repository.GetType() == "local" {
-		localRepo := repository.(*repo.RepositoryLocal)
-		return filepath.Clean(fmt.Sprintf("%s/%s/", localRepo.Path, uuid)), nil
+	destination := repository.GetDestination(uuid)
+	if destination == "" {
+		return "", fmt.Errorf("repo type not implemented")
 	}
 
-	return "", fmt.Errorf("repo type not implemented")
+	return destination, nil
 }
 
 func GetCatalogPath(uuid string) (string) {
@@ -56,6 +76,11 @@ func FormatDatetime(t time.Time) string {
 	return t.Format("2006/01/02 15:04:05")
 }
 
+// FormatPercent formats a ratio between 0 and 1 as a percentage
+func FormatPercent(ratio float64) string {
+	return fmt.Sprintf("%.1f%%", ratio*100)
+}
+
 func FormatDuration(d time.Duration) string {
 	return d.String()
 }
@@ -79,6 +104,7 @@ func RenderTemplateToMarkdown(templateName string, tpl string, data interface{})
 			"join":      strings.Join,
 			"file_size": humanize.Bytes,
 			"datetime":  FormatDatetime,
+			"percent":   FormatPercent,
 		}).Parse(tpl),
 	)
 	if err := t.Execute(&tplRender, data); err != nil {
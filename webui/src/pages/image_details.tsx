@@ -74,6 +74,22 @@ function ImageDetails() {
                                     <td>Size on disk</td>
                                     <td>{Utils.formatSize(img.size_on_disk)}</td>
                                 </tr>
+                                <tr>
+                                    <td>Unique size</td>
+                                    <td>{Utils.formatSize(img.unique_size)}</td>
+                                </tr>
+                                <tr>
+                                    <td>Shared with other images</td>
+                                    <td>{Utils.formatSize(img.shared_size)}</td>
+                                </tr>
+                                <tr>
+                                    <td>New data</td>
+                                    <td>{Utils.formatSize(img.new_data_size)}</td>
+                                </tr>
+                                <tr>
+                                    <td>Deduplication ratio</td>
+                                    <td>{(img.dedup_ratio * 100).toFixed(1)} %</td>
+                                </tr>
                                 <tr>
                                     <td>Number of elements (total)</td>
                                     <td>{img.number_of_elements}</td>
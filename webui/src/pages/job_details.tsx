@@ -3,6 +3,7 @@ import { Link, useParams } from "react-router-dom";
 import Card from '../components/card';
 import StatusBadge from '../components/status_badge';
 import Job from '../types/job';
+import JobProgress from '../types/job_progress';
 import Image from '../types/image';
 import API from '../utils/api';
 import JobUtils from '../utils/job';
@@ -15,6 +16,7 @@ function JobDetails() {
     const { job_uuid } = useParams();
     let [j, setJob] = useState<Job>({} as Job);
     let [img, setImage] = useState<Image>({} as Image);
+    let [progress, setProgress] = useState<JobProgress>({} as JobProgress);
 
     useEffect(() => {
         function getJob() {
@@ -34,6 +36,32 @@ function JobDetails() {
         getJob();
     }, [job_uuid])
 
+    useEffect(() => {
+        if (job_uuid === undefined || j.uuid === undefined || j.done) {
+            return;
+        }
+
+        const source = API.jobs.progress(job_uuid);
+        source.addEventListener("progress", (event: MessageEvent) => {
+            let p: JobProgress = JSON.parse(event.data);
+            setProgress(p);
+            if (p.done) {
+                source.close();
+                // Refresh job details to get final status and end time
+                API.jobs.get(job_uuid).then((response: any) => {
+                    setJob(response.data);
+                });
+            }
+        });
+        source.onerror = () => {
+            source.close();
+        };
+
+        return () => {
+            source.close();
+        };
+    }, [job_uuid, j.uuid, j.done])
+
     useEffect(() => {
         function getImage() {
             if (job_uuid === undefined) {
@@ -87,6 +115,12 @@ function JobDetails() {
                                     <td>Status</td>
                                     <td><StatusBadge label={j.status} status={JobUtils.jobStateToCode(j.status)} /></td>
                                 </tr>
+                                {j.status_message && (
+                                    <tr>
+                                        <td>Status details</td>
+                                        <td>{j.status_message}</td>
+                                    </tr>
+                                )}
                                 <tr>
                                     <td>Start time</td>
                                     <td>{Utils.formatDate(j.start_time)}</td>
@@ -107,6 +141,42 @@ function JobDetails() {
                 </div>
             </Card>
 
+            {!j.done && j.uuid && (
+                <Card>
+                    <div className="px-6 py-4 flex">
+                        <h3 className="flex-grow font-bold">
+                            Progress
+                        </h3>
+                        <span className="text-l ml-4">
+                            {(progress.progress ?? 0).toFixed(1)}%
+                        </span>
+                    </div>
+                    <div className="mx-6 mb-4">
+                        <progress className="progress progress-info w-full" value={progress.progress ?? 0} max="100"></progress>
+                    </div>
+                    <table className="table">
+                        <thead>
+                            <tr>
+                                <th>Backup path</th>
+                                <th>Progress</th>
+                                <th>Remaining elements</th>
+                                <th>Speed</th>
+                            </tr>
+                        </thead>
+                        <tbody>
+                            {(progress.tasks ?? []).map(t => (
+                                <tr key={t.backup_path}>
+                                    <td className="code">{t.backup_path}</td>
+                                    <td><progress className="progress w-32" value={t.progress} max="100"></progress></td>
+                                    <td>{t.elements_remaining} / {t.elements_count}</td>
+                                    <td>{t.transfer_speed || "---"}</td>
+                                </tr>
+                            ))}
+                        </tbody>
+                    </table>
+                </Card>
+            )}
+
             <Card>
                 <ImageList
                     title="Generated image"
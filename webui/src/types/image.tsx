@@ -11,7 +11,14 @@ type Image = {
     number_of_files: boolean,
     number_of_folders: string,
     size_on_disk: number,
+    unique_size: number,
+    shared_size: number,
+    new_data_size: number,
+    dedup_ratio: number,
     created_at: any,
+    verified_at: any,
+    verification_status: string,
+    source_image_uuid: string,
 };
 
 export default Image;
\ No newline at end of file
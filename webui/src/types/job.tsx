@@ -8,9 +8,11 @@ type Job = {
     module: Module,
     repository: Repository,
     status: string,
+    status_message: string,
     done: boolean,
     backup_type: string,
     job_type: string,
+    source_image_uuid: string,
     start_time: any,
     end_time: any,
 };
@@ -0,0 +1,19 @@
+type TaskProgress = {
+    backup_path: string,
+    progress: number,
+    elements_remaining: number,
+    elements_count: number,
+    transfer_speed: string,
+};
+
+type JobProgress = {
+    uuid: string,
+    status: string,
+    done: boolean,
+    progress: number,
+    tasks: TaskProgress[],
+    updated_at: any,
+};
+
+export type { TaskProgress };
+export default JobProgress;
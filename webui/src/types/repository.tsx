@@ -1,8 +1,38 @@
+type Capacity = {
+    total: number,
+    free: number,
+    quota: number,
+    used: number,
+    min_free_space: number,
+    available: number,
+};
+
 type Repository = {
     name: string,
     default: boolean,
     type: string,
     path?: string,
+    host?: string,
+    user?: string,
+    port?: number,
+    staging_path?: string,
+    endpoint?: string,
+    region?: string,
+    bucket?: string,
+    prefix?: string,
+    access_key?: string,
+    encryption_key_file?: string,
+    quota?: string,
+    min_free_space?: string,
+    space_check?: string,
+    space?: {
+        used: number,
+        free: number,
+        total: number,
+    },
+    space_error?: string,
+    capacity?: Capacity,
+    capacity_error?: string,
 };
 
 export default Repository;
\ No newline at end of file
@@ -30,6 +30,27 @@ export default class API {
             let sp = new URLSearchParams(params)
             return API.handler().get('/jobs/' + uuid + '/logs?' + sp.toString());
         },
+        changes: function (uuid: string, p = {}) {
+            return API.handler().get('/jobs/' + uuid + '/changes', { params: p });
+        },
+        progress: function (uuid: string) {
+            return new EventSource(axios.defaults.baseURL + 'jobs/' + uuid + '/progress');
+        },
+        cancel: function (uuid: string) {
+            return API.handler().post('/jobs/' + uuid + '/cancel');
+        },
+    };
+
+    static backups = {
+        start: function (params = {}) {
+            return API.handler().post('/backups', params);
+        },
+    };
+
+    static restores = {
+        start: function (params = {}) {
+            return API.handler().post('/restores', params);
+        },
     };
 
     static clients = {
@@ -57,12 +78,28 @@ export default class API {
         list: async function (p = {}) {
             return API.handler().get('/images', { params: p });
         },
+        files: function (uuid: string, path: string, p = {}) {
+            return API.handler().get('/images/' + uuid + '/files', { params: { ...p, path: path } });
+        },
         get: function (uuid: string) {
             return API.handler().get('/images/' + uuid);
         },
+        diff: function (uuid: string, other_uuid: string) {
+            return API.handler().get('/images/' + uuid + '/diff/' + other_uuid);
+        },
+        downloadUrl: function (uuid: string, path: string, format = "tar") {
+            let sp = new URLSearchParams({ "path": path, "format": format });
+            return axios.defaults.baseURL + 'images/' + uuid + '/download?' + sp.toString();
+        },
         stats: function () {
             return API.handler().get('/images/stats');
         },
+        verify: function (uuid: string) {
+            return API.handler().post('/images/' + uuid + '/verify');
+        },
+        delete: function (uuid: string, force = false) {
+            return API.handler().delete('/images/' + uuid, { params: { force: force } });
+        },
     };
 
     static repos = {
@@ -74,4 +111,10 @@ export default class API {
         },
     };
 
+    static search = {
+        files: function (pattern: string, p = {}) {
+            return API.handler().get('/search', { params: { ...p, pattern: pattern } });
+        },
+    };
+
 }
\ No newline at end of file
@@ -19,6 +19,9 @@ export default class JobUtils {
             case "error":
                 color = "text-red-700";
                 break;
+            case "cancelled":
+                color = "text-neutral-500";
+                break;
             default:
                 color = "text-slate-700";
         }
@@ -38,6 +41,8 @@ export default class JobUtils {
                 return Const.WARNING;
             case "error":
                 return Const.CRITICAL;
+            case "cancelled":
+                return Const.UNKNOWN;
             default:
                 return Const.UNKNOWN;
         }